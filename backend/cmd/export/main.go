@@ -0,0 +1,128 @@
+// Command export writes klines, screen matches or a saved backtest's
+// points or trades from the database at DB_PATH as CSV or Parquet.
+//
+//	export klines [-code 600519] [-interval 1d] [-start 2024-01-01] [-end 2024-12-31] [-format csv] [-o FILE]
+//	export screen -strategy 1 [-format csv] [-o FILE]
+//	export points -backtest 3 [-format csv] [-o FILE]
+//	export trades -backtest 3 [-format csv] [-o FILE]
+//
+// Output goes to standard output unless -o names a file; the format
+// defaults to the file's extension, else CSV.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/config"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/db"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/services"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/tabular"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	format := flags.String("format", "", "csv or parquet (default: from -o, else csv)")
+	output := flags.String("o", "", "output file (default: standard output)")
+
+	var (
+		code, interval, start, end *string
+		strategyID, backtestID     *uint
+	)
+	switch command {
+	case "klines":
+		code = flags.String("code", "", "stock code (default: all)")
+		interval = flags.String("interval", "", "interval such as 1d or 30m (default: all)")
+		start = flags.String("start", "", "first date, YYYY-MM-DD")
+		end = flags.String("end", "", "last date, YYYY-MM-DD")
+	case "screen":
+		strategyID = flags.Uint("strategy", 0, "strategy id")
+	case "points", "trades":
+		backtestID = flags.Uint("backtest", 0, "backtest id")
+	default:
+		usage()
+	}
+	flags.Parse(os.Args[2:])
+
+	if *format == "" && *output != "" {
+		*format = strings.TrimPrefix(filepath.Ext(*output), ".")
+	}
+	resolved, err := tabular.ParseOutputFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var filter services.KLineExportFilter
+	if command == "klines" {
+		if filter, err = klineFilter(*code, *interval, *start, *end); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	database, err := db.Open(config.Load().DBPath)
+	if err != nil {
+		log.Fatalf("failed to open db: %v", err)
+	}
+	stockService := services.NewStockService(database)
+	backtestService := services.NewBacktestService(database)
+	analysisService := services.NewAnalysisService(database, stockService, services.NewStrategyService(database), services.NewWatchlistService(database))
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var rows int
+	switch command {
+	case "klines":
+		rows, err = stockService.ExportKLines(out, resolved, filter)
+	case "screen":
+		rows, err = analysisService.ExportScreen(out, resolved, *strategyID)
+	case "points":
+		rows, err = backtestService.ExportPoints(out, resolved, *backtestID)
+	case "trades":
+		rows, err = backtestService.ExportTrades(out, resolved, *backtestID)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("exported %d rows", rows)
+}
+
+func klineFilter(code, interval, start, end string) (services.KLineExportFilter, error) {
+	filter := services.KLineExportFilter{Code: code, Interval: interval}
+	if start != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", start, time.UTC)
+		if err != nil {
+			return filter, fmt.Errorf("invalid -start: %s", start)
+		}
+		filter.Start = parsed
+	}
+	if end != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", end, time.UTC)
+		if err != nil {
+			return filter, fmt.Errorf("invalid -end: %s", end)
+		}
+		filter.End = parsed.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: export klines|screen|points|trades [flags]")
+	os.Exit(2)
+}
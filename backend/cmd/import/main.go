@@ -0,0 +1,74 @@
+// Command import loads klines from a CSV or XLSX file into the database at
+// DB_PATH and prints the import report as JSON.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/config"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/db"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/services"
+)
+
+func main() {
+	var (
+		format     = flag.String("format", "", "csv or xlsx (default: from the file extension)")
+		sheet      = flag.String("sheet", "", "XLSX sheet name (default: the first sheet)")
+		code       = flag.String("code", "", "stock code of every row, for single-stock files")
+		interval   = flag.String("interval", "1d", "bar interval such as 1d or 30m")
+		dateFormat = flag.String("date-format", "", "Go time layout of the time column, e.g. 2006-01-02 (default: common layouts)")
+		columns    = flag.String("columns", "", "field=header pairs, comma-separated, e.g. time=Date,close=Close")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	mapping, err := services.ParseImportColumns(*columns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	options := services.KLineImportOptions{
+		Format:     *format,
+		Filename:   path,
+		Sheet:      *sheet,
+		StockCode:  *code,
+		Interval:   *interval,
+		DateFormat: *dateFormat,
+		Columns:    mapping,
+	}
+	if _, err := services.ParseImportOptions(options); err != nil {
+		log.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	database, err := db.Open(config.Load().DBPath)
+	if err != nil {
+		log.Fatalf("failed to open db: %v", err)
+	}
+	report, err := services.NewStockService(database).ImportKLines(file, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+}
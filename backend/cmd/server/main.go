@@ -3,7 +3,6 @@ package main
 import (
 	"log"
 	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 
@@ -11,6 +10,7 @@ import (
 	"github.com/xiedonge/stock-strategy-system/backend/internal/db"
 	"github.com/xiedonge/stock-strategy-system/backend/internal/handlers"
 	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/provider"
 	"github.com/xiedonge/stock-strategy-system/backend/internal/services"
 )
 
@@ -24,12 +24,15 @@ func main() {
 
 	stockService := services.NewStockService(database)
 	strategyService := services.NewStrategyService(database)
-	analysisService := services.NewAnalysisService(database, stockService, strategyService)
-	syncService := initSyncService(cfg.DBPath)
+	watchlistService := services.NewWatchlistService(database)
+	analysisService := services.NewAnalysisService(database, stockService, strategyService, watchlistService)
+	backtestService := services.NewBacktestService(database)
+	jobService := services.NewJobService(database, analysisService, cfg.BacktestWorkers, cfg.JobQueueSize)
+	syncService := initSyncService(stockService)
 
 	ensureDefaultStrategy(strategyService)
 
-	router := handlers.NewRouter(stockService, strategyService, analysisService, syncService)
+	router := handlers.NewRouter(stockService, strategyService, watchlistService, analysisService, backtestService, jobService, syncService)
 
 	log.Printf("stock strategy backend listening on :%s", cfg.Port)
 	if err := router.Run("0.0.0.0:" + cfg.Port); err != nil {
@@ -58,22 +61,7 @@ func ensureDefaultStrategy(strategyService *services.StrategyService) {
 	}
 }
 
-func initSyncService(dbPath string) *services.SyncService {
-	scriptPath := os.Getenv("AKSHARE_SYNC_SCRIPT")
-	if scriptPath == "" {
-		scriptPath = filepath.Join("..", "scripts", "sync_akshare.sh")
-	}
-	absScript, err := filepath.Abs(scriptPath)
-	if err != nil {
-		absScript = scriptPath
-	}
-	if _, err := os.Stat(absScript); err != nil {
-		alt := filepath.Join("scripts", "sync_akshare.sh")
-		if altAbs, err := filepath.Abs(alt); err == nil {
-			absScript = altAbs
-		}
-	}
-
+func initSyncService(stockService *services.StockService) *services.SyncService {
 	timeoutSeconds := 300
 	if raw := os.Getenv("SYNC_TIMEOUT_SECONDS"); raw != "" {
 		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
@@ -81,5 +69,5 @@ func initSyncService(dbPath string) *services.SyncService {
 		}
 	}
 
-	return services.NewSyncService(absScript, dbPath, time.Duration(timeoutSeconds)*time.Second)
+	return services.NewSyncService(provider.NewHTTPProvider(nil), stockService, time.Duration(timeoutSeconds)*time.Second)
 }
@@ -1,11 +1,18 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+)
 
 // Config holds server and storage settings derived from environment variables.
 type Config struct {
 	Port   string
 	DBPath string
+	// BacktestWorkers bounds concurrently running asynchronous backtests.
+	BacktestWorkers int
+	// JobQueueSize bounds backtests waiting for a worker.
+	JobQueueSize int
 }
 
 // Load reads environment variables and provides sensible defaults.
@@ -20,5 +27,17 @@ func Load() Config {
 		dbPath = "data/stock.db"
 	}
 
-	return Config{Port: port, DBPath: dbPath}
+	return Config{
+		Port:            port,
+		DBPath:          dbPath,
+		BacktestWorkers: positiveInt("BACKTEST_WORKERS", 2),
+		JobQueueSize:    positiveInt("BACKTEST_QUEUE_SIZE", 64),
+	}
+}
+
+func positiveInt(key string, fallback int) int {
+	if parsed, err := strconv.Atoi(os.Getenv(key)); err == nil && parsed > 0 {
+		return parsed
+	}
+	return fallback
 }
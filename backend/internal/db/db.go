@@ -21,13 +21,23 @@ func Open(path string) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	if err := dedupKLines(database); err != nil {
+		return nil, err
+	}
+
 	// AutoMigrate keeps the schema aligned with the models.
 	if err := database.AutoMigrate(
 		&models.Stock{},
 		&models.KLine{},
+		&models.AdjustFactor{},
+		&models.CorporateAction{},
 		&models.Strategy{},
+		&models.Watchlist{},
 		&models.Backtest{},
 		&models.BacktestPoint{},
+		&models.BacktestCurve{},
+		&models.BacktestTrade{},
+		&models.Job{},
 	); err != nil {
 		return nil, err
 	}
@@ -35,6 +45,34 @@ func Open(path string) (*gorm.DB, error) {
 	return database, nil
 }
 
+// klineKeyIndex is the unique index on a bar's stock code, interval and time.
+const klineKeyIndex = "idx_k_lines_key"
+
+// dedupKLines prepares databases created before the unique kline key so
+// AutoMigrate can create it. Dates and times the sync script wrote without
+// a zone, which the server reads as UTC, are rewritten in the form the
+// server writes, then duplicate bars of the same instant are removed,
+// keeping the latest row of each.
+func dedupKLines(database *gorm.DB) error {
+	migrator := database.Migrator()
+	if !migrator.HasTable(&models.KLine{}) || migrator.HasIndex(&models.KLine{}, klineKeyIndex) {
+		return nil
+	}
+	return database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE k_lines SET time = time || ' 00:00:00+00:00' WHERE length(time) = 10`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE k_lines SET time = time || '+00:00' WHERE length(time) = 19`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+			DELETE FROM k_lines
+			WHERE id NOT IN (
+				SELECT MAX(id) FROM k_lines GROUP BY stock_code, interval, datetime(time)
+			)`).Error
+	})
+}
+
 func ensureDir(path string) error {
 	dir := filepath.Dir(path)
 	if dir == "." {
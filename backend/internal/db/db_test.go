@@ -0,0 +1,100 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// TestDedupKLines opens a database created before the unique kline key,
+// holding bars the sync script wrote with bare dates and zone-less times
+// next to bars the server wrote for the same instants.
+func TestDedupKLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stocks.db")
+	legacy, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements := []string{
+		`CREATE TABLE k_lines (id integer PRIMARY KEY AUTOINCREMENT, stock_code text, interval text, time datetime,
+			open real, high real, low real, close real, volume real, created_at datetime)`,
+		`CREATE INDEX idx_k_lines_stock_code ON k_lines(stock_code)`,
+		`INSERT INTO k_lines (id, stock_code, interval, time, close) VALUES
+			(1, '600519', '1d', '2024-03-01', 10),
+			(2, '600519', '1d', '2024-03-01 00:00:00+00:00', 11),
+			(3, '600519', '1d', '2024-03-01 00:00:00', 12),
+			(4, '600519', '30m', '2024-03-01 10:00:00+00:00', 20),
+			(5, '600519', '30m', '2024-03-01 10:00:00', 21),
+			(6, '000001', '1d', '2024-03-01', 5),
+			(7, '600519', '1d', '2024-03-02 00:00:00+00:00', 13),
+			(8, '600519', '30m', '2024-03-01 10:30:00', 22)`,
+	}
+	for _, statement := range statements {
+		if err := legacy.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	sqlDB, _ := legacy.DB()
+	sqlDB.Close()
+
+	database, err := Open(path)
+	if err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	if !database.Migrator().HasIndex(&models.KLine{}, klineKeyIndex) {
+		t.Fatal("unique kline key missing after migration")
+	}
+
+	// The newest row of each instant survives, written as the server writes.
+	want := []struct {
+		id    uint
+		raw   string
+		time  time.Time
+		close float64
+	}{
+		{3, "2024-03-01 00:00:00+00:00", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 12},
+		{5, "2024-03-01 10:00:00+00:00", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), 21},
+		{6, "2024-03-01 00:00:00+00:00", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 5},
+		{7, "2024-03-02 00:00:00+00:00", time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), 13},
+		{8, "2024-03-01 10:30:00+00:00", time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC), 22},
+	}
+	var bars []models.KLine
+	if err := database.Order("id").Find(&bars).Error; err != nil {
+		t.Fatal(err)
+	}
+	var raws []string
+	if err := database.Raw("SELECT CAST(time AS TEXT) FROM k_lines ORDER BY id").Scan(&raws).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(bars) != len(want) || len(raws) != len(want) {
+		t.Fatalf("got %d bars, want %d: %+v", len(bars), len(want), bars)
+	}
+	for i, w := range want {
+		if bars[i].ID != w.id || !bars[i].Time.Equal(w.time) || bars[i].Close != w.close || raws[i] != w.raw {
+			t.Errorf("bar %d = id %d at %v (%q) closing %v, want id %d at %v (%q) closing %v",
+				i, bars[i].ID, bars[i].Time, raws[i], bars[i].Close, w.id, w.time, w.raw, w.close)
+		}
+	}
+
+	// The key now rejects a second bar of the same instant, and opening
+	// the migrated database again leaves it as it is.
+	duplicate := models.KLine{StockCode: "600519", Interval: "1d", Time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Close: 99}
+	if err := database.Create(&duplicate).Error; err == nil {
+		t.Error("duplicate bar inserted after migration")
+	}
+	sqlDB, _ = database.DB()
+	sqlDB.Close()
+	again, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	var count int64
+	again.Model(&models.KLine{}).Count(&count)
+	if count != int64(len(want)) {
+		t.Errorf("%d bars after reopening, want %d", count, len(want))
+	}
+}
@@ -1,16 +1,23 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/xiedonge/stock-strategy-system/backend/internal/services"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/tabular"
+	"gorm.io/gorm"
 )
 
 // NewRouter wires the HTTP routes to services.
-func NewRouter(stockService *services.StockService, strategyService *services.StrategyService, analysisService *services.AnalysisService, syncService *services.SyncService) *gin.Engine {
+func NewRouter(stockService *services.StockService, strategyService *services.StrategyService, watchlistService *services.WatchlistService, analysisService *services.AnalysisService, backtestService *services.BacktestService, jobService *services.JobService, syncService *services.SyncService) *gin.Engine {
 	router := gin.Default()
 	router.Use(cors.Default())
 
@@ -41,7 +48,12 @@ func NewRouter(stockService *services.StockService, strategyService *services.St
 			code := c.Param("code")
 			interval := c.Query("interval")
 			limit, _ := strconv.Atoi(c.Query("limit"))
-			klines, err := stockService.GetKLines(code, interval, limit)
+			adjust, err := strategy.ParseAdjustMode(c.Query("adjust"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			klines, err := stockService.GetKLines(code, interval, limit, adjust)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
@@ -133,7 +145,50 @@ func NewRouter(stockService *services.StockService, strategyService *services.St
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-			result, err := analysisService.RunBacktest(req.StrategyID, req.StockCode, req.InitialCapital)
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			result, err := analysisService.RunBacktest(c.Request.Context(), options)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		})
+
+		api.POST("/backtest/portfolio", func(c *gin.Context) {
+			var req PortfolioBacktestRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			result, err := analysisService.RunPortfolioBacktest(c.Request.Context(), options)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		})
+
+		api.POST("/backtest/screen", func(c *gin.Context) {
+			var req ScreenBacktestRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			result, err := analysisService.RunScreenBacktest(c.Request.Context(), options)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
@@ -141,30 +196,311 @@ func NewRouter(stockService *services.StockService, strategyService *services.St
 			c.JSON(http.StatusOK, result)
 		})
 
-		api.POST("/sync/akshare", func(c *gin.Context) {
+		api.POST("/backtests", func(c *gin.Context) {
+			var req BacktestRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			job, err := jobService.EnqueueBacktest(options)
+			if err != nil {
+				c.JSON(enqueueStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusAccepted, job)
+		})
+
+		api.POST("/backtests/portfolio", func(c *gin.Context) {
+			var req PortfolioBacktestRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			job, err := jobService.EnqueuePortfolioBacktest(options)
+			if err != nil {
+				c.JSON(enqueueStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusAccepted, job)
+		})
+
+		api.GET("/jobs/:id", func(c *gin.Context) {
+			id, _ := strconv.Atoi(c.Param("id"))
+			job, err := jobService.Get(uint(id))
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, job)
+		})
+
+		api.DELETE("/jobs/:id", func(c *gin.Context) {
+			id, _ := strconv.Atoi(c.Param("id"))
+			job, err := jobService.Cancel(uint(id))
+			if err != nil {
+				switch {
+				case errors.Is(err, gorm.ErrRecordNotFound):
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				case errors.Is(err, services.ErrJobFinished):
+					c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "job": job})
+				default:
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				}
+				return
+			}
+			c.JSON(http.StatusAccepted, job)
+		})
+
+		api.POST("/optimize", func(c *gin.Context) {
+			var req OptimizeRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			result, err := analysisService.Optimize(c.Request.Context(), options)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		})
+
+		api.POST("/optimize/diagnostics", func(c *gin.Context) {
+			var req DiagnosticsRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			result, err := analysisService.Diagnose(c.Request.Context(), options)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		})
+
+		api.POST("/walk-forward", func(c *gin.Context) {
+			var req WalkForwardRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			result, err := analysisService.WalkForward(c.Request.Context(), options)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		})
+
+		api.GET("/backtests", func(c *gin.Context) {
+			filter, err := parseBacktestFilter(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			page, err := backtestService.List(filter)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, page)
+		})
+
+		api.GET("/backtests/:id", func(c *gin.Context) {
+			id, _ := strconv.Atoi(c.Param("id"))
+			result, err := backtestService.Get(uint(id))
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		})
+
+		api.POST("/backtests/:id/monte-carlo", func(c *gin.Context) {
+			id, _ := strconv.Atoi(c.Param("id"))
+			var req strategy.MonteCarloOptions
+			if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			result, err := backtestService.MonteCarlo(uint(id), req)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		})
+
+		api.DELETE("/backtests/:id", func(c *gin.Context) {
+			id, _ := strconv.Atoi(c.Param("id"))
+			if err := backtestService.Delete(uint(id)); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+		})
+
+		api.GET("/watchlists", func(c *gin.Context) {
+			watchlists, err := watchlistService.List()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, watchlists)
+		})
+
+		api.POST("/watchlists", func(c *gin.Context) {
+			var req WatchlistRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			watchlist := req.toModel()
+			if err := watchlistService.Create(watchlist); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, watchlist)
+		})
+
+		api.DELETE("/watchlists/:id", func(c *gin.Context) {
+			id, _ := strconv.Atoi(c.Param("id"))
+			if err := watchlistService.Delete(uint(id)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+		})
+
+		api.POST("/import/klines", func(c *gin.Context) {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+			var req KLineImportRequest
+			if err := c.ShouldBind(&req); err != nil {
+				c.JSON(importErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			upload, err := c.FormFile("file")
+			if err != nil {
+				if status := importErrorStatus(err); status != http.StatusBadRequest {
+					c.JSON(status, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+				return
+			}
+			options, err := req.toOptions(upload.Filename)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			file, err := upload.Open()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			defer file.Close()
+
+			report, err := stockService.ImportKLines(file, options)
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch {
+				case errors.Is(err, tabular.ErrTooLarge):
+					status = http.StatusRequestEntityTooLarge
+				case errors.Is(err, services.ErrInvalidImport):
+					status = http.StatusBadRequest
+				}
+				c.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "import completed", "report": report})
+		})
+
+		api.GET("/export/klines", func(c *gin.Context) {
+			filter, err := parseKLineExportFilter(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			exportTable(c, "klines", func(out io.Writer, format string) (int, error) {
+				return stockService.ExportKLines(out, format, filter)
+			})
+		})
+
+		api.GET("/export/screen", func(c *gin.Context) {
+			strategyID, _ := strconv.Atoi(c.Query("strategy_id"))
+			exportTable(c, "screen", func(out io.Writer, format string) (int, error) {
+				return analysisService.ExportScreen(out, format, uint(strategyID))
+			})
+		})
+
+		api.GET("/export/backtests/:id/points", func(c *gin.Context) {
+			id, _ := strconv.Atoi(c.Param("id"))
+			exportTable(c, fmt.Sprintf("backtest-%d-points", id), func(out io.Writer, format string) (int, error) {
+				return backtestService.ExportPoints(out, format, uint(id))
+			})
+		})
+
+		api.GET("/export/backtests/:id/trades", func(c *gin.Context) {
+			id, _ := strconv.Atoi(c.Param("id"))
+			exportTable(c, fmt.Sprintf("backtest-%d-trades", id), func(out io.Writer, format string) (int, error) {
+				return backtestService.ExportTrades(out, format, uint(id))
+			})
+		})
+
+		api.POST("/sync", func(c *gin.Context) {
 			if syncService == nil {
 				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "sync service not configured"})
 				return
 			}
 
-			var req AkshareSyncRequest
+			var req SyncRequest
 			if err := c.ShouldBindJSON(&req); err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
+			options, err := req.toOptions()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 
-			summary, err := syncService.RunAkShareSync(services.SyncOptions{
-				Symbols:  req.Symbols,
-				Mode:     req.Mode,
-				StartDate: req.StartDate,
-				EndDate:   req.EndDate,
-				MinStart:  req.MinStart,
-				MinEnd:    req.MinEnd,
-				Period:    req.Period,
-				Limit:     req.Limit,
-			})
+			summary, err := syncService.Sync(c.Request.Context(), options)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "summary": summary})
 				return
 			}
 			c.JSON(http.StatusOK, gin.H{"message": "sync completed", "summary": summary})
@@ -173,3 +509,130 @@ func NewRouter(stockService *services.StockService, strategyService *services.St
 
 	return router
 }
+
+// enqueueStatus maps a job enqueue error to an HTTP status.
+func enqueueStatus(err error) int {
+	if errors.Is(err, services.ErrQueueFull) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// maxImportBytes bounds the request body of a kline file upload.
+const maxImportBytes = 64 << 20
+
+// importErrorStatus is 413 for an upload body beyond maxImportBytes and
+// 400 otherwise.
+func importErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// exportContentTypes maps export formats to their media types.
+var exportContentTypes = map[string]string{
+	tabular.CSV:     "text/csv; charset=utf-8",
+	tabular.Parquet: "application/vnd.apache.parquet",
+}
+
+// exportTable streams the table export writes as a download named after
+// name and the format query parameter (csv by default, or parquet). Errors
+// before the first byte are returned as JSON; later ones cut the download
+// short.
+func exportTable(c *gin.Context, name string, export func(out io.Writer, format string) (int, error)) {
+	format, err := tabular.ParseOutputFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	out := &downloadWriter{c: c, contentType: exportContentTypes[format], filename: name + "." + format}
+	if _, err := export(out, format); err != nil {
+		if out.started {
+			c.Error(err)
+			return
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+	}
+}
+
+// downloadWriter sets the download headers on its first write.
+type downloadWriter struct {
+	c           *gin.Context
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	if !d.started {
+		d.started = true
+		d.c.Header("Content-Type", d.contentType)
+		d.c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.filename))
+		d.c.Status(http.StatusOK)
+	}
+	return d.c.Writer.Write(p)
+}
+
+// parseKLineExportFilter reads kline export query parameters. Dates use
+// YYYY-MM-DD and end_date includes the whole day.
+func parseKLineExportFilter(c *gin.Context) (services.KLineExportFilter, error) {
+	filter := services.KLineExportFilter{Code: c.Query("code"), Interval: c.Query("interval")}
+	if filter.Interval != "" {
+		if _, err := strategy.IntervalMinutes(filter.Interval); err != nil {
+			return filter, err
+		}
+	}
+	if raw := c.Query("start_date"); raw != "" {
+		start, err := time.ParseInLocation("2006-01-02", raw, time.UTC)
+		if err != nil {
+			return filter, fmt.Errorf("invalid start_date: %s", raw)
+		}
+		filter.Start = start
+	}
+	if raw := c.Query("end_date"); raw != "" {
+		end, err := time.ParseInLocation("2006-01-02", raw, time.UTC)
+		if err != nil {
+			return filter, fmt.Errorf("invalid end_date: %s", raw)
+		}
+		filter.End = end.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// parseBacktestFilter reads history query parameters. Dates use YYYY-MM-DD
+// and "to" includes the whole day.
+func parseBacktestFilter(c *gin.Context) (services.BacktestFilter, error) {
+	strategyID, _ := strconv.Atoi(c.Query("strategy_id"))
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	filter := services.BacktestFilter{
+		StrategyID: uint(strategyID),
+		StockCode:  c.Query("stock_code"),
+		Page:       page,
+		PageSize:   pageSize,
+		Sort:       c.Query("sort"),
+		Desc:       c.DefaultQuery("order", "desc") != "asc",
+	}
+
+	if raw := c.Query("from"); raw != "" {
+		from, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date %q", raw)
+		}
+		filter.From = from
+	}
+	if raw := c.Query("to"); raw != "" {
+		to, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date %q", raw)
+		}
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
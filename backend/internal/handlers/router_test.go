@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/services"
+)
+
+func TestParseBacktestFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.Local) }
+	tests := []struct {
+		query string
+		want  services.BacktestFilter
+		err   bool
+	}{
+		{"", services.BacktestFilter{Desc: true}, false},
+		{
+			"strategy_id=3&stock_code=600519&page=2&page_size=50&sort=sharpe&order=asc",
+			services.BacktestFilter{StrategyID: 3, StockCode: "600519", Page: 2, PageSize: 50, Sort: "sharpe"},
+			false,
+		},
+		// "to" includes the whole day.
+		{"from=2024-03-01&to=2024-03-05", services.BacktestFilter{From: day(1), To: day(6), Desc: true}, false},
+		// Unparsable numbers are left to the service's defaults.
+		{"strategy_id=x&page=-&page_size=", services.BacktestFilter{Desc: true}, false},
+		{"from=2024-3-1", services.BacktestFilter{}, true},
+		{"to=yesterday", services.BacktestFilter{}, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/api/backtests?"+tt.query, nil)
+		got, err := parseBacktestFilter(c)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: want an error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if got.StrategyID != tt.want.StrategyID || got.StockCode != tt.want.StockCode || got.Page != tt.want.Page || got.PageSize != tt.want.PageSize ||
+			got.Sort != tt.want.Sort || got.Desc != tt.want.Desc || !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) {
+			t.Errorf("%q: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,16 @@
 package handlers
 
-import "github.com/xiedonge/stock-strategy-system/backend/internal/models"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/provider"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/services"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
 
 // StrategyRequest defines the payload to create/update a strategy.
 type StrategyRequest struct {
@@ -39,10 +49,263 @@ type BacktestRequest struct {
 	StrategyID     uint    `json:"strategy_id"`
 	StockCode      string  `json:"stock_code"`
 	InitialCapital float64 `json:"initial_capital"`
+	// Interval is the kline interval to test on: 1d (default) or minute
+	// bars such as 30m.
+	Interval string `json:"interval"`
+	// SessionExit decides what intraday positions do at the lunch break and
+	// the close: hold (default), day_end or session_end.
+	SessionExit string `json:"session_exit"`
+	// Adjust is the price adjustment signals are computed on: qfq
+	// (default), hfq or none. Orders always fill at raw prices.
+	Adjust string `json:"adjust"`
+	// TaxFree skips the holding-period dividend tax on sales, as for
+	// accounts exempt from it.
+	TaxFree bool `json:"tax_free"`
+	// StartDate and EndDate (YYYY-MM-DD, inclusive) bound the reported
+	// window; without a start the latest 1000 bars are tested.
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	// WarmupBars overrides the bars loaded before the window for indicators.
+	WarmupBars int `json:"warmup_bars"`
+	// Execution selects the fill timing: same_close, next_open (default),
+	// next_close or next_vwap.
+	Execution string `json:"execution"`
+	// Slippage selects none, fixed_bps, fixed_ticks or volume slippage.
+	Slippage strategy.SlippageConfig `json:"slippage"`
+	// Exits configures stop-loss, take-profit, trailing and time exits.
+	Exits strategy.ExitConfig `json:"exits"`
+	// Sizing selects the position sizing policy (all_in by default).
+	Sizing strategy.SizingConfig `json:"sizing"`
+	// Margin enables financing and short selling (融资融券).
+	Margin strategy.MarginConfig `json:"margin"`
+	// Benchmark is a kline code such as 000300; empty compares against
+	// buy-and-hold of the stock (equal-weight universe for portfolios).
+	Benchmark string `json:"benchmark"`
+	// CurveStorage saves the equity curve as rows (default), one
+	// compressed blob, or downsample to at most CurveMaxPoints rows.
+	CurveStorage   string `json:"curve_storage"`
+	CurveMaxPoints int    `json:"curve_max_points"`
+}
+
+func (b BacktestRequest) toOptions() (services.BacktestOptions, error) {
+	options := services.BacktestOptions{
+		StrategyID:     b.StrategyID,
+		StockCode:      b.StockCode,
+		InitialCapital: b.InitialCapital,
+		Interval:       b.Interval,
+		SessionExit:    b.SessionExit,
+		Adjust:         b.Adjust,
+		TaxFree:        b.TaxFree,
+		WarmupBars:     b.WarmupBars,
+		Execution:      b.Execution,
+		Slippage:       b.Slippage,
+		Exits:          b.Exits,
+		Sizing:         b.Sizing,
+		Margin:         b.Margin,
+		Benchmark:      b.Benchmark,
+		CurveStorage:   b.CurveStorage,
+		CurveMaxPoints: b.CurveMaxPoints,
+	}
+	if _, err := strategy.IntervalMinutes(b.Interval); err != nil {
+		return options, err
+	}
+	if _, err := strategy.ParseAdjustMode(b.Adjust); err != nil {
+		return options, err
+	}
+	if _, err := services.ParseCurveStorage(b.CurveStorage); err != nil {
+		return options, err
+	}
+	if b.CurveMaxPoints < 0 {
+		return options, fmt.Errorf("curve_max_points must not be negative")
+	}
+	if b.StartDate != "" {
+		start, err := time.ParseInLocation("2006-01-02", b.StartDate, time.UTC)
+		if err != nil {
+			return options, fmt.Errorf("invalid start_date: %s", b.StartDate)
+		}
+		options.Start = start
+	}
+	if b.EndDate != "" {
+		end, err := time.ParseInLocation("2006-01-02", b.EndDate, time.UTC)
+		if err != nil {
+			return options, fmt.Errorf("invalid end_date: %s", b.EndDate)
+		}
+		options.End = end.AddDate(0, 0, 1)
+	}
+	if !options.Start.IsZero() && !options.End.IsZero() && !options.Start.Before(options.End) {
+		return options, fmt.Errorf("start_date must not be after end_date")
+	}
+	return options, nil
+}
+
+// PortfolioBacktestRequest defines the payload for a multi-stock backtest.
+// StockCode is ignored; the universe is Codes, else WatchlistID, else all stocks.
+type PortfolioBacktestRequest struct {
+	BacktestRequest
+	Codes        []string `json:"codes"`
+	WatchlistID  uint     `json:"watchlist_id"`
+	MaxPositions int      `json:"max_positions"`
+	// Ranking breaks ties when more buy signals fire than free slots:
+	// momentum (default), turnover, low_volatility or code.
+	Ranking      string `json:"ranking"`
+	RankLookback int    `json:"rank_lookback"`
+}
+
+func (p PortfolioBacktestRequest) toOptions() (services.PortfolioBacktestOptions, error) {
+	options, err := p.BacktestRequest.toOptions()
+	if err != nil {
+		return services.PortfolioBacktestOptions{}, err
+	}
+	return services.PortfolioBacktestOptions{
+		BacktestOptions: options,
+		Codes:           p.Codes,
+		WatchlistID:     p.WatchlistID,
+		MaxPositions:    p.MaxPositions,
+		Ranking:         p.Ranking,
+		RankLookback:    p.RankLookback,
+	}, nil
+}
+
+// ScreenBacktestRequest defines the payload for backtesting a strategy's
+// screen as a rebalanced portfolio over the universe of Codes, else
+// WatchlistID, else all stocks.
+type ScreenBacktestRequest struct {
+	BacktestRequest
+	Codes       []string `json:"codes"`
+	WatchlistID uint     `json:"watchlist_id"`
+	// Rebalance is daily, weekly or monthly (default).
+	Rebalance string `json:"rebalance"`
+	// Weighting is equal (default) or score, by the MA spread.
+	Weighting string `json:"weighting"`
+	// HoldBars keeps picks that many bars across rebalances; zero holds
+	// them until the next rebalance.
+	HoldBars     int `json:"hold_bars"`
+	MaxPositions int `json:"max_positions"`
+}
+
+func (s ScreenBacktestRequest) toOptions() (services.ScreenBacktestOptions, error) {
+	options, err := s.BacktestRequest.toOptions()
+	if err != nil {
+		return services.ScreenBacktestOptions{}, err
+	}
+	if _, err := strategy.ParseRebalance(s.Rebalance); err != nil {
+		return services.ScreenBacktestOptions{}, err
+	}
+	if _, err := strategy.ParseWeighting(s.Weighting); err != nil {
+		return services.ScreenBacktestOptions{}, err
+	}
+	if s.HoldBars < 0 || s.MaxPositions < 0 {
+		return services.ScreenBacktestOptions{}, fmt.Errorf("hold_bars and max_positions must not be negative")
+	}
+	return services.ScreenBacktestOptions{
+		BacktestOptions: options,
+		Codes:           s.Codes,
+		WatchlistID:     s.WatchlistID,
+		Rebalance:       s.Rebalance,
+		Weighting:       s.Weighting,
+		HoldBars:        s.HoldBars,
+		MaxPositions:    s.MaxPositions,
+	}, nil
+}
+
+// OptimizeRequest defines the payload for a parameter grid search. With
+// stock_code set one stock is searched, otherwise the portfolio universe.
+type OptimizeRequest struct {
+	PortfolioBacktestRequest
+	StrategyType string `json:"strategy_type"`
+	// Grid maps parameter names to {"values":[...]} or {"from","to","step"}.
+	Grid      strategy.ParamGrid `json:"grid"`
+	Objective string             `json:"objective"`
+	// Heatmap names the x and y parameters of the score heatmap.
+	Heatmap []string `json:"heatmap"`
+	Top     int      `json:"top"`
+}
+
+func (o OptimizeRequest) toOptions() (services.OptimizeOptions, error) {
+	portfolio, err := o.PortfolioBacktestRequest.toOptions()
+	if err != nil {
+		return services.OptimizeOptions{}, err
+	}
+	options := services.OptimizeOptions{
+		PortfolioBacktestOptions: portfolio,
+		StrategyType:             o.StrategyType,
+		Grid:                     o.Grid,
+		Objective:                o.Objective,
+		Top:                      o.Top,
+	}
+	switch len(o.Heatmap) {
+	case 0:
+	case 2:
+		options.HeatmapX, options.HeatmapY = o.Heatmap[0], o.Heatmap[1]
+	default:
+		return options, fmt.Errorf("heatmap needs exactly two parameter names")
+	}
+	return options, nil
+}
+
+// DiagnosticsRequest defines the payload for overfitting diagnostics of a
+// parameter grid search.
+type DiagnosticsRequest struct {
+	OptimizeRequest
+	Partitions   int   `json:"partitions"`
+	RandomTrials int   `json:"random_trials"`
+	Seed         int64 `json:"seed"`
+}
+
+func (d DiagnosticsRequest) toOptions() (services.DiagnosticsOptions, error) {
+	options, err := d.OptimizeRequest.toOptions()
+	if err != nil {
+		return services.DiagnosticsOptions{}, err
+	}
+	return services.DiagnosticsOptions{
+		OptimizeOptions: options,
+		Partitions:      d.Partitions,
+		RandomTrials:    d.RandomTrials,
+		Seed:            d.Seed,
+	}, nil
+}
+
+// WalkForwardRequest defines the payload for a walk-forward analysis.
+type WalkForwardRequest struct {
+	BacktestRequest
+	StrategyType string             `json:"strategy_type"`
+	Grid         strategy.ParamGrid `json:"grid"`
+	Objective    string             `json:"objective"`
+	// InSampleBars and OutOfSampleBars size the rolling windows (defaults 250 and 60).
+	InSampleBars    int  `json:"in_sample_bars"`
+	OutOfSampleBars int  `json:"out_of_sample_bars"`
+	Anchored        bool `json:"anchored"`
+}
+
+func (w WalkForwardRequest) toOptions() (services.WalkForwardOptions, error) {
+	options, err := w.BacktestRequest.toOptions()
+	if err != nil {
+		return services.WalkForwardOptions{}, err
+	}
+	return services.WalkForwardOptions{
+		BacktestOptions: options,
+		StrategyType:    w.StrategyType,
+		Grid:            w.Grid,
+		Objective:       w.Objective,
+		InSampleBars:    w.InSampleBars,
+		OutOfSampleBars: w.OutOfSampleBars,
+		Anchored:        w.Anchored,
+	}, nil
+}
+
+// WatchlistRequest defines the payload to create a watchlist.
+type WatchlistRequest struct {
+	Name  string   `json:"name"`
+	Codes []string `json:"codes"`
+}
+
+func (w WatchlistRequest) toModel() *models.Watchlist {
+	return &models.Watchlist{Name: w.Name, Codes: strings.Join(w.Codes, ",")}
 }
 
-// AkshareSyncRequest defines the payload for AkShare data sync.
-type AkshareSyncRequest struct {
+// SyncRequest defines the payload for data sync. Daily dates are YYYYMMDD
+// and minute times YYYY-MM-DD HH:MM:SS, both inclusive.
+type SyncRequest struct {
 	Symbols   []string `json:"symbols"`
 	Mode      string   `json:"mode"`
 	StartDate string   `json:"start_date"`
@@ -52,3 +315,77 @@ type AkshareSyncRequest struct {
 	Period    string   `json:"period"`
 	Limit     int      `json:"limit"`
 }
+
+func (s SyncRequest) toOptions() (services.DataSyncOptions, error) {
+	options := services.DataSyncOptions{Symbols: s.Symbols, Mode: s.Mode, Limit: s.Limit}
+	if _, err := services.ParseSyncMode(s.Mode); err != nil {
+		return options, err
+	}
+	for _, code := range s.Symbols {
+		if provider.Exchange(code) == "" {
+			return options, fmt.Errorf("unknown exchange for %s", code)
+		}
+	}
+	if s.Period != "" {
+		period, err := strconv.Atoi(s.Period)
+		if err != nil || !provider.ValidPeriod(period) {
+			return options, fmt.Errorf("invalid period: %s", s.Period)
+		}
+		options.Period = period
+	}
+
+	fields := []struct {
+		name, raw, layout string
+		out               *time.Time
+	}{
+		{"start_date", s.StartDate, "20060102", &options.DailyStart},
+		{"end_date", s.EndDate, "20060102", &options.DailyEnd},
+		{"min_start", s.MinStart, "2006-01-02 15:04:05", &options.MinuteStart},
+		{"min_end", s.MinEnd, "2006-01-02 15:04:05", &options.MinuteEnd},
+	}
+	for _, field := range fields {
+		if field.raw == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation(field.layout, field.raw, time.UTC)
+		if err != nil {
+			return options, fmt.Errorf("invalid %s: %s", field.name, field.raw)
+		}
+		*field.out = parsed
+	}
+	if !options.DailyStart.IsZero() && !options.DailyEnd.IsZero() && options.DailyStart.After(options.DailyEnd) {
+		return options, fmt.Errorf("start_date must not be after end_date")
+	}
+	if !options.MinuteStart.IsZero() && !options.MinuteEnd.IsZero() && options.MinuteStart.After(options.MinuteEnd) {
+		return options, fmt.Errorf("min_start must not be after min_end")
+	}
+	return options, nil
+}
+
+// KLineImportRequest defines the form fields of a kline file upload. The
+// file itself is the "file" field.
+type KLineImportRequest struct {
+	Format     string `form:"format"`
+	Sheet      string `form:"sheet"`
+	StockCode  string `form:"stock_code"`
+	Interval   string `form:"interval"`
+	DateFormat string `form:"date_format"`
+	// Columns maps fields to headers as field=header pairs, comma-separated.
+	Columns string `form:"columns"`
+}
+
+func (k KLineImportRequest) toOptions(filename string) (services.KLineImportOptions, error) {
+	columns, err := services.ParseImportColumns(k.Columns)
+	if err != nil {
+		return services.KLineImportOptions{}, err
+	}
+	return services.ParseImportOptions(services.KLineImportOptions{
+		Format:     k.Format,
+		Filename:   filename,
+		Sheet:      k.Sheet,
+		StockCode:  k.StockCode,
+		Interval:   k.Interval,
+		DateFormat: k.DateFormat,
+		Columns:    columns,
+	})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/db"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/services"
+)
+
+// TestBacktestRequestDates checks that start_date and end_date bound the
+// stored UTC bars to the requested days on a server east of UTC.
+func TestBacktestRequestDates(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("CST", 8*3600)
+	t.Cleanup(func() { time.Local = local })
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "stocks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stocks := services.NewStockService(database)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	var bars []models.KLine
+	for d := 1; d <= 10; d++ {
+		bars = append(bars, models.KLine{StockCode: "600519", Interval: "1d", Time: day(d), Open: 10, High: 10, Low: 10, Close: 10, Volume: 1})
+	}
+	if err := stocks.SaveKLines(bars); err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := BacktestRequest{StockCode: "600519", StartDate: "2024-03-04", EndDate: "2024-03-07"}.toOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !options.Start.Equal(day(4)) || !options.End.Equal(day(8)) {
+		t.Fatalf("window [%v, %v), want [%v, %v)", options.Start, options.End, day(4), day(8))
+	}
+
+	window, err := stocks.GetKLinesBetween("600519", "1d", options.Start, options.End)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(window) != 4 || !window[0].Time.Equal(day(4)) || !window[3].Time.Equal(day(7)) {
+		t.Errorf("window bars %v, want 2024-03-04 to 2024-03-07", window)
+	}
+	warm, err := stocks.GetKLinesBefore("600519", "1d", options.Start, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warm) != 2 || !warm[0].Time.Equal(day(2)) || !warm[1].Time.Equal(day(3)) {
+		t.Errorf("warm-up bars %v, want 2024-03-02 and 2024-03-03", warm)
+	}
+}
@@ -12,12 +12,13 @@ type Stock struct {
 	UpdatedAt time.Time
 }
 
-// KLine holds OHLCV data for a given interval.
+// KLine holds OHLCV data for a given interval. A stock has at most one bar
+// per interval and time.
 type KLine struct {
 	ID        uint      `gorm:"primaryKey"`
-	StockCode string    `gorm:"index;size:16"`
-	Interval  string    `gorm:"index;size:8"` // 1d or 30m
-	Time      time.Time `gorm:"index"`
+	StockCode string    `gorm:"index;size:16;uniqueIndex:idx_k_lines_key"`
+	Interval  string    `gorm:"index;size:8;uniqueIndex:idx_k_lines_key"` // 1d or 30m
+	Time      time.Time `gorm:"index;uniqueIndex:idx_k_lines_key"`
 	Open      float64
 	High      float64
 	Low       float64
@@ -26,6 +27,30 @@ type KLine struct {
 	CreatedAt time.Time
 }
 
+// AdjustFactor is a stock's cumulative backward adjustment (后复权) factor
+// in effect from Date on: a raw price times Factor is the backward-adjusted
+// price. The factor changes on ex-rights and ex-dividend dates.
+type AdjustFactor struct {
+	ID        uint      `gorm:"primaryKey"`
+	StockCode string    `gorm:"index;size:16"`
+	Date      time.Time `gorm:"index"`
+	Factor    float64
+	CreatedAt time.Time
+}
+
+// CorporateAction is a dividend or bonus-share (送转) distribution going ex
+// on ExDate. Holders at the previous close receive CashPerShare yuan before
+// tax on PayDate and BonusRatio new shares per share held.
+type CorporateAction struct {
+	ID           uint      `gorm:"primaryKey"`
+	StockCode    string    `gorm:"index;size:16"`
+	ExDate       time.Time `gorm:"index"`
+	PayDate      time.Time
+	CashPerShare float64
+	BonusRatio   float64
+	CreatedAt    time.Time
+}
+
 // Strategy defines a screening rule and its serialized parameters.
 type Strategy struct {
 	ID          uint      `gorm:"primaryKey"`
@@ -37,19 +62,66 @@ type Strategy struct {
 	UpdatedAt   time.Time
 }
 
-// Backtest stores summary results for a strategy on a single stock.
+// Watchlist is a named list of stock codes used as a backtest universe.
+type Watchlist struct {
+	ID        uint      `gorm:"primaryKey"`
+	Name      string    `gorm:"size:64"`
+	Codes     string    `gorm:"type:text"` // comma-separated stock codes
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Backtest stores summary results for a strategy on a single stock or, when
+// Universe is set, on a portfolio of stocks.
 type Backtest struct {
 	ID             uint      `gorm:"primaryKey"`
 	StrategyID     uint      `gorm:"index"`
 	StockCode      string    `gorm:"size:16;index"`
+	Universe       string    `gorm:"type:text"` // comma-separated codes of a portfolio run
+	Interval       string    `gorm:"size:8"`
+	Execution      string    `gorm:"size:16"` // same_close, next_open, next_close or next_vwap
 	Start          time.Time
 	End            time.Time
 	InitialCapital float64
 	FinalCapital   float64
 	ReturnPct      float64
+	BacktestMetrics `gorm:"embedded"`
+	BenchmarkCode  string    `gorm:"size:16"`
+	BenchmarkMetrics `gorm:"embedded"`
+	CurveStorage   string    `gorm:"size:16"` // rows, blob or downsample
+	CurvePoints    int       // points of the full equity curve
 	CreatedAt      time.Time
 }
 
+// BacktestMetrics holds performance statistics of a backtest run.
+// Percentages are expressed in percent; durations are in bars.
+type BacktestMetrics struct {
+	AnnualReturnPct float64 `gorm:"index"`
+	VolatilityPct   float64
+	Sharpe          float64 `gorm:"index"`
+	Sortino         float64
+	MaxDrawdownPct  float64 `gorm:"index"`
+	MaxDrawdownBars int
+	Calmar          float64
+	WinRatePct      float64
+	ProfitFactor    float64
+	AvgWinPct       float64
+	AvgLossPct      float64
+	ExpectancyPct   float64
+	Trades          int
+	AvgHoldingBars  float64
+	ExposurePct     float64
+}
+
+// BenchmarkMetrics relates a backtest to its benchmark. Alpha and tracking
+// error are annualized percentages.
+type BenchmarkMetrics struct {
+	AlphaPct         float64
+	Beta             float64
+	InformationRatio float64
+	TrackingErrorPct float64
+}
+
 // BacktestPoint is a single point on the equity curve.
 type BacktestPoint struct {
 	ID         uint      `gorm:"primaryKey"`
@@ -57,3 +129,40 @@ type BacktestPoint struct {
 	Time       time.Time `gorm:"index"`
 	Equity     float64
 }
+
+// BacktestCurve holds a whole equity curve in one compressed blob, for
+// backtests stored with the blob curve storage instead of BacktestPoint rows.
+type BacktestCurve struct {
+	ID         uint   `gorm:"primaryKey"`
+	BacktestID uint   `gorm:"uniqueIndex"`
+	Encoding   string `gorm:"size:16"` // gzip+json
+	Data       []byte
+}
+
+// Job is a backtest queued for asynchronous execution.
+type Job struct {
+	ID         uint   `gorm:"primaryKey"`
+	Kind       string `gorm:"size:32"` // backtest or portfolio_backtest
+	Status     string `gorm:"size:16;index"`
+	Progress   int    // percent of bars processed
+	ParamsJSON string `gorm:"type:text"`
+	BacktestID uint   // set once the job succeeded
+	Error      string `gorm:"type:text"`
+	CreatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+// BacktestTrade is a simulated fill recorded during a backtest.
+type BacktestTrade struct {
+	ID         uint      `gorm:"primaryKey"`
+	BacktestID uint      `gorm:"index"`
+	StockCode  string    `gorm:"size:16"`
+	Time       time.Time `gorm:"index"`
+	Side       string    `gorm:"size:8"` // BUY, SELL, DIVIDEND or BONUS
+	Price      float64
+	Shares     float64
+	Slippage   float64
+	Tax        float64
+	Reason     string `gorm:"size:32"`
+}
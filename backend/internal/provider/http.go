@@ -0,0 +1,267 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// Public endpoints of HTTPProvider: Eastmoney's quote list and kline APIs,
+// which AkShare wraps, and Sina's backward adjustment factors.
+const (
+	DefaultListURL   = "https://push2.eastmoney.com/api/qt/clist/get"
+	DefaultKLineURL  = "https://push2his.eastmoney.com/api/qt/stock/kline/get"
+	DefaultFactorURL = "https://finance.sina.com.cn/realstock/company/%s/hfq.js"
+)
+
+// listPageSize is the number of securities requested per list page.
+const listPageSize = 100
+
+// aShareFilter selects the Shanghai, Shenzhen and Beijing A-share boards
+// in the Eastmoney list API.
+const aShareFilter = "m:0 t:6,m:0 t:80,m:1 t:2,m:1 t:23,m:0 t:81 s:2048"
+
+// HTTPProvider reads public quote sources over HTTP. The URLs may be
+// pointed elsewhere, as Fake does; FactorURL takes the exchange-prefixed
+// symbol such as sh600519.
+type HTTPProvider struct {
+	Client    *http.Client
+	ListURL   string
+	KLineURL  string
+	FactorURL string
+}
+
+// NewHTTPProvider returns a provider for the public endpoints. A nil client
+// uses one with a 30 second timeout.
+func NewHTTPProvider(client *http.Client) *HTTPProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &HTTPProvider{Client: client, ListURL: DefaultListURL, KLineURL: DefaultKLineURL, FactorURL: DefaultFactorURL}
+}
+
+// ListSecurities pages through the A-share list in code order.
+func (p *HTTPProvider) ListSecurities(ctx context.Context, limit int) ([]models.Stock, error) {
+	var stocks []models.Stock
+	for page := 1; ; page++ {
+		query := url.Values{
+			"pn":     {strconv.Itoa(page)},
+			"pz":     {strconv.Itoa(listPageSize)},
+			"po":     {"0"},
+			"np":     {"1"},
+			"fltt":   {"2"},
+			"invt":   {"2"},
+			"fid":    {"f12"},
+			"fs":     {aShareFilter},
+			"fields": {"f12,f14"},
+		}
+		var body struct {
+			Data *struct {
+				Total int `json:"total"`
+				Diff  []struct {
+					Code string `json:"f12"`
+					Name string `json:"f14"`
+				} `json:"diff"`
+			} `json:"data"`
+		}
+		if err := p.getJSON(ctx, p.ListURL+"?"+query.Encode(), &body); err != nil {
+			return nil, err
+		}
+		if body.Data == nil || len(body.Data.Diff) == 0 {
+			return stocks, nil
+		}
+		for _, item := range body.Data.Diff {
+			stocks = append(stocks, models.Stock{Code: item.Code, Name: item.Name, Exchange: Exchange(item.Code)})
+			if limit > 0 && len(stocks) == limit {
+				return stocks, nil
+			}
+		}
+		if page*listPageSize >= body.Data.Total {
+			return stocks, nil
+		}
+	}
+}
+
+// DailyBars requests unadjusted daily klines for the date range.
+func (p *HTTPProvider) DailyBars(ctx context.Context, code string, start, end time.Time) ([]models.KLine, error) {
+	bars, err := p.klines(ctx, code, "101", "1d", start.Format("20060102"), end.Format("20060102"))
+	if err != nil {
+		return nil, err
+	}
+	return within(bars, start, end), nil
+}
+
+// MinuteBars requests unadjusted minute klines for the days of the range
+// and keeps those ending within it.
+func (p *HTTPProvider) MinuteBars(ctx context.Context, code string, minutes int, start, end time.Time) ([]models.KLine, error) {
+	if !ValidPeriod(minutes) {
+		return nil, fmt.Errorf("unsupported minute period %d", minutes)
+	}
+	bars, err := p.klines(ctx, code, strconv.Itoa(minutes), fmt.Sprintf("%dm", minutes), start.Format("20060102"), end.Format("20060102"))
+	if err != nil {
+		return nil, err
+	}
+	return within(bars, start, end), nil
+}
+
+// klines fetches the kline API. Each kline is "time,open,close,high,low,
+// volume,..." with volume in lots, as the sync script stores it.
+func (p *HTTPProvider) klines(ctx context.Context, code, klt, interval, begin, end string) ([]models.KLine, error) {
+	market := "0"
+	if Exchange(code) == "SH" {
+		market = "1"
+	}
+	query := url.Values{
+		"secid":   {market + "." + code},
+		"klt":     {klt},
+		"fqt":     {"0"},
+		"beg":     {begin},
+		"end":     {end},
+		"fields1": {"f1,f2,f3,f4,f5,f6"},
+		"fields2": {"f51,f52,f53,f54,f55,f56"},
+	}
+	var body struct {
+		Data *struct {
+			KLines []string `json:"klines"`
+		} `json:"data"`
+	}
+	if err := p.getJSON(ctx, p.KLineURL+"?"+query.Encode(), &body); err != nil {
+		return nil, err
+	}
+	if body.Data == nil {
+		return nil, fmt.Errorf("%w %s", ErrNoData, code)
+	}
+
+	bars := make([]models.KLine, 0, len(body.Data.KLines))
+	for _, line := range body.Data.KLines {
+		bar, err := parseKLine(code, interval, line)
+		if err != nil {
+			return nil, err
+		}
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
+
+func parseKLine(code, interval, line string) (models.KLine, error) {
+	fields := strings.Split(line, ",")
+	if len(fields) < 6 {
+		return models.KLine{}, fmt.Errorf("malformed kline %q", line)
+	}
+	layout := "2006-01-02 15:04"
+	if len(fields[0]) == len("2006-01-02") {
+		layout = "2006-01-02"
+	}
+	stamp, err := time.ParseInLocation(layout, fields[0], time.UTC)
+	if err != nil {
+		return models.KLine{}, fmt.Errorf("malformed kline time %q", fields[0])
+	}
+	var values [5]float64
+	for i := range values {
+		if values[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+			return models.KLine{}, fmt.Errorf("malformed kline %q", line)
+		}
+	}
+	return models.KLine{
+		StockCode: code,
+		Interval:  interval,
+		Time:      stamp,
+		Open:      values[0],
+		Close:     values[1],
+		High:      values[2],
+		Low:       values[3],
+		Volume:    values[4],
+	}, nil
+}
+
+// AdjustFactors reads Sina's hfq.js, a script assigning
+// {"data":[{"d":"2024-06-19","f":"8.3932"},...]} newest first.
+func (p *HTTPProvider) AdjustFactors(ctx context.Context, code string) ([]models.AdjustFactor, error) {
+	exchange := Exchange(code)
+	if exchange == "" {
+		return nil, fmt.Errorf("unknown exchange for %s", code)
+	}
+	raw, err := p.get(ctx, fmt.Sprintf(p.FactorURL, strings.ToLower(exchange)+code))
+	if err != nil {
+		return nil, err
+	}
+	// The object ends its line; a comment may follow.
+	text := string(raw)
+	from := strings.Index(text, "{")
+	if from < 0 {
+		return nil, fmt.Errorf("%w %s", ErrNoData, code)
+	}
+	text = text[from:]
+	if end := strings.IndexByte(text, '\n'); end >= 0 {
+		text = text[:end]
+	}
+	text = strings.TrimRight(strings.TrimSpace(text), ";")
+	var body struct {
+		Data []struct {
+			Date   string `json:"d"`
+			Factor string `json:"f"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(text), &body); err != nil {
+		return nil, fmt.Errorf("parse adjust factors of %s: %w", code, err)
+	}
+
+	factors := make([]models.AdjustFactor, 0, len(body.Data))
+	for _, item := range body.Data {
+		date, err := time.ParseInLocation("2006-01-02", item.Date, time.UTC)
+		if err != nil {
+			return nil, fmt.Errorf("malformed factor date %q", item.Date)
+		}
+		factor, err := strconv.ParseFloat(item.Factor, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed factor %q", item.Factor)
+		}
+		factors = append(factors, models.AdjustFactor{StockCode: code, Date: date, Factor: factor})
+	}
+	sort.Slice(factors, func(i, j int) bool { return factors[i].Date.Before(factors[j].Date) })
+	return factors, nil
+}
+
+func (p *HTTPProvider) getJSON(ctx context.Context, target string, out any) error {
+	raw, err := p.get(ctx, target)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("parse %s: %w", withoutQuery(target), err)
+	}
+	return nil
+}
+
+func (p *HTTPProvider) get(ctx context.Context, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", withoutQuery(target), resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// withoutQuery drops the query string from a URL for error messages.
+func withoutQuery(target string) string {
+	if i := strings.Index(target, "?"); i >= 0 {
+		return target[:i]
+	}
+	return target
+}
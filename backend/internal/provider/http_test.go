@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// Responses recorded from the public endpoints, trimmed to a few rows.
+const (
+	recordedList = `{"rc":0,"rt":6,"svr":181669437,"lt":1,"full":1,"dlmkts":"","data":{"total":3,"diff":[` +
+		`{"f12":"000001","f14":"平安银行"},{"f12":"600519","f14":"贵州茅台"},{"f12":"830799","f14":"艾融软件"}]}}`
+	recordedDaily = `{"rc":0,"rt":17,"svr":177617938,"lt":1,"full":0,"dlmkts":"","data":{"code":"600519","market":1,` +
+		`"name":"贵州茅台","decimal":2,"dktotal":5361,"preKPrice":1688.0,"klines":[` +
+		`"2024-06-17,1688.00,1678.00,1699.00,1671.31,29337",` +
+		`"2024-06-18,1678.00,1619.00,1678.00,1603.00,65703",` +
+		`"2024-06-19,1600.01,1570.00,1619.48,1561.00,60911"]}}`
+	recordedMinute = `{"rc":0,"rt":17,"svr":177617938,"lt":1,"full":0,"dlmkts":"","data":{"code":"000001","market":0,` +
+		`"name":"平安银行","decimal":2,"dktotal":1152,"preKPrice":10.12,"klines":[` +
+		`"2024-06-19 10:00,10.12,10.18,10.20,10.10,215533",` +
+		`"2024-06-19 10:30,10.18,10.15,10.19,10.14,98312"]}}`
+	recordedNoData = `{"rc":102,"rt":17,"svr":177617938,"lt":1,"full":0,"dlmkts":"","data":null}`
+	recordedHFQ    = `var sh600519hfq={"total":3,"data":[{"d":"2024-06-19","f":"8.3932"},{"d":"2023-06-30","f":"8.1524"},` +
+		`{"d":"2001-08-27","f":"1.0000"}]}
+/* 2024-06-20 */`
+)
+
+// recordedServer serves body for every request and records the URLs
+// requested.
+func recordedServer(t *testing.T, status int, body string) (*HTTPProvider, *[]*url.URL) {
+	t.Helper()
+	var requests []*url.URL
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	p := NewHTTPProvider(server.Client())
+	p.ListURL = server.URL + "/api/qt/clist/get"
+	p.KLineURL = server.URL + "/api/qt/stock/kline/get"
+	p.FactorURL = server.URL + "/realstock/company/%s/hfq.js"
+	return p, &requests
+}
+
+func TestParseKLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want models.KLine
+		err  string
+	}{
+		{"2024-06-19,1600.01,1570.00,1619.48,1561.00,60911,9.6e9,1.12", models.KLine{
+			StockCode: "600519", Interval: "1d", Time: time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC),
+			Open: 1600.01, Close: 1570, High: 1619.48, Low: 1561, Volume: 60911,
+		}, ""},
+		{"2024-06-19 14:30,10.18,10.15,10.19,10.14,98312", models.KLine{
+			StockCode: "600519", Interval: "1d", Time: time.Date(2024, 6, 19, 14, 30, 0, 0, time.UTC),
+			Open: 10.18, Close: 10.15, High: 10.19, Low: 10.14, Volume: 98312,
+		}, ""},
+		{"2024-06-19,1600.01,1570.00,1619.48,1561.00", models.KLine{}, "malformed kline"},
+		{"19/06/2024,1600.01,1570.00,1619.48,1561.00,60911", models.KLine{}, "malformed kline time"},
+		{"2024-06-19,1600.01,-,1619.48,1561.00,60911", models.KLine{}, "malformed kline"},
+	}
+	for _, tt := range tests {
+		got, err := parseKLine("600519", "1d", tt.line)
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("parseKLine(%q) error %v, want %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want || got.Time.Location() != time.UTC {
+			t.Errorf("parseKLine(%q) = %+v, %v; want %+v", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+func TestListSecurities(t *testing.T) {
+	p, requests := recordedServer(t, http.StatusOK, recordedList)
+	stocks, err := p.ListSecurities(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Stock{
+		{Code: "000001", Name: "平安银行", Exchange: "SZ"},
+		{Code: "600519", Name: "贵州茅台", Exchange: "SH"},
+		{Code: "830799", Name: "艾融软件", Exchange: "BJ"},
+	}
+	if len(stocks) != len(want) {
+		t.Fatalf("got %d stocks, want %d", len(stocks), len(want))
+	}
+	for i := range want {
+		if stocks[i] != want[i] {
+			t.Errorf("stock %d = %+v, want %+v", i, stocks[i], want[i])
+		}
+	}
+	if query := (*requests)[0].Query(); len(*requests) != 1 || query.Get("fs") != aShareFilter || query.Get("pn") != "1" {
+		t.Errorf("requests %v, want one for the first page of A-shares", *requests)
+	}
+
+	stocks, err = p.ListSecurities(context.Background(), 2)
+	if err != nil || len(stocks) != 2 {
+		t.Errorf("limit 2: got %d stocks, %v", len(stocks), err)
+	}
+}
+
+func TestDailyBars(t *testing.T) {
+	p, requests := recordedServer(t, http.StatusOK, recordedDaily)
+	start := time.Date(2024, 6, 18, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC)
+	bars, err := p.DailyBars(context.Background(), "600519", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The source returns a bar before the range, which is dropped.
+	if len(bars) != 2 || !bars[0].Time.Equal(start) || !bars[1].Time.Equal(end) {
+		t.Fatalf("got bars %+v, want those of 06-18 and 06-19", bars)
+	}
+	if bar := bars[1]; bar.Interval != "1d" || bar.Open != 1600.01 || bar.Close != 1570 || bar.High != 1619.48 || bar.Low != 1561 || bar.Volume != 60911 {
+		t.Errorf("06-19 bar = %+v", bar)
+	}
+	query := (*requests)[0].Query()
+	if query.Get("secid") != "1.600519" || query.Get("klt") != "101" || query.Get("fqt") != "0" || query.Get("beg") != "20240618" || query.Get("end") != "20240619" {
+		t.Errorf("query %v, want unadjusted daily klines of 1.600519 for 20240618-20240619", query)
+	}
+}
+
+func TestMinuteBars(t *testing.T) {
+	p, requests := recordedServer(t, http.StatusOK, recordedMinute)
+	day := time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC)
+	bars, err := p.MinuteBars(context.Background(), "000001", 30, day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bars) != 2 || bars[0].Interval != "30m" || !bars[0].Time.Equal(day.Add(10*time.Hour)) || !bars[1].Time.Equal(day.Add(10*time.Hour+30*time.Minute)) {
+		t.Errorf("got bars %+v, want 30m bars ending 10:00 and 10:30 UTC", bars)
+	}
+	if query := (*requests)[0].Query(); query.Get("secid") != "0.000001" || query.Get("klt") != "30" {
+		t.Errorf("query %v, want 30 minute klines of 0.000001", query)
+	}
+	if _, err := p.MinuteBars(context.Background(), "000001", 10, day, day); err == nil {
+		t.Error("MinuteBars accepted a 10 minute period")
+	}
+}
+
+func TestKLineErrors(t *testing.T) {
+	day := time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		status int
+		body   string
+		check  func(error) bool
+	}{
+		{http.StatusOK, recordedNoData, func(err error) bool { return errors.Is(err, ErrNoData) }},
+		{http.StatusBadGateway, "bad gateway", func(err error) bool {
+			// The status is reported without the query string.
+			return strings.Contains(err.Error(), "502 Bad Gateway") && !strings.Contains(err.Error(), "secid")
+		}},
+		{http.StatusOK, `{"data":{"klines":["2024-06-19,1,2"]}}`, func(err error) bool { return strings.Contains(err.Error(), "malformed kline") }},
+		{http.StatusOK, `{"data":{"klines":["06/19/2024,1,2,3,4,5"]}}`, func(err error) bool { return strings.Contains(err.Error(), "malformed kline time") }},
+		{http.StatusOK, `<html>`, func(err error) bool { return strings.HasPrefix(err.Error(), "parse ") }},
+	}
+	for _, tt := range tests {
+		p, _ := recordedServer(t, tt.status, tt.body)
+		_, err := p.DailyBars(context.Background(), "600519", day, day)
+		if err == nil || !tt.check(err) {
+			t.Errorf("status %d body %.30q: error %v", tt.status, tt.body, err)
+		}
+	}
+}
+
+func TestAdjustFactors(t *testing.T) {
+	p, requests := recordedServer(t, http.StatusOK, recordedHFQ)
+	factors, err := p.AdjustFactors(context.Background(), "600519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := (*requests)[0].Path; path != "/realstock/company/sh600519/hfq.js" {
+		t.Errorf("requested %s, want the sh600519 factors", path)
+	}
+	want := []models.AdjustFactor{
+		{StockCode: "600519", Date: time.Date(2001, 8, 27, 0, 0, 0, 0, time.UTC), Factor: 1},
+		{StockCode: "600519", Date: time.Date(2023, 6, 30, 0, 0, 0, 0, time.UTC), Factor: 8.1524},
+		{StockCode: "600519", Date: time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC), Factor: 8.3932},
+	}
+	if len(factors) != len(want) {
+		t.Fatalf("got %d factors, want %d", len(factors), len(want))
+	}
+	for i := range want {
+		if factors[i] != want[i] {
+			t.Errorf("factor %d = %+v, want %+v", i, factors[i], want[i])
+		}
+	}
+
+	if _, err := p.AdjustFactors(context.Background(), "700001"); err == nil {
+		t.Error("AdjustFactors accepted a code of no exchange")
+	}
+	empty, _ := recordedServer(t, http.StatusOK, "var sh600519hfq=null")
+	if _, err := empty.AdjustFactors(context.Background(), "600519"); !errors.Is(err, ErrNoData) {
+		t.Errorf("empty script: error %v, want ErrNoData", err)
+	}
+	bad, _ := recordedServer(t, http.StatusOK, `var sh600519hfq={"data":[{"d":"2024/06/19","f":"8.3932"}]}`)
+	if _, err := bad.AdjustFactors(context.Background(), "600519"); err == nil || !strings.Contains(err.Error(), "malformed factor date") {
+		t.Errorf("bad date: error %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Package provider fetches securities, bars and adjustment factors from
+// market data sources.
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// ErrNoData marks a security the source has no data for.
+var ErrNoData = errors.New("no data for security")
+
+// DataProvider is a source of A-share market data. Bars carry raw
+// (unadjusted) prices with times as wall-clock values in UTC, the form the
+// server stores them in; adjustment factors are backward (hfq) factors.
+// Corporate actions are not provided: their source, CNINFO, signs requests
+// in browser script, so the AkShare sync script still fetches them.
+type DataProvider interface {
+	// ListSecurities returns listed stocks in code order, at most limit of
+	// them unless limit is zero.
+	ListSecurities(ctx context.Context, limit int) ([]models.Stock, error)
+	// DailyBars returns the 1d bars dated within [start, end].
+	DailyBars(ctx context.Context, code string, start, end time.Time) ([]models.KLine, error)
+	// MinuteBars returns the bars of the given minutes (1, 5, 15, 30 or 60)
+	// ending within [start, end].
+	MinuteBars(ctx context.Context, code string, minutes int, start, end time.Time) ([]models.KLine, error)
+	// AdjustFactors returns the stock's backward adjustment factors in date
+	// order.
+	AdjustFactors(ctx context.Context, code string) ([]models.AdjustFactor, error)
+}
+
+// Exchange infers a stock's exchange from its code: SH, SZ, BJ or empty.
+func Exchange(code string) string {
+	switch {
+	case strings.HasPrefix(code, "6"):
+		return "SH"
+	case strings.HasPrefix(code, "0"), strings.HasPrefix(code, "3"):
+		return "SZ"
+	case strings.HasPrefix(code, "8"), strings.HasPrefix(code, "4"), strings.HasPrefix(code, "9"):
+		return "BJ"
+	}
+	return ""
+}
+
+// MinutePeriods lists the minute bar sizes providers support.
+var MinutePeriods = []int{1, 5, 15, 30, 60}
+
+// ValidPeriod reports whether minutes is one of MinutePeriods.
+func ValidPeriod(minutes int) bool {
+	for _, period := range MinutePeriods {
+		if period == minutes {
+			return true
+		}
+	}
+	return false
+}
+
+// within filters bars to those with start <= time <= end.
+func within(bars []models.KLine, start, end time.Time) []models.KLine {
+	kept := bars[:0]
+	for _, bar := range bars {
+		if !bar.Time.Before(start) && !bar.Time.After(end) {
+			kept = append(kept, bar)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,144 @@
+// Package providertest serves market data in the wire formats of the
+// public sources, for testing code that syncs through the HTTP provider.
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/provider"
+)
+
+// Data is what a Fake serves. Bars are matched to requests by stock
+// code and interval.
+type Data struct {
+	Stocks  []models.Stock
+	Bars    []models.KLine
+	Factors []models.AdjustFactor
+}
+
+// Fake serves Data over a local httptest server in the wire formats
+// of the public sources, so the HTTP provider runs against it offline.
+type Fake struct {
+	*provider.HTTPProvider
+	Server *httptest.Server
+	data   Data
+}
+
+// NewFake starts a server for data and returns a provider reading from it.
+// Close stops the server.
+func NewFake(data Data) *Fake {
+	f := &Fake{data: data}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/qt/clist/get", f.serveList)
+	mux.HandleFunc("/api/qt/stock/kline/get", f.serveKLines)
+	mux.HandleFunc("/realstock/company/", f.serveFactors)
+	f.Server = httptest.NewServer(mux)
+	f.HTTPProvider = &provider.HTTPProvider{
+		Client:    f.Server.Client(),
+		ListURL:   f.Server.URL + "/api/qt/clist/get",
+		KLineURL:  f.Server.URL + "/api/qt/stock/kline/get",
+		FactorURL: f.Server.URL + "/realstock/company/%s/hfq.js",
+	}
+	return f
+}
+
+// Close shuts the server down.
+func (f *Fake) Close() {
+	f.Server.Close()
+}
+
+func (f *Fake) serveList(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("pn"))
+	size, _ := strconv.Atoi(r.URL.Query().Get("pz"))
+	if page < 1 || size < 1 {
+		http.Error(w, "bad page", http.StatusBadRequest)
+		return
+	}
+	stocks := append([]models.Stock(nil), f.data.Stocks...)
+	sort.Slice(stocks, func(i, j int) bool { return stocks[i].Code < stocks[j].Code })
+
+	type item struct {
+		Code string `json:"f12"`
+		Name string `json:"f14"`
+	}
+	diff := []item{}
+	for i := (page - 1) * size; i < page*size && i < len(stocks); i++ {
+		diff = append(diff, item{Code: stocks[i].Code, Name: stocks[i].Name})
+	}
+	writeJSON(w, map[string]any{"data": map[string]any{"total": len(stocks), "diff": diff}})
+}
+
+func (f *Fake) serveKLines(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	_, code, _ := strings.Cut(query.Get("secid"), ".")
+	interval := query.Get("klt") + "m"
+	layout := "2006-01-02 15:04"
+	if query.Get("klt") == "101" {
+		interval, layout = "1d", "2006-01-02"
+	}
+	begin, err1 := time.ParseInLocation("20060102", query.Get("beg"), time.UTC)
+	end, err2 := time.ParseInLocation("20060102", query.Get("end"), time.UTC)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad range", http.StatusBadRequest)
+		return
+	}
+	end = end.AddDate(0, 0, 1)
+
+	if !f.known(code) {
+		writeJSON(w, map[string]any{"data": nil})
+		return
+	}
+	klines := []string{}
+	for _, bar := range f.data.Bars {
+		if bar.StockCode != code || bar.Interval != interval || bar.Time.Before(begin) || !bar.Time.Before(end) {
+			continue
+		}
+		klines = append(klines, fmt.Sprintf("%s,%.2f,%.2f,%.2f,%.2f,%.0f",
+			bar.Time.Format(layout), bar.Open, bar.Close, bar.High, bar.Low, bar.Volume))
+	}
+	writeJSON(w, map[string]any{"data": map[string]any{"code": code, "klines": klines}})
+}
+
+func (f *Fake) serveFactors(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/realstock/company/"), "/hfq.js")
+	code := strings.TrimLeft(symbol, "abcdefghijklmnopqrstuvwxyz")
+	if !f.known(code) {
+		http.NotFound(w, r)
+		return
+	}
+	type item struct {
+		Date   string `json:"d"`
+		Factor string `json:"f"`
+	}
+	items := []item{}
+	for _, factor := range f.data.Factors {
+		if factor.StockCode == code {
+			items = append(items, item{Date: factor.Date.Format("2006-01-02"), Factor: strconv.FormatFloat(factor.Factor, 'f', 4, 64)})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Date > items[j].Date })
+	raw, _ := json.Marshal(map[string]any{"total": len(items), "data": items})
+	fmt.Fprintf(w, "var %shfq=%s\n/* fake */\n", symbol, raw)
+}
+
+func (f *Fake) known(code string) bool {
+	for _, stock := range f.data.Stocks {
+		if stock.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
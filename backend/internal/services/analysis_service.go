@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
 	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
@@ -10,28 +12,30 @@ import (
 
 // ScreeningResult represents a stock that satisfies a strategy.
 type ScreeningResult struct {
-	Stock   models.Stock        `json:"stock"`
-	Reason  string              `json:"reason"`
-	Metrics map[string]float64  `json:"metrics"`
+	Stock   models.Stock       `json:"stock"`
+	Reason  string             `json:"reason"`
+	Metrics map[string]float64 `json:"metrics"`
 }
 
 // BacktestResult packages the equity curve and summary.
 type BacktestResult struct {
-	Summary models.Backtest     `json:"summary"`
-	Points  []strategy.EquityPoint `json:"points"`
-	Trades  []strategy.Trade       `json:"trades"`
+	Summary   models.Backtest               `json:"summary"`
+	Points    []strategy.EquityPoint        `json:"points"`
+	Trades    []strategy.Trade              `json:"trades"`
+	Benchmark *strategy.BenchmarkComparison `json:"benchmark,omitempty"`
 }
 
 // AnalysisService performs screening and backtesting.
 type AnalysisService struct {
-	db       *gorm.DB
-	stocks   *StockService
+	db         *gorm.DB
+	stocks     *StockService
 	strategies *StrategyService
+	watchlists *WatchlistService
 }
 
 // NewAnalysisService creates an AnalysisService.
-func NewAnalysisService(db *gorm.DB, stocks *StockService, strategies *StrategyService) *AnalysisService {
-	return &AnalysisService{db: db, stocks: stocks, strategies: strategies}
+func NewAnalysisService(db *gorm.DB, stocks *StockService, strategies *StrategyService, watchlists *WatchlistService) *AnalysisService {
+	return &AnalysisService{db: db, stocks: stocks, strategies: strategies, watchlists: watchlists}
 }
 
 // Screen runs a strategy across all stocks and returns the matches.
@@ -49,7 +53,7 @@ func (a *AnalysisService) Screen(strategyID uint) ([]ScreeningResult, error) {
 	params := strategy.ParseMACrossoverParams(strategyModel.ParamsJSON)
 	var results []ScreeningResult
 	for _, stock := range stocks {
-		klines, err := a.stocks.GetKLines(stock.Code, "1d", 200)
+		klines, err := a.latestKLines(stock.Code, "1d", screenBars, strategy.AdjustForward)
 		if err != nil {
 			return nil, err
 		}
@@ -60,6 +64,7 @@ func (a *AnalysisService) Screen(strategyID uint) ([]ScreeningResult, error) {
 				Metrics: map[string]float64{
 					"short_window": float64(params.ShortWindow),
 					"long_window":  float64(params.LongWindow),
+					"spread_pct":   strategy.SpreadPct(klines, params),
 				},
 			})
 		}
@@ -68,44 +73,308 @@ func (a *AnalysisService) Screen(strategyID uint) ([]ScreeningResult, error) {
 	return results, nil
 }
 
-// RunBacktest performs a backtest for a given stock and strategy.
-func (a *AnalysisService) RunBacktest(strategyID uint, code string, initial float64) (*BacktestResult, error) {
-	strategyModel, err := a.strategies.Get(strategyID)
+// latestKLines returns a stock's newest limit bars in time order, adjusted
+// as mode asks.
+func (a *AnalysisService) latestKLines(code, interval string, limit int, mode strategy.AdjustMode) ([]models.KLine, error) {
+	klines, err := a.stocks.GetKLinesBefore(code, interval, time.Time{}, limit)
+	if err != nil || len(klines) == 0 || mode == strategy.AdjustNone {
+		return klines, err
+	}
+	factors, err := a.stocks.GetAdjustFactors(code)
 	if err != nil {
 		return nil, err
 	}
+	return strategy.AdjustKLines(klines, factors, mode), nil
+}
+
+// screenBars is the number of latest daily bars a screen looks at.
+const screenBars = 200
+
+// defaultWindowBars is the backtest window used when no start date is given.
+const defaultWindowBars = 1000
 
+// BacktestOptions carries parameters for a single-stock backtest.
+type BacktestOptions struct {
+	StrategyID     uint
+	StockCode      string
+	InitialCapital float64
+	// Interval is the kline interval to test on (default 1d).
+	Interval string
+	// SessionExit is hold, day_end or session_end; it only affects
+	// intraday intervals.
+	SessionExit string
+	// Adjust is the price adjustment signals are computed on: qfq (default),
+	// hfq or none. Fills always use raw prices.
+	Adjust string
+	// TaxFree skips the holding-period dividend tax.
+	TaxFree bool
+	// Start and End bound the reported window as start <= time < end. A zero
+	// Start means the latest defaultWindowBars bars; a zero End means now.
+	Start time.Time
+	End   time.Time
+	// WarmupBars overrides the number of bars fetched before Start to
+	// initialize indicators; zero derives it from the strategy and options.
+	WarmupBars int
+	Execution  string
+	Slippage   strategy.SlippageConfig
+	Exits      strategy.ExitConfig
+	Sizing     strategy.SizingConfig
+	// Margin enables financing and short selling.
+	Margin strategy.MarginConfig
+	// Benchmark is a kline code such as an index; empty means buy-and-hold
+	// of the backtested stock (or an equal-weight basket for portfolios).
+	Benchmark string
+	// CurveStorage saves the equity curve as rows (default), one
+	// compressed blob or downsampled rows; CurveMaxPoints bounds the
+	// downsampled curve (default 1000).
+	CurveStorage   string
+	CurveMaxPoints int
+	// Progress, when set, receives the engine's processed and total bars.
+	Progress func(done, total int) `json:"-"`
+}
+
+// engineOptions converts service options into engine options with defaults.
+func (o BacktestOptions) engineOptions() strategy.BacktestOptions {
+	initial := o.InitialCapital
 	if initial <= 0 {
 		initial = 100000
 	}
+	return strategy.BacktestOptions{
+		InitialCapital: initial,
+		Execution:      strategy.ParseExecutionMode(o.Execution),
+		Slippage:       o.Slippage,
+		Exits:          o.Exits,
+		Sizing:         o.Sizing,
+		Margin:         o.Margin,
+		Interval:       o.interval(),
+		SessionExit:    strategy.ParseSessionExit(o.SessionExit),
+		Adjust:         o.adjust(),
+		TaxFree:        o.TaxFree,
+		Progress:       o.Progress,
+	}
+}
+
+// adjust returns the adjustment mode, qfq unless another valid one is set.
+func (o BacktestOptions) adjust() strategy.AdjustMode {
+	mode, err := strategy.ParseAdjustMode(o.Adjust)
+	if err != nil || o.Adjust == "" {
+		return strategy.AdjustForward
+	}
+	return mode
+}
+
+func (o BacktestOptions) interval() string {
+	if o.Interval == "" {
+		return "1d"
+	}
+	return o.Interval
+}
 
-	klines, err := a.stocks.GetKLines(code, "1d", 1000)
+func (o BacktestOptions) warmup(engine strategy.BacktestOptions, params strategy.MACrossoverParams) int {
+	if o.WarmupBars > 0 {
+		return o.WarmupBars
+	}
+	return engine.WarmupBars(params)
+}
+
+// RunBacktest performs a backtest for a given stock and strategy. It stops
+// with ctx's error if ctx is done before the result is saved.
+func (a *AnalysisService) RunBacktest(ctx context.Context, options BacktestOptions) (*BacktestResult, error) {
+	strategyModel, err := a.strategies.Get(options.StrategyID)
 	if err != nil {
 		return nil, err
 	}
+
+	engine := options.engineOptions()
+	initial := engine.InitialCapital
+	code := options.StockCode
 	params := strategy.ParseMACrossoverParams(strategyModel.ParamsJSON)
-	final, points, trades := strategy.Backtest(klines, params, initial)
-	if len(klines) == 0 {
-		return nil, fmt.Errorf("no kline data for %s", code)
+
+	klines, reportFrom, err := a.loadWindow(code, options.interval(), options.Start, options.End, options.warmup(engine, params))
+	if err != nil {
+		return nil, err
+	}
+	engine.ReportFrom = reportFrom
+	if err := a.loadCorporateData(&engine, code); err != nil {
+		return nil, err
+	}
+	final, points, trades, err := strategy.BacktestContext(ctx, klines, params, engine)
+	if err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("no kline data for %s in the requested range", code)
 	}
 
 	summary := models.Backtest{
-		StrategyID:     strategyID,
-		StockCode:      code,
-		Start:          klines[0].Time,
-		End:            klines[len(klines)-1].Time,
-		InitialCapital: initial,
-		FinalCapital:   final,
-		ReturnPct:      (final - initial) / initial * 100,
+		StrategyID:      options.StrategyID,
+		StockCode:       code,
+		Interval:        options.interval(),
+		Execution:       string(engine.Execution),
+		Start:           points[0].Time,
+		End:             points[len(points)-1].Time,
+		InitialCapital:  initial,
+		FinalCapital:    final,
+		ReturnPct:       (final - initial) / initial * 100,
+		BacktestMetrics: strategy.ComputeMetrics(points, trades, initial, engine.BarsPerYear()),
+	}
+
+	benchmarkCode, benchmarkKLines := code, strategy.AdjustKLines(klines, engine.Factors[code], engine.Adjust)
+	if options.Benchmark != "" {
+		benchmarkCode = options.Benchmark
+		if benchmarkKLines, err = a.benchmarkKLines(benchmarkCode, options.interval(), klines[0].Time, options.End, engine.Adjust); err != nil {
+			return nil, err
+		}
 	}
+	benchmark := strategy.CompareBenchmark(benchmarkCode, points, benchmarkKLines, initial, engine.BarsPerYear())
+	summary.BenchmarkCode = benchmarkCode
+	summary.BenchmarkMetrics = benchmark.Metrics
 
-	if err := a.db.Create(&summary).Error; err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	if err := a.saveBacktest(&summary, points, trades, options); err != nil {
+		return nil, err
+	}
+
+	return &BacktestResult{Summary: summary, Points: points, Trades: trades, Benchmark: &benchmark}, nil
+}
+
+// saveBacktest persists a summary with its equity curve and trades in one
+// transaction, inserting rows in batches and storing the curve as the
+// options ask. Metrics are computed before saving, from the full curve.
+func (a *AnalysisService) saveBacktest(summary *models.Backtest, points []strategy.EquityPoint, trades []strategy.Trade, options BacktestOptions) error {
+	storage, err := ParseCurveStorage(options.CurveStorage)
+	if err != nil {
+		return err
+	}
+	summary.CurveStorage = storage
+	summary.CurvePoints = len(points)
+
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(summary).Error; err != nil {
+			return err
+		}
+
+		if storage == CurveBlob {
+			data, err := encodeCurve(points)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&models.BacktestCurve{BacktestID: summary.ID, Encoding: curveEncoding, Data: data}).Error; err != nil {
+				return err
+			}
+		} else {
+			if storage == CurveDownsample {
+				maxPoints := options.CurveMaxPoints
+				if maxPoints <= 0 {
+					maxPoints = defaultCurvePoints
+				}
+				points = downsampleCurve(points, maxPoints)
+			}
+			rows := make([]models.BacktestPoint, len(points))
+			for i, point := range points {
+				rows[i] = models.BacktestPoint{BacktestID: summary.ID, Time: point.Time, Equity: point.Equity}
+			}
+			// GORM rejects an empty batch.
+			if len(rows) > 0 {
+				if err := tx.CreateInBatches(rows, saveBatchSize).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		rows := make([]models.BacktestTrade, len(trades))
+		for i, trade := range trades {
+			rows[i] = models.BacktestTrade{
+				BacktestID: summary.ID,
+				StockCode:  trade.StockCode,
+				Time:       trade.Time,
+				Side:       trade.Side,
+				Price:      trade.Price,
+				Shares:     trade.Shares,
+				Slippage:   trade.Slippage,
+				Tax:        trade.Tax,
+				Reason:     trade.Reason,
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rows, saveBatchSize).Error
+	})
+}
+
+// loadWindow fetches a stock's bars for [start, end) plus warmup bars before
+// the window, and returns them with the time the reported window begins.
+// Without a start date the window is the latest defaultWindowBars bars; a
+// stock with no more bars than the warm-up yields no bars.
+func (a *AnalysisService) loadWindow(code, interval string, start, end time.Time, warmup int) ([]models.KLine, time.Time, error) {
+	if start.IsZero() {
+		klines, err := a.stocks.GetKLinesBefore(code, interval, end, defaultWindowBars+warmup)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if len(klines) <= warmup {
+			return nil, time.Time{}, nil
+		}
+		return klines, klines[warmup].Time, nil
+	}
 
-	for _, point := range points {
-		a.db.Create(&models.BacktestPoint{BacktestID: summary.ID, Time: point.Time, Equity: point.Equity})
+	warm, err := a.stocks.GetKLinesBefore(code, interval, start, warmup)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
+	window, err := a.stocks.GetKLinesBetween(code, interval, start, end)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return append(warm, window...), start, nil
+}
 
-	return &BacktestResult{Summary: summary, Points: points, Trades: trades}, nil
+// benchmarkKLines loads the bars of a benchmark code for [start, end),
+// adjusted as mode asks.
+func (a *AnalysisService) benchmarkKLines(code, interval string, start, end time.Time, mode strategy.AdjustMode) ([]models.KLine, error) {
+	klines, err := a.stocks.GetKLinesBetween(code, interval, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(klines) == 0 {
+		return nil, fmt.Errorf("no kline data for benchmark %s", code)
+	}
+	if mode == strategy.AdjustNone {
+		return klines, nil
+	}
+	factors, err := a.stocks.GetAdjustFactors(code)
+	if err != nil {
+		return nil, err
+	}
+	return strategy.AdjustKLines(klines, factors, mode), nil
+}
+
+// loadCorporateData loads the corporate actions of codes into the engine
+// options, and their adjustment factors unless adjustment is off.
+func (a *AnalysisService) loadCorporateData(engine *strategy.BacktestOptions, codes ...string) error {
+	engine.Actions = make(map[string][]models.CorporateAction, len(codes))
+	engine.Factors = make(map[string][]models.AdjustFactor, len(codes))
+	for _, code := range codes {
+		actions, err := a.stocks.GetCorporateActions(code)
+		if err != nil {
+			return err
+		}
+		if len(actions) > 0 {
+			engine.Actions[code] = actions
+		}
+		if engine.Adjust == strategy.AdjustNone {
+			continue
+		}
+		factors, err := a.stocks.GetAdjustFactors(code)
+		if err != nil {
+			return err
+		}
+		if len(factors) > 0 {
+			engine.Factors[code] = factors
+		}
+	}
+	return nil
 }
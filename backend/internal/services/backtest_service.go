@@ -0,0 +1,204 @@
+package services
+
+import (
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+	"gorm.io/gorm"
+)
+
+// BacktestFilter narrows the backtest history listing. From and To bound the
+// run time (CreatedAt); zero values are ignored.
+type BacktestFilter struct {
+	StrategyID uint
+	StockCode  string
+	From       time.Time
+	To         time.Time
+	Page       int
+	PageSize   int
+	Sort       string
+	Desc       bool
+}
+
+// BacktestPage is one page of backtest summaries.
+type BacktestPage struct {
+	Items    []models.Backtest `json:"items"`
+	Total    int64             `json:"total"`
+	Page     int               `json:"page"`
+	PageSize int               `json:"page_size"`
+}
+
+// backtestSortColumns whitelists the summary columns history can be sorted by.
+var backtestSortColumns = map[string]string{
+	"created_at":        "created_at",
+	"return_pct":        "return_pct",
+	"annual_return_pct": "annual_return_pct",
+	"sharpe":            "sharpe",
+	"max_drawdown_pct":  "max_drawdown_pct",
+	"alpha_pct":         "alpha_pct",
+}
+
+// BacktestService reads and deletes persisted backtest runs.
+type BacktestService struct {
+	db *gorm.DB
+}
+
+// NewBacktestService constructs a BacktestService.
+func NewBacktestService(db *gorm.DB) *BacktestService {
+	return &BacktestService{db: db}
+}
+
+// List returns a page of backtest summaries matching the filter. A stock
+// code matches single-stock runs on it and portfolio runs that include it.
+func (s *BacktestService) List(filter BacktestFilter) (*BacktestPage, error) {
+	if filter.Page <= 0 {
+		filter.Page = 1
+	}
+	if filter.PageSize <= 0 || filter.PageSize > 100 {
+		filter.PageSize = 20
+	}
+
+	query := s.db.Model(&models.Backtest{})
+	if filter.StrategyID > 0 {
+		query = query.Where("strategy_id = ?", filter.StrategyID)
+	}
+	if filter.StockCode != "" {
+		query = query.Where("stock_code = ? OR ',' || universe || ',' LIKE ?", filter.StockCode, "%,"+filter.StockCode+",%")
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	column, ok := backtestSortColumns[filter.Sort]
+	if !ok {
+		column = "created_at"
+		filter.Desc = true
+	}
+	order := column + " asc"
+	if filter.Desc {
+		order = column + " desc"
+	}
+
+	var items []models.Backtest
+	err := query.Order(order).Order("id desc").
+		Offset((filter.Page - 1) * filter.PageSize).
+		Limit(filter.PageSize).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return &BacktestPage{Items: items, Total: total, Page: filter.Page, PageSize: filter.PageSize}, nil
+}
+
+// Get returns a backtest summary with its equity curve and trades.
+func (s *BacktestService) Get(id uint) (*BacktestResult, error) {
+	var summary models.Backtest
+	if err := s.db.First(&summary, id).Error; err != nil {
+		return nil, err
+	}
+
+	points, err := s.curve(summary)
+	if err != nil {
+		return nil, err
+	}
+	var trades []models.BacktestTrade
+	if err := s.db.Where("backtest_id = ?", id).Order("time asc, id asc").Find(&trades).Error; err != nil {
+		return nil, err
+	}
+
+	result := &BacktestResult{
+		Summary: summary,
+		Points:  points,
+		Trades:  make([]strategy.Trade, 0, len(trades)),
+	}
+	for _, trade := range trades {
+		result.Trades = append(result.Trades, strategy.Trade{
+			StockCode: trade.StockCode,
+			Time:      trade.Time,
+			Side:      trade.Side,
+			Price:     trade.Price,
+			Shares:    trade.Shares,
+			Slippage:  trade.Slippage,
+			Tax:       trade.Tax,
+			Reason:    trade.Reason,
+		})
+	}
+	return result, nil
+}
+
+// curve loads a backtest's equity curve from its points or its blob.
+func (s *BacktestService) curve(summary models.Backtest) ([]strategy.EquityPoint, error) {
+	if summary.CurveStorage == CurveBlob {
+		var curve models.BacktestCurve
+		if err := s.db.Where("backtest_id = ?", summary.ID).First(&curve).Error; err != nil {
+			return nil, err
+		}
+		return decodeCurve(curve)
+	}
+
+	var rows []models.BacktestPoint
+	if err := s.db.Where("backtest_id = ?", summary.ID).Order("time asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	points := make([]strategy.EquityPoint, 0, len(rows))
+	for _, row := range rows {
+		points = append(points, strategy.EquityPoint{Time: row.Time, Equity: row.Equity})
+	}
+	return points, nil
+}
+
+// MonteCarlo resamples the closed trades of a saved backtest.
+func (s *BacktestService) MonteCarlo(id uint, options strategy.MonteCarloOptions) (*strategy.MonteCarloResult, error) {
+	saved, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	initial := saved.Summary.InitialCapital
+	returns := strategy.TradeEquityReturns(saved.Points, saved.Trades, initial)
+	options.Initial = initial
+	options.Bars = len(saved.Points)
+	if saved.Summary.CurvePoints > 0 {
+		// A downsampled curve is shorter than the run.
+		options.Bars = saved.Summary.CurvePoints
+	}
+	options.BarsPerYear = strategy.BarsPerYear(saved.Summary.Interval)
+
+	result, err := strategy.MonteCarlo(returns, options)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Delete removes a backtest together with its curve and trades.
+func (s *BacktestService) Delete(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("backtest_id = ?", id).Delete(&models.BacktestPoint{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("backtest_id = ?", id).Delete(&models.BacktestCurve{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("backtest_id = ?", id).Delete(&models.BacktestTrade{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.Backtest{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+	"gorm.io/gorm"
+)
+
+// seedHistory saves five backtest summaries run on consecutive days; the
+// second and fourth are portfolio runs.
+func seedHistory(t *testing.T, database *gorm.DB) {
+	t.Helper()
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 9, 0, 0, 0, time.UTC) }
+	summaries := []models.Backtest{
+		{ID: 1, StrategyID: 1, StockCode: "600519", ReturnPct: 5, BacktestMetrics: models.BacktestMetrics{Sharpe: 1}, CreatedAt: day(1)},
+		{ID: 2, StrategyID: 1, Universe: "600519,000001", ReturnPct: -2, BacktestMetrics: models.BacktestMetrics{Sharpe: 0.5}, CreatedAt: day(2)},
+		{ID: 3, StrategyID: 2, StockCode: "000001", ReturnPct: 10, BacktestMetrics: models.BacktestMetrics{Sharpe: 2}, CreatedAt: day(3)},
+		{ID: 4, StrategyID: 2, Universe: "000001,600518", ReturnPct: 0, CreatedAt: day(4)},
+		{ID: 5, StrategyID: 1, StockCode: "600519", ReturnPct: 3, BacktestMetrics: models.BacktestMetrics{Sharpe: -1}, CreatedAt: day(5)},
+	}
+	if err := database.Create(&summaries).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pageIDs(page *BacktestPage) []uint {
+	ids := make([]uint, len(page.Items))
+	for i, item := range page.Items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func TestListBacktests(t *testing.T) {
+	database := memoryDB(t)
+	seedHistory(t, database)
+	backtests := NewBacktestService(database)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		filter BacktestFilter
+		ids    []uint
+		total  int64
+	}{
+		{"newest first", BacktestFilter{}, []uint{5, 4, 3, 2, 1}, 5},
+		{"strategy", BacktestFilter{StrategyID: 2}, []uint{4, 3}, 2},
+		// Portfolio runs match the codes of their universe, whole codes only.
+		{"stock code", BacktestFilter{StockCode: "600519"}, []uint{5, 2, 1}, 3},
+		{"run dates", BacktestFilter{From: day(2), To: day(4)}, []uint{3, 2}, 2},
+		{"combined", BacktestFilter{StrategyID: 1, StockCode: "600519", From: day(2)}, []uint{5, 2}, 2},
+		{"return ascending", BacktestFilter{Sort: "return_pct"}, []uint{2, 4, 5, 1, 3}, 5},
+		{"sharpe descending", BacktestFilter{Sort: "sharpe", Desc: true}, []uint{3, 1, 2, 4, 5}, 5},
+		// A column outside the whitelist never reaches the query.
+		{"injected sort", BacktestFilter{Sort: "return_pct; DROP TABLE backtests"}, []uint{5, 4, 3, 2, 1}, 5},
+		{"unknown sort", BacktestFilter{Sort: "initial_capital"}, []uint{5, 4, 3, 2, 1}, 5},
+		{"second page", BacktestFilter{Page: 2, PageSize: 2}, []uint{3, 2}, 5},
+		{"last page", BacktestFilter{Page: 3, PageSize: 2}, []uint{1}, 5},
+		{"past the end", BacktestFilter{Page: 4, PageSize: 2}, []uint{}, 5},
+	}
+	for _, tt := range tests {
+		page, err := backtests.List(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ids := pageIDs(page); !reflect.DeepEqual(ids, tt.ids) || page.Total != tt.total {
+			t.Errorf("%s: got %v of %d, want %v of %d", tt.name, ids, page.Total, tt.ids, tt.total)
+		}
+	}
+
+	// Out-of-range pages and sizes fall back to the first page of 20.
+	for _, filter := range []BacktestFilter{{Page: -1, PageSize: -5}, {PageSize: 101}} {
+		page, err := backtests.List(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Page != 1 || page.PageSize != 20 || len(page.Items) != 5 {
+			t.Errorf("page %d size %d: got page %d of size %d with %d items, want page 1 of 20 with 5",
+				filter.Page, filter.PageSize, page.Page, page.PageSize, len(page.Items))
+		}
+	}
+}
+
+// TestDeleteBacktest checks that deleting a backtest removes its curve
+// rows or blob and its trades and leaves other runs intact.
+func TestDeleteBacktest(t *testing.T) {
+	database := memoryDB(t)
+	analysis := newAnalysisService(database)
+	backtests := NewBacktestService(database)
+
+	points := curvePoints(100000, 101000, 100500)
+	trades := []strategy.Trade{
+		{StockCode: "600519", Time: points[0].Time, Side: strategy.SideBuy, Price: 10, Shares: 100},
+		{StockCode: "600519", Time: points[2].Time, Side: strategy.SideSell, Price: 10.5, Shares: 100},
+	}
+	saved := make(map[string]uint)
+	for _, storage := range []string{CurveRows, CurveBlob, CurveRows} {
+		summary := models.Backtest{StockCode: "600519", InitialCapital: 100000}
+		if err := analysis.saveBacktest(&summary, points, trades, BacktestOptions{CurveStorage: storage}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := saved[storage]; !ok {
+			saved[storage] = summary.ID
+		}
+	}
+
+	counts := func() [4]int64 {
+		var c [4]int64
+		database.Model(&models.Backtest{}).Count(&c[0])
+		database.Model(&models.BacktestPoint{}).Count(&c[1])
+		database.Model(&models.BacktestCurve{}).Count(&c[2])
+		database.Model(&models.BacktestTrade{}).Count(&c[3])
+		return c
+	}
+	if got := counts(); got != [4]int64{3, 6, 1, 6} {
+		t.Fatalf("saved backtests, points, curves and trades %v, want [3 6 1 6]", got)
+	}
+
+	for _, tt := range []struct {
+		storage string
+		want    [4]int64
+	}{
+		{CurveRows, [4]int64{2, 3, 1, 4}},
+		{CurveBlob, [4]int64{1, 3, 0, 2}},
+	} {
+		id := saved[tt.storage]
+		if err := backtests.Delete(id); err != nil {
+			t.Fatalf("%s: %v", tt.storage, err)
+		}
+		if got := counts(); got != tt.want {
+			t.Errorf("%s: left backtests, points, curves and trades %v, want %v", tt.storage, got, tt.want)
+		}
+		if _, err := backtests.Get(id); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("%s: Get after delete: %v, want ErrRecordNotFound", tt.storage, err)
+		}
+		if err := backtests.Delete(id); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("%s: second delete: %v, want ErrRecordNotFound", tt.storage, err)
+		}
+	}
+
+	// The remaining run still loads in full.
+	left, err := backtests.Get(saved[CurveRows] + 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCurve(t, "remaining", left.Points, points)
+	if len(left.Trades) != 2 || left.Trades[1].Side != strategy.SideSell || left.Trades[1].Price != 10.5 {
+		t.Errorf("remaining trades %+v, want the buy and the sale", left.Trades)
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+// Curve storage modes of saved backtests.
+const (
+	// CurveRows stores one BacktestPoint row per equity point (the default).
+	CurveRows = "rows"
+	// CurveBlob stores the whole curve compressed in one BacktestCurve row.
+	CurveBlob = "blob"
+	// CurveDownsample stores at most CurveMaxPoints BacktestPoint rows.
+	CurveDownsample = "downsample"
+)
+
+// curveEncoding is the only BacktestCurve encoding: gzip-compressed JSON
+// of the equity points.
+const curveEncoding = "gzip+json"
+
+// defaultCurvePoints is the downsampled curve size used when none is given.
+const defaultCurvePoints = 1000
+
+// saveBatchSize is the number of rows per INSERT when saving a backtest.
+const saveBatchSize = 500
+
+// ParseCurveStorage normalizes a curve storage mode, rows by default.
+func ParseCurveStorage(raw string) (string, error) {
+	switch raw {
+	case "":
+		return CurveRows, nil
+	case CurveRows, CurveBlob, CurveDownsample:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("unknown curve storage %q", raw)
+	}
+}
+
+// encodeCurve compresses points into a BacktestCurve payload.
+func encodeCurve(points []strategy.EquityPoint) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(points); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeCurve restores the points of a BacktestCurve.
+func decodeCurve(curve models.BacktestCurve) ([]strategy.EquityPoint, error) {
+	if curve.Encoding != curveEncoding {
+		return nil, fmt.Errorf("unknown curve encoding %q", curve.Encoding)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(curve.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	var points []strategy.EquityPoint
+	if err := json.Unmarshal(raw, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// downsampleCurve keeps at most max points (at least 4): the first and the
+// last, and between them the lowest and highest equity of equal time
+// slices in time order, so peaks and drawdowns survive.
+func downsampleCurve(points []strategy.EquityPoint, max int) []strategy.EquityPoint {
+	if max < 4 {
+		max = 4
+	}
+	if len(points) <= max {
+		return points
+	}
+
+	inner := points[1 : len(points)-1]
+	buckets := (max - 2) / 2
+	result := make([]strategy.EquityPoint, 0, max)
+	result = append(result, points[0])
+	for k := 0; k < buckets; k++ {
+		from, to := k*len(inner)/buckets, (k+1)*len(inner)/buckets
+		low, high := from, from
+		for i := from + 1; i < to; i++ {
+			if inner[i].Equity < inner[low].Equity {
+				low = i
+			}
+			if inner[i].Equity > inner[high].Equity {
+				high = i
+			}
+		}
+		switch {
+		case low == high:
+			result = append(result, inner[low])
+		case low < high:
+			result = append(result, inner[low], inner[high])
+		default:
+			result = append(result, inner[high], inner[low])
+		}
+	}
+	return append(result, points[len(points)-1])
+}
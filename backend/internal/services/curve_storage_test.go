@@ -0,0 +1,112 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/db"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+// curvePoints returns daily points with the given equities.
+func curvePoints(equities ...float64) []strategy.EquityPoint {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := make([]strategy.EquityPoint, len(equities))
+	for i, equity := range equities {
+		points[i] = strategy.EquityPoint{Time: start.AddDate(0, 0, i), Equity: equity}
+	}
+	return points
+}
+
+func checkCurve(t *testing.T, name string, got, want []strategy.EquityPoint) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d points, want %d: %v", name, len(got), len(want), got)
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Equity != want[i].Equity {
+			t.Errorf("%s: point %d = %v, want %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestDownsampleCurve(t *testing.T) {
+	points := curvePoints(100, 104, 98, 101, 103, 99, 107, 102, 105, 106)
+	// Eight inner points in two slices: 98 and 104 in the first, 99 and
+	// 107 in the second, kept in time order between the ends.
+	checkCurve(t, "6 points", downsampleCurve(points, 6), []strategy.EquityPoint{points[0], points[1], points[2], points[5], points[6], points[9]})
+	// Below four points the floor of four keeps one slice.
+	checkCurve(t, "floor", downsampleCurve(points, 1), []strategy.EquityPoint{points[0], points[2], points[6], points[9]})
+	checkCurve(t, "short", downsampleCurve(points, 10), points)
+}
+
+func TestCurveBlob(t *testing.T) {
+	points := curvePoints(100000, 100250.5, 99875.25, 101000.125)
+	data, err := encodeCurve(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeCurve(models.BacktestCurve{Encoding: curveEncoding, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCurve(t, "blob", decoded, points)
+
+	if _, err := decodeCurve(models.BacktestCurve{Encoding: "zstd", Data: data}); err == nil {
+		t.Error("unknown encoding: want an error")
+	}
+}
+
+// TestSaveBacktestCurve saves one curve in every storage mode and loads
+// it back through BacktestService.
+func TestSaveBacktestCurve(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "stocks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	analysis := NewAnalysisService(database, NewStockService(database), NewStrategyService(database), NewWatchlistService(database))
+	backtests := NewBacktestService(database)
+
+	equities := make([]float64, 50)
+	for i := range equities {
+		equities[i] = 100000 + float64((i*37)%23)*100
+	}
+	points := curvePoints(equities...)
+	trades := []strategy.Trade{{StockCode: "600519", Time: points[3].Time, Side: strategy.SideBuy, Price: 10, Shares: 100}}
+
+	tests := []struct {
+		storage   string
+		maxPoints int
+		want      []strategy.EquityPoint
+	}{
+		{CurveRows, 0, points},
+		{CurveBlob, 0, points},
+		{CurveDownsample, 10, downsampleCurve(points, 10)},
+	}
+	for _, tt := range tests {
+		summary := models.Backtest{StockCode: "600519", InitialCapital: 100000}
+		if err := analysis.saveBacktest(&summary, points, trades, BacktestOptions{CurveStorage: tt.storage, CurveMaxPoints: tt.maxPoints}); err != nil {
+			t.Fatalf("%s: %v", tt.storage, err)
+		}
+		saved, err := backtests.Get(summary.ID)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.storage, err)
+		}
+		if saved.Summary.CurveStorage != tt.storage || saved.Summary.CurvePoints != len(points) {
+			t.Errorf("%s: saved as %s with %d curve points, want %d", tt.storage, saved.Summary.CurveStorage, saved.Summary.CurvePoints, len(points))
+		}
+		checkCurve(t, tt.storage, saved.Points, tt.want)
+		if len(saved.Trades) != 1 || saved.Trades[0].Shares != 100 {
+			t.Errorf("%s: trades %+v, want the one buy", tt.storage, saved.Trades)
+		}
+	}
+
+	var rows, curves int64
+	database.Model(&models.BacktestPoint{}).Count(&rows)
+	database.Model(&models.BacktestCurve{}).Count(&curves)
+	if rows != 50+10 || curves != 1 {
+		t.Errorf("%d point rows and %d curve blobs, want 60 and 1", rows, curves)
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+// DiagnosticsOptions carries an optimization to check for overfitting.
+type DiagnosticsOptions struct {
+	OptimizeOptions
+	// Partitions is the number of CSCV blocks, even and at most 16 (default 10).
+	Partitions int
+	// RandomTrials is the number of random-entry backtests (default 1000).
+	RandomTrials int
+	// Seed makes the random entries reproducible; zero seeds from the clock.
+	Seed int64
+}
+
+// DiagnosticsResult reports how much of an optimization's best result is
+// explained by trying many configurations.
+type DiagnosticsResult struct {
+	Objective      string                   `json:"objective"`
+	Tried          int                      `json:"tried"`
+	Best           strategy.OptimizationRun `json:"best"`
+	DeflatedSharpe *strategy.DeflatedSharpe `json:"deflated_sharpe,omitempty"`
+	PBO            *strategy.PBOResult      `json:"pbo,omitempty"`
+	RandomBaseline *strategy.RandomBaseline `json:"random_baseline,omitempty"`
+	// Notes explains diagnostics that could not be computed.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// Diagnose runs the optimization grid and computes the deflated Sharpe
+// ratio and CSCV probability of backtest overfitting across every tried
+// configuration. Single-stock searches also compare the best configuration
+// with random entries taking the same number of trades.
+func (a *AnalysisService) Diagnose(ctx context.Context, options DiagnosticsOptions) (*DiagnosticsResult, error) {
+	search, prepared, err := a.searchGrid(ctx, options.OptimizeOptions)
+	if err != nil {
+		return nil, err
+	}
+	partitions := options.Partitions
+	if partitions == 0 {
+		partitions = 10
+	}
+
+	result := &DiagnosticsResult{
+		Objective: search.Objective,
+		Tried:     search.Tried,
+		Best:      search.Runs[0],
+	}
+	note := func(err error) {
+		result.Notes = append(result.Notes, err.Error())
+	}
+
+	if deflated, err := strategy.ComputeDeflatedSharpe(search.Runs, prepared.initial, prepared.engine.BarsPerYear()); err != nil {
+		note(err)
+	} else {
+		result.DeflatedSharpe = &deflated
+	}
+	if pbo, err := strategy.ComputePBO(search.Runs, prepared.initial, partitions); err != nil {
+		note(err)
+	} else {
+		result.PBO = &pbo
+	}
+
+	if prepared.klines == nil {
+		result.Notes = append(result.Notes, "random-entry baseline is only available for single-stock searches")
+		return result, nil
+	}
+	baseline, err := strategy.CompareRandomEntries(ctx, prepared.klines, result.Best, prepared.engine, options.RandomTrials, options.Seed)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		note(err)
+	} else {
+		result.RandomBaseline = &baseline
+	}
+	return result, nil
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"database/sql"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/tabular"
+	"gorm.io/gorm"
+)
+
+// KLineExportFilter selects the klines to export; empty fields select all.
+// End is exclusive.
+type KLineExportFilter struct {
+	Code     string
+	Interval string
+	Start    time.Time
+	End      time.Time
+}
+
+var klineExportColumns = []tabular.Column{
+	{Name: "code", Type: tabular.String},
+	{Name: "interval", Type: tabular.String},
+	{Name: "time", Type: tabular.Time},
+	{Name: "open", Type: tabular.Float},
+	{Name: "high", Type: tabular.Float},
+	{Name: "low", Type: tabular.Float},
+	{Name: "close", Type: tabular.Float},
+	{Name: "volume", Type: tabular.Float},
+}
+
+var pointExportColumns = []tabular.Column{
+	{Name: "time", Type: tabular.Time},
+	{Name: "equity", Type: tabular.Float},
+}
+
+var tradeExportColumns = []tabular.Column{
+	{Name: "code", Type: tabular.String},
+	{Name: "time", Type: tabular.Time},
+	{Name: "side", Type: tabular.String},
+	{Name: "price", Type: tabular.Float},
+	{Name: "shares", Type: tabular.Float},
+	{Name: "slippage", Type: tabular.Float},
+	{Name: "tax", Type: tabular.Float},
+	{Name: "reason", Type: tabular.String},
+}
+
+// ExportKLines writes the raw klines matching the filter, ordered by code,
+// interval and time, and returns how many it wrote. Rows are streamed from
+// the database, so the whole table can be exported.
+func (s *StockService) ExportKLines(out io.Writer, format string, filter KLineExportFilter) (int, error) {
+	query := s.db.Model(&models.KLine{})
+	if filter.Code != "" {
+		query = query.Where("stock_code = ?", filter.Code)
+	}
+	if filter.Interval != "" {
+		query = query.Where("interval = ?", filter.Interval)
+	}
+	if !filter.Start.IsZero() {
+		query = query.Where("time >= ?", filter.Start)
+	}
+	if !filter.End.IsZero() {
+		query = query.Where("time < ?", filter.End)
+	}
+
+	return exportRows(query.Order("stock_code asc, interval asc, time asc"), out, format, klineExportColumns, func(rows *sql.Rows) ([]any, error) {
+		var bar models.KLine
+		if err := s.db.ScanRows(rows, &bar); err != nil {
+			return nil, err
+		}
+		return []any{bar.StockCode, bar.Interval, bar.Time, bar.Open, bar.High, bar.Low, bar.Close, bar.Volume}, nil
+	})
+}
+
+// ExportPoints writes a saved backtest's equity curve as stored, which is
+// downsampled for runs saved that way.
+func (s *BacktestService) ExportPoints(out io.Writer, format string, id uint) (int, error) {
+	var summary models.Backtest
+	if err := s.db.First(&summary, id).Error; err != nil {
+		return 0, err
+	}
+
+	if summary.CurveStorage == CurveBlob {
+		// The blob is decoded whole; it is one run's curve.
+		points, err := s.curve(summary)
+		if err != nil {
+			return 0, err
+		}
+		writer, err := tabular.NewWriter(out, format, pointExportColumns)
+		if err != nil {
+			return 0, err
+		}
+		for _, point := range points {
+			if err := writer.Write([]any{point.Time, point.Equity}); err != nil {
+				return 0, err
+			}
+		}
+		return len(points), writer.Close()
+	}
+
+	query := s.db.Model(&models.BacktestPoint{}).Where("backtest_id = ?", id).Order("time asc")
+	return exportRows(query, out, format, pointExportColumns, func(rows *sql.Rows) ([]any, error) {
+		var point models.BacktestPoint
+		if err := s.db.ScanRows(rows, &point); err != nil {
+			return nil, err
+		}
+		return []any{point.Time, point.Equity}, nil
+	})
+}
+
+// ExportTrades writes a saved backtest's trades in time order.
+func (s *BacktestService) ExportTrades(out io.Writer, format string, id uint) (int, error) {
+	if err := s.db.Select("id").First(&models.Backtest{}, id).Error; err != nil {
+		return 0, err
+	}
+
+	query := s.db.Model(&models.BacktestTrade{}).Where("backtest_id = ?", id).Order("time asc, id asc")
+	return exportRows(query, out, format, tradeExportColumns, func(rows *sql.Rows) ([]any, error) {
+		var trade models.BacktestTrade
+		if err := s.db.ScanRows(rows, &trade); err != nil {
+			return nil, err
+		}
+		return []any{trade.StockCode, trade.Time, trade.Side, trade.Price, trade.Shares, trade.Slippage, trade.Tax, trade.Reason}, nil
+	})
+}
+
+// ExportScreen runs a strategy's screen and writes the matches, one column
+// per metric any match reports; a match lacking a metric leaves it empty.
+func (a *AnalysisService) ExportScreen(out io.Writer, format string, strategyID uint) (int, error) {
+	results, err := a.Screen(strategyID)
+	if err != nil {
+		return 0, err
+	}
+
+	var metrics []string
+	seen := make(map[string]bool)
+	for _, result := range results {
+		for name := range result.Metrics {
+			if !seen[name] {
+				seen[name] = true
+				metrics = append(metrics, name)
+			}
+		}
+	}
+	sort.Strings(metrics)
+
+	columns := []tabular.Column{
+		{Name: "code", Type: tabular.String},
+		{Name: "name", Type: tabular.String},
+		{Name: "exchange", Type: tabular.String},
+		{Name: "reason", Type: tabular.String},
+	}
+	for _, name := range metrics {
+		columns = append(columns, tabular.Column{Name: name, Type: tabular.Float})
+	}
+	writer, err := tabular.NewWriter(out, format, columns)
+	if err != nil {
+		return 0, err
+	}
+	for _, result := range results {
+		row := []any{result.Stock.Code, result.Stock.Name, result.Stock.Exchange, result.Reason}
+		for _, name := range metrics {
+			value, ok := result.Metrics[name]
+			if !ok {
+				value = math.NaN()
+			}
+			row = append(row, value)
+		}
+		if err := writer.Write(row); err != nil {
+			return 0, err
+		}
+	}
+	return len(results), writer.Close()
+}
+
+// exportRows streams the rows of query into a table of columns, converting
+// each with row.
+func exportRows(query *gorm.DB, out io.Writer, format string, columns []tabular.Column, row func(*sql.Rows) ([]any, error)) (int, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	writer, err := tabular.NewWriter(out, format, columns)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for rows.Next() {
+		values, err := row(rows)
+		if err != nil {
+			return count, err
+		}
+		if err := writer.Write(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, writer.Close()
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/db"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/tabular"
+)
+
+// TestExportScreen screens stocks with more history than a screen reads:
+// 600000 crosses on its latest bar, 000002 only on the last bar of its
+// oldest screenBars.
+func TestExportScreen(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "screen.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stocks := NewStockService(database)
+	strategies := NewStrategyService(database)
+	analysis := NewAnalysisService(database, stocks, strategies, NewWatchlistService(database))
+
+	model := models.Strategy{Name: "MA", Type: "ma_crossover", ParamsJSON: `{"short_window":5,"long_window":20}`}
+	if err := strategies.Create(&model); err != nil {
+		t.Fatal(err)
+	}
+	const days = screenBars + 50
+	for code, spike := range map[string]int{"600000": days - 1, "000002": screenBars - 1} {
+		if err := stocks.UpsertStock(models.Stock{Code: code, Name: "股票" + code, Exchange: "SH"}); err != nil {
+			t.Fatal(err)
+		}
+		var bars []models.KLine
+		for i := 0; i < days; i++ {
+			price := 10 - float64(i)*0.01
+			if i == spike {
+				price = 20
+			}
+			bars = append(bars, models.KLine{StockCode: code, Interval: "1d", Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i), Open: price, High: price, Low: price, Close: price, Volume: 1000})
+		}
+		if err := stocks.SaveKLines(bars); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := analysis.ExportScreen(&buf, tabular.CSV, model.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(records) != 2 {
+		t.Fatalf("%d matches, records %q; want 600000 alone", n, records)
+	}
+	header := []string{"code", "name", "exchange", "reason", "long_window", "short_window", "spread_pct"}
+	if !reflect.DeepEqual(records[0], header) {
+		t.Errorf("header %q, want %q", records[0], header)
+	}
+	if got := records[1][:6]; !reflect.DeepEqual(got, []string{"600000", "股票600000", "SH", "MA5/MA20 上穿", "20", "5"}) {
+		t.Errorf("match %q, want 600000 crossing on its latest bar", records[1])
+	}
+}
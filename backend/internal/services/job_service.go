@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+	// JobInterrupted marks jobs that were queued or running when the server stopped.
+	JobInterrupted = "interrupted"
+)
+
+var (
+	// ErrQueueFull is returned when no more jobs can wait for a worker.
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrJobFinished is returned when canceling a job that already ended.
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// jobRunner executes a job and returns the ID of the saved backtest.
+type jobRunner func(ctx context.Context, progress func(done, total int)) (uint, error)
+
+type jobTask struct {
+	id  uint
+	ctx context.Context
+	run jobRunner
+}
+
+// JobService runs backtests asynchronously on a bounded worker pool and
+// records their state in the jobs table.
+type JobService struct {
+	db       *gorm.DB
+	analysis *AnalysisService
+	queue    chan jobTask
+
+	mu      sync.Mutex
+	cancels map[uint]context.CancelFunc
+}
+
+// NewJobService marks jobs left unfinished by a previous process as
+// interrupted and starts the given number of workers.
+func NewJobService(db *gorm.DB, analysis *AnalysisService, workers, queueSize int) *JobService {
+	s := &JobService{
+		db:       db,
+		analysis: analysis,
+		queue:    make(chan jobTask, queueSize),
+		cancels:  make(map[uint]context.CancelFunc),
+	}
+
+	err := db.Model(&models.Job{}).
+		Where("status IN ?", []string{JobQueued, JobRunning}).
+		Updates(map[string]any{"status": JobInterrupted, "error": "server restarted", "finished_at": time.Now()}).Error
+	if err != nil {
+		log.Printf("failed to mark interrupted jobs: %v", err)
+	}
+
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// EnqueueBacktest queues a single-stock backtest.
+func (s *JobService) EnqueueBacktest(options BacktestOptions) (*models.Job, error) {
+	return s.enqueue("backtest", options, func(ctx context.Context, progress func(done, total int)) (uint, error) {
+		options.Progress = progress
+		result, err := s.analysis.RunBacktest(ctx, options)
+		if err != nil {
+			return 0, err
+		}
+		return result.Summary.ID, nil
+	})
+}
+
+// EnqueuePortfolioBacktest queues a portfolio backtest.
+func (s *JobService) EnqueuePortfolioBacktest(options PortfolioBacktestOptions) (*models.Job, error) {
+	return s.enqueue("portfolio_backtest", options, func(ctx context.Context, progress func(done, total int)) (uint, error) {
+		options.Progress = progress
+		result, err := s.analysis.RunPortfolioBacktest(ctx, options)
+		if err != nil {
+			return 0, err
+		}
+		return result.Summary.ID, nil
+	})
+}
+
+// Get returns a job by ID.
+func (s *JobService) Get(id uint) (*models.Job, error) {
+	var job models.Job
+	if err := s.db.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Cancel stops a queued or running job. A queued job is marked canceled
+// immediately; a running job is marked by its worker once the engine stops.
+func (s *JobService) Cancel(id uint) (*models.Job, error) {
+	job, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != JobQueued && job.Status != JobRunning {
+		return job, ErrJobFinished
+	}
+
+	s.mu.Lock()
+	cancel := s.cancels[id]
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+
+	err = s.db.Model(&models.Job{}).
+		Where("id = ? AND status = ?", id, JobQueued).
+		Updates(map[string]any{"status": JobCanceled, "finished_at": time.Now()}).Error
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(id)
+}
+
+func (s *JobService) enqueue(kind string, params any, run jobRunner) (*models.Job, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.Job{Kind: kind, Status: JobQueued, ParamsJSON: string(raw)}
+	if err := s.db.Create(job).Error; err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancels[job.ID] = cancel
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- jobTask{id: job.ID, ctx: ctx, run: run}:
+		return job, nil
+	default:
+		s.forget(job.ID)
+		s.db.Delete(job)
+		return nil, ErrQueueFull
+	}
+}
+
+func (s *JobService) work() {
+	for task := range s.queue {
+		s.execute(task)
+	}
+}
+
+func (s *JobService) execute(task jobTask) {
+	defer s.forget(task.id)
+
+	// The conditional update loses against a cancel that arrived while queued.
+	started := s.db.Model(&models.Job{}).
+		Where("id = ? AND status = ?", task.id, JobQueued).
+		Updates(map[string]any{"status": JobRunning, "started_at": time.Now()})
+	if started.Error != nil || started.RowsAffected == 0 {
+		return
+	}
+
+	reported := 0
+	progress := func(done, total int) {
+		if total <= 0 {
+			return
+		}
+		if pct := done * 100 / total; pct > reported {
+			reported = pct
+			s.db.Model(&models.Job{}).Where("id = ?", task.id).Update("progress", pct)
+		}
+	}
+
+	backtestID, err := task.run(task.ctx, progress)
+	updates := map[string]any{"finished_at": time.Now()}
+	switch {
+	case err == nil:
+		updates["status"] = JobSucceeded
+		updates["progress"] = 100
+		updates["backtest_id"] = backtestID
+	case errors.Is(err, context.Canceled):
+		updates["status"] = JobCanceled
+	default:
+		updates["status"] = JobFailed
+		updates["error"] = err.Error()
+	}
+	if err := s.db.Model(&models.Job{}).Where("id = ?", task.id).Updates(updates).Error; err != nil {
+		log.Printf("failed to record job %d result: %v", task.id, err)
+	}
+}
+
+// forget releases a job's cancel function once it can no longer run.
+func (s *JobService) forget(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/db"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// memoryDB opens an in-memory database. A single connection keeps every
+// query, from the test and from the workers, on the same database.
+func memoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	database, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return database
+}
+
+func newAnalysisService(database *gorm.DB) *AnalysisService {
+	return NewAnalysisService(database, NewStockService(database), NewStrategyService(database), NewWatchlistService(database))
+}
+
+// waitJob polls the job until done accepts it.
+func waitJob(t *testing.T, jobs *JobService, id uint, done func(*models.Job) bool) *models.Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		job, err := jobs.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done(job) {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d stuck in %s at %d%%", id, job.Status, job.Progress)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func jobStatus(status string) func(*models.Job) bool {
+	return func(job *models.Job) bool { return job.Status == status }
+}
+
+// TestEnqueueBacktests runs a single-stock and a portfolio backtest on the
+// demo data through the queue, and a backtest of a missing strategy.
+func TestEnqueueBacktests(t *testing.T) {
+	database := memoryDB(t)
+	analysis := newAnalysisService(database)
+	if err := NewStockService(database).SeedDemoData(); err != nil {
+		t.Fatal(err)
+	}
+	model := models.Strategy{Name: "MA", Type: "ma_crossover", ParamsJSON: `{"short_window":5,"long_window":20}`}
+	if err := NewStrategyService(database).Create(&model); err != nil {
+		t.Fatal(err)
+	}
+	jobs := NewJobService(database, analysis, 2, 4)
+
+	single, err := jobs.EnqueueBacktest(BacktestOptions{StrategyID: model.ID, StockCode: "600519"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	portfolio, err := jobs.EnqueuePortfolioBacktest(PortfolioBacktestOptions{BacktestOptions: BacktestOptions{StrategyID: model.ID}, Codes: []string{"600519", "000001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Kind != "backtest" || portfolio.Kind != "portfolio_backtest" || single.Status != JobQueued {
+		t.Errorf("enqueued %s (%s) and %s, want a queued backtest and a portfolio_backtest", single.Kind, single.Status, portfolio.Kind)
+	}
+
+	for _, tt := range []struct {
+		job                 *models.Job
+		stockCode, universe string
+	}{
+		{single, "600519", ""},
+		{portfolio, "", "600519,000001"},
+	} {
+		job := waitJob(t, jobs, tt.job.ID, func(job *models.Job) bool { return job.Status != JobQueued && job.Status != JobRunning })
+		if job.Status != JobSucceeded || job.Progress != 100 || job.StartedAt == nil || job.FinishedAt == nil {
+			t.Fatalf("%s: %s at %d%% (%s), want succeeded at 100%%", job.Kind, job.Status, job.Progress, job.Error)
+		}
+		var saved models.Backtest
+		if err := database.First(&saved, job.BacktestID).Error; err != nil {
+			t.Fatalf("%s: backtest %d: %v", job.Kind, job.BacktestID, err)
+		}
+		if saved.StrategyID != model.ID || saved.StockCode != tt.stockCode || saved.Universe != tt.universe {
+			t.Errorf("%s: saved strategy %d on %q / %q, want %d on %q / %q", job.Kind, saved.StrategyID, saved.StockCode, saved.Universe, model.ID, tt.stockCode, tt.universe)
+		}
+	}
+
+	missing, err := jobs.EnqueueBacktest(BacktestOptions{StrategyID: model.ID + 1, StockCode: "600519"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := waitJob(t, jobs, missing.ID, jobStatus(JobFailed))
+	if job.Error == "" || job.BacktestID != 0 {
+		t.Errorf("failed job has error %q and backtest %d, want an error and no backtest", job.Error, job.BacktestID)
+	}
+}
+
+// TestJobQueue runs a job that reports a quarter of its progress and then
+// blocks until canceled on one worker with room for one waiting job.
+func TestJobQueue(t *testing.T) {
+	database := memoryDB(t)
+	jobs := NewJobService(database, nil, 1, 1)
+
+	blocking := func(ctx context.Context, progress func(done, total int)) (uint, error) {
+		progress(1, 4)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	ran := make(chan uint, 4)
+	quick := func(id uint) jobRunner {
+		return func(ctx context.Context, progress func(done, total int)) (uint, error) {
+			ran <- id
+			return id, nil
+		}
+	}
+
+	running, err := jobs.enqueue("backtest", nil, blocking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, jobs, running.ID, func(job *models.Job) bool { return job.Status == JobRunning && job.Progress == 25 })
+
+	queued, err := jobs.enqueue("backtest", nil, quick(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.enqueue("backtest", nil, quick(2)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("third job: %v, want ErrQueueFull", err)
+	}
+	var count int64
+	database.Model(&models.Job{}).Count(&count)
+	if count != 2 {
+		t.Errorf("%d jobs recorded, want the rejected one removed", count)
+	}
+
+	// A queued job is canceled at once and never runs.
+	if job, err := jobs.Cancel(queued.ID); err != nil || job.Status != JobCanceled {
+		t.Fatalf("cancel queued job: %v, %v; want canceled", job, err)
+	}
+	// A running job is marked by its worker once the run stops.
+	if _, err := jobs.Cancel(running.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, jobs, running.ID, jobStatus(JobCanceled))
+	if _, err := jobs.Cancel(running.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("cancel finished job: %v, want ErrJobFinished", err)
+	}
+
+	// The worker takes jobs in order, so the canceled one was skipped by
+	// the time the next one succeeds.
+	next, err := jobs.enqueue("backtest", nil, quick(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := waitJob(t, jobs, next.ID, jobStatus(JobSucceeded))
+	if job.BacktestID != 3 {
+		t.Errorf("backtest %d, want 3", job.BacktestID)
+	}
+	if id := <-ran; id != 3 || len(ran) != 0 {
+		t.Errorf("runner %d ran, want only runner 3", id)
+	}
+}
+
+// TestJobsInterruptedOnRestart checks that a new service marks the jobs a
+// previous process left queued or running as interrupted.
+func TestJobsInterruptedOnRestart(t *testing.T) {
+	database := memoryDB(t)
+	left := []models.Job{
+		{Kind: "backtest", Status: JobQueued},
+		{Kind: "backtest", Status: JobRunning, Progress: 40},
+		{Kind: "backtest", Status: JobSucceeded, Progress: 100, BacktestID: 7},
+	}
+	if err := database.Create(&left).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := NewJobService(database, nil, 0, 1)
+	want := []string{JobInterrupted, JobInterrupted, JobSucceeded}
+	for i, job := range left {
+		got, err := jobs.Get(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != want[i] {
+			t.Errorf("job %d: %s, want %s", i, got.Status, want[i])
+		}
+		if interrupted := got.Status == JobInterrupted; interrupted != (got.FinishedAt != nil) || interrupted != (got.Error == "server restarted") {
+			t.Errorf("job %d: finished %v with error %q", i, got.FinishedAt, got.Error)
+		}
+	}
+}
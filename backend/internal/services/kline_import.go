@@ -0,0 +1,388 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/provider"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/tabular"
+)
+
+// Fields an imported kline file maps its columns to.
+const (
+	ImportCode   = "code"
+	ImportName   = "name"
+	ImportTime   = "time"
+	ImportOpen   = "open"
+	ImportHigh   = "high"
+	ImportLow    = "low"
+	ImportClose  = "close"
+	ImportVolume = "volume"
+)
+
+// importHeaders lists the header names each field is found under when no
+// column is mapped to it, compared case-insensitively.
+var importHeaders = map[string][]string{
+	ImportCode:   {"code", "stock_code", "symbol", "ts_code", "代码", "股票代码", "证券代码"},
+	ImportName:   {"name", "stock_name", "名称", "股票名称", "证券名称", "证券简称"},
+	ImportTime:   {"time", "date", "datetime", "trade_date", "日期", "时间", "交易日期"},
+	ImportOpen:   {"open", "开盘", "开盘价"},
+	ImportHigh:   {"high", "最高", "最高价"},
+	ImportLow:    {"low", "最低", "最低价"},
+	ImportClose:  {"close", "收盘", "收盘价"},
+	ImportVolume: {"volume", "vol", "成交量"},
+}
+
+// importTimeLayouts are tried in order when no date format is given.
+var importTimeLayouts = []string{
+	"2006-1-2",
+	"2006-1-2 15:04:05",
+	"2006-1-2 15:04",
+	"2006-1-2T15:04:05",
+	"2006/1/2",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"20060102",
+}
+
+const (
+	// importBatchSize is the number of accepted bars saved at a time.
+	importBatchSize = 5000
+	// maxImportErrors bounds the rejected rows an import report lists.
+	maxImportErrors = 100
+)
+
+// KLineImportOptions describes a kline file and how to read it.
+type KLineImportOptions struct {
+	// Format is csv or xlsx; empty infers it from Filename.
+	Format   string
+	Filename string
+	// Sheet names the XLSX sheet to read, the first by default.
+	Sheet string
+	// StockCode is the stock of every row, overriding any code column, for
+	// files holding the bars of one stock.
+	StockCode string
+	// Interval of the bars, 1d by default. Daily and weekly bar times are
+	// truncated to the date.
+	Interval string
+	// DateFormat is the Go layout of the time column, such as 2006-01-02 or
+	// 01/02/2006 15:04; empty tries common layouts.
+	DateFormat string
+	// Columns maps fields to header names, overriding the usual ones.
+	Columns map[string]string
+}
+
+// ImportReport counts the data rows of an imported file and lists the
+// first rows rejected and why.
+type ImportReport struct {
+	Rows          int              `json:"rows"`
+	Accepted      int              `json:"accepted"`
+	Rejected      int              `json:"rejected"`
+	CreatedStocks []string         `json:"created_stocks"`
+	Errors        []ImportRowError `json:"errors"`
+}
+
+// ImportRowError is a rejected row, numbered as in the file: its CSV line
+// or XLSX sheet row.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ErrInvalidImport marks a file or options that cannot be imported at all,
+// as opposed to individual rejected rows.
+var ErrInvalidImport = errors.New("invalid import")
+
+// ParseImportOptions validates the options and fills in their defaults.
+func ParseImportOptions(options KLineImportOptions) (KLineImportOptions, error) {
+	var err error
+	if options.Format, err = tabular.ParseFormat(options.Format, options.Filename); err != nil {
+		return options, err
+	}
+	if options.Interval == "" {
+		options.Interval = "1d"
+	}
+	if _, err := strategy.IntervalMinutes(options.Interval); err != nil {
+		return options, err
+	}
+	if options.StockCode != "" {
+		code, err := normalizeImportCode(options.StockCode)
+		if err != nil {
+			return options, err
+		}
+		options.StockCode = code
+	}
+	for field := range options.Columns {
+		if _, ok := importHeaders[field]; !ok {
+			return options, fmt.Errorf("unknown import field %q", field)
+		}
+	}
+	return options, nil
+}
+
+// ParseImportColumns reads a column mapping written as comma-separated
+// field=header pairs, such as time=Date,close=Adj Close.
+func ParseImportColumns(raw string) (map[string]string, error) {
+	columns := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		field, header, ok := strings.Cut(pair, "=")
+		field, header = strings.ToLower(strings.TrimSpace(field)), strings.TrimSpace(header)
+		if !ok || field == "" || header == "" {
+			return nil, fmt.Errorf("invalid column mapping %q", pair)
+		}
+		columns[field] = header
+	}
+	return columns, nil
+}
+
+// ImportKLines reads bars from a CSV or XLSX file with a header row and
+// upserts the valid ones, creating the stocks they belong to when missing.
+// Invalid rows are rejected and reported; an unreadable file or header is
+// an error wrapping ErrInvalidImport, and also tabular.ErrTooLarge for a
+// workbook too large to read.
+func (s *StockService) ImportKLines(r io.Reader, options KLineImportOptions) (*ImportReport, error) {
+	options, err := ParseImportOptions(options)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	rows, err := tabular.Open(r, options.Format, options.Sheet)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+
+	report := &ImportReport{CreatedStocks: []string{}, Errors: []ImportRowError{}}
+	var columns map[string]int
+	known := make(map[string]bool)
+	var batch []models.KLine
+	flush := func() error {
+		if err := s.SaveKLines(batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		cells, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		if blankRow(cells) {
+			continue
+		}
+		if columns == nil {
+			if columns, err = importColumns(cells, options); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+			}
+			continue
+		}
+
+		report.Rows++
+		bar, name, err := parseImportRow(cells, columns, options)
+		if err != nil {
+			report.Rejected++
+			if len(report.Errors) < maxImportErrors {
+				report.Errors = append(report.Errors, ImportRowError{Row: rows.Row(), Error: err.Error()})
+			}
+			continue
+		}
+		if !known[bar.StockCode] {
+			if name == "" {
+				name = bar.StockCode
+			}
+			created, err := s.EnsureStock(models.Stock{Code: bar.StockCode, Name: name, Exchange: provider.Exchange(bar.StockCode)})
+			if err != nil {
+				return nil, err
+			}
+			if created {
+				report.CreatedStocks = append(report.CreatedStocks, bar.StockCode)
+			}
+			known[bar.StockCode] = true
+		}
+		report.Accepted++
+		batch = append(batch, bar)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if columns == nil {
+		return nil, fmt.Errorf("%w: file has no header row", ErrInvalidImport)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// importColumns finds the column of each field in the header row. The
+// time and price columns are required, as is a code column unless the
+// options name the stock.
+func importColumns(header []string, options KLineImportOptions) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, cell := range header {
+		key := strings.ToLower(strings.TrimSpace(cell))
+		if _, seen := index[key]; !seen {
+			index[key] = i
+		}
+	}
+
+	columns := make(map[string]int, len(importHeaders))
+	for field, names := range importHeaders {
+		if mapped, ok := options.Columns[field]; ok {
+			column, found := index[strings.ToLower(strings.TrimSpace(mapped))]
+			if !found {
+				return nil, fmt.Errorf("column %q mapped to %s is not in the header", mapped, field)
+			}
+			columns[field] = column
+			continue
+		}
+		for _, name := range names {
+			if column, found := index[name]; found {
+				columns[field] = column
+				break
+			}
+		}
+	}
+
+	required := []string{ImportTime, ImportOpen, ImportHigh, ImportLow, ImportClose}
+	if options.StockCode == "" {
+		required = append(required, ImportCode)
+	}
+	for _, field := range required {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("no %s column in the header", field)
+		}
+	}
+	return columns, nil
+}
+
+// parseImportRow validates a data row and returns its bar and stock name.
+func parseImportRow(cells []string, columns map[string]int, options KLineImportOptions) (models.KLine, string, error) {
+	cell := func(field string) string {
+		if column, ok := columns[field]; ok && column < len(cells) {
+			return strings.TrimSpace(cells[column])
+		}
+		return ""
+	}
+
+	code := options.StockCode
+	if code == "" {
+		var err error
+		if code, err = normalizeImportCode(cell(ImportCode)); err != nil {
+			return models.KLine{}, "", err
+		}
+	}
+	stamp, err := parseImportTime(cell(ImportTime), options)
+	if err != nil {
+		return models.KLine{}, "", err
+	}
+
+	bar := models.KLine{StockCode: code, Interval: options.Interval, Time: stamp}
+	prices := []struct {
+		field string
+		out   *float64
+	}{
+		{ImportOpen, &bar.Open},
+		{ImportHigh, &bar.High},
+		{ImportLow, &bar.Low},
+		{ImportClose, &bar.Close},
+	}
+	for _, price := range prices {
+		value, err := parseImportNumber(price.field, cell(price.field))
+		if err != nil {
+			return models.KLine{}, "", err
+		}
+		if value <= 0 {
+			return models.KLine{}, "", fmt.Errorf("%s must be positive", price.field)
+		}
+		*price.out = value
+	}
+	if raw := cell(ImportVolume); raw != "" {
+		if bar.Volume, err = parseImportNumber(ImportVolume, raw); err != nil {
+			return models.KLine{}, "", err
+		}
+		if bar.Volume < 0 {
+			return models.KLine{}, "", fmt.Errorf("volume must not be negative")
+		}
+	}
+	if bar.High < math.Max(bar.Open, bar.Close) || bar.Low > math.Min(bar.Open, bar.Close) {
+		return models.KLine{}, "", fmt.Errorf("high %.4g and low %.4g do not bound open and close", bar.High, bar.Low)
+	}
+	return bar, cell(ImportName), nil
+}
+
+// normalizeImportCode accepts a six-digit code, optionally with an exchange
+// prefix or suffix as in sh600519 or 600519.SH.
+func normalizeImportCode(raw string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(raw))
+	if code == "" {
+		return "", fmt.Errorf("missing code")
+	}
+	for _, exchange := range []string{"SH", "SZ", "BJ"} {
+		code = strings.TrimPrefix(strings.TrimPrefix(code, exchange), ".")
+		code = strings.TrimSuffix(strings.TrimSuffix(code, exchange), ".")
+	}
+	if _, err := strconv.Atoi(code); err != nil || len(code) != 6 || provider.Exchange(code) == "" {
+		return "", fmt.Errorf("invalid code %q", raw)
+	}
+	return code, nil
+}
+
+// parseImportTime reads a bar time as a wall-clock time in UTC, the form
+// bars are stored in, truncated to the date for daily and weekly bars.
+func parseImportTime(raw string, options KLineImportOptions) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, fmt.Errorf("missing time")
+	}
+	layouts := importTimeLayouts
+	if options.DateFormat != "" {
+		layouts = []string{options.DateFormat}
+	}
+	for _, layout := range layouts {
+		stamp, err := time.ParseInLocation(layout, raw, time.UTC)
+		if err != nil {
+			continue
+		}
+		if minutes, _ := strategy.IntervalMinutes(options.Interval); minutes == 0 {
+			stamp = time.Date(stamp.Year(), stamp.Month(), stamp.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		return stamp, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", raw)
+}
+
+// parseImportNumber reads a number, ignoring thousands separators.
+func parseImportNumber(field, raw string) (float64, error) {
+	if raw == "" {
+		return 0, fmt.Errorf("missing %s", field)
+	}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(raw, ",", ""), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid %s %q", field, raw)
+	}
+	return value, nil
+}
+
+func blankRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/tabular"
+)
+
+// importWorkbook zips a one-sheet workbook whose style 1 shows dates. A
+// nonzero declared size is recorded as the sheet's unpacked size.
+func importWorkbook(t *testing.T, rows string, declared uint64) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/styles.xml": `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<cellXfs count="2"><xf numFmtId="0"/><xf numFmtId="14"/></cellXfs></styleSheet>`,
+	} {
+		w, _ := archive.Create(name)
+		w.Write([]byte(content))
+	}
+	sheet := []byte(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + rows + `</sheetData></worksheet>`)
+	header := &zip.FileHeader{Name: "xl/worksheets/sheet1.xml", Method: zip.Store, CompressedSize64: uint64(len(sheet)), UncompressedSize64: uint64(len(sheet))}
+	if declared > 0 {
+		header.UncompressedSize64 = declared
+	}
+	w, err := archive.CreateRaw(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(sheet)
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNormalizeImportCode(t *testing.T) {
+	tests := map[string]string{
+		"600519":     "600519",
+		" 600519 ":   "600519",
+		"sh600519":   "600519",
+		"SH.600519":  "600519",
+		"600519.SH":  "600519",
+		"000001.sz":  "000001",
+		"sz000001":   "000001",
+		"bj830799":   "830799",
+		"830799.BJ":  "830799",
+		"":           "",
+		"60051":      "",
+		"6005190":    "",
+		"700001":     "",
+		"600519.HK":  "",
+		"us600519":   "",
+		"sh60051x":   "",
+		"600519.SH.": "",
+	}
+	for raw, want := range tests {
+		got, err := normalizeImportCode(raw)
+		if got != want || (err == nil) != (want != "") {
+			t.Errorf("normalizeImportCode(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+}
+
+func TestParseImportOptions(t *testing.T) {
+	options, err := ParseImportOptions(KLineImportOptions{Filename: "bars.CSV", StockCode: "sz000001"})
+	if err != nil || options.Format != tabular.CSV || options.Interval != "1d" || options.StockCode != "000001" {
+		t.Errorf("ParseImportOptions = %+v, %v", options, err)
+	}
+	for _, bad := range []KLineImportOptions{
+		{Filename: "bars.json"},
+		{Filename: "bars.csv", Interval: "7m"},
+		{Filename: "bars.csv", StockCode: "AAPL"},
+		{Filename: "bars.csv", Columns: map[string]string{"amount": "成交额"}},
+	} {
+		if _, err := ParseImportOptions(bad); err == nil {
+			t.Errorf("ParseImportOptions(%+v) succeeded", bad)
+		}
+	}
+
+	columns, err := ParseImportColumns(" Time=Date, close=Adj Close,,")
+	if want := map[string]string{"time": "Date", "close": "Adj Close"}; err != nil || !reflect.DeepEqual(columns, want) {
+		t.Errorf("ParseImportColumns = %v, %v; want %v", columns, err, want)
+	}
+	for _, bad := range []string{"time", "=Date", "time="} {
+		if _, err := ParseImportColumns(bad); err == nil {
+			t.Errorf("ParseImportColumns(%q) succeeded", bad)
+		}
+	}
+}
+
+// TestImportKLines reads Chinese headers, exchange-qualified codes and
+// thousands separators, and rejects invalid rows by their line.
+func TestImportKLines(t *testing.T) {
+	s := newStockService(t)
+	if err := s.UpsertStock(models.Stock{Code: "000001", Name: "平安银行", Exchange: "SZ"}); err != nil {
+		t.Fatal(err)
+	}
+	file := "股票代码,名称,日期,开盘,最高,最低,收盘,成交量\n" +
+		"sh600519,贵州茅台,2024-06-19 10:00,1600,1620,1560,1570,60911\n" +
+		"600519.SH,,2024/6/20,1570,1580,1550,1560,\"12,000\"\n" +
+		"\n" +
+		"12345,,2024-06-20,1,1,1,1,1\n" +
+		"000001.SZ,平安,2024-06-20,10,10.5,9.9,10.2,\n" +
+		"000001,,2024-06-21,10,9,9.5,9.8,1\n" +
+		"000001,,2024-06-24,10,10.5,9.9,10.2,-5\n" +
+		"000001,,24-06-25,10,10.5,9.9,10.2,1\n" +
+		"000001,,2024-06-26,0,10.5,9.9,10.2,1\n"
+
+	report, err := s.ImportKLines(strings.NewReader(file), KLineImportOptions{Filename: "bars.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ImportReport{
+		Rows:          8,
+		Accepted:      3,
+		Rejected:      5,
+		CreatedStocks: []string{"600519"},
+		Errors: []ImportRowError{
+			{Row: 5, Error: `invalid code "12345"`},
+			{Row: 7, Error: "high 9 and low 9.5 do not bound open and close"},
+			{Row: 8, Error: "volume must not be negative"},
+			{Row: 9, Error: `invalid time "24-06-25"`},
+			{Row: 10, Error: "open must be positive"},
+		},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v\nwant %+v", report, want)
+	}
+
+	bars, err := s.GetKLines("600519", "1d", 0, strategy.AdjustNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Daily bar times are truncated to the date.
+	if len(bars) != 2 || !bars[0].Time.Equal(time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC)) || bars[1].Volume != 12000 {
+		t.Errorf("600519 bars = %+v", bars)
+	}
+	stocks, err := s.ListStocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Existing stocks keep their names; new ones take the file's.
+	if len(stocks) != 2 || stocks[0].Name != "平安银行" || stocks[1].Name != "贵州茅台" || stocks[1].Exchange != "SH" {
+		t.Errorf("stocks = %+v", stocks)
+	}
+
+	// Importing again updates the bars in place.
+	report, err = s.ImportKLines(strings.NewReader(file), KLineImportOptions{Filename: "bars.csv"})
+	if err != nil || report.Accepted != 3 || len(report.CreatedStocks) != 0 {
+		t.Fatalf("second import = %+v, %v", report, err)
+	}
+	var count int64
+	s.db.Model(&models.KLine{}).Count(&count)
+	if count != 3 {
+		t.Errorf("%d bars after importing twice, want 3", count)
+	}
+}
+
+// TestImportKLinesColumns maps columns by name, takes the stock from the
+// options and keeps intraday times.
+func TestImportKLinesColumns(t *testing.T) {
+	s := newStockService(t)
+	file := "Date,Adj Close,CLOSE,Open,High,Low,Volume\n" +
+		"06/19/2024 10:30,10.1,99,10,10.5,9.9,100\n"
+	options := KLineImportOptions{
+		Filename:   "bars.csv",
+		StockCode:  "sz000001",
+		Interval:   "30m",
+		DateFormat: "01/02/2006 15:04",
+		Columns:    map[string]string{ImportClose: "adj close"},
+	}
+	report, err := s.ImportKLines(strings.NewReader(file), options)
+	if err != nil || report.Accepted != 1 || !reflect.DeepEqual(report.CreatedStocks, []string{"000001"}) {
+		t.Fatalf("report = %+v, %v", report, err)
+	}
+	bars, err := s.GetKLines("000001", "30m", 0, strategy.AdjustNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bars) != 1 || bars[0].Close != 10.1 || !bars[0].Time.Equal(time.Date(2024, 6, 19, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("bars = %+v", bars)
+	}
+
+	for _, tt := range []struct {
+		file    string
+		options KLineImportOptions
+		err     string
+	}{
+		{file, KLineImportOptions{Filename: "bars.csv", StockCode: "000001", Columns: map[string]string{ImportClose: "Close Price"}}, `column "Close Price" mapped to close`},
+		{file, KLineImportOptions{Filename: "bars.csv"}, "no code column"},
+		{"\n\n", KLineImportOptions{Filename: "bars.csv"}, "no header row"},
+		{file, KLineImportOptions{Filename: "bars.xlsx"}, "open xlsx"},
+	} {
+		_, err := s.ImportKLines(strings.NewReader(tt.file), tt.options)
+		if !errors.Is(err, ErrInvalidImport) || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%+v: error %v, want ErrInvalidImport with %q", tt.options, err, tt.err)
+		}
+	}
+}
+
+// TestImportKLinesXLSX numbers rejected rows as the sheet does, counting
+// missing rows.
+func TestImportKLinesXLSX(t *testing.T) {
+	s := newStockService(t)
+	cell := func(ref, text string) string {
+		return fmt.Sprintf(`<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, text)
+	}
+	rows := `<row r="1">` + cell("A1", "代码") + cell("B1", "交易日期") + cell("C1", "开盘价") + cell("D1", "最高价") + cell("E1", "最低价") + cell("F1", "收盘价") + `</row>` +
+		`<row r="2">` + cell("A2", "600519") + `<c r="B2" s="1"><v>45462</v></c><c r="C2"><v>1600</v></c><c r="D2"><v>1620</v></c><c r="E2"><v>1560</v></c><c r="F2"><v>1570</v></c></row>` +
+		`<row r="5">` + cell("A5", "600519") + `<c r="B5" s="1"><v>45463</v></c><c r="C5"><v>1570</v></c><c r="F5"><v>1560</v></c></row>`
+	report, err := s.ImportKLines(bytes.NewReader(importWorkbook(t, rows, 0)), KLineImportOptions{Filename: "bars.xlsx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ImportRowError{{Row: 5, Error: "missing high"}}
+	if report.Accepted != 1 || !reflect.DeepEqual(report.Errors, want) {
+		t.Errorf("report = %+v, want one bar and %+v", report, want)
+	}
+	bars, _ := s.GetKLines("600519", "1d", 0, strategy.AdjustNone)
+	if len(bars) != 1 || !bars[0].Time.Equal(time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("bars = %+v, want the date-styled serial read as 2024-06-19", bars)
+	}
+}
+
+func TestImportKLinesErrorCap(t *testing.T) {
+	s := newStockService(t)
+	file := "code,time,open,high,low,close\n" + strings.Repeat("600519,,1,1,1,1\n", maxImportErrors+50)
+	report, err := s.ImportKLines(strings.NewReader(file), KLineImportOptions{Filename: "bars.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows != maxImportErrors+50 || report.Rejected != maxImportErrors+50 || len(report.Errors) != maxImportErrors {
+		t.Fatalf("report counts %d rows, %d rejected and %d errors", report.Rows, report.Rejected, len(report.Errors))
+	}
+	if first, last := report.Errors[0], report.Errors[maxImportErrors-1]; first.Row != 2 || last.Row != maxImportErrors+1 || first.Error != "missing time" {
+		t.Errorf("errors run from %+v to %+v, want rows 2 to %d", first, last, maxImportErrors+1)
+	}
+}
+
+// TestImportKLinesLimits rejects workbooks beyond the sheet's columns as
+// invalid and those too large to unpack as too large.
+func TestImportKLinesLimits(t *testing.T) {
+	s := newStockService(t)
+	options := KLineImportOptions{Filename: "bars.xlsx"}
+
+	_, err := s.ImportKLines(bytes.NewReader(importWorkbook(t, `<row r="1"><c r="XFE1"><v>1</v></c></row>`, 0)), options)
+	if !errors.Is(err, ErrInvalidImport) || errors.Is(err, tabular.ErrTooLarge) || !strings.Contains(err.Error(), "beyond column XFD") {
+		t.Errorf("column beyond XFD: error %v", err)
+	}
+	_, err = s.ImportKLines(bytes.NewReader(importWorkbook(t, "", 1<<40)), options)
+	if !errors.Is(err, ErrInvalidImport) || !errors.Is(err, tabular.ErrTooLarge) {
+		t.Errorf("oversized sheet: error %v, want ErrInvalidImport and tabular.ErrTooLarge", err)
+	}
+}
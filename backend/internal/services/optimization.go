@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+// OptimizeOptions carries a parameter search. A StockCode searches that
+// stock alone; otherwise the portfolio universe rules apply.
+type OptimizeOptions struct {
+	PortfolioBacktestOptions
+	// StrategyType defaults to the type of StrategyID's strategy, whose
+	// params also fill in parameters the grid leaves out.
+	StrategyType string
+	Grid         strategy.ParamGrid
+	// Objective is the metric ranked on (default sharpe).
+	Objective string
+	// HeatmapX and HeatmapY name the heatmap axes; they default to the
+	// first two grid parameters in name order.
+	HeatmapX string
+	HeatmapY string
+	// Top limits the returned runs; zero returns all of them.
+	Top int
+}
+
+// OptimizationResult is the ranked outcome of a parameter search.
+type OptimizationResult struct {
+	StrategyType string                     `json:"strategy_type"`
+	Objective    string                     `json:"objective"`
+	Tried        int                        `json:"tried"`
+	Skipped      int                        `json:"skipped"`
+	Runs         []strategy.OptimizationRun `json:"runs"`
+	Heatmap      *strategy.Heatmap          `json:"heatmap,omitempty"`
+}
+
+// backtestFunc runs the strategy with the given params over preloaded bars.
+type backtestFunc func(ctx context.Context, params strategy.MACrossoverParams) ([]strategy.EquityPoint, []strategy.Trade, error)
+
+// preparedBacktest is the data a grid search runs on.
+type preparedBacktest struct {
+	run     backtestFunc
+	initial float64
+	engine  strategy.BacktestOptions
+	klines  []models.KLine // single-stock searches only
+}
+
+// Optimize backtests every valid combination of the grid in parallel and
+// ranks them by the objective. Runs are not saved to the backtest history.
+func (a *AnalysisService) Optimize(ctx context.Context, options OptimizeOptions) (*OptimizationResult, error) {
+	result, _, err := a.searchGrid(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.Top > 0 && options.Top < len(result.Runs) {
+		result.Runs = result.Runs[:options.Top]
+	}
+	return result, nil
+}
+
+// searchGrid runs and ranks the whole grid, returning the data it ran on.
+func (a *AnalysisService) searchGrid(ctx context.Context, options OptimizeOptions) (*OptimizationResult, *preparedBacktest, error) {
+	strategyType, base, err := a.baseParams(options.StrategyID, options.StrategyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	objective := options.Objective
+	if objective == "" {
+		objective = strategy.DefaultObjective
+	}
+	if _, err := strategy.ObjectiveScore(objective, 0, models.BacktestMetrics{}); err != nil {
+		return nil, nil, err
+	}
+
+	combos, err := options.Grid.Combinations()
+	if err != nil {
+		return nil, nil, err
+	}
+	candidates, skipped, err := strategy.ParamSets(base, combos)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	heatmapX, heatmapY := options.HeatmapX, options.HeatmapY
+	if heatmapX == "" && heatmapY == "" {
+		names := make([]string, 0, len(options.Grid))
+		for name := range options.Grid {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) >= 2 {
+			heatmapX, heatmapY = names[0], names[1]
+		}
+	}
+	for _, name := range []string{heatmapX, heatmapY} {
+		if _, ok := options.Grid[name]; name != "" && !ok {
+			return nil, nil, fmt.Errorf("heatmap parameter %q is not in the grid", name)
+		}
+	}
+
+	prepared, err := a.prepareBacktest(options.PortfolioBacktestOptions, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	runs, err := runGrid(ctx, candidates, prepared.run, objective, prepared.initial, prepared.engine.BarsPerYear())
+	if err != nil {
+		return nil, nil, err
+	}
+	strategy.RankRuns(runs)
+
+	result := &OptimizationResult{
+		StrategyType: strategyType,
+		Objective:    objective,
+		Tried:        len(runs),
+		Skipped:      skipped,
+		Runs:         runs,
+	}
+	if heatmapX != "" && heatmapY != "" {
+		heatmap := strategy.BuildHeatmap(runs, heatmapX, heatmapY)
+		result.Heatmap = &heatmap
+	}
+	return result, prepared, nil
+}
+
+// baseParams resolves the strategy type and the params grid points start
+// from. Only the moving average crossover can be optimized.
+func (a *AnalysisService) baseParams(strategyID uint, strategyType string) (string, strategy.MACrossoverParams, error) {
+	base := strategy.DefaultMACrossoverParams()
+	if strategyID > 0 {
+		model, err := a.strategies.Get(strategyID)
+		if err != nil {
+			return "", base, err
+		}
+		if strategyType == "" {
+			strategyType = model.Type
+		}
+		base = strategy.ParseMACrossoverParams(model.ParamsJSON)
+	}
+	if strategyType == "" {
+		strategyType = strategy.TypeMACrossover
+	}
+	if strategyType != strategy.TypeMACrossover {
+		return "", base, fmt.Errorf("optimization is not supported for strategy type %q", strategyType)
+	}
+	return strategyType, base, nil
+}
+
+// prepareBacktest loads the bars once for all candidates, with enough
+// warm-up for the slowest of them, and binds a backtest of one parameter
+// set on them.
+func (a *AnalysisService) prepareBacktest(options PortfolioBacktestOptions, candidates []strategy.ParamSet) (*preparedBacktest, error) {
+	engine := options.engineOptions()
+	warmup := options.WarmupBars
+	if warmup <= 0 {
+		for _, candidate := range candidates {
+			warmup = max(warmup, engine.WarmupBars(candidate.Params))
+		}
+	}
+
+	if options.StockCode != "" {
+		klines, reportFrom, err := a.loadWindow(options.StockCode, options.interval(), options.Start, options.End, warmup)
+		if err != nil {
+			return nil, err
+		}
+		if len(klines) == 0 {
+			return nil, fmt.Errorf("no kline data for %s in the requested range", options.StockCode)
+		}
+		engine.ReportFrom = reportFrom
+		if err := a.loadCorporateData(&engine, options.StockCode); err != nil {
+			return nil, err
+		}
+		run := func(ctx context.Context, params strategy.MACrossoverParams) ([]strategy.EquityPoint, []strategy.Trade, error) {
+			_, points, trades, err := strategy.BacktestContext(ctx, klines, params, engine)
+			return points, trades, err
+		}
+		return &preparedBacktest{run: run, initial: engine.InitialCapital, engine: engine, klines: klines}, nil
+	}
+
+	codes, err := a.resolveUniverse(options.Codes, options.WatchlistID)
+	if err != nil {
+		return nil, err
+	}
+	series, reportFrom, _, err := a.loadUniverse(options.BacktestOptions, codes, warmup)
+	if err != nil {
+		return nil, err
+	}
+	engine.ReportFrom = reportFrom
+	if err := a.loadCorporateData(&engine, codes...); err != nil {
+		return nil, err
+	}
+	portfolio := options.portfolioOptions(engine)
+	run := func(ctx context.Context, params strategy.MACrossoverParams) ([]strategy.EquityPoint, []strategy.Trade, error) {
+		result, err := strategy.PortfolioBacktestContext(ctx, series, params, portfolio)
+		return result.Points, result.Trades, err
+	}
+	return &preparedBacktest{run: run, initial: engine.InitialCapital, engine: engine}, nil
+}
+
+// runGrid backtests the candidates on one worker per CPU. The first error
+// cancels the remaining runs.
+func runGrid(ctx context.Context, candidates []strategy.ParamSet, run backtestFunc, objective string, initial, barsPerYear float64) ([]strategy.OptimizationRun, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runs := make([]strategy.OptimizationRun, len(candidates))
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() { firstErr = err })
+		cancel()
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				points, trades, err := run(ctx, candidates[i].Params)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				if runs[i], err = strategy.EvaluateRun(candidates[i].Values, points, trades, objective, initial, barsPerYear); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range candidates {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+// gridCandidates returns n parameter sets with short windows 1..n.
+func gridCandidates(n int) []strategy.ParamSet {
+	candidates := make([]strategy.ParamSet, n)
+	for i := range candidates {
+		short := i + 1
+		candidates[i] = strategy.ParamSet{
+			Values: map[string]float64{"short_window": float64(short)},
+			Params: strategy.MACrossoverParams{ShortWindow: short, LongWindow: short + 10},
+		}
+	}
+	return candidates
+}
+
+// gridBacktest ends each run at a return in percent equal to its short
+// window, and stops like the engine once ctx is done.
+func gridBacktest(calls *atomic.Int64) backtestFunc {
+	return func(ctx context.Context, params strategy.MACrossoverParams) ([]strategy.EquityPoint, []strategy.Trade, error) {
+		calls.Add(1)
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		equity := 100000 * (1 + float64(params.ShortWindow)/100)
+		return []strategy.EquityPoint{{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Equity: equity}}, nil, nil
+	}
+}
+
+func TestRunGrid(t *testing.T) {
+	var calls atomic.Int64
+	candidates := gridCandidates(40)
+	runs, err := runGrid(context.Background(), candidates, gridBacktest(&calls), "return_pct", 100000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != len(candidates) || calls.Load() != int64(len(candidates)) {
+		t.Fatalf("%d runs from %d backtests, want %d of each", len(runs), calls.Load(), len(candidates))
+	}
+	// Runs keep the candidates' order whichever worker ran them.
+	for i, run := range runs {
+		want := float64(i + 1)
+		if run.Params["short_window"] != want || math.Abs(run.Score-want) > 1e-9 {
+			t.Errorf("run %d: params %v scored %v, want short_window %v scored %v", i, run.Params, run.Score, want, want)
+		}
+	}
+}
+
+func TestRunGridCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls atomic.Int64
+	backtest := gridBacktest(&calls)
+	canceling := func(ctx context.Context, params strategy.MACrossoverParams) ([]strategy.EquityPoint, []strategy.Trade, error) {
+		if params.ShortWindow == 5 {
+			cancel()
+		}
+		return backtest(ctx, params)
+	}
+
+	candidates := gridCandidates(1000)
+	runs, err := runGrid(ctx, candidates, canceling, "return_pct", 100000, 0)
+	if !errors.Is(err, context.Canceled) || runs != nil {
+		t.Fatalf("runGrid = %d runs, %v; want no runs and context.Canceled", len(runs), err)
+	}
+	if calls.Load() >= int64(len(candidates)) {
+		t.Errorf("%d backtests started after canceling, want the grid to stop early", calls.Load())
+	}
+}
+
+func TestRunGridError(t *testing.T) {
+	var calls atomic.Int64
+	backtest := gridBacktest(&calls)
+	boom := errors.New("boom")
+	failing := func(ctx context.Context, params strategy.MACrossoverParams) ([]strategy.EquityPoint, []strategy.Trade, error) {
+		if params.ShortWindow == 3 {
+			return nil, nil, boom
+		}
+		return backtest(ctx, params)
+	}
+
+	candidates := gridCandidates(1000)
+	runs, err := runGrid(context.Background(), candidates, failing, "return_pct", 100000, 0)
+	if !errors.Is(err, boom) || runs != nil {
+		t.Fatalf("runGrid = %d runs, %v; want no runs and the backtest's error", len(runs), err)
+	}
+	if calls.Load() >= int64(len(candidates)) {
+		t.Errorf("%d backtests started after the failure, want the grid to stop early", calls.Load())
+	}
+
+	if _, err := runGrid(context.Background(), gridCandidates(2), backtest, "nope", 100000, 0); err == nil {
+		t.Error("unknown objective: want an error")
+	}
+}
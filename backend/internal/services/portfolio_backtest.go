@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+// PortfolioBacktestOptions carries parameters for a multi-stock backtest.
+// The universe is Codes when given, otherwise the watchlist, otherwise all
+// known stocks.
+type PortfolioBacktestOptions struct {
+	BacktestOptions
+	Codes        []string
+	WatchlistID  uint
+	MaxPositions int
+	Ranking      string
+	RankLookback int
+}
+
+// PortfolioBacktestResult packages the combined curve and per-stock breakdown.
+type PortfolioBacktestResult struct {
+	Summary       models.Backtest               `json:"summary"`
+	Points        []strategy.EquityPoint        `json:"points"`
+	Trades        []strategy.Trade              `json:"trades"`
+	Contributions []strategy.StockContribution  `json:"contributions"`
+	Benchmark     *strategy.BenchmarkComparison `json:"benchmark,omitempty"`
+}
+
+// RunPortfolioBacktest backtests a strategy across a universe of stocks
+// sharing one cash balance. It stops with ctx's error if ctx is done before
+// the result is saved.
+func (a *AnalysisService) RunPortfolioBacktest(ctx context.Context, options PortfolioBacktestOptions) (*PortfolioBacktestResult, error) {
+	strategyModel, err := a.strategies.Get(options.StrategyID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := a.resolveUniverse(options.Codes, options.WatchlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := options.engineOptions()
+	params := strategy.ParseMACrossoverParams(strategyModel.ParamsJSON)
+	warmup := options.warmup(engine, params)
+
+	series, reportFrom, first, err := a.loadUniverse(options.BacktestOptions, codes, warmup)
+	if err != nil {
+		return nil, err
+	}
+	engine.ReportFrom = reportFrom
+	if err := a.loadCorporateData(&engine, codes...); err != nil {
+		return nil, err
+	}
+
+	result, err := strategy.PortfolioBacktestContext(ctx, series, params, options.portfolioOptions(engine))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Points) == 0 {
+		return nil, errors.New("portfolio backtest produced no equity points")
+	}
+
+	initial := engine.InitialCapital
+	summary := models.Backtest{
+		StrategyID:      options.StrategyID,
+		Universe:        strings.Join(codes, ","),
+		Interval:        options.interval(),
+		Execution:       string(engine.Execution),
+		Start:           result.Points[0].Time,
+		End:             result.Points[len(result.Points)-1].Time,
+		InitialCapital:  initial,
+		FinalCapital:    result.Final,
+		ReturnPct:       (result.Final - initial) / initial * 100,
+		BacktestMetrics: strategy.ComputeMetrics(result.Points, result.Trades, initial, engine.BarsPerYear()),
+	}
+
+	benchmark, err := a.universeBenchmark(options.BacktestOptions, engine, series, first, result.Points)
+	if err != nil {
+		return nil, err
+	}
+	summary.BenchmarkCode = benchmark.Code
+	summary.BenchmarkMetrics = benchmark.Metrics
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := a.saveBacktest(&summary, result.Points, result.Trades, options.BacktestOptions); err != nil {
+		return nil, err
+	}
+
+	return &PortfolioBacktestResult{
+		Summary:       summary,
+		Points:        result.Points,
+		Trades:        result.Trades,
+		Contributions: result.Contributions,
+		Benchmark:     &benchmark,
+	}, nil
+}
+
+// universeBenchmark compares points with options.Benchmark, or with an
+// equal-weight index of the universe when none is set. first is the
+// earliest bar loaded.
+func (a *AnalysisService) universeBenchmark(options BacktestOptions, engine strategy.BacktestOptions, series map[string][]models.KLine, first time.Time, points []strategy.EquityPoint) (strategy.BenchmarkComparison, error) {
+	if options.Benchmark != "" {
+		klines, err := a.benchmarkKLines(options.Benchmark, options.interval(), first, options.End, engine.Adjust)
+		if err != nil {
+			return strategy.BenchmarkComparison{}, err
+		}
+		return strategy.CompareBenchmark(options.Benchmark, points, klines, engine.InitialCapital, engine.BarsPerYear()), nil
+	}
+	adjusted := make(map[string][]models.KLine, len(series))
+	for code, klines := range series {
+		adjusted[code] = strategy.AdjustKLines(klines, engine.Factors[code], engine.Adjust)
+	}
+	return strategy.CompareBenchmark("equal_weight", points, strategy.EqualWeightIndex(adjusted, engine.ReportFrom), engine.InitialCapital, engine.BarsPerYear()), nil
+}
+
+func (o PortfolioBacktestOptions) portfolioOptions(engine strategy.BacktestOptions) strategy.PortfolioOptions {
+	return strategy.PortfolioOptions{
+		BacktestOptions: engine,
+		MaxPositions:    o.MaxPositions,
+		Ranking:         o.Ranking,
+		RankLookback:    o.RankLookback,
+	}
+}
+
+// loadUniverse loads each code's window like loadWindow, skipping codes
+// without data. Without a start date every stock reports from its own first
+// bar after warm-up, so the portfolio reports from the earliest of those;
+// first is the earliest bar loaded, warm-up included.
+func (a *AnalysisService) loadUniverse(options BacktestOptions, codes []string, warmup int) (map[string][]models.KLine, time.Time, time.Time, error) {
+	series := make(map[string][]models.KLine, len(codes))
+	var reportFrom, first time.Time
+	for _, code := range codes {
+		klines, from, err := a.loadWindow(code, options.interval(), options.Start, options.End, warmup)
+		if err != nil {
+			return nil, time.Time{}, time.Time{}, err
+		}
+		if len(klines) == 0 {
+			continue
+		}
+		series[code] = klines
+		if reportFrom.IsZero() || from.Before(reportFrom) {
+			reportFrom = from
+		}
+		if first.IsZero() || klines[0].Time.Before(first) {
+			first = klines[0].Time
+		}
+	}
+	if len(series) == 0 {
+		return nil, time.Time{}, time.Time{}, errors.New("no kline data for the selected universe")
+	}
+	return series, reportFrom, first, nil
+}
+
+// resolveUniverse picks explicit codes, then a watchlist, then all stocks.
+func (a *AnalysisService) resolveUniverse(codes []string, watchlistID uint) ([]string, error) {
+	if len(codes) > 0 {
+		return SplitCodes(strings.Join(codes, ",")), nil
+	}
+
+	if watchlistID > 0 {
+		watchlist, err := a.watchlists.Get(watchlistID)
+		if err != nil {
+			return nil, fmt.Errorf("watchlist %d: %w", watchlistID, err)
+		}
+		codes = SplitCodes(watchlist.Codes)
+		if len(codes) == 0 {
+			return nil, fmt.Errorf("watchlist %d is empty", watchlistID)
+		}
+		return codes, nil
+	}
+
+	stocks, err := a.stocks.ListStocks()
+	if err != nil {
+		return nil, err
+	}
+	for _, stock := range stocks {
+		codes = append(codes, stock.Code)
+	}
+	return codes, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+// ScreenBacktestOptions carries parameters for backtesting a strategy's
+// screen as a rebalanced portfolio. The universe is resolved as for
+// portfolio backtests.
+type ScreenBacktestOptions struct {
+	BacktestOptions
+	Codes       []string
+	WatchlistID uint
+	// Rebalance is daily, weekly or monthly (default).
+	Rebalance string
+	// Weighting is equal (default) or score.
+	Weighting    string
+	HoldBars     int
+	MaxPositions int
+}
+
+// ScreenBacktestResult packages the curve, the rebalances and turnover of
+// a screen backtest.
+type ScreenBacktestResult struct {
+	Summary           models.Backtest               `json:"summary"`
+	Points            []strategy.EquityPoint        `json:"points"`
+	Trades            []strategy.Trade              `json:"trades"`
+	Rebalances        []strategy.RebalanceRecord    `json:"rebalances"`
+	AnnualTurnoverPct float64                       `json:"annual_turnover_pct"`
+	Benchmark         *strategy.BenchmarkComparison `json:"benchmark,omitempty"`
+}
+
+// RunScreenBacktest runs a strategy's screen over a universe at each
+// rebalance date and holds the picks as one portfolio. It stops with ctx's
+// error if ctx is done before the result is saved.
+func (a *AnalysisService) RunScreenBacktest(ctx context.Context, options ScreenBacktestOptions) (*ScreenBacktestResult, error) {
+	strategyModel, err := a.strategies.Get(options.StrategyID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := a.resolveUniverse(options.Codes, options.WatchlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := options.engineOptions()
+	params := strategy.ParseMACrossoverParams(strategyModel.ParamsJSON)
+	series, reportFrom, first, err := a.loadUniverse(options.BacktestOptions, codes, options.warmup(engine, params))
+	if err != nil {
+		return nil, err
+	}
+	engine.ReportFrom = reportFrom
+	if err := a.loadCorporateData(&engine, codes...); err != nil {
+		return nil, err
+	}
+
+	result, err := strategy.ScreenBacktestContext(ctx, series, params, strategy.ScreenBacktestOptions{
+		BacktestOptions: engine,
+		Rebalance:       options.Rebalance,
+		Weighting:       options.Weighting,
+		HoldBars:        options.HoldBars,
+		MaxPositions:    options.MaxPositions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Points) == 0 {
+		return nil, errors.New("screen backtest produced no equity points")
+	}
+
+	initial := engine.InitialCapital
+	summary := models.Backtest{
+		StrategyID:      options.StrategyID,
+		Universe:        strings.Join(codes, ","),
+		Interval:        options.interval(),
+		Execution:       string(engine.Execution),
+		Start:           result.Points[0].Time,
+		End:             result.Points[len(result.Points)-1].Time,
+		InitialCapital:  initial,
+		FinalCapital:    result.Final,
+		ReturnPct:       (result.Final - initial) / initial * 100,
+		BacktestMetrics: strategy.ComputeMetrics(result.Points, result.Trades, initial, engine.BarsPerYear()),
+	}
+
+	benchmark, err := a.universeBenchmark(options.BacktestOptions, engine, series, first, result.Points)
+	if err != nil {
+		return nil, err
+	}
+	summary.BenchmarkCode = benchmark.Code
+	summary.BenchmarkMetrics = benchmark.Metrics
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := a.saveBacktest(&summary, result.Points, result.Trades, options.BacktestOptions); err != nil {
+		return nil, err
+	}
+
+	return &ScreenBacktestResult{
+		Summary:           summary,
+		Points:            result.Points,
+		Trades:            result.Trades,
+		Rebalances:        result.Rebalances,
+		AnnualTurnoverPct: result.AnnualTurnoverPct,
+		Benchmark:         &benchmark,
+	}, nil
+}
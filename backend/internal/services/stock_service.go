@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // StockService encapsulates stock and kline persistence.
@@ -29,8 +31,9 @@ func (s *StockService) ListStocks() ([]models.Stock, error) {
 	return stocks, nil
 }
 
-// GetKLines fetches klines for a stock code and interval.
-func (s *StockService) GetKLines(code, interval string, limit int) ([]models.KLine, error) {
+// GetKLines fetches klines for a stock code and interval with prices
+// adjusted by the stock's adjustment factors as mode asks.
+func (s *StockService) GetKLines(code, interval string, limit int, mode strategy.AdjustMode) ([]models.KLine, error) {
 	if interval == "" {
 		interval = "1d"
 	}
@@ -43,6 +46,79 @@ func (s *StockService) GetKLines(code, interval string, limit int) ([]models.KLi
 	if err := query.Find(&klines).Error; err != nil {
 		return nil, err
 	}
+	if mode == strategy.AdjustNone {
+		return klines, nil
+	}
+	factors, err := s.GetAdjustFactors(code)
+	if err != nil {
+		return nil, err
+	}
+	return strategy.AdjustKLines(klines, factors, mode), nil
+}
+
+// GetCorporateActions returns a stock's dividends and bonus shares in
+// ex-date order.
+func (s *StockService) GetCorporateActions(code string) ([]models.CorporateAction, error) {
+	var actions []models.CorporateAction
+	if err := s.db.Where("stock_code = ?", code).Order("ex_date asc").Find(&actions).Error; err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// GetAdjustFactors returns a stock's adjustment factors in date order.
+func (s *StockService) GetAdjustFactors(code string) ([]models.AdjustFactor, error) {
+	var factors []models.AdjustFactor
+	if err := s.db.Where("stock_code = ?", code).Order("date asc").Find(&factors).Error; err != nil {
+		return nil, err
+	}
+	return factors, nil
+}
+
+// GetKLinesBetween fetches klines with start <= time < end in ascending
+// order. A zero bound leaves that side open.
+func (s *StockService) GetKLinesBetween(code, interval string, start, end time.Time) ([]models.KLine, error) {
+	if interval == "" {
+		interval = "1d"
+	}
+
+	query := s.db.Where("stock_code = ? AND interval = ?", code, interval)
+	if !start.IsZero() {
+		query = query.Where("time >= ?", start)
+	}
+	if !end.IsZero() {
+		query = query.Where("time < ?", end)
+	}
+
+	var klines []models.KLine
+	if err := query.Order("time asc").Find(&klines).Error; err != nil {
+		return nil, err
+	}
+	return klines, nil
+}
+
+// GetKLinesBefore fetches the latest limit klines strictly before t (or the
+// latest overall when t is zero), returned in ascending order.
+func (s *StockService) GetKLinesBefore(code, interval string, t time.Time, limit int) ([]models.KLine, error) {
+	if interval == "" {
+		interval = "1d"
+	}
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	query := s.db.Where("stock_code = ? AND interval = ?", code, interval)
+	if !t.IsZero() {
+		query = query.Where("time < ?", t)
+	}
+
+	var klines []models.KLine
+	if err := query.Order("time desc").Limit(limit).Find(&klines).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(klines)-1; i < j; i, j = i+1, j-1 {
+		klines[i], klines[j] = klines[j], klines[i]
+	}
 	return klines, nil
 }
 
@@ -61,12 +137,41 @@ func (s *StockService) UpsertStock(stock models.Stock) error {
 	return s.db.Save(&existing).Error
 }
 
-// SaveKLines persists kline entries.
+// EnsureStock creates a stock row unless one exists for its code and
+// reports whether it did.
+func (s *StockService) EnsureStock(stock models.Stock) (bool, error) {
+	result := s.db.Where(models.Stock{Code: stock.Code}).Attrs(stock).FirstOrCreate(&stock)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReplaceAdjustFactors replaces a stock's adjustment factors and returns
+// how many were saved. Without factors the existing ones are kept.
+func (s *StockService) ReplaceAdjustFactors(code string, factors []models.AdjustFactor) (int, error) {
+	if len(factors) == 0 {
+		return 0, nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("stock_code = ?", code).Delete(&models.AdjustFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(&factors, saveBatchSize).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(factors), nil
+}
+
+// SaveKLines persists kline entries, replacing the prices and volume of
+// bars that already exist for the same stock, interval and time.
 func (s *StockService) SaveKLines(klines []models.KLine) error {
 	if len(klines) == 0 {
 		return nil
 	}
-	return s.db.Create(&klines).Error
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "stock_code"}, {Name: "interval"}, {Name: "time"}},
+		DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "volume"}),
+	}).CreateInBatches(&klines, saveBatchSize).Error
 }
 
 // SeedDemoData populates the database with deterministic sample data.
@@ -95,7 +200,13 @@ func (s *StockService) seedKLines(code, interval string, points int, seed *rand.
 		return nil
 	}
 
-	start := time.Now().AddDate(0, 0, -points)
+	// Whole days keep the bars' times stable, so seeding again updates them.
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -points)
+	var sessionStamps []time.Time
+	if interval == "30m" {
+		sessionStamps = demoSessionStamps(points, 30)
+	}
 	price := base
 	klines := make([]models.KLine, 0, points)
 
@@ -109,8 +220,8 @@ func (s *StockService) seedKLines(code, interval string, points int, seed *rand.
 		volume := 100000 + seed.Float64()*200000
 
 		stamp := start.AddDate(0, 0, i)
-		if interval == "30m" {
-			stamp = start.Add(time.Duration(i) * 30 * time.Minute)
+		if sessionStamps != nil {
+			stamp = sessionStamps[i]
 		}
 
 		klines = append(klines, models.KLine{
@@ -129,3 +240,26 @@ func (s *StockService) seedKLines(code, interval string, points int, seed *rand.
 	sort.Slice(klines, func(i, j int) bool { return klines[i].Time.Before(klines[j].Time) })
 	return s.SaveKLines(klines)
 }
+
+// demoSessionStamps returns the bar-end times of the latest points
+// intraday bars of the given minutes before today, on weekdays within the
+// A-share trading sessions, in ascending order.
+func demoSessionStamps(points, minutes int) []time.Time {
+	stamps := make([]time.Time, points)
+	now := time.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for n := points - 1; n >= 0; {
+		day = day.AddDate(0, 0, -1)
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		for s := len(strategy.AShareSessions) - 1; s >= 0 && n >= 0; s-- {
+			session := strategy.AShareSessions[s]
+			for end := session.Close; end > session.Open && n >= 0; end -= minutes {
+				stamps[n] = day.Add(time.Duration(end) * time.Minute)
+				n--
+			}
+		}
+	}
+	return stamps
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/db"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+func newStockService(t *testing.T) *StockService {
+	t.Helper()
+	database, err := db.Open(filepath.Join(t.TempDir(), "stocks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStockService(database)
+}
+
+// TestSeedDemoData checks that demo bars fall on UTC midnights and UTC
+// session ends, and that seeding again updates them in place.
+func TestSeedDemoData(t *testing.T) {
+	s := newStockService(t)
+	for round := 0; round < 2; round++ {
+		if err := s.SeedDemoData(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var count int64
+	if err := s.db.Model(&models.KLine{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 2*(160+240) {
+		t.Errorf("%d bars after seeding twice, want %d", count, 2*(160+240))
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	daily, err := s.GetKLines("600519", "1d", 0, strategy.AdjustNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 160 || !daily[len(daily)-1].Time.Equal(today.AddDate(0, 0, -1)) {
+		t.Fatalf("got %d daily bars ending %v, want 160 ending %v", len(daily), daily[len(daily)-1].Time, today.AddDate(0, 0, -1))
+	}
+	for _, bar := range daily {
+		if !bar.Time.Equal(bar.Time.UTC().Truncate(24 * time.Hour)) {
+			t.Errorf("daily bar at %v, want UTC midnight", bar.Time)
+		}
+	}
+
+	intraday, err := s.GetKLines("600519", "30m", 0, strategy.AdjustNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionEnd, dayEnd := 0, 0
+	for _, bar := range intraday {
+		hour, minute, _ := bar.Time.UTC().Clock()
+		switch {
+		case hour == 11 && minute == 30:
+			sessionEnd++
+		case hour == 15 && minute == 0:
+			dayEnd++
+		}
+	}
+	// 240 bars are 30 days of eight.
+	if len(intraday) != 240 || sessionEnd != 30 || dayEnd != 30 {
+		t.Errorf("got %d 30m bars, %d ending at 11:30 and %d at 15:00 UTC; want 240, 30 and 30", len(intraday), sessionEnd, dayEnd)
+	}
+}
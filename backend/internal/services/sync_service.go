@@ -2,80 +2,197 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
 	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/provider"
 )
 
-// SyncOptions carries parameters for AkShare sync.
-type SyncOptions struct {
-	Symbols  []string
-	Mode     string
-	StartDate string
-	EndDate   string
-	MinStart  string
-	MinEnd    string
-	Period    string
-	Limit     int
+// SyncService syncs market data from a DataProvider in process.
+type SyncService struct {
+	provider provider.DataProvider
+	stocks   *StockService
+	timeout  time.Duration
 }
 
-// SyncService runs external sync jobs.
-type SyncService struct {
-	scriptPath string
-	dbPath     string
-	timeout    time.Duration
+// NewSyncService creates a SyncService whose syncs stop after timeout.
+func NewSyncService(dataProvider provider.DataProvider, stockService *StockService, timeout time.Duration) *SyncService {
+	return &SyncService{provider: dataProvider, stocks: stockService, timeout: timeout}
 }
 
-// NewSyncService creates a SyncService.
-func NewSyncService(scriptPath, dbPath string, timeout time.Duration) *SyncService {
-	return &SyncService{scriptPath: scriptPath, dbPath: dbPath, timeout: timeout}
+// Sync modes.
+const (
+	SyncDaily  = "daily"
+	SyncMinute = "minute"
+	SyncAll    = "all"
+)
+
+// ParseSyncMode normalizes a sync mode, all by default.
+func ParseSyncMode(raw string) (string, error) {
+	switch raw {
+	case "":
+		return SyncAll, nil
+	case SyncDaily, SyncMinute, SyncAll:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("unknown sync mode %q", raw)
+	}
 }
 
-// RunAkShareSync executes the AkShare sync script and returns its JSON summary.
-func (s *SyncService) RunAkShareSync(options SyncOptions) (map[string]any, error) {
-	args := []string{s.scriptPath}
-	if len(options.Symbols) > 0 {
-		args = append(args, "--symbols", strings.Join(options.Symbols, ","))
+// DataSyncOptions selects what Sync fetches. Without Symbols the first
+// Limit listed stocks (default 50) are synced. Zero times default to the
+// last 365 days of daily bars and the last 20 days of minute bars; minute
+// bars are Period minutes (default 30).
+type DataSyncOptions struct {
+	Symbols     []string
+	Mode        string
+	DailyStart  time.Time
+	DailyEnd    time.Time
+	MinuteStart time.Time
+	MinuteEnd   time.Time
+	Period      int
+	Limit       int
+}
+
+// SyncSummary reports the rows a sync wrote and the stocks it failed on.
+type SyncSummary struct {
+	Mode       string      `json:"mode"`
+	Stocks     int         `json:"stocks"`
+	DailyRows  int         `json:"daily_rows"`
+	MinuteRows int         `json:"minute_rows"`
+	FactorRows int         `json:"factor_rows"`
+	Errors     []SyncError `json:"errors"`
+}
+
+// SyncError is a failed fetch or write of one stock.
+type SyncError struct {
+	Symbol string `json:"symbol"`
+	Mode   string `json:"mode"`
+	Error  string `json:"error"`
+}
+
+// Sync fetches bars and adjustment factors from the provider and upserts
+// them. A failure on one stock is recorded in the summary and the sync goes
+// on; it stops with the context's error once ctx is done or the service's
+// timeout passes.
+func (s *SyncService) Sync(ctx context.Context, options DataSyncOptions) (*SyncSummary, error) {
+	if s.provider == nil {
+		return nil, errors.New("data provider not configured")
+	}
+	mode, err := ParseSyncMode(options.Mode)
+	if err != nil {
+		return nil, err
+	}
+	options = options.withDefaults(time.Now().UTC())
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	symbols, err := s.syncStocks(ctx, options)
+	if err != nil {
+		return nil, err
 	}
-	if options.Mode != "" {
-		args = append(args, "--mode", options.Mode)
+
+	summary := &SyncSummary{Mode: mode, Stocks: len(symbols), Errors: []SyncError{}}
+	fail := func(code, mode string, err error) {
+		summary.Errors = append(summary.Errors, SyncError{Symbol: code, Mode: mode, Error: err.Error()})
 	}
-	if options.StartDate != "" {
-		args = append(args, "--start-date", options.StartDate)
+	for _, code := range symbols {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		if mode != SyncMinute {
+			if rows, err := s.syncBars(s.provider.DailyBars(ctx, code, options.DailyStart, options.DailyEnd)); err != nil {
+				fail(code, SyncDaily, err)
+			} else {
+				summary.DailyRows += rows
+			}
+			if rows, err := s.syncFactors(ctx, code); err != nil {
+				fail(code, "factor", err)
+			} else {
+				summary.FactorRows += rows
+			}
+		}
+		if mode != SyncDaily {
+			if rows, err := s.syncBars(s.provider.MinuteBars(ctx, code, options.Period, options.MinuteStart, options.MinuteEnd)); err != nil {
+				fail(code, SyncMinute, err)
+			} else {
+				summary.MinuteRows += rows
+			}
+		}
 	}
-	if options.EndDate != "" {
-		args = append(args, "--end-date", options.EndDate)
+	return summary, ctx.Err()
+}
+
+// withDefaults fills in the ranges, period and limit left unset.
+func (o DataSyncOptions) withDefaults(now time.Time) DataSyncOptions {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if o.DailyEnd.IsZero() {
+		o.DailyEnd = today
 	}
-	if options.MinStart != "" {
-		args = append(args, "--min-start", options.MinStart)
+	if o.DailyStart.IsZero() {
+		o.DailyStart = o.DailyEnd.AddDate(0, 0, -365)
 	}
-	if options.MinEnd != "" {
-		args = append(args, "--min-end", options.MinEnd)
+	if o.MinuteEnd.IsZero() {
+		o.MinuteEnd = today.Add(15 * time.Hour)
 	}
-	if options.Period != "" {
-		args = append(args, "--period", options.Period)
+	if o.MinuteStart.IsZero() {
+		day := o.MinuteEnd.AddDate(0, 0, -20)
+		o.MinuteStart = time.Date(day.Year(), day.Month(), day.Day(), 9, 30, 0, 0, time.UTC)
 	}
-	if options.Limit > 0 {
-		args = append(args, "--limit", fmt.Sprintf("%d", options.Limit))
+	if o.Period == 0 {
+		o.Period = 30
 	}
+	if o.Limit <= 0 {
+		o.Limit = 50
+	}
+	return o
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
-	defer cancel()
+// syncStocks records the stocks to sync and returns their codes. Listed
+// stocks are saved with their names; given symbols are only added when
+// missing, named by their code.
+func (s *SyncService) syncStocks(ctx context.Context, options DataSyncOptions) ([]string, error) {
+	if len(options.Symbols) > 0 {
+		for _, code := range options.Symbols {
+			if _, err := s.stocks.EnsureStock(models.Stock{Code: code, Name: code, Exchange: provider.Exchange(code)}); err != nil {
+				return nil, err
+			}
+		}
+		return options.Symbols, nil
+	}
 
-	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("DB_PATH=%s", s.dbPath))
+	stocks, err := s.provider.ListSecurities(ctx, options.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("list securities: %w", err)
+	}
+	symbols := make([]string, 0, len(stocks))
+	for _, stock := range stocks {
+		if err := s.stocks.UpsertStock(stock); err != nil {
+			return nil, err
+		}
+		symbols = append(symbols, stock.Code)
+	}
+	return symbols, nil
+}
 
-	output, err := cmd.CombinedOutput()
+// syncBars saves fetched bars and returns how many there were.
+func (s *SyncService) syncBars(bars []models.KLine, err error) (int, error) {
 	if err != nil {
-		return nil, fmt.Errorf("akshare sync failed: %w: %s", err, string(output))
+		return 0, err
+	}
+	if err := s.stocks.SaveKLines(bars); err != nil {
+		return 0, err
 	}
+	return len(bars), nil
+}
 
-	var summary map[string]any
-	if err := json.Unmarshal(output, &summary); err != nil {
-		return nil, fmt.Errorf("parse akshare output: %w: %s", err, string(output))
+// syncFactors replaces a stock's adjustment factors with the provider's.
+func (s *SyncService) syncFactors(ctx context.Context, code string) (int, error) {
+	factors, err := s.provider.AdjustFactors(ctx, code)
+	if err != nil {
+		return 0, err
 	}
-	return summary, nil
+	return s.stocks.ReplaceAdjustFactors(code, factors)
 }
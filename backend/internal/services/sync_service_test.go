@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/provider/providertest"
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+func syncData() providertest.Data {
+	day := func(d int) time.Time { return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC) }
+	bar := func(code, interval string, stamp time.Time, price float64) models.KLine {
+		return models.KLine{StockCode: code, Interval: interval, Time: stamp, Open: price, High: price + 0.5, Low: price - 0.5, Close: price, Volume: 1000}
+	}
+	return providertest.Data{
+		Stocks: []models.Stock{
+			{Code: "000001", Name: "平安银行", Exchange: "SZ"},
+			{Code: "600519", Name: "贵州茅台", Exchange: "SH"},
+		},
+		Bars: []models.KLine{
+			bar("000001", "1d", day(17), 10.1),
+			bar("000001", "1d", day(18), 10.2),
+			bar("000001", "1d", day(19), 10.3),
+			bar("000001", "30m", day(19).Add(10*time.Hour), 10.25),
+			bar("000001", "30m", day(19).Add(10*time.Hour+30*time.Minute), 10.3),
+			bar("600519", "1d", day(18), 1619),
+			bar("600519", "1d", day(19), 1570),
+		},
+		Factors: []models.AdjustFactor{
+			{StockCode: "000001", Date: time.Date(1991, 4, 3, 0, 0, 0, 0, time.UTC), Factor: 1},
+			{StockCode: "000001", Date: day(14), Factor: 1.05},
+		},
+	}
+}
+
+func syncOptions(symbols ...string) DataSyncOptions {
+	return DataSyncOptions{
+		Symbols:     symbols,
+		DailyStart:  time.Date(2024, 6, 18, 0, 0, 0, 0, time.UTC),
+		DailyEnd:    time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC),
+		MinuteStart: time.Date(2024, 6, 19, 9, 30, 0, 0, time.UTC),
+		MinuteEnd:   time.Date(2024, 6, 19, 15, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestSync(t *testing.T) {
+	fake := providertest.NewFake(syncData())
+	defer fake.Close()
+	stocks := newStockService(t)
+	sync := NewSyncService(fake, stocks, time.Minute)
+
+	// A stale bar and factor the sync replaces.
+	if err := stocks.SaveKLines([]models.KLine{{StockCode: "000001", Interval: "1d", Time: time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC), Close: 9}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stocks.ReplaceAdjustFactors("000001", []models.AdjustFactor{{StockCode: "000001", Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Factor: 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 2; round++ {
+		summary, err := sync.Sync(context.Background(), syncOptions("000001", "600519"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := SyncSummary{Mode: SyncAll, Stocks: 2, DailyRows: 4, MinuteRows: 2, FactorRows: 2, Errors: []SyncError{}}
+		if !reflect.DeepEqual(*summary, want) {
+			t.Errorf("round %d: summary %+v, want %+v", round, *summary, want)
+		}
+	}
+
+	daily, err := stocks.GetKLines("000001", "1d", 0, strategy.AdjustNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 2 || daily[1].Close != 10.3 || daily[1].Volume != 1000 {
+		t.Errorf("000001 daily bars %+v, want those of 06-18 and 06-19 with the stale close replaced", daily)
+	}
+	minute, err := stocks.GetKLines("000001", "30m", 0, strategy.AdjustNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(minute) != 2 || !minute[0].Time.Equal(time.Date(2024, 6, 19, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("000001 30m bars %+v, want two from 10:00 UTC", minute)
+	}
+	factors, err := stocks.GetAdjustFactors("000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(factors) != 2 || factors[1].Factor != 1.05 {
+		t.Errorf("000001 factors %+v, want the provider's two", factors)
+	}
+}
+
+func TestSyncErrors(t *testing.T) {
+	fake := providertest.NewFake(syncData())
+	defer fake.Close()
+	stocks := newStockService(t)
+	sync := NewSyncService(fake, stocks, time.Minute)
+
+	// 300750 is unknown to the provider; the sync records its failures and
+	// goes on with 600519.
+	options := syncOptions("300750", "600519")
+	options.Mode = SyncDaily
+	summary, err := sync.Sync(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Stocks != 2 || summary.DailyRows != 2 || summary.MinuteRows != 0 {
+		t.Errorf("summary %+v, want 2 stocks and 600519's 2 daily rows", *summary)
+	}
+	var modes []string
+	for _, e := range summary.Errors {
+		if e.Symbol != "300750" {
+			t.Errorf("error for %s: %s", e.Symbol, e.Error)
+		}
+		modes = append(modes, e.Mode)
+	}
+	if !reflect.DeepEqual(modes, []string{SyncDaily, "factor"}) {
+		t.Errorf("errors %+v, want daily and factor failures of 300750", summary.Errors)
+	}
+
+	// Given symbols are added named by their code.
+	list, err := stocks.ListStocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Code != "300750" || list[0].Name != "300750" || list[0].Exchange != "SZ" {
+		t.Errorf("stocks %+v, want 300750 and 600519", list)
+	}
+
+	if _, err := sync.Sync(context.Background(), DataSyncOptions{Mode: "weekly"}); err == nil {
+		t.Error("Sync accepted an unknown mode")
+	}
+	if _, err := NewSyncService(nil, stocks, time.Minute).Sync(context.Background(), DataSyncOptions{}); err == nil {
+		t.Error("Sync ran without a provider")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sync.Sync(ctx, syncOptions("600519")); err != context.Canceled {
+		t.Errorf("canceled sync: error %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestSyncListed syncs the first listed stock with its name.
+func TestSyncListed(t *testing.T) {
+	fake := providertest.NewFake(syncData())
+	defer fake.Close()
+	stocks := newStockService(t)
+	sync := NewSyncService(fake, stocks, time.Minute)
+
+	options := syncOptions()
+	options.Limit = 1
+	options.Mode = SyncMinute
+	summary, err := sync.Sync(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Stocks != 1 || summary.MinuteRows != 2 || summary.DailyRows != 0 || summary.FactorRows != 0 || len(summary.Errors) != 0 {
+		t.Errorf("summary %+v, want 000001's 2 minute rows", *summary)
+	}
+	list, err := stocks.ListStocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "平安银行" {
+		t.Errorf("stocks %+v, want 000001 named", list)
+	}
+}
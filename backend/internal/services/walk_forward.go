@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/strategy"
+)
+
+// WalkForwardOptions carries a walk-forward analysis of one stock.
+type WalkForwardOptions struct {
+	BacktestOptions
+	StrategyType    string
+	Grid            strategy.ParamGrid
+	Objective       string
+	InSampleBars    int
+	OutOfSampleBars int
+	Anchored        bool
+}
+
+// WalkForward re-optimizes the grid on rolling in-sample windows of the
+// requested range and stitches the out-of-sample results into one curve.
+// The analysis is not saved to the backtest history.
+func (a *AnalysisService) WalkForward(ctx context.Context, options WalkForwardOptions) (*strategy.WalkForwardResult, error) {
+	if options.StockCode == "" {
+		return nil, errors.New("walk-forward analysis needs a stock_code")
+	}
+	_, base, err := a.baseParams(options.StrategyID, options.StrategyType)
+	if err != nil {
+		return nil, err
+	}
+	combos, err := options.Grid.Combinations()
+	if err != nil {
+		return nil, err
+	}
+	sets, _, err := strategy.ParamSets(base, combos)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := options.engineOptions()
+	warmup := options.WarmupBars
+	if warmup <= 0 {
+		for _, set := range sets {
+			warmup = max(warmup, engine.WarmupBars(set.Params))
+		}
+	}
+	klines, reportFrom, err := a.loadWindow(options.StockCode, options.interval(), options.Start, options.End, warmup)
+	if err != nil {
+		return nil, err
+	}
+	if len(klines) == 0 {
+		return nil, fmt.Errorf("no kline data for %s in the requested range", options.StockCode)
+	}
+	engine.ReportFrom = reportFrom
+	if err := a.loadCorporateData(&engine, options.StockCode); err != nil {
+		return nil, err
+	}
+
+	result, err := strategy.WalkForward(ctx, klines, sets, strategy.WalkForwardOptions{
+		BacktestOptions: engine,
+		InSampleBars:    options.InSampleBars,
+		OutOfSampleBars: options.OutOfSampleBars,
+		Anchored:        options.Anchored,
+		Objective:       options.Objective,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
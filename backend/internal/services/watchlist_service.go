@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// WatchlistService manages named stock lists.
+type WatchlistService struct {
+	db *gorm.DB
+}
+
+// NewWatchlistService constructs a WatchlistService.
+func NewWatchlistService(db *gorm.DB) *WatchlistService {
+	return &WatchlistService{db: db}
+}
+
+// List returns all watchlists.
+func (s *WatchlistService) List() ([]models.Watchlist, error) {
+	var watchlists []models.Watchlist
+	if err := s.db.Order("id desc").Find(&watchlists).Error; err != nil {
+		return nil, err
+	}
+	return watchlists, nil
+}
+
+// Get fetches a watchlist by ID.
+func (s *WatchlistService) Get(id uint) (*models.Watchlist, error) {
+	var watchlist models.Watchlist
+	if err := s.db.First(&watchlist, id).Error; err != nil {
+		return nil, err
+	}
+	return &watchlist, nil
+}
+
+// Create inserts a new watchlist.
+func (s *WatchlistService) Create(watchlist *models.Watchlist) error {
+	if watchlist == nil {
+		return errors.New("watchlist is nil")
+	}
+	watchlist.Codes = strings.Join(SplitCodes(watchlist.Codes), ",")
+	return s.db.Create(watchlist).Error
+}
+
+// Delete removes a watchlist by ID.
+func (s *WatchlistService) Delete(id uint) error {
+	return s.db.Delete(&models.Watchlist{}, id).Error
+}
+
+// SplitCodes parses a comma-separated code list, dropping blanks and duplicates.
+func SplitCodes(raw string) []string {
+	seen := make(map[string]bool)
+	var codes []string
+	for _, code := range strings.Split(raw, ",") {
+		code = strings.TrimSpace(code)
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	return codes
+}
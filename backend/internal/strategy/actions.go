@@ -0,0 +1,161 @@
+package strategy
+
+import (
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// Trade sides. Besides fills, the trade list records corporate actions on
+// held shares: DIVIDEND credits Price yuan per share on Shares shares and
+// BONUS adds Shares bonus shares at no cost. Both have negative Shares on
+// short positions, which owe the lender the dividend and the shares.
+const (
+	SideBuy      = "BUY"
+	SideSell     = "SELL"
+	SideDividend = "DIVIDEND"
+	SideBonus    = "BONUS"
+)
+
+// Reasons recorded on corporate-action trades.
+const (
+	ReasonDividend = "dividend"
+	ReasonBonus    = "bonus"
+)
+
+// taxLot is a block of shares bought at one time, with the cash dividends
+// it has received per share, for the holding-period dividend tax.
+type taxLot struct {
+	shares   float64
+	since    time.Time
+	dividend float64
+}
+
+// receivable is a cash dividend that went ex but is not paid yet; it is
+// negative when a short position owes it to the lender.
+type receivable struct {
+	payDate time.Time
+	amount  float64
+}
+
+// dividendTaxRate is the differential dividend tax (差别化红利税) on shares
+// sold at the given time: 20% when held up to one month, 10% up to one
+// year and nothing beyond.
+func dividendTaxRate(since, sold time.Time) float64 {
+	switch {
+	case !sold.After(since.AddDate(0, 1, 0)):
+		return 0.2
+	case !sold.After(since.AddDate(1, 0, 0)):
+		return 0.1
+	default:
+		return 0
+	}
+}
+
+// exRights brings the stock to the price basis of its current bar. Its
+// corporate actions going ex since the previous bar are applied; a stock
+// without action records is rescaled by its adjustment factors instead.
+func (b *Broker) exRights(h *holding) {
+	at := h.bars[h.bar].Time
+	for h.nextAction < len(h.actions) && !h.actions[h.nextAction].ExDate.After(at) {
+		action := h.actions[h.nextAction]
+		h.nextAction++
+		if h.bar > 0 {
+			b.applyAction(h, action)
+		}
+	}
+	if h.actions == nil && h.bar > 0 && h.ratio(h.bar) != h.ratio(h.bar-1) {
+		b.adjust(h, h.ratio(h.bar)/h.ratio(h.bar-1))
+	}
+}
+
+// applyAction credits a dividend and bonus shares to the open position, or
+// charges them to a short one, and moves working order prices to the
+// ex-rights reference price.
+func (b *Broker) applyAction(h *holding, action models.CorporateAction) {
+	bar := h.bars[h.bar]
+	cash, bonus := action.CashPerShare, action.BonusRatio
+	if cash <= 0 && bonus <= 0 {
+		return
+	}
+	// The ex-rights reference price is (close - cash) / (1 + bonus).
+	ratio := 1 + bonus
+	if prev := h.bars[h.bar-1].Close; prev > cash {
+		ratio = (1 + bonus) * prev / (prev - cash)
+	}
+
+	if h.position != 0 {
+		if dividend := h.position * cash; dividend != 0 {
+			if action.PayDate.After(bar.Time) {
+				b.receivables = append(b.receivables, receivable{payDate: action.PayDate, amount: dividend})
+			} else {
+				b.receive(dividend)
+			}
+			h.proceeds += dividend
+			h.cashFlow += dividend
+			b.trades = append(b.trades, Trade{StockCode: h.code, Time: bar.Time, Side: SideDividend, Price: cash, Shares: h.position, Reason: ReasonDividend})
+		}
+		for i := range h.lots {
+			h.lots[i].dividend = (h.lots[i].dividend + cash) / (1 + bonus)
+			h.lots[i].shares *= 1 + bonus
+		}
+		if added := h.position * bonus; added != 0 {
+			b.trades = append(b.trades, Trade{StockCode: h.code, Time: bar.Time, Side: SideBonus, Shares: added, Reason: ReasonBonus})
+		}
+		h.position *= 1 + bonus
+		h.avgPrice /= 1 + bonus
+	}
+	h.lastClose /= ratio
+	for _, o := range b.orders {
+		if o.StockCode != h.code {
+			continue
+		}
+		o.Shares *= 1 + bonus
+		o.remaining *= 1 + bonus
+		o.Filled *= 1 + bonus
+		o.LimitPrice /= ratio
+		o.StopPrice /= ratio
+	}
+	b.emit(h, Event{Type: EventAdjust, Position: h.position, Ratio: ratio})
+}
+
+// collect pays the dividends due by stamp.
+func (b *Broker) collect(stamp time.Time) {
+	pending := b.receivables[:0]
+	for _, r := range b.receivables {
+		if r.payDate.After(stamp) {
+			pending = append(pending, r)
+			continue
+		}
+		b.receive(r.amount)
+	}
+	b.receivables = pending
+}
+
+// receivable returns the dividends that went ex but are not paid yet.
+func (b *Broker) receivable() float64 {
+	var total float64
+	for _, r := range b.receivables {
+		total += r.amount
+	}
+	return total
+}
+
+// sellLots takes qty shares from the stock's lots, oldest first, and returns
+// the dividend tax due on them.
+func (b *Broker) sellLots(h *holding, qty float64, sold time.Time) float64 {
+	var tax float64
+	for qty > 0 && len(h.lots) > 0 {
+		lot := &h.lots[0]
+		take := min(qty, lot.shares)
+		if !b.opts.TaxFree {
+			tax += take * lot.dividend * dividendTaxRate(lot.since, sold)
+		}
+		lot.shares -= take
+		qty -= take
+		if lot.shares <= 1e-9 {
+			h.lots = h.lots[1:]
+		}
+	}
+	return tax
+}
@@ -0,0 +1,109 @@
+package strategy
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+func TestDividendTaxRate(t *testing.T) {
+	bought := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		sold time.Time
+		want float64
+	}{
+		{bought.AddDate(0, 0, 10), 0.2},
+		{time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), 0.2}, // AddDate normalizes Feb 31 to Mar 2
+		{time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC), 0.1},
+		{bought.AddDate(1, 0, 0), 0.1},
+		{bought.AddDate(1, 0, 1), 0},
+	}
+	for _, tt := range tests {
+		if got := dividendTaxRate(bought, tt.sold); got != tt.want {
+			t.Errorf("dividendTaxRate(%s) = %v, want %v", tt.sold.Format("2006-01-02"), got, tt.want)
+		}
+	}
+}
+
+// TestCorporateAction buys 1000 shares at 20 and holds them through a
+// 0.5 yuan dividend with 5 bonus shares per 10, paid three days after the
+// ex-date, when the price drops to the ex-rights reference of 13.
+func TestCorporateAction(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const exDate, payDate = 10, 13
+	closes := make([]float64, 60)
+	for i := range closes {
+		closes[i] = 20
+		if i >= exDate {
+			closes[i] = 13
+		}
+	}
+	bars := closeBars(closes)
+	actions := map[string][]models.CorporateAction{"X": {{
+		StockCode:    "X",
+		ExDate:       start.AddDate(0, 0, exDate),
+		PayDate:      start.AddDate(0, 0, payDate),
+		CashPerShare: 0.5,
+		BonusRatio:   0.5,
+	}}}
+
+	tests := []struct {
+		sellAt  int
+		taxFree bool
+		tax     float64
+	}{
+		{20, false, 100}, // 20% on 1500 x 0.5/1.5 within a month
+		{50, false, 50},  // 10% after a month
+		{20, true, 0},
+	}
+	for _, tt := range tests {
+		var stop float64
+		s := &scriptStrategy{
+			orders: map[int][]Order{
+				2:         {{StockCode: "X", Side: 1, Shares: 1000}},
+				5:         {{StockCode: "X", Side: -1, Type: OrderStop, StopPrice: 19}},
+				tt.sellAt: {{StockCode: "X", Side: -1}},
+			},
+			inspect: func(b *Broker, stamp int) {
+				if stamp < exDate || stamp > payDate {
+					return
+				}
+				wantCash := 80000.0
+				if stamp == payDate {
+					wantCash += 500
+				}
+				if b.Position("X") != 1500 || b.Cash() != wantCash || math.Abs(b.Equity()-100000) > 1e-6 {
+					t.Errorf("bar %d: position %v cash %v equity %v, want 1500, %v, 100000", stamp, b.Position("X"), b.Cash(), b.Equity(), wantCash)
+				}
+				if orders := b.Orders("X"); len(orders) == 1 {
+					stop = orders[0].StopPrice
+				}
+			},
+		}
+		opts := BacktestOptions{Actions: actions, TaxFree: tt.taxFree}
+		res, err := Run(context.Background(), map[string][]models.KLine{"X": bars}, s, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkTrades(t, res.Trades, []wantTrade{
+			{"X", 3, SideBuy, 20, 1000},
+			{"X", exDate, SideDividend, 0.5, 1000},
+			{"X", exDate, SideBonus, 0, 500},
+			{"X", tt.sellAt + 1, SideSell, 13, 1500},
+		})
+		if sale := res.Trades[len(res.Trades)-1]; math.Abs(sale.Tax-tt.tax) > 1e-9 {
+			t.Errorf("sell at %d, tax free %v: tax %v, want %v", tt.sellAt, tt.taxFree, sale.Tax, tt.tax)
+		}
+		if math.Abs(res.Final-(100000-tt.tax)) > 1e-6 {
+			t.Errorf("sell at %d, tax free %v: final %v, want %v", tt.sellAt, tt.taxFree, res.Final, 100000-tt.tax)
+		}
+		// The stop moves with the price to 19 / (1.5 x 20 / 19.5).
+		if want := 19 * 19.5 / 30; math.Abs(stop-want) > 1e-9 {
+			t.Errorf("stop price after the ex-date = %v, want %v", stop, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package strategy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// AdjustMode names how prices are adjusted for splits and dividends.
+type AdjustMode string
+
+const (
+	// AdjustNone keeps raw traded prices.
+	AdjustNone AdjustMode = "none"
+	// AdjustForward (前复权) keeps the latest prices and scales earlier ones.
+	AdjustForward AdjustMode = "qfq"
+	// AdjustBackward (后复权) scales prices by the cumulative factor, keeping
+	// those before the first corporate action.
+	AdjustBackward AdjustMode = "hfq"
+)
+
+// ParseAdjustMode validates an adjustment mode; empty means none.
+func ParseAdjustMode(raw string) (AdjustMode, error) {
+	switch mode := AdjustMode(raw); mode {
+	case "":
+		return AdjustNone, nil
+	case AdjustNone, AdjustForward, AdjustBackward:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown adjust mode %q (want none, qfq or hfq)", raw)
+	}
+}
+
+// AdjustKLines returns copies of klines with open, high, low and close
+// adjusted by the stock's factors. Bars before the first factor use it;
+// volumes stay in raw shares. Without factors the bars are returned as is.
+func AdjustKLines(klines []models.KLine, factors []models.AdjustFactor, mode AdjustMode) []models.KLine {
+	ratios := adjustRatios(klines, factors, mode)
+	if ratios == nil {
+		return klines
+	}
+	return applyRatios(klines, ratios)
+}
+
+// adjustRatios returns, per bar, the multiplier from raw to adjusted
+// prices, or nil when mode is none or there are no usable factors.
+func adjustRatios(klines []models.KLine, factors []models.AdjustFactor, mode AdjustMode) []float64 {
+	if mode == AdjustNone || mode == "" {
+		return nil
+	}
+	sorted := make([]models.AdjustFactor, 0, len(factors))
+	for _, factor := range factors {
+		if factor.Factor > 0 {
+			sorted = append(sorted, factor)
+		}
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+
+	base := 1.0
+	if mode == AdjustForward {
+		base = sorted[len(sorted)-1].Factor
+	}
+	ratios := make([]float64, len(klines))
+	for i, bar := range klines {
+		k := sort.Search(len(sorted), func(k int) bool { return sorted[k].Date.After(bar.Time) }) - 1
+		ratios[i] = sorted[max(k, 0)].Factor / base
+	}
+	return ratios
+}
+
+func applyRatios(klines []models.KLine, ratios []float64) []models.KLine {
+	adjusted := make([]models.KLine, len(klines))
+	for i, bar := range klines {
+		bar.Open *= ratios[i]
+		bar.High *= ratios[i]
+		bar.Low *= ratios[i]
+		bar.Close *= ratios[i]
+		adjusted[i] = bar
+	}
+	return adjusted
+}
+
+// adjusted returns the bars of one stock as strategies see them under the
+// options' adjustment mode.
+func (o BacktestOptions) adjusted(code string, sorted []models.KLine) []models.KLine {
+	return AdjustKLines(sorted, o.Factors[code], o.Adjust)
+}
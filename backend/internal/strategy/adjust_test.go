@@ -0,0 +1,111 @@
+package strategy
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+func TestParseAdjustMode(t *testing.T) {
+	tests := map[string]AdjustMode{"": AdjustNone, "none": AdjustNone, "qfq": AdjustForward, "hfq": AdjustBackward}
+	for raw, want := range tests {
+		if got, err := ParseAdjustMode(raw); err != nil || got != want {
+			t.Errorf("ParseAdjustMode(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := ParseAdjustMode("forward"); err == nil {
+		t.Error("ParseAdjustMode accepted an unknown mode")
+	}
+}
+
+func TestAdjustKLines(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bars := closeBars([]float64{10, 10, 5, 5, 4})
+	factors := []models.AdjustFactor{
+		{StockCode: "X", Date: start.AddDate(0, 0, 4), Factor: 2.5},
+		{StockCode: "X", Date: start.AddDate(0, 0, 1), Factor: 1},
+		{StockCode: "X", Date: start.AddDate(0, 0, 2), Factor: 2},
+		{StockCode: "X", Date: start.AddDate(0, 0, 3), Factor: 0}, // ignored
+	}
+
+	tests := []struct {
+		mode AdjustMode
+		want []float64
+	}{
+		// The first bar precedes every factor and uses the earliest.
+		{AdjustBackward, []float64{10, 10, 10, 10, 10}},
+		{AdjustForward, []float64{4, 4, 4, 4, 4}},
+	}
+	for _, tt := range tests {
+		adjusted := AdjustKLines(bars, factors, tt.mode)
+		for i, bar := range adjusted {
+			if math.Abs(bar.Close-tt.want[i]) > 1e-9 || math.Abs(bar.Open-tt.want[i]) > 1e-9 {
+				t.Errorf("%s bar %d: open %v close %v, want %v", tt.mode, i, bar.Open, bar.Close, tt.want[i])
+			}
+			if bar.Volume != bars[i].Volume {
+				t.Errorf("%s bar %d: volume %v, want raw %v", tt.mode, i, bar.Volume, bars[i].Volume)
+			}
+		}
+		if bars[2].Close != 5 {
+			t.Fatalf("%s: AdjustKLines modified its input", tt.mode)
+		}
+	}
+
+	if got := AdjustKLines(bars, factors, AdjustNone); &got[0] != &bars[0] {
+		t.Error("AdjustKLines copied bars for mode none")
+	}
+	if got := AdjustKLines(bars, nil, AdjustForward); &got[0] != &bars[0] {
+		t.Error("AdjustKLines copied bars without factors")
+	}
+}
+
+// TestAdjustedBacktest checks that a run on raw bars with factors takes
+// its signals from the adjusted series but trades at raw prices.
+func TestAdjustedBacktest(t *testing.T) {
+	start := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	const split = 137 // the price halves from this bar on
+	var raw, backward []models.KLine
+	price := 20.0
+	for i := 0; i < 300; i++ {
+		price *= 1 + 0.02*math.Sin(float64(i)/9)
+		bar := models.KLine{StockCode: "X", Time: start.AddDate(0, 0, i), Open: price * 0.995, High: price * 1.01, Low: price * 0.99, Close: price, Volume: 1e7}
+		backward = append(backward, bar)
+		if i >= split {
+			bar.Open, bar.High, bar.Low, bar.Close = bar.Open/2, bar.High/2, bar.Low/2, bar.Close/2
+		}
+		raw = append(raw, bar)
+	}
+	factors := map[string][]models.AdjustFactor{"X": {
+		{StockCode: "X", Date: start.AddDate(-1, 0, 0), Factor: 1},
+		{StockCode: "X", Date: start.AddDate(0, 0, split), Factor: 2},
+	}}
+	params := MACrossoverParams{ShortWindow: 5, LongWindow: 20}
+
+	_, _, want := Backtest(backward, params, BacktestOptions{})
+	_, _, unadjusted := Backtest(raw, params, BacktestOptions{})
+	changed := len(unadjusted) != len(want)
+	for i := 0; !changed && i < len(want); i++ {
+		changed = !unadjusted[i].Time.Equal(want[i].Time)
+	}
+	if !changed {
+		t.Fatal("the split does not change the raw run's trades; the test proves nothing")
+	}
+	for _, mode := range []AdjustMode{AdjustForward, AdjustBackward} {
+		_, _, got := Backtest(raw, params, BacktestOptions{Adjust: mode, Factors: factors})
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d trades, want %d", mode, len(got), len(want))
+		}
+		for i := range want {
+			ratio := 1.0
+			if !want[i].Time.Before(start.AddDate(0, 0, split)) {
+				ratio = 2
+			}
+			if !got[i].Time.Equal(want[i].Time) || got[i].Side != want[i].Side || math.Abs(got[i].Price*ratio-want[i].Price) > 1e-9 {
+				t.Errorf("%s trade %d = %s %s %v, want %s %s %v", mode, i,
+					got[i].Time.Format("2006-01-02"), got[i].Side, got[i].Price, want[i].Time.Format("2006-01-02"), want[i].Side, want[i].Price/ratio)
+			}
+		}
+	}
+}
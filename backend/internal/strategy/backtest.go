@@ -0,0 +1,175 @@
+package strategy
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// ExecutionMode controls which price a signal is filled at.
+type ExecutionMode string
+
+const (
+	// ExecSameClose fills at the close of the signal bar (optimistic).
+	ExecSameClose ExecutionMode = "same_close"
+	// ExecNextOpen fills at the open of the bar after the signal.
+	ExecNextOpen ExecutionMode = "next_open"
+	// ExecNextClose fills at the close of the bar after the signal.
+	ExecNextClose ExecutionMode = "next_close"
+	// ExecNextVWAP fills at (High+Low+Close)/3 of the bar after the signal.
+	ExecNextVWAP ExecutionMode = "next_vwap"
+)
+
+// DefaultExecutionMode avoids filling on the bar that produced the signal.
+const DefaultExecutionMode = ExecNextOpen
+
+// ParseExecutionMode normalizes a mode name, falling back to the default.
+func ParseExecutionMode(raw string) ExecutionMode {
+	switch mode := ExecutionMode(raw); mode {
+	case ExecSameClose, ExecNextOpen, ExecNextClose, ExecNextVWAP:
+		return mode
+	default:
+		return DefaultExecutionMode
+	}
+}
+
+// fillPrice returns the execution price on the given bar for the mode.
+func (m ExecutionMode) fillPrice(bar models.KLine) float64 {
+	switch m {
+	case ExecNextOpen:
+		return bar.Open
+	case ExecNextVWAP:
+		return (bar.High + bar.Low + bar.Close) / 3
+	default:
+		return bar.Close
+	}
+}
+
+// BacktestOptions configures a backtest run.
+type BacktestOptions struct {
+	InitialCapital float64
+	Execution      ExecutionMode
+	Slippage       SlippageConfig
+	Exits          ExitConfig
+	Sizing         SizingConfig
+	// ReportFrom marks the first bar of the requested window. Earlier bars
+	// only warm up indicators: they produce no trades and no equity points.
+	ReportFrom time.Time
+	// CloseAtEnd closes every open position at the last bar's close, in
+	// portfolio backtests as well as single-stock ones.
+	CloseAtEnd bool
+	// Interval is the bar interval, such as "1d" (the default) or "30m".
+	// Intraday bars outside the A-share sessions are ignored.
+	Interval string
+	// SessionExit decides whether intraday positions are held over the
+	// lunch break and overnight or closed at the end of the session.
+	SessionExit SessionExit
+	// Adjust selects the price adjustment strategies see; orders still fill
+	// on raw prices, and positions and working orders are rescaled on the
+	// ex-dates in Factors so their value is unchanged.
+	Adjust AdjustMode
+	// Factors holds each stock's adjustment factors by stock code.
+	Factors map[string][]models.AdjustFactor
+	// Actions holds each stock's dividends and bonus shares by stock code.
+	// They are credited to positions held into the ex-date and replace the
+	// factor rescaling for stocks that have them.
+	Actions map[string][]models.CorporateAction
+	// TaxFree skips the holding-period dividend tax charged on sales.
+	TaxFree bool
+	// Margin enables financing and short selling.
+	Margin MarginConfig
+	// Progress, when set, is called after each processed bar.
+	Progress func(done, total int)
+}
+
+// BarsPerYear returns the number of bars in a trading year at the
+// options' interval.
+func (o BacktestOptions) BarsPerYear() float64 {
+	return BarsPerYear(o.Interval)
+}
+
+// WarmupBars returns how many bars before the window the strategy, exits
+// and sizing need to have their indicators initialized.
+func (o BacktestOptions) WarmupBars(params MACrossoverParams) int {
+	bars := params.LongWindow + 1
+	if o.Exits.ATRStopMultiple > 0 {
+		bars = max(bars, o.Exits.normalized().ATRPeriod+1)
+	}
+	sizing := o.Sizing.normalized()
+	switch sizing.Policy {
+	case SizingATRRisk:
+		bars = max(bars, sizing.ATRPeriod+1)
+	case SizingVolatilityTarget:
+		bars = max(bars, sizing.VolatilityWindow+1)
+	}
+	return bars
+}
+
+// Backtest runs the crossover strategy on the event-driven engine.
+func Backtest(klines []models.KLine, params MACrossoverParams, opts BacktestOptions) (float64, []EquityPoint, []Trade) {
+	final, points, trades, _ := BacktestContext(context.Background(), klines, params, opts)
+	return final, points, trades
+}
+
+// BacktestContext is Backtest that stops with the context's error once ctx
+// is done.
+func BacktestContext(ctx context.Context, klines []models.KLine, params MACrossoverParams, opts BacktestOptions) (float64, []EquityPoint, []Trade, error) {
+	sorted := make([]models.KLine, len(klines))
+	copy(sorted, klines)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	sorted = tradingBars(sorted, opts.Interval)
+
+	var code string
+	if len(sorted) > 0 {
+		code = sorted[0].StockCode
+	}
+	return runSignals(ctx, sorted, crossoverSignals(opts.adjusted(code, sorted), params), opts)
+}
+
+// runSignals trades time-sorted bars on per-bar signals (1 buy, -1 sell).
+func runSignals(ctx context.Context, sorted []models.KLine, signals []int, opts BacktestOptions) (float64, []EquityPoint, []Trade, error) {
+	code := ""
+	if len(sorted) > 0 {
+		code = sorted[0].StockCode
+	}
+	strategy := newSignalStrategy(PortfolioOptions{BacktestOptions: opts}, func([]models.KLine) []int { return signals })
+	result, err := Run(ctx, map[string][]models.KLine{code: sorted}, strategy, opts)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return result.Final, result.Points, result.Trades, nil
+}
+
+func valueAt(series []float64, i int) float64 {
+	if i < 0 || i >= len(series) {
+		return 0
+	}
+	return series[i]
+}
+
+// EquityPoint captures one point on the equity curve.
+type EquityPoint struct {
+	Time   time.Time `json:"time"`
+	Equity float64   `json:"equity"`
+}
+
+// Trade records a simulated trade decision or a corporate action on held
+// shares.
+type Trade struct {
+	StockCode string    `json:"stock_code,omitempty"`
+	Time      time.Time `json:"time"`
+	// Side is SideBuy, SideSell, SideShort, SideCover, SideDividend or
+	// SideBonus.
+	Side   string  `json:"side"`
+	Price  float64 `json:"price"`
+	Shares float64 `json:"shares"`
+	// Slippage is the cost of the fill versus the quoted price, in yuan.
+	Slippage float64 `json:"slippage"`
+	// Tax is the dividend tax charged on a sale, in yuan.
+	Tax float64 `json:"tax"`
+	// Reason is "signal", the risk exit that closed the position, or
+	// dividend and bonus for corporate actions.
+	Reason string `json:"reason"`
+}
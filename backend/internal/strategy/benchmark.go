@@ -0,0 +1,164 @@
+package strategy
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// ExcessPoint is the cumulative return of the strategy minus the benchmark.
+type ExcessPoint struct {
+	Time      time.Time `json:"time"`
+	ExcessPct float64   `json:"excess_pct"`
+}
+
+// BenchmarkComparison relates a strategy equity curve to a benchmark.
+type BenchmarkComparison struct {
+	Code    string                  `json:"code"`
+	Points  []EquityPoint           `json:"points"`
+	Excess  []ExcessPoint           `json:"excess"`
+	Metrics models.BenchmarkMetrics `json:"metrics"`
+}
+
+// CompareBenchmark scales the benchmark to the strategy's initial capital on
+// the strategy's timestamps and derives alpha, beta, information ratio and
+// tracking error from per-bar returns. The benchmark close is carried
+// forward over timestamps it has no bar for. barsPerYear annualizes as in
+// ComputeMetrics.
+func CompareBenchmark(code string, points []EquityPoint, benchmark []models.KLine, initial, barsPerYear float64) BenchmarkComparison {
+	comparison := BenchmarkComparison{Code: code}
+	closes := alignCloses(points, benchmark)
+	if len(closes) == 0 || closes[0] <= 0 {
+		return comparison
+	}
+	if initial <= 0 {
+		initial = points[0].Equity
+	}
+
+	base := closes[0]
+	comparison.Points = make([]EquityPoint, len(points))
+	comparison.Excess = make([]ExcessPoint, len(points))
+	for i, point := range points {
+		benchEquity := initial * closes[i] / base
+		comparison.Points[i] = EquityPoint{Time: point.Time, Equity: benchEquity}
+		comparison.Excess[i] = ExcessPoint{
+			Time:      point.Time,
+			ExcessPct: (point.Equity - benchEquity) / initial * 100,
+		}
+	}
+
+	strategyReturns := equityReturns(points, initial)
+	benchReturns := equityReturns(comparison.Points, initial)
+	n := len(strategyReturns)
+	if len(benchReturns) < n {
+		n = len(benchReturns)
+	}
+	if n < 2 {
+		return comparison
+	}
+	strategyReturns, benchReturns = strategyReturns[:n], benchReturns[:n]
+
+	barsPerYear = annualBars(barsPerYear)
+	benchMean := mean(benchReturns)
+	strategyMean := mean(strategyReturns)
+	var covariance, variance float64
+	active := make([]float64, n)
+	for i := 0; i < n; i++ {
+		covariance += (strategyReturns[i] - strategyMean) * (benchReturns[i] - benchMean)
+		variance += (benchReturns[i] - benchMean) * (benchReturns[i] - benchMean)
+		active[i] = strategyReturns[i] - benchReturns[i]
+	}
+	if variance > 0 {
+		comparison.Metrics.Beta = covariance / variance
+	}
+	comparison.Metrics.AlphaPct = (strategyMean - comparison.Metrics.Beta*benchMean) * barsPerYear * 100
+
+	trackingError := stdDev(active) * math.Sqrt(barsPerYear)
+	comparison.Metrics.TrackingErrorPct = trackingError * 100
+	if trackingError > 0 {
+		comparison.Metrics.InformationRatio = mean(active) * barsPerYear / trackingError
+	}
+	return comparison
+}
+
+// alignCloses returns the benchmark close in effect at each point's time.
+// Points before the first benchmark bar use its first close.
+func alignCloses(points []EquityPoint, benchmark []models.KLine) []float64 {
+	if len(points) == 0 || len(benchmark) == 0 {
+		return nil
+	}
+	sorted := make([]models.KLine, len(benchmark))
+	copy(sorted, benchmark)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	closes := make([]float64, len(points))
+	j := 0
+	for i, point := range points {
+		for j+1 < len(sorted) && !sorted[j+1].Time.After(point.Time) {
+			j++
+		}
+		closes[i] = sorted[j].Close
+	}
+	return closes
+}
+
+// EqualWeightIndex builds a synthetic close series holding every stock in
+// equal weight at from, the first bar of the report window, used as the
+// default benchmark of portfolio runs. Each stock is normalized at its
+// close in effect at from, or at its first bar if it lists later; bars
+// before that are left out. A zero from normalizes at each first bar.
+func EqualWeightIndex(series map[string][]models.KLine, from time.Time) []models.KLine {
+	type normalized struct {
+		bars []models.KLine
+		base float64
+		next int
+		last float64
+	}
+	var books []*normalized
+	stamps := make(map[int64]time.Time)
+	for _, klines := range series {
+		if len(klines) == 0 {
+			continue
+		}
+		sorted := make([]models.KLine, len(klines))
+		copy(sorted, klines)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+		first := 0
+		for first+1 < len(sorted) && !sorted[first+1].Time.After(from) {
+			first++
+		}
+		if sorted[first].Close <= 0 {
+			continue
+		}
+		books = append(books, &normalized{bars: sorted, base: sorted[first].Close, next: first, last: 1})
+		for _, bar := range sorted[first:] {
+			stamps[bar.Time.UnixNano()] = bar.Time
+		}
+	}
+	if len(books) == 0 {
+		return nil
+	}
+
+	times := make([]time.Time, 0, len(stamps))
+	for _, stamp := range stamps {
+		times = append(times, stamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	index := make([]models.KLine, 0, len(times))
+	for _, stamp := range times {
+		var sum float64
+		for _, book := range books {
+			for book.next < len(book.bars) && !book.bars[book.next].Time.After(stamp) {
+				book.last = book.bars[book.next].Close / book.base
+				book.next++
+			}
+			sum += book.last
+		}
+		value := sum / float64(len(books))
+		index = append(index, models.KLine{Time: stamp, Open: value, High: value, Low: value, Close: value})
+	}
+	return index
+}
@@ -0,0 +1,125 @@
+package strategy
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// TestCompareBenchmark compares a strategy returning 0, +3% and -1% per
+// bar with a benchmark returning 0, +2% and -2%. The benchmark returns have
+// mean 0 and the strategy's covary with them one for one, so beta is 1 and
+// alpha is the strategy's mean of 1/150 a bar. The active returns 0, 1%
+// and 1% have mean 1/150 and sample deviation 0.01/sqrt(3).
+func TestCompareBenchmark(t *testing.T) {
+	points := equityPoints(1000, 1030, 1019.7)
+	day := func(d int) time.Time { return time.Date(2024, 1, 1+d, 0, 0, 0, 0, time.UTC) }
+	benchmark := []models.KLine{
+		{Time: day(-1), Close: 40},
+		{Time: day(0), Close: 50},
+		{Time: day(1), Close: 51},
+		{Time: day(2), Close: 49.98},
+	}
+
+	got := CompareBenchmark("000300", points, benchmark, 1000, 252)
+	trackingError := 0.01 / math.Sqrt(3) * math.Sqrt(252)
+	for _, field := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"Beta", got.Metrics.Beta, 1},
+		{"AlphaPct", got.Metrics.AlphaPct, 252.0 / 150 * 100},
+		{"TrackingErrorPct", got.Metrics.TrackingErrorPct, trackingError * 100},
+		{"InformationRatio", got.Metrics.InformationRatio, 252.0 / 150 / trackingError},
+	} {
+		if math.Abs(field.got-field.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+		}
+	}
+
+	// The benchmark is scaled to the initial capital at the first point,
+	// not at its earlier bar.
+	wantPoints := []float64{1000, 1020, 999.6}
+	wantExcess := []float64{0, 1, 2.01}
+	if got.Code != "000300" || len(got.Points) != 3 || len(got.Excess) != 3 {
+		t.Fatalf("%s: %d points and %d excess points, want 3 of each", got.Code, len(got.Points), len(got.Excess))
+	}
+	for i := range points {
+		if !got.Points[i].Time.Equal(points[i].Time) || math.Abs(got.Points[i].Equity-wantPoints[i]) > 1e-9 {
+			t.Errorf("point %d = %v, want %v", i, got.Points[i], wantPoints[i])
+		}
+		if math.Abs(got.Excess[i].ExcessPct-wantExcess[i]) > 1e-9 {
+			t.Errorf("excess %d = %v%%, want %v%%", i, got.Excess[i].ExcessPct, wantExcess[i])
+		}
+	}
+}
+
+// TestCompareBenchmarkCarriesCloses checks that a benchmark missing a bar
+// holds its last close and one starting late uses its first close.
+func TestCompareBenchmarkCarriesCloses(t *testing.T) {
+	points := equityPoints(100, 100, 100, 100)
+	day := func(d int) time.Time { return time.Date(2024, 1, 1+d, 0, 0, 0, 0, time.UTC) }
+	benchmark := []models.KLine{
+		{Time: day(1), Close: 10},
+		{Time: day(3), Close: 12},
+	}
+	got := CompareBenchmark("X", points, benchmark, 100, 252)
+	want := []float64{100, 100, 100, 120}
+	for i, point := range got.Points {
+		if math.Abs(point.Equity-want[i]) > 1e-9 {
+			t.Errorf("point %d = %v, want %v", i, point.Equity, want[i])
+		}
+	}
+}
+
+// TestEqualWeightIndex checks that the index weighs stocks equally at the
+// start of the report window, day 2, whatever they did during warm-up. A
+// doubles in warm-up and then gains half, B has no bar on day 2 and is
+// normalized at its day-1 close, and C lists on day 3 and counts at par
+// until then.
+func TestEqualWeightIndex(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, 1+d, 0, 0, 0, 0, time.UTC) }
+	bars := func(closes map[int]float64) []models.KLine {
+		var klines []models.KLine
+		for d := 3; d >= 0; d-- {
+			if close, ok := closes[d]; ok {
+				klines = append(klines, models.KLine{Time: day(d), Close: close})
+			}
+		}
+		return klines
+	}
+	series := map[string][]models.KLine{
+		"A": bars(map[int]float64{0: 10, 1: 20, 2: 20, 3: 30}),
+		"B": bars(map[int]float64{0: 10, 1: 8, 3: 12}),
+		"C": bars(map[int]float64{3: 8}),
+	}
+
+	tests := []struct {
+		from  time.Time
+		days  []int
+		value []float64
+	}{
+		// A and C at par on day 1 while B sits at its base.
+		{day(2), []int{1, 2, 3}, []float64{1, 1, (1.5 + 1.5 + 1) / 3}},
+		// From each stock's first bar, as without a report window.
+		{time.Time{}, []int{0, 1, 2, 3}, []float64{1, (2 + 0.8 + 1) / 3, (2 + 0.8 + 1) / 3, (3 + 1.2 + 1) / 3}},
+	}
+	for _, tt := range tests {
+		index := EqualWeightIndex(series, tt.from)
+		if len(index) != len(tt.days) {
+			t.Fatalf("from %s: got %d bars, want %d: %v", tt.from.Format("01-02"), len(index), len(tt.days), index)
+		}
+		for i, bar := range index {
+			if !bar.Time.Equal(day(tt.days[i])) || math.Abs(bar.Close-tt.value[i]) > 1e-12 || bar.Open != bar.Close {
+				t.Errorf("from %s: bar %d = %s %v, want %s %v", tt.from.Format("01-02"), i,
+					bar.Time.Format("01-02"), bar.Close, day(tt.days[i]).Format("01-02"), tt.value[i])
+			}
+		}
+	}
+
+	if index := EqualWeightIndex(map[string][]models.KLine{"X": nil}, time.Time{}); index != nil {
+		t.Errorf("empty series: got %v, want nil", index)
+	}
+}
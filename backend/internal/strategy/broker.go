@@ -0,0 +1,692 @@
+package strategy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// EventType names what an Event reports.
+type EventType string
+
+const (
+	// EventFill reports shares traded by an order.
+	EventFill EventType = "fill"
+	// EventCancel reports an order that stopped working before it filled.
+	EventCancel EventType = "cancel"
+	// EventPosition reports a stock's position after a fill.
+	EventPosition EventType = "position"
+	// EventAdjust reports an ex-date that moved the stock's position and
+	// working orders to a new raw price basis.
+	EventAdjust EventType = "adjust"
+)
+
+// Event is emitted by the broker as orders fill or are canceled.
+type Event struct {
+	Type      EventType
+	Time      time.Time
+	StockCode string
+	// Bar is the index of the current bar in the stock's series.
+	Bar int
+	// Order is the order's state after the event.
+	Order Order
+	// Price and Shares describe a fill.
+	Price  float64
+	Shares float64
+	// Position is the stock's shares held after the event.
+	Position float64
+	// Reason explains a cancellation.
+	Reason string
+	// Ratio is the factor an adjustment divided prices by. Factor rescaling
+	// also multiplies the position by it.
+	Ratio float64
+}
+
+// Broker simulates an account trading against bars: it holds the cash and
+// positions, matches working orders against each bar's OHLC and reports
+// what happens to the strategy as events.
+type Broker struct {
+	opts         BacktestOptions
+	strategy     Strategy
+	cash         float64
+	codes        []string
+	holdings     map[string]*holding
+	orders       []*Order // working orders in submission order
+	nextID       int
+	closing      bool // the closes of the current bars are known
+	trades       []Trade
+	tradeReturns []float64 // closed round-trip returns, used by Kelly sizing
+	receivables  []receivable
+	// debt is the cash borrowed on margin; interest and borrowFees total
+	// what financing and short positions have cost, charged up to accrued.
+	debt       float64
+	interest   float64
+	borrowFees float64
+	accrued    time.Time
+}
+
+// holding is one stock's bars and position in a broker.
+type holding struct {
+	code       string
+	bars       []models.KLine
+	bar        int  // index of the latest bar, -1 before the first
+	current    bool // the latest bar is at the current timestamp
+	used       float64
+	position   float64 // negative when short
+	avgPrice   float64
+	frozen     float64 // proceeds of the open short sale, held as collateral
+	cost       float64 // cash spent on entries of the open round trip
+	proceeds   float64 // cash received from exits of the open round trip
+	cashFlow   float64 // sale proceeds minus purchase cost over the run
+	lastClose  float64
+	prevClose  float64 // lastClose before the current bar's close was known
+	sizingATR  []float64
+	volatility []float64
+	// sessionEnd and dayEnd flag the last bar of each trading session and
+	// day of intraday bars; both are nil for daily bars.
+	sessionEnd []bool
+	dayEnd     []bool
+	// ratios maps raw to adjusted prices per bar and adjusted holds the
+	// adjusted bars; both are nil without adjustment.
+	ratios   []float64
+	adjusted []models.KLine
+	// actions are the stock's corporate actions by ex-date, nextAction the
+	// first not yet applied, and lots the open position by purchase.
+	actions    []models.CorporateAction
+	nextAction int
+	lots       []taxLot
+}
+
+func newBroker(series map[string][]models.KLine, opts BacktestOptions, strategy Strategy) *Broker {
+	b := &Broker{
+		opts:     opts,
+		strategy: strategy,
+		cash:     opts.InitialCapital,
+		holdings: make(map[string]*holding, len(series)),
+	}
+	minutes, _ := IntervalMinutes(opts.Interval)
+	for code, klines := range series {
+		if len(klines) == 0 {
+			continue
+		}
+		sorted := make([]models.KLine, len(klines))
+		copy(sorted, klines)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+		if sorted = tradingBars(sorted, opts.Interval); len(sorted) == 0 {
+			continue
+		}
+
+		h := &holding{code: code, bars: sorted, bar: -1}
+		if minutes > 0 {
+			h.sessionEnd, h.dayEnd = sessionBreaks(sorted)
+		}
+		if h.ratios = adjustRatios(sorted, opts.Factors[code], opts.Adjust); h.ratios != nil {
+			h.adjusted = applyRatios(sorted, h.ratios)
+		}
+		if actions := opts.Actions[code]; len(actions) > 0 {
+			h.actions = append([]models.CorporateAction(nil), actions...)
+			sort.Slice(h.actions, func(i, j int) bool { return h.actions[i].ExDate.Before(h.actions[j].ExDate) })
+		}
+		switch opts.Sizing.Policy {
+		case SizingATRRisk:
+			h.sizingATR = averageTrueRange(h.view(), opts.Sizing.ATRPeriod)
+		case SizingVolatilityTarget:
+			h.volatility = rollingVolatility(h.view(), opts.Sizing.VolatilityWindow)
+		}
+		b.holdings[code] = h
+		b.codes = append(b.codes, code)
+	}
+	sort.Strings(b.codes)
+	return b
+}
+
+// Cash returns the uninvested cash.
+func (b *Broker) Cash() float64 {
+	return b.cash
+}
+
+// Equity values the account with every position at its latest close,
+// dividends due at their amount and margin debt deducted.
+func (b *Broker) Equity() float64 {
+	equity := b.cash + b.receivable() - b.debt
+	for _, code := range b.codes {
+		h := b.holdings[code]
+		equity += h.frozen + h.position*h.lastClose
+	}
+	return equity
+}
+
+// Position returns the shares held in a stock, negative when short.
+func (b *Broker) Position(code string) float64 {
+	if h, ok := b.holdings[code]; ok {
+		return h.position
+	}
+	return 0
+}
+
+// AvgPrice returns the average entry price of the stock's open position.
+func (b *Broker) AvgPrice(code string) float64 {
+	if h, ok := b.holdings[code]; ok {
+		return h.avgPrice
+	}
+	return 0
+}
+
+// Bar returns the index and data of the stock's latest bar, or -1 before
+// its first one.
+func (b *Broker) Bar(code string) (int, models.KLine) {
+	h, ok := b.holdings[code]
+	if !ok || h.bar < 0 {
+		return -1, models.KLine{}
+	}
+	return h.bar, h.bars[h.bar]
+}
+
+// Adjustment returns the multiplier from raw to adjusted prices on the
+// stock's latest bar: 1 without adjustment. Strategies see adjusted bars in
+// Start; dividing an adjusted price by it gives the raw price orders use.
+func (b *Broker) Adjustment(code string) float64 {
+	if h, ok := b.holdings[code]; ok && h.bar >= 0 {
+		return h.ratio(h.bar)
+	}
+	return 1
+}
+
+// Order returns a working order by ID.
+func (b *Broker) Order(id int) (Order, bool) {
+	for _, o := range b.orders {
+		if o.ID == id {
+			return *o, true
+		}
+	}
+	return Order{}, false
+}
+
+// Orders returns the stock's working orders in submission order.
+func (b *Broker) Orders(code string) []Order {
+	var orders []Order
+	for _, o := range b.orders {
+		if o.StockCode == code {
+			orders = append(orders, *o)
+		}
+	}
+	return orders
+}
+
+// Submit places an order and returns its ID. Orders trade from the stock's
+// next bar, except market orders under same_close execution and
+// market-on-close orders submitted once the bar's close is known, which
+// fill on it at once. An empty type means market and an empty time in
+// force good-till-canceled.
+func (b *Broker) Submit(order Order) (int, error) {
+	if order.Type == "" {
+		order.Type = OrderMarket
+	}
+	if order.TIF == "" {
+		order.TIF = TIFGoodTillCanceled
+	}
+	if err := order.validate(); err != nil {
+		return 0, err
+	}
+	h, ok := b.holdings[order.StockCode]
+	if !ok {
+		return 0, fmt.Errorf("no bars for stock %q", order.StockCode)
+	}
+
+	b.nextID++
+	o := &order
+	o.ID = b.nextID
+	o.Status = OrderWorking
+	o.Filled, o.Triggered, o.sized = 0, false, false
+	o.placed, o.expires = h.bar, h.closeOfDay(h.bar+1)
+	b.orders = append(b.orders, o)
+
+	immediate := o.Type == OrderMarketOnClose || (o.Type == OrderMarket && b.opts.Execution == ExecSameClose)
+	if immediate && b.closing && h.current {
+		o.expires = h.bar
+		b.fill(o, h, h.bars[h.bar].Close)
+	}
+	return o.ID, nil
+}
+
+// Cancel stops a working order. It reports whether the order was working.
+func (b *Broker) Cancel(id int) bool {
+	for _, o := range b.orders {
+		if o.ID == id {
+			b.cancel(o, CancelRequested)
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Broker) cancel(o *Order, reason string) {
+	o.Status = OrderCanceled
+	b.remove(o)
+	h := b.holdings[o.StockCode]
+	b.emit(h, Event{Type: EventCancel, Order: *o, Position: h.position, Reason: reason})
+}
+
+func (b *Broker) remove(o *Order) {
+	for i, working := range b.orders {
+		if working == o {
+			b.orders = append(b.orders[:i], b.orders[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *Broker) emit(h *holding, event Event) {
+	event.StockCode = h.code
+	event.Bar = h.bar
+	if h.bar >= 0 {
+		event.Time = h.bars[h.bar].Time
+	}
+	if b.strategy != nil {
+		b.strategy.OnEvent(b, event)
+	}
+}
+
+// advance charges margin costs, pays the dividends due by stamp, moves every stock to its latest
+// bar at or before it, applying ex-dates on the way, and returns, in code
+// order, the stocks that have a bar at stamp.
+func (b *Broker) advance(stamp time.Time) []string {
+	b.accrue(stamp)
+	b.collect(stamp)
+	var codes []string
+	for _, code := range b.codes {
+		h := b.holdings[code]
+		h.current = false
+		for h.bar+1 < len(h.bars) && !h.bars[h.bar+1].Time.After(stamp) {
+			h.bar++
+			if h.bars[h.bar].Time.Equal(stamp) {
+				h.current = true
+			}
+			b.exRights(h)
+		}
+		if h.current {
+			h.used = 0
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// adjust applies an ex-date to a stock: its position and the shares of its
+// working orders are multiplied by split and their prices divided by it,
+// so values carry over to the new raw price basis.
+func (b *Broker) adjust(h *holding, split float64) {
+	h.position *= split
+	h.avgPrice /= split
+	h.lastClose /= split
+	for i := range h.lots {
+		h.lots[i].shares *= split
+		h.lots[i].dividend /= split
+	}
+	for _, o := range b.orders {
+		if o.StockCode != h.code {
+			continue
+		}
+		o.Shares *= split
+		o.remaining *= split
+		o.Filled *= split
+		o.LimitPrice /= split
+		o.StopPrice /= split
+	}
+	b.emit(h, Event{Type: EventAdjust, Position: h.position, Ratio: split})
+}
+
+// stamps returns every bar timestamp across stocks in ascending order.
+func (b *Broker) stamps() []time.Time {
+	seen := make(map[int64]time.Time)
+	for _, h := range b.holdings {
+		for _, bar := range h.bars {
+			seen[bar.Time.UnixNano()] = bar.Time
+		}
+	}
+	stamps := make([]time.Time, 0, len(seen))
+	for _, stamp := range seen {
+		stamps = append(stamps, stamp)
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i].Before(stamps[j]) })
+	return stamps
+}
+
+// open matches working orders against the open and range of the current
+// bars. Orders on stocks already held go first, stock by stock, and
+// entries into flat stocks last so the proceeds of sales can fund them;
+// each stock's orders go in priority order.
+func (b *Broker) open(codes []string) {
+	b.closing = false
+	for _, entries := range []bool{false, true} {
+		for _, code := range codes {
+			h := b.holdings[code]
+			var orders []*Order
+			for _, o := range b.orders {
+				if o.StockCode == code && o.placed < h.bar && (h.position == 0 && o.opens()) == entries {
+					orders = append(orders, o)
+				}
+			}
+			sort.SliceStable(orders, func(i, j int) bool { return orders[i].priority() < orders[j].priority() })
+
+			for _, o := range orders {
+				if o.Status != OrderWorking {
+					continue
+				}
+				if price, ok := o.quote(h.bars[h.bar], b.opts.Execution); ok {
+					b.fill(o, h, price)
+				}
+			}
+		}
+	}
+}
+
+// mark records the closes of the current bars.
+func (b *Broker) mark(codes []string) {
+	for _, code := range codes {
+		h := b.holdings[code]
+		h.prevClose = h.lastClose
+		h.lastClose = h.bars[h.bar].Close
+	}
+	b.closing = true
+}
+
+// close cancels day orders whose trading bar has ended.
+func (b *Broker) close(codes []string) {
+	for _, code := range codes {
+		h := b.holdings[code]
+		var expired []*Order
+		for _, o := range b.orders {
+			if o.StockCode == code && o.TIF == TIFDay && h.bar >= o.expires {
+				expired = append(expired, o)
+			}
+		}
+		for _, o := range expired {
+			if o.Status == OrderWorking {
+				b.cancel(o, CancelExpired)
+			}
+		}
+	}
+}
+
+// liquidate replaces every working order with a trade closing each open
+// position at the stock's latest close.
+func (b *Broker) liquidate(reason string) {
+	for _, code := range b.codes {
+		b.flatten(b.holdings[code], reason)
+	}
+}
+
+// endSessions flattens the current stocks whose session or trading day
+// ends on this bar, as the session exit setting asks.
+func (b *Broker) endSessions(codes []string) {
+	for _, code := range codes {
+		if h := b.holdings[code]; b.flattening(h) {
+			b.flatten(h, ReasonSessionEnd)
+		}
+	}
+}
+
+// flattening reports whether the stock's position is closed at the end of
+// its current bar under the session exit setting.
+func (b *Broker) flattening(h *holding) bool {
+	if h.dayEnd == nil || h.bar < 0 {
+		return false
+	}
+	switch b.opts.SessionExit {
+	case SessionExitDay:
+		return h.dayEnd[h.bar]
+	case SessionExitSession:
+		return h.sessionEnd[h.bar]
+	}
+	return false
+}
+
+// flatten replaces the stock's working orders with a trade closing its
+// open position at the latest close: a sale, or a buy to cover a short.
+func (b *Broker) flatten(h *holding, reason string) {
+	if h.position == 0 {
+		return
+	}
+	for _, o := range b.Orders(h.code) {
+		b.Cancel(o.ID)
+	}
+	side := -1
+	if h.position < 0 {
+		side = 1
+	}
+	b.nextID++
+	o := &Order{ID: b.nextID, StockCode: h.code, Side: side, Short: side > 0, Type: OrderMarketOnClose, TIF: TIFGoodTillCanceled, Reason: reason, Status: OrderWorking, placed: h.bar, expires: h.bar}
+	b.orders = append(b.orders, o)
+	b.fill(o, h, h.lastClose)
+}
+
+// view returns the bars as strategies see them: adjusted when a mode and
+// factors are set, raw otherwise.
+func (h *holding) view() []models.KLine {
+	if h.adjusted != nil {
+		return h.adjusted
+	}
+	return h.bars
+}
+
+// ratio returns the multiplier from raw to adjusted prices of bar i.
+func (h *holding) ratio(i int) float64 {
+	if h.ratios == nil {
+		return 1
+	}
+	return h.ratios[i]
+}
+
+// closeOfDay returns the index of the last bar of the trading day bar i
+// belongs to; for daily bars that is i itself.
+func (h *holding) closeOfDay(i int) int {
+	if h.dayEnd == nil {
+		return i
+	}
+	for i < len(h.dayEnd)-1 && !h.dayEnd[i] {
+		i++
+	}
+	return i
+}
+
+// known returns the index of the latest bar whose data may inform an
+// order filled on the stock's current bar.
+func (b *Broker) known(h *holding) int {
+	if b.opts.Execution == ExecSameClose {
+		return h.bar
+	}
+	return h.bar - 1
+}
+
+// equityAt values the account with the stock marked at price.
+func (b *Broker) equityAt(h *holding, price float64) float64 {
+	equity := b.cash + b.receivable() - b.debt + h.frozen + h.position*price
+	for _, code := range b.codes {
+		if other := b.holdings[code]; other != h {
+			equity += other.frozen + other.position*other.lastClose
+		}
+	}
+	return equity
+}
+
+// fill executes as much of the order as the bar allows at the quoted
+// price after slippage. Shares closing the opposite position trade first;
+// the rest opens or adds to a position within cash, or buying power on a
+// margin account. Sizing happens on the first fill attempt; an order stops
+// working once it can neither close nor open any more shares.
+func (b *Broker) fill(o *Order, h *holding, quote float64) {
+	if quote <= 0 {
+		return
+	}
+	bar := h.bars[h.bar]
+
+	if !o.sized {
+		o.remaining = o.Shares
+		if o.Shares == 0 {
+			o.remaining = h.closable(o)
+			if o.opens() {
+				o.remaining += b.size(o, h, quote, o.remaining)
+			}
+		}
+		o.sized = true
+	}
+
+	qty := math.Min(o.remaining, b.opts.Slippage.capacity(bar)-h.used)
+	closing := math.Min(qty, h.closable(o))
+	if !o.opens() {
+		qty = closing
+	}
+	price := b.opts.Slippage.adjust(quote, o.Side, qty, bar)
+	if o.limited() {
+		if o.Side > 0 {
+			price = math.Min(price, o.LimitPrice)
+		} else {
+			price = math.Max(price, o.LimitPrice)
+		}
+	}
+	if opening := qty - closing; opening > 0 {
+		if room := b.room(h, o.Side, quote, closing); opening*price > room {
+			qty = closing + math.Floor(room/price)
+		}
+	}
+
+	if qty > 0 {
+		h.used += qty
+		o.remaining -= qty
+		o.Filled += qty
+		if closing > 0 {
+			b.settle(h, o, closing, price, quote)
+		}
+		if qty > closing {
+			b.settle(h, o, qty-closing, price, quote)
+		}
+
+		if o.remaining <= 0 {
+			o.Status = OrderFilled
+			b.remove(o)
+		}
+		b.emit(h, Event{Type: EventFill, Order: *o, Price: price, Shares: qty, Position: h.position})
+		b.emit(h, Event{Type: EventPosition, Order: *o, Position: h.position})
+	}
+
+	// The strategy may have canceled the order while handling the fill.
+	if o.Status != OrderWorking {
+		return
+	}
+	switch {
+	case o.remaining <= 0:
+		b.cancel(o, CancelZeroSize)
+	case h.closable(o) > 0:
+	case !o.opens():
+		b.cancel(o, CancelNoPosition)
+	case o.Side < 0 && !b.opts.Margin.eligible(h.code):
+		b.cancel(o, CancelNotShortable)
+	case b.room(h, o.Side, quote, 0) < price:
+		b.cancel(o, CancelInsufficientCash)
+	}
+}
+
+// size returns the shares the sizing policy opens at quote once closing
+// shares of the opposite position are closed. The account is valued with
+// the other stocks at their closes before the current bar even when their
+// current closes are known, so a same-close fill is sized independently
+// of the order the stocks' bars are processed in.
+func (b *Broker) size(o *Order, h *holding, quote, closing float64) float64 {
+	known := b.known(h)
+	held := math.Max(0, float64(o.Side)*h.position)
+	equity := b.equityAt(h, quote)
+	if b.closing {
+		for _, code := range b.codes {
+			if other := b.holdings[code]; other != h && other.current {
+				equity -= other.position * (other.lastClose - other.prevClose)
+			}
+		}
+	}
+	return b.opts.Sizing.shares(sizingInput{
+		equity:        equity,
+		cash:          b.room(h, o.Side, quote, closing),
+		price:         b.opts.Slippage.adjust(quote, o.Side, 0, h.bars[h.bar]),
+		positionValue: held * quote,
+		atr:           valueAt(h.sizingATR, known) / h.ratio(h.bar),
+		volatility:    valueAt(h.volatility, known),
+		barsPerYear:   b.opts.BarsPerYear(),
+		tradeReturns:  b.tradeReturns,
+	})
+}
+
+// settle books qty shares filled at price against the stock's position and
+// the account. BUY and SHORT open or add to a position; SELL and COVER
+// reduce it, settling the cash against margin debt and the frozen short
+// sale proceeds.
+func (b *Broker) settle(h *holding, o *Order, qty, price, quote float64) {
+	bar := h.bars[h.bar]
+	value := qty * price
+	if h.position == 0 {
+		h.avgPrice, h.cost, h.proceeds = 0, 0, 0
+	}
+	trade := Trade{
+		StockCode: h.code,
+		Time:      bar.Time,
+		Price:     price,
+		Shares:    qty,
+		Slippage:  math.Abs(price-quote) * qty,
+		Reason:    o.Reason,
+	}
+
+	switch {
+	case o.Side > 0 && h.position < 0:
+		trade.Side = SideCover
+		released := h.frozen
+		if qty < -h.position {
+			released = h.frozen * qty / -h.position
+		}
+		h.frozen -= released
+		b.cash += released
+		h.cost += value
+		h.cashFlow -= value
+		b.pay(value)
+		h.position += qty
+		if h.position >= 0 && h.proceeds > 0 {
+			b.tradeReturns = append(b.tradeReturns, 1-h.cost/h.proceeds)
+		}
+	case o.Side > 0:
+		trade.Side = SideBuy
+		h.avgPrice = (h.avgPrice*h.position + value) / (h.position + qty)
+		h.cost += value
+		h.cashFlow -= value
+		b.pay(value)
+		h.position += qty
+		h.lots = append(h.lots, taxLot{shares: qty, since: bar.Time})
+	case h.position > 0:
+		trade.Side = SideSell
+		trade.Tax = b.sellLots(h, qty, bar.Time)
+		h.proceeds += value - trade.Tax
+		h.cashFlow += value - trade.Tax
+		b.receive(value - trade.Tax)
+		h.position -= qty
+		if h.position <= 0 && h.cost > 0 {
+			b.tradeReturns = append(b.tradeReturns, h.proceeds/h.cost-1)
+		}
+	default:
+		trade.Side = SideShort
+		h.avgPrice = (h.avgPrice*-h.position + value) / (qty - h.position)
+		h.proceeds += value
+		h.cashFlow += value
+		h.frozen += value
+		h.position -= qty
+	}
+	b.trades = append(b.trades, trade)
+}
+
+// closable returns the shares of the stock's position the order trades
+// against: a short for buys, a long for sells.
+func (h *holding) closable(o *Order) float64 {
+	if o.Side > 0 {
+		return math.Max(0, -h.position)
+	}
+	return math.Max(0, h.position)
+}
@@ -0,0 +1,96 @@
+package strategy
+
+import (
+	"context"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// Strategy decides what to trade in an event-driven backtest.
+type Strategy interface {
+	// Start receives each stock's time-sorted bars, adjusted as the options
+	// ask, before the first one is processed, so indicators can be computed
+	// up front.
+	Start(series map[string][]models.KLine)
+	// OnBar is called once the closes of the bars at a timestamp are known,
+	// with the codes of the stocks that have a bar at it in code order.
+	OnBar(b *Broker, codes []string)
+	// OnEvent receives fills, cancellations and position changes as the
+	// broker produces them, including those of orders submitted in OnBar.
+	OnEvent(b *Broker, event Event)
+}
+
+// RunResult is the outcome of an event-driven backtest.
+type RunResult struct {
+	Final  float64
+	Points []EquityPoint
+	Trades []Trade
+	// Interest and BorrowFees are what margin debt and short positions cost.
+	Interest   float64
+	BorrowFees float64
+}
+
+// Run drives a strategy over the bars of one or more stocks sharing one
+// account. At each timestamp the broker first matches working orders
+// against the bars' open and range, then the strategy sees the closes and
+// submits new orders. Bars before opts.ReportFrom only warm up: the
+// strategy is not called on them and they produce no equity points.
+// Intraday bars outside the trading sessions are ignored, and
+// opts.SessionExit may sell positions at the close of a session or day.
+// On a margin account, positions are closed at the close of any bar that
+// leaves the account below the maintenance ratio.
+func Run(ctx context.Context, series map[string][]models.KLine, strategy Strategy, opts BacktestOptions) (RunResult, error) {
+	b, points, err := run(ctx, series, strategy, opts)
+	if err != nil {
+		return RunResult{}, err
+	}
+	final := b.cash
+	if len(points) > 0 {
+		final = points[len(points)-1].Equity
+	}
+	return RunResult{Final: final, Points: points, Trades: b.trades, Interest: b.interest, BorrowFees: b.borrowFees}, nil
+}
+
+func run(ctx context.Context, series map[string][]models.KLine, strategy Strategy, opts BacktestOptions) (*Broker, []EquityPoint, error) {
+	if opts.InitialCapital <= 0 {
+		opts.InitialCapital = 100000
+	}
+	opts.Execution = ParseExecutionMode(string(opts.Execution))
+	opts.Slippage = opts.Slippage.normalized()
+	opts.Exits = opts.Exits.normalized()
+	opts.Sizing = opts.Sizing.normalized()
+	opts.SessionExit = ParseSessionExit(string(opts.SessionExit))
+	opts.Margin = opts.Margin.normalized()
+
+	b := newBroker(series, opts, strategy)
+	sorted := make(map[string][]models.KLine, len(b.holdings))
+	for code, h := range b.holdings {
+		sorted[code] = h.view()
+	}
+	strategy.Start(sorted)
+
+	var points []EquityPoint
+	stamps := b.stamps()
+	for n, stamp := range stamps {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		codes := b.advance(stamp)
+		if !stamp.Before(opts.ReportFrom) {
+			b.open(codes)
+			b.mark(codes)
+			b.marginCall()
+			strategy.OnBar(b, codes)
+			b.close(codes)
+			b.endSessions(codes)
+			if opts.CloseAtEnd && n == len(stamps)-1 {
+				b.liquidate(ReasonWindowEnd)
+			}
+			points = append(points, EquityPoint{Time: stamp, Equity: b.Equity()})
+		}
+		if opts.Progress != nil {
+			opts.Progress(n+1, len(stamps))
+		}
+	}
+	return b, points, nil
+}
@@ -0,0 +1,113 @@
+package strategy
+
+import "math"
+
+// Trade reasons recorded on fills.
+const (
+	ReasonSignal       = "signal"
+	ReasonStopLoss     = "stop_loss"
+	ReasonATRStop      = "atr_stop"
+	ReasonTakeProfit   = "take_profit"
+	ReasonTrailingStop = "trailing_stop"
+	ReasonTimeExit     = "time_exit"
+	ReasonPyramid      = "pyramid"
+	ReasonWindowEnd    = "window_end"
+	ReasonSessionEnd   = "session_end"
+	ReasonMarginCall   = "margin_call"
+)
+
+// ExitConfig configures risk exits applied on top of any strategy's signals.
+// Percentages are expressed in percent (5 means 5%); zero disables a rule.
+type ExitConfig struct {
+	StopLossPct     float64 `json:"stop_loss_pct"`
+	ATRStopMultiple float64 `json:"atr_stop_multiple"`
+	ATRPeriod       int     `json:"atr_period"`
+	TakeProfitPct   float64 `json:"take_profit_pct"`
+	TrailingStopPct float64 `json:"trailing_stop_pct"`
+	MaxHoldingBars  int     `json:"max_holding_bars"`
+}
+
+func (c ExitConfig) normalized() ExitConfig {
+	if c.ATRPeriod <= 0 {
+		c.ATRPeriod = 14
+	}
+	return c
+}
+
+// entryState tracks the open position for risk exits and pyramiding.
+type entryState struct {
+	short     bool
+	price     float64 // average entry price
+	last      float64 // price of the most recent entry fill
+	atr       float64 // ATR known when the position was opened
+	bar       int     // bar index of the first fill
+	bestClose float64 // highest close while long, lowest while short
+	adds      int     // pyramiding add-ons taken
+}
+
+// sign is 1 for a long position and -1 for a short one.
+func (e entryState) sign() float64 {
+	if e.short {
+		return -1
+	}
+	return 1
+}
+
+// rebase moves the prices of the entry to a new raw price basis after an
+// ex-date that multiplied the shares by split.
+func (e *entryState) rebase(split float64) {
+	e.price /= split
+	e.last /= split
+	e.atr /= split
+	e.bestClose /= split
+}
+
+// stopLevel returns the tightest active stop and the rule that set it: the
+// highest level below a long position, the lowest above a short one.
+func (c ExitConfig) stopLevel(entry entryState) (float64, string) {
+	sign := entry.sign()
+	level, reason := 0.0, ""
+	consider := func(price float64, name string) {
+		tighter := price > level
+		if entry.short {
+			tighter = level == 0 || price < level
+		}
+		if tighter {
+			level, reason = price, name
+		}
+	}
+	if c.StopLossPct > 0 {
+		consider(entry.price*(1-sign*c.StopLossPct/100), ReasonStopLoss)
+	}
+	if c.ATRStopMultiple > 0 && entry.atr > 0 {
+		consider(entry.price-sign*c.ATRStopMultiple*entry.atr, ReasonATRStop)
+	}
+	if c.TrailingStopPct > 0 && entry.bestClose > 0 {
+		consider(entry.bestClose*(1-sign*c.TrailingStopPct/100), ReasonTrailingStop)
+	}
+	return level, reason
+}
+
+// target returns the take-profit price of the position, or zero when the
+// rule is off.
+func (c ExitConfig) target(entry entryState) float64 {
+	if c.TakeProfitPct <= 0 {
+		return 0
+	}
+	return entry.price * (1 + entry.sign()*c.TakeProfitPct/100)
+}
+
+// timeExit reports whether the position has been held for the maximum bars.
+func (c ExitConfig) timeExit(entry entryState, i int) bool {
+	return c.MaxHoldingBars > 0 && i-entry.bar >= c.MaxHoldingBars
+}
+
+func (e *entryState) markClose(close float64) {
+	if e.short {
+		if e.bestClose == 0 || close < e.bestClose {
+			e.bestClose = close
+		}
+		return
+	}
+	e.bestClose = math.Max(e.bestClose, close)
+}
@@ -0,0 +1,66 @@
+package strategy
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// TestRiskExits buys 100 shares at the close of 10 on bar 2, with a true
+// range of 1 on every bar before it, and checks the trade each exit rule
+// closes the position with on the bars that follow.
+func TestRiskExits(t *testing.T) {
+	flat := [4]float64{10, 10.5, 9.5, 10}
+	tests := []struct {
+		name  string
+		exits ExitConfig
+		after [][4]float64 // bars from bar 3 on
+		day   int
+		price float64
+		// reason is the Trade.Reason of the sale.
+		reason string
+	}{
+		{"stop loss", ExitConfig{StopLossPct: 5}, [][4]float64{{10, 10.2, 9.4, 9.8}}, 3, 9.5, ReasonStopLoss},
+		{"stop loss gap", ExitConfig{StopLossPct: 5}, [][4]float64{{9, 9.2, 8.8, 9}}, 3, 9, ReasonStopLoss},
+		{"stop loss not reached", ExitConfig{StopLossPct: 5}, [][4]float64{{10, 10.2, 9.6, 9.8}, {9.8, 9.9, 9.3, 9.4}}, 4, 9.5, ReasonStopLoss},
+		// An ATR of 1 at entry puts a 2 ATR stop at 8.
+		{"atr stop", ExitConfig{ATRStopMultiple: 2, ATRPeriod: 2}, [][4]float64{{9.5, 9.6, 7.5, 8}}, 3, 8, ReasonATRStop},
+		{"tightest stop", ExitConfig{StopLossPct: 5, ATRStopMultiple: 2, ATRPeriod: 2}, [][4]float64{{9.5, 9.6, 7.5, 8}}, 3, 9.5, ReasonStopLoss},
+		{"take profit", ExitConfig{TakeProfitPct: 10}, [][4]float64{{10.2, 11.3, 10.1, 11}}, 3, 11, ReasonTakeProfit},
+		{"take profit gap", ExitConfig{TakeProfitPct: 10}, [][4]float64{{11.5, 11.8, 11.2, 11.6}}, 3, 11.5, ReasonTakeProfit},
+		// The close of 12 raises a 5% trailing stop from 9.5 to 11.4.
+		{"trailing stop", ExitConfig{TrailingStopPct: 5}, [][4]float64{{10, 12.2, 10, 12}, {11.8, 11.9, 11.2, 11.5}}, 4, 11.4, ReasonTrailingStop},
+		{"time exit", ExitConfig{MaxHoldingBars: 2}, [][4]float64{flat, {10, 10.6, 9.9, 10.4}, flat}, 4, 10.4, ReasonTimeExit},
+		// A bar reaching both the stop and the target is assumed to hit
+		// the stop first.
+		{"stop and target", ExitConfig{StopLossPct: 5, TakeProfitPct: 10}, [][4]float64{{10, 11.5, 9.2, 10}}, 3, 9.5, ReasonStopLoss},
+	}
+
+	signal := func(bars []models.KLine) []int {
+		signals := make([]int, len(bars))
+		signals[2] = 1
+		return signals
+	}
+	for _, tt := range tests {
+		bars := ohlcBars("X", append([][4]float64{flat, flat, flat}, tt.after...))
+		opts := BacktestOptions{InitialCapital: 1000, Execution: ExecSameClose, Exits: tt.exits}
+		res, err := Run(context.Background(), map[string][]models.KLine{"X": bars}, newSignalStrategy(PortfolioOptions{BacktestOptions: opts}, signal), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res.Trades) != 2 {
+			t.Fatalf("%s: got %d trades, want a buy and a sale: %+v", tt.name, len(res.Trades), res.Trades)
+		}
+		buy, sale := res.Trades[0], res.Trades[1]
+		if !buy.Time.Equal(bars[2].Time) || buy.Side != SideBuy || buy.Price != 10 || buy.Shares != 100 || buy.Reason != ReasonSignal {
+			t.Errorf("%s: entry %s %s %v x %v (%s), want day 2 BUY 10 x 100 (signal)", tt.name, buy.Time.Format("2006-01-02"), buy.Side, buy.Price, buy.Shares, buy.Reason)
+		}
+		if !sale.Time.Equal(bars[tt.day].Time) || sale.Side != SideSell || math.Abs(sale.Price-tt.price) > 1e-9 || sale.Shares != 100 || sale.Reason != tt.reason {
+			t.Errorf("%s: exit %s %s %v x %v (%s), want day %d SELL %v x 100 (%s)",
+				tt.name, sale.Time.Format("2006-01-02"), sale.Side, sale.Price, sale.Shares, sale.Reason, tt.day, tt.price, tt.reason)
+		}
+	}
+}
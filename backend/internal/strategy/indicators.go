@@ -0,0 +1,76 @@
+package strategy
+
+import (
+	"math"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// averageTrueRange returns a Wilder-smoothed ATR aligned with klines. Values
+// before the first full period are zero.
+func averageTrueRange(klines []models.KLine, period int) []float64 {
+	result := make([]float64, len(klines))
+	if period <= 0 || len(klines) < period {
+		return result
+	}
+
+	trueRange := func(i int) float64 {
+		tr := klines[i].High - klines[i].Low
+		if i > 0 {
+			prevClose := klines[i-1].Close
+			tr = math.Max(tr, math.Abs(klines[i].High-prevClose))
+			tr = math.Max(tr, math.Abs(klines[i].Low-prevClose))
+		}
+		return tr
+	}
+
+	var sum float64
+	for i := 0; i < period; i++ {
+		sum += trueRange(i)
+	}
+	result[period-1] = sum / float64(period)
+	for i := period; i < len(klines); i++ {
+		result[i] = (result[i-1]*float64(period-1) + trueRange(i)) / float64(period)
+	}
+	return result
+}
+
+// rollingVolatility returns the standard deviation of close-to-close
+// returns over the trailing window, aligned with klines. Values before the
+// first full window are zero.
+func rollingVolatility(klines []models.KLine, window int) []float64 {
+	result := make([]float64, len(klines))
+	if window < 2 {
+		return result
+	}
+
+	returns := make([]float64, len(klines))
+	for i := 1; i < len(klines); i++ {
+		if klines[i-1].Close > 0 {
+			returns[i] = klines[i].Close/klines[i-1].Close - 1
+		}
+	}
+
+	for i := window; i < len(klines); i++ {
+		result[i] = stdDev(returns[i-window+1 : i+1])
+	}
+	return result
+}
+
+// stdDev returns the sample standard deviation of values.
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)-1))
+}
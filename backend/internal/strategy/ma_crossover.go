@@ -2,17 +2,28 @@ package strategy
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"sort"
-	"time"
 
 	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
 )
 
+// TypeMACrossover is the strategy type of the moving average crossover.
+const TypeMACrossover = "ma_crossover"
+
+// ErrInvalidParams marks a parameter combination the strategy cannot run,
+// such as a short window that is not shorter than the long window.
+var ErrInvalidParams = errors.New("invalid strategy parameters")
+
 // MACrossoverParams configures the moving average crossover strategy.
 type MACrossoverParams struct {
 	ShortWindow int `json:"short_window"`
 	LongWindow  int `json:"long_window"`
+	// AllowShort sells short on a cross below, until the next cross above,
+	// on margin accounts.
+	AllowShort bool `json:"allow_short"`
 }
 
 // DefaultMACrossoverParams provides conservative defaults for screening.
@@ -43,6 +54,25 @@ func ParseMACrossoverParams(raw string) MACrossoverParams {
 	return params
 }
 
+// WithValues returns a copy of p with named parameters overridden, as used
+// for optimization grid points.
+func (p MACrossoverParams) WithValues(values map[string]float64) (MACrossoverParams, error) {
+	for name, value := range values {
+		switch name {
+		case "short_window":
+			p.ShortWindow = int(math.Round(value))
+		case "long_window":
+			p.LongWindow = int(math.Round(value))
+		default:
+			return p, fmt.Errorf("unknown %s parameter %q", TypeMACrossover, name)
+		}
+	}
+	if p.ShortWindow <= 0 || p.ShortWindow >= p.LongWindow {
+		return p, ErrInvalidParams
+	}
+	return p, nil
+}
+
 // ShouldSelect checks whether the most recent data indicates a bullish crossover.
 func ShouldSelect(klines []models.KLine, params MACrossoverParams) bool {
 	if len(klines) < params.LongWindow+1 {
@@ -65,78 +95,47 @@ func ShouldSelect(klines []models.KLine, params MACrossoverParams) bool {
 	return prev <= 0 && curr > 0
 }
 
-// Backtest runs a simple long-only crossover backtest.
-func Backtest(klines []models.KLine, params MACrossoverParams, initial float64) (float64, []EquityPoint, []Trade) {
-	if initial <= 0 {
-		initial = 100000
+// SpreadPct returns how far the short MA is above the long MA on the
+// latest bar in percent of the long MA, the score screen backtests weight
+// picks by. It is NaN without enough bars.
+func SpreadPct(klines []models.KLine, params MACrossoverParams) float64 {
+	if len(klines) == 0 {
+		return math.NaN()
 	}
-
 	sorted := make([]models.KLine, len(klines))
 	copy(sorted, klines)
 	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	spreads := maSpread(sorted, params)
+	return spreads[len(spreads)-1]
+}
 
+// crossoverSignals marks each bar with 1 when the short MA crosses above the
+// long MA on that bar's close, -1 (SignalShort with AllowShort) when it
+// crosses below and 0 otherwise. Both averages are read at the long MA's
+// offset, as the original close-fill backtest did, and the series is the
+// same under every execution mode: modes only differ in when signals fill.
+func crossoverSignals(sorted []models.KLine, params MACrossoverParams) []int {
+	signals := make([]int, len(sorted))
 	shortMA := movingAverage(sorted, params.ShortWindow)
 	longMA := movingAverage(sorted, params.LongWindow)
-
-	cash := initial
-	position := 0.0
-	var points []EquityPoint
-	var trades []Trade
-
-	for i := range sorted {
-		if i < params.LongWindow-1 {
-			points = append(points, EquityPoint{Time: sorted[i].Time, Equity: cash})
-			continue
-		}
-
-		idx := i - (params.LongWindow - 1)
-		if idx >= 1 && idx < len(shortMA) && idx < len(longMA) {
-			prevDiff := shortMA[idx-1] - longMA[idx-1]
-			currDiff := shortMA[idx] - longMA[idx]
-
-			if position == 0 && prevDiff <= 0 && currDiff > 0 {
-				// Buy at close.
-				price := sorted[i].Close
-				shares := math.Floor(cash / price)
-				if shares > 0 {
-					position = shares
-					cash -= shares * price
-					trades = append(trades, Trade{Time: sorted[i].Time, Side: "BUY", Price: price, Shares: shares})
-				}
-			}
-			if position > 0 && prevDiff >= 0 && currDiff < 0 {
-				// Sell at close.
-				price := sorted[i].Close
-				cash += position * price
-				trades = append(trades, Trade{Time: sorted[i].Time, Side: "SELL", Price: price, Shares: position})
-				position = 0
-			}
-		}
-
-		equity := cash + position*sorted[i].Close
-		points = append(points, EquityPoint{Time: sorted[i].Time, Equity: equity})
+	if len(longMA) < 2 {
+		return signals
 	}
 
-	final := cash
-	if len(sorted) > 0 {
-		final += position * sorted[len(sorted)-1].Close
+	offset := params.LongWindow - 1
+	for i := offset + 1; i < len(sorted); i++ {
+		prevDiff := shortMA[i-1-offset] - longMA[i-1-offset]
+		currDiff := shortMA[i-offset] - longMA[i-offset]
+		switch {
+		case prevDiff <= 0 && currDiff > 0:
+			signals[i] = 1
+		case prevDiff >= 0 && currDiff < 0 && params.AllowShort:
+			signals[i] = SignalShort
+		case prevDiff >= 0 && currDiff < 0:
+			signals[i] = -1
+		}
 	}
-
-	return final, points, trades
-}
-
-// EquityPoint captures one point on the equity curve.
-type EquityPoint struct {
-	Time   time.Time `json:"time"`
-	Equity float64   `json:"equity"`
-}
-
-// Trade records a simulated trade decision.
-type Trade struct {
-	Time   time.Time `json:"time"`
-	Side   string    `json:"side"`
-	Price  float64   `json:"price"`
-	Shares float64   `json:"shares"`
+	return signals
 }
 
 func movingAverage(klines []models.KLine, window int) []float64 {
@@ -0,0 +1,157 @@
+package strategy
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// crossoverCloses moves enough for a 2/4 crossover to trigger both ways.
+var crossoverCloses = []float64{10, 10, 10, 10, 9, 8, 9, 11, 12, 12, 11, 9, 8, 8, 9, 10, 12, 11, 10, 9}
+
+func closeBars(closes []float64) []models.KLine {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bars := make([]models.KLine, len(closes))
+	for i, price := range closes {
+		bars[i] = models.KLine{StockCode: "X", Time: start.AddDate(0, 0, i), Open: price, High: price, Low: price, Close: price, Volume: 1e6}
+	}
+	return bars
+}
+
+func TestCrossoverSignals(t *testing.T) {
+	bars := closeBars(crossoverCloses)
+	params := MACrossoverParams{ShortWindow: 2, LongWindow: 4}
+	want := []int{0, 0, 0, 0, 1, 0, 0, -1, 0, 0, 0, 1, 0, 0, 0, -1, 0, 0, 1, 0}
+
+	if got := crossoverSignals(bars, params); !reflect.DeepEqual(got, want) {
+		t.Errorf("signals = %v, want %v", got, want)
+	}
+}
+
+// TestExecutionModesShareSignals checks that execution modes only move the
+// fills of one signal series: every mode trades on the same signal bars.
+func TestExecutionModesShareSignals(t *testing.T) {
+	bars := closeBars(crossoverCloses)
+	params := MACrossoverParams{ShortWindow: 2, LongWindow: 4}
+	signalDays := []int{4, 7, 11, 15, 18}
+
+	for _, mode := range []ExecutionMode{ExecSameClose, ExecNextOpen, ExecNextClose, ExecNextVWAP} {
+		_, _, trades := Backtest(bars, params, BacktestOptions{InitialCapital: 10000, Execution: mode})
+		if len(trades) != len(signalDays) {
+			t.Fatalf("%s: got %d trades, want %d: %+v", mode, len(trades), len(signalDays), trades)
+		}
+		lag := 1
+		if mode == ExecSameClose {
+			lag = 0
+		}
+		for i, day := range signalDays {
+			if want := bars[day+lag].Time; !trades[i].Time.Equal(want) {
+				t.Errorf("%s: trade %d on %s, want %s", mode, i, trades[i].Time.Format("2006-01-02"), want.Format("2006-01-02"))
+			}
+		}
+	}
+}
+
+// TestBacktestSameClose pins the trades of the original close-fill
+// backtest, which same_close runs must reproduce.
+func TestBacktestSameClose(t *testing.T) {
+	final, points, trades := Backtest(closeBars(crossoverCloses), MACrossoverParams{ShortWindow: 2, LongWindow: 4}, BacktestOptions{
+		InitialCapital: 10000,
+		Execution:      ExecSameClose,
+	})
+
+	want := []struct {
+		day    int
+		side   string
+		price  float64
+		shares float64
+	}{
+		{4, SideBuy, 9, 1111},
+		{7, SideSell, 11, 1111},
+		{11, SideBuy, 9, 1358},
+		{15, SideSell, 10, 1358},
+		{18, SideBuy, 10, 1358},
+	}
+	if len(trades) != len(want) {
+		t.Fatalf("got %d trades, want %d: %+v", len(trades), len(want), trades)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, w := range want {
+		got := trades[i]
+		if !got.Time.Equal(start.AddDate(0, 0, w.day)) || got.Side != w.side || got.Price != w.price || got.Shares != w.shares {
+			t.Errorf("trade %d = %s %s %.0f x %.0f, want day %d %s %.0f x %.0f",
+				i, got.Time.Format("2006-01-02"), got.Side, got.Price, got.Shares, w.day, w.side, w.price, w.shares)
+		}
+	}
+	if final != 12222 {
+		t.Errorf("final = %v, want 12222", final)
+	}
+	if len(points) != len(crossoverCloses) {
+		t.Errorf("got %d points, want %d", len(points), len(crossoverCloses))
+	}
+}
+
+// baselineCloses is a 120-bar random walk the original close-fill Backtest
+// was run on with 5/20 windows.
+var baselineCloses = []float64{
+	20.35, 20.6, 21.33, 21.79, 22.47, 21.97, 21.84, 21.85, 21.55, 21.49, 21.24, 21.74, 22.29, 22.02, 22.83,
+	22.79, 22.79, 23.2, 23.01, 22.84, 23.23, 24.08, 24.45, 25.21, 25.6, 24.73, 25.15, 24.52, 25.16, 25.34,
+	24.76, 24.94, 24.22, 24.32, 24.46, 25.3, 25.78, 26.55, 25.99, 26.84, 26.13, 26.49, 25.55, 26.15, 25.53,
+	24.95, 23.97, 24.67, 25.37, 24.88, 25.08, 24.33, 24.35, 24.81, 24.55, 24.84, 25.12, 24.13, 24.32, 24.99,
+	25.35, 24.79, 24.8, 24.72, 25.34, 25.57, 25.08, 24.24, 24.23, 23.39, 23.52, 22.9, 22.75, 22, 22.15,
+	21.3, 21.22, 21.68, 21.77, 22.49, 21.99, 22.48, 21.59, 22.14, 22.94, 23.03, 22.62, 22.6, 23.3, 23.4,
+	24.15, 24.23, 23.74, 24.53, 24.66, 25.34, 25.26, 24.31, 24.93, 25.1, 25.23, 24.4, 23.77, 22.88, 23.14,
+	23.91, 24.39, 25.05, 25.25, 25.75, 26.32, 25.55, 25.25, 25.7, 26.66, 25.6, 25.06, 25.61, 25.27, 25,
+}
+
+// TestBacktestMatchesBaseline pins the trades and equity curve the original
+// close-fill Backtest produced on baselineCloses, which the event-driven
+// engine must reproduce under same_close execution. The curve is flat at
+// 100000 before the first buy and at the final equity after the last sell.
+func TestBacktestMatchesBaseline(t *testing.T) {
+	bars := closeBars(baselineCloses)
+	final, points, trades := Backtest(bars, MACrossoverParams{ShortWindow: 5, LongWindow: 20}, BacktestOptions{InitialCapital: 100000, Execution: ExecSameClose})
+
+	checkTrades(t, trades, []wantTrade{
+		{"X", 53, SideBuy, 24.81, 4030},
+		{"X", 65, SideSell, 25.57, 4030},
+		{"X", 66, SideBuy, 25.08, 4109},
+		{"X", 67, SideSell, 24.24, 4109},
+		{"X", 71, SideBuy, 22.9, 4349},
+		{"X", 90, SideSell, 24.15, 4349},
+		{"X", 111, SideBuy, 25.55, 4111},
+		{"X", 116, SideSell, 25.06, 4111},
+	})
+	if math.Abs(final-103033.10) > 1e-6 {
+		t.Errorf("final = %.2f, want 103033.10", final)
+	}
+
+	// Equity on bars 54 to 116: the buy on bar 53 leaves it at 100000.
+	held := []float64{
+		98952.20, 100120.90, 101249.30, 97259.60, 98025.30, 100725.40, 102176.20, 99919.40, 99959.70, 99637.30,
+		102135.90, 103062.80, 103062.80, 99611.24, 99611.24, 99611.24, 99611.24, 99611.24, 98958.89, 95697.14,
+		96349.49, 92652.84, 92304.92, 94305.46, 94696.87, 97828.15, 95653.65, 97784.66, 93914.05, 96306.00,
+		99785.20, 100176.61, 98393.52, 98306.54, 101350.84, 101785.74, 105047.49, 105047.49, 105047.49, 105047.49,
+		105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 105047.49,
+		105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 105047.49, 103814.19, 105664.14,
+		109610.70, 105253.04, 103033.10,
+	}
+	if len(points) != len(bars) {
+		t.Fatalf("got %d points, want %d", len(points), len(bars))
+	}
+	for i, point := range points {
+		want := 100000.0
+		switch {
+		case i >= 54+len(held):
+			want = 103033.10
+		case i >= 54:
+			want = held[i-54]
+		}
+		if !point.Time.Equal(bars[i].Time) || math.Abs(point.Equity-want) > 1e-6 {
+			t.Errorf("point %d = %s %.2f, want %s %.2f", i, point.Time.Format("2006-01-02"), point.Equity, bars[i].Time.Format("2006-01-02"), want)
+		}
+	}
+}
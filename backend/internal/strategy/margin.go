@@ -0,0 +1,203 @@
+package strategy
+
+import (
+	"math"
+	"time"
+)
+
+// Trade sides of short positions: SHORT sells borrowed shares and COVER
+// buys them back.
+const (
+	SideShort = "SHORT"
+	SideCover = "COVER"
+)
+
+// Signals beyond buy (1) and sell (-1): SignalShort sells any long position
+// and sells short, SignalCover buys a short position back.
+const (
+	SignalShort = -2
+	SignalCover = 2
+)
+
+// MarginConfig configures a margin account (融资融券): buying with borrowed
+// cash, selling borrowed shares short, what both cost and the forced
+// liquidation once the account falls below the maintenance ratio.
+// Percentages are expressed in percent (100 means 100%).
+type MarginConfig struct {
+	Enabled bool `json:"enabled"`
+	// MarginRatioPct is the margin required per yuan financed or sold
+	// short (default 100, so equity can back as much again in debt).
+	MarginRatioPct float64 `json:"margin_ratio_pct"`
+	// FinancingRatePct is the annual interest on borrowed cash (default 8.35).
+	FinancingRatePct float64 `json:"financing_rate_pct"`
+	// BorrowFeePct is the annual fee on the value of shares sold short
+	// (default 10.35).
+	BorrowFeePct float64 `json:"borrow_fee_pct"`
+	// MaintenancePct is the minimum ratio of assets to liabilities
+	// (维持担保比例); below it every position is closed at the bar's close
+	// (default 130).
+	MaintenancePct float64 `json:"maintenance_pct"`
+	// Eligible lists the stocks that may be bought on margin or sold short;
+	// empty means every stock.
+	Eligible []string `json:"eligible"`
+}
+
+func (c MarginConfig) normalized() MarginConfig {
+	if c.MarginRatioPct <= 0 {
+		c.MarginRatioPct = 100
+	}
+	if c.FinancingRatePct <= 0 {
+		c.FinancingRatePct = 8.35
+	}
+	if c.BorrowFeePct <= 0 {
+		c.BorrowFeePct = 10.35
+	}
+	if c.MaintenancePct <= 0 {
+		c.MaintenancePct = 130
+	}
+	return c
+}
+
+// eligible reports whether the stock may be financed or sold short.
+func (c MarginConfig) eligible(code string) bool {
+	if !c.Enabled {
+		return false
+	}
+	if len(c.Eligible) == 0 {
+		return true
+	}
+	for _, eligible := range c.Eligible {
+		if eligible == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Debt returns the cash borrowed on margin.
+func (b *Broker) Debt() float64 {
+	return b.debt
+}
+
+// pay takes amount from cash, borrowing what cash does not cover.
+func (b *Broker) pay(amount float64) {
+	b.cash -= amount
+	if b.cash < 0 {
+		b.debt -= b.cash
+		b.cash = 0
+	}
+}
+
+// receive adds amount to cash after repaying debt with it; a negative
+// amount is paid instead.
+func (b *Broker) receive(amount float64) {
+	if amount < 0 {
+		b.pay(-amount)
+		return
+	}
+	repay := math.Min(b.debt, amount)
+	b.debt -= repay
+	b.cash += amount - repay
+}
+
+// exposure returns the value of long and short positions with the stock
+// marked at price.
+func (b *Broker) exposure(h *holding, price float64) (long, short float64) {
+	for _, code := range b.codes {
+		other := b.holdings[code]
+		value := other.position * other.lastClose
+		if other == h {
+			value = other.position * price
+		}
+		if value > 0 {
+			long += value
+		} else {
+			short -= value
+		}
+	}
+	return long, short
+}
+
+// room returns the yuan an order may spend opening a position at quote
+// after closing shares of the opposite one. Equity may back a long
+// position up to itself plus the debt the margin ratio allows beyond the
+// short side, and shorts within the margin not used by debt. Without a
+// margin account, or for ineligible stocks, longs are bounded by cash and
+// shorts are not allowed; such stocks are never held short.
+func (b *Broker) room(h *holding, side int, quote, closing float64) float64 {
+	if !b.opts.Margin.eligible(h.code) {
+		if side < 0 {
+			return 0
+		}
+		return b.cash
+	}
+	equity := b.equityAt(h, quote)
+	long, short := b.exposure(h, quote)
+	limit := equity * 100 / b.opts.Margin.MarginRatioPct
+	if side > 0 {
+		short -= closing * quote
+		return math.Max(0, equity+math.Max(0, limit-short)-long)
+	}
+	long -= closing * quote
+	return math.Max(0, limit-short-math.Max(0, long-equity))
+}
+
+// accrue charges financing interest on the debt and borrow fees on short
+// positions for the calendar days since the previous timestamp, on the
+// 360-day year brokers use.
+func (b *Broker) accrue(stamp time.Time) {
+	last := b.accrued
+	b.accrued = stamp
+	if !b.opts.Margin.Enabled || last.IsZero() {
+		return
+	}
+	days := calendarDays(last, stamp)
+	if days <= 0 {
+		return
+	}
+	years := float64(days) / 360
+	interest := b.debt * b.opts.Margin.FinancingRatePct / 100 * years
+	var fees float64
+	for _, code := range b.codes {
+		h := b.holdings[code]
+		if h.position < 0 {
+			fee := -h.position * h.lastClose * b.opts.Margin.BorrowFeePct / 100 * years
+			h.cashFlow -= fee
+			fees += fee
+		}
+	}
+	b.interest += interest
+	b.borrowFees += fees
+	b.pay(interest + fees)
+}
+
+// calendarDays returns the number of date changes from one time to another.
+func calendarDays(from, to time.Time) int {
+	y1, m1, d1 := from.Date()
+	y2, m2, d2 := to.Date()
+	start := time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC)
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// marginCall closes every position at the latest close once assets fall
+// below the maintenance ratio of liabilities.
+func (b *Broker) marginCall() {
+	if !b.opts.Margin.Enabled {
+		return
+	}
+	assets := b.cash + b.receivable()
+	liabilities := b.debt
+	for _, code := range b.codes {
+		h := b.holdings[code]
+		assets += h.frozen
+		if value := h.position * h.lastClose; value > 0 {
+			assets += value
+		} else {
+			liabilities -= value
+		}
+	}
+	if liabilities > 0 && assets < liabilities*b.opts.Margin.MaintenancePct/100 {
+		b.liquidate(ReasonMarginCall)
+	}
+}
@@ -0,0 +1,177 @@
+package strategy
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+func flatBars(count int) []models.KLine {
+	rows := make([][4]float64, count)
+	for i := range rows {
+		rows[i] = [4]float64{10, 10, 10, 10}
+	}
+	return ohlcBars("X", rows)
+}
+
+func TestMarginConfigEligible(t *testing.T) {
+	tests := []struct {
+		config MarginConfig
+		code   string
+		want   bool
+	}{
+		{MarginConfig{}, "X", false},
+		{MarginConfig{Enabled: true}, "X", true},
+		{MarginConfig{Enabled: true, Eligible: []string{"X"}}, "X", true},
+		{MarginConfig{Enabled: true, Eligible: []string{"Y"}}, "X", false},
+	}
+	for _, tt := range tests {
+		if got := tt.config.eligible(tt.code); got != tt.want {
+			t.Errorf("%+v eligible(%q) = %v, want %v", tt.config, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestCalendarDays(t *testing.T) {
+	from := time.Date(2024, 1, 5, 15, 0, 0, 0, time.UTC)
+	tests := map[time.Time]int{
+		time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC): 0,
+		time.Date(2024, 1, 6, 9, 30, 0, 0, time.UTC): 1,
+		time.Date(2024, 1, 8, 15, 0, 0, 0, time.UTC): 3,
+	}
+	for to, want := range tests {
+		if got := calendarDays(from, to); got != want {
+			t.Errorf("calendarDays(%v, %v) = %d, want %d", from, to, got, want)
+		}
+	}
+}
+
+// TestMarginBuy buys 150000 yuan of stock with 100000 of capital: a cash
+// account fills what it can pay for, a margin account borrows the rest and
+// pays interest on it.
+func TestMarginBuy(t *testing.T) {
+	tests := []struct {
+		margin   MarginConfig
+		shares   float64
+		interest float64
+	}{
+		{MarginConfig{}, 10000, 0},
+		{MarginConfig{Enabled: true, Eligible: []string{"Y"}}, 10000, 0},
+		// 50000 borrowed at 8.35% from day 1 to day 4, the interest
+		// borrowed too.
+		{MarginConfig{Enabled: true}, 15000, 50000 * (math.Pow(1+0.0835/360, 3) - 1)},
+	}
+	for _, tt := range tests {
+		s := &scriptStrategy{orders: map[int][]Order{0: {{StockCode: "X", Side: 1, Shares: 15000}}}}
+		res, err := Run(context.Background(), map[string][]models.KLine{"X": flatBars(5)}, s, BacktestOptions{Margin: tt.margin, Execution: ExecNextOpen})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTrades(t, res.Trades, []wantTrade{{"X", 1, SideBuy, 10, tt.shares}})
+		if math.Abs(res.Interest-tt.interest) > 1e-9 || math.Abs(res.Final-(100000-tt.interest)) > 1e-6 {
+			t.Errorf("%+v: interest %v final %v, want %v and %v", tt.margin, res.Interest, res.Final, tt.interest, 100000-tt.interest)
+		}
+	}
+}
+
+func TestShortSale(t *testing.T) {
+	tests := []struct {
+		margin MarginConfig
+		want   []wantTrade
+		cancel string
+	}{
+		{MarginConfig{}, nil, CancelNotShortable},
+		{MarginConfig{Enabled: true, Eligible: []string{"Y"}}, nil, CancelNotShortable},
+		{MarginConfig{Enabled: true}, []wantTrade{{"X", 1, SideShort, 10, 1000}, {"X", 3, SideCover, 10, 1000}}, ""},
+	}
+	for _, tt := range tests {
+		s := &scriptStrategy{orders: map[int][]Order{0: {{StockCode: "X", Side: -1, Short: true, Shares: 1000}}}}
+		if tt.want != nil {
+			s.orders[2] = []Order{{StockCode: "X", Side: 1, Shares: 1000}}
+		}
+		res, err := Run(context.Background(), map[string][]models.KLine{"X": flatBars(5)}, s, BacktestOptions{Margin: tt.margin, Execution: ExecNextOpen})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTrades(t, res.Trades, tt.want)
+		var cancel string
+		for _, e := range s.events {
+			if e.Type == EventCancel && e.Order.Short {
+				cancel = e.Reason
+			}
+		}
+		if cancel != tt.cancel {
+			t.Errorf("%+v: short canceled with %q, want %q", tt.margin, cancel, tt.cancel)
+		}
+		// 10000 yuan sold short for two days at 10.35% a year.
+		if want := 10000 * 0.1035 * 2 / 360; tt.want != nil && math.Abs(res.BorrowFees-want) > 1e-9 {
+			t.Errorf("borrow fees %v, want %v", res.BorrowFees, want)
+		}
+	}
+}
+
+// TestMarginCall shorts 100000 yuan of stock with 100000 of capital and
+// lets the price climb until assets of 200000 fall below 130% of the
+// shares owed, which happens once the close passes 15.38.
+func TestMarginCall(t *testing.T) {
+	bars := ohlcBars("X", [][4]float64{
+		{10, 10, 10, 10},
+		{10, 10, 10, 10},
+		{12, 12, 12, 12},
+		{14, 14, 14, 14},
+		{16, 16, 16, 16},
+		{18, 18, 18, 18},
+	})
+	s := &scriptStrategy{orders: map[int][]Order{0: {{StockCode: "X", Side: -1, Short: true, Shares: 10000}}}}
+	res, err := Run(context.Background(), map[string][]models.KLine{"X": bars}, s, BacktestOptions{Margin: MarginConfig{Enabled: true}, Execution: ExecNextOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTrades(t, res.Trades, []wantTrade{
+		{"X", 1, SideShort, 10, 10000},
+		{"X", 4, SideCover, 16, 10000},
+	})
+	if reason := res.Trades[1].Reason; reason != ReasonMarginCall {
+		t.Errorf("cover reason %q, want %q", reason, ReasonMarginCall)
+	}
+	if want := 100000 - 60000 - res.BorrowFees; math.Abs(res.Final-want) > 1e-6 {
+		t.Errorf("final %v, want %v", res.Final, want)
+	}
+}
+
+// TestShortSignals runs the crossover strategy long and short on a wave:
+// with margin it alternates long and short positions, without it the
+// short signals only close longs.
+func TestShortSignals(t *testing.T) {
+	var closes []float64
+	for i := 0; i < 120; i++ {
+		closes = append(closes, 50+10*math.Sin(float64(i)/8))
+	}
+	bars := closeBars(closes)
+	params := MACrossoverParams{ShortWindow: 3, LongWindow: 10, AllowShort: true}
+	signals := func(b []models.KLine) []int { return crossoverSignals(b, params) }
+
+	for _, margin := range []bool{false, true} {
+		opts := BacktestOptions{Margin: MarginConfig{Enabled: margin}}
+		res, err := Run(context.Background(), map[string][]models.KLine{"X": bars}, newSignalStrategy(PortfolioOptions{BacktestOptions: opts}, signals), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sides := make(map[string]int)
+		for _, trade := range res.Trades {
+			sides[trade.Side]++
+		}
+		if margin && (sides[SideShort] == 0 || sides[SideCover] == 0 || sides[SideBuy] == 0) {
+			t.Errorf("margin: trades by side %v, want longs and shorts", sides)
+		}
+		if !margin && (sides[SideShort] != 0 || sides[SideCover] != 0 || sides[SideBuy] == 0) {
+			t.Errorf("cash: trades by side %v, want longs only", sides)
+		}
+		if margin && res.BorrowFees <= 0 {
+			t.Error("margin: no borrow fees on the shorts")
+		}
+	}
+}
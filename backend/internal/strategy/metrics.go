@@ -0,0 +1,271 @@
+package strategy
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// RoundTrip is one position from the first buy, or short sale, until it is
+// flat again. For shorts Proceeds are from the short sales and Cost from
+// the covers.
+type RoundTrip struct {
+	StockCode string    `json:"stock_code,omitempty"`
+	Short     bool      `json:"short"`
+	Entry     time.Time `json:"entry"`
+	Exit      time.Time `json:"exit"`
+	Cost      float64   `json:"cost"`
+	Proceeds  float64   `json:"proceeds"`
+	PnL       float64   `json:"pnl"`
+	ReturnPct float64   `json:"return_pct"`
+	// Open marks a position still held at the end; it is not counted as a trade.
+	Open bool `json:"open"`
+}
+
+// RoundTrips groups fills into positions per stock in time order.
+func RoundTrips(trades []Trade) []RoundTrip {
+	sorted := make([]Trade, len(trades))
+	copy(sorted, trades)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	type book struct {
+		shares float64
+		trip   RoundTrip
+	}
+	books := make(map[string]*book)
+	var trips []RoundTrip
+
+	for _, trade := range sorted {
+		b := books[trade.StockCode]
+		if b == nil {
+			b = &book{}
+			books[trade.StockCode] = b
+		}
+		value := trade.Price * trade.Shares
+		switch trade.Side {
+		case SideBuy:
+			if b.shares == 0 {
+				b.trip = RoundTrip{StockCode: trade.StockCode, Entry: trade.Time}
+			}
+			b.shares += trade.Shares
+			b.trip.Cost += value
+			continue
+		case SideShort:
+			if b.shares == 0 {
+				b.trip = RoundTrip{StockCode: trade.StockCode, Entry: trade.Time, Short: true}
+			}
+			b.shares -= trade.Shares
+			b.trip.Proceeds += value
+			continue
+		case SideDividend:
+			b.trip.Proceeds += value
+			continue
+		case SideBonus:
+			b.shares += trade.Shares
+			continue
+		case SideCover:
+			b.shares += trade.Shares
+			b.trip.Cost += value
+			b.trip.Exit = trade.Time
+			if b.shares >= 0 {
+				b.shares = 0
+				trips = append(trips, b.trip.finish())
+			}
+			continue
+		}
+
+		b.shares -= trade.Shares
+		b.trip.Proceeds += value - trade.Tax
+		b.trip.Exit = trade.Time
+		if b.shares <= 0 {
+			b.shares = 0
+			trips = append(trips, b.trip.finish())
+		}
+	}
+
+	for code, b := range books {
+		if b.shares != 0 {
+			trip := b.trip
+			trip.StockCode = code
+			trip.Open = true
+			trips = append(trips, trip)
+		}
+	}
+	sort.SliceStable(trips, func(i, j int) bool { return trips[i].Entry.Before(trips[j].Entry) })
+	return trips
+}
+
+func (r RoundTrip) finish() RoundTrip {
+	r.PnL = r.Proceeds - r.Cost
+	basis := r.Cost
+	if r.Short {
+		basis = r.Proceeds
+	}
+	if basis > 0 {
+		r.ReturnPct = r.PnL / basis * 100
+	}
+	return r
+}
+
+// ComputeMetrics derives performance statistics from an equity curve and its
+// fills. Ratios that are undefined (no losses, no drawdown, no variance) are
+// reported as zero. barsPerYear annualizes per-bar returns; zero or less
+// means daily bars.
+func ComputeMetrics(points []EquityPoint, trades []Trade, initial, barsPerYear float64) models.BacktestMetrics {
+	var metrics models.BacktestMetrics
+	if len(points) == 0 {
+		return metrics
+	}
+	if initial <= 0 {
+		initial = points[0].Equity
+	}
+
+	barsPerYear = annualBars(barsPerYear)
+	returns := equityReturns(points, initial)
+	final := points[len(points)-1].Equity
+	if len(returns) > 0 && initial > 0 && final > 0 {
+		years := float64(len(returns)) / barsPerYear
+		metrics.AnnualReturnPct = (math.Pow(final/initial, 1/years) - 1) * 100
+	}
+
+	volatility := stdDev(returns) * math.Sqrt(barsPerYear)
+	metrics.VolatilityPct = volatility * 100
+	if volatility > 0 {
+		metrics.Sharpe = mean(returns) * barsPerYear / volatility
+	}
+	if downside := downsideDeviation(returns) * math.Sqrt(barsPerYear); downside > 0 {
+		metrics.Sortino = mean(returns) * barsPerYear / downside
+	}
+
+	metrics.MaxDrawdownPct, metrics.MaxDrawdownBars = maxDrawdown(points, initial)
+	if metrics.MaxDrawdownPct > 0 {
+		metrics.Calmar = metrics.AnnualReturnPct / metrics.MaxDrawdownPct
+	}
+
+	index := make(map[int64]int, len(points))
+	for i, point := range points {
+		index[point.Time.UnixNano()] = i
+	}
+	barOf := func(t time.Time) int {
+		if i, ok := index[t.UnixNano()]; ok {
+			return i
+		}
+		return sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(t) })
+	}
+
+	var wins, losses int
+	var grossProfit, grossLoss, winPct, lossPct, holding float64
+	exposed := make([]bool, len(points))
+	for _, trip := range RoundTrips(trades) {
+		from := barOf(trip.Entry)
+		to := len(points)
+		if !trip.Open {
+			to = barOf(trip.Exit)
+			holding += float64(to - from)
+		}
+		for i := from; i < to && i < len(exposed); i++ {
+			exposed[i] = true
+		}
+		if trip.Open {
+			continue
+		}
+
+		metrics.Trades++
+		if trip.PnL > 0 {
+			wins++
+			grossProfit += trip.PnL
+			winPct += trip.ReturnPct
+		} else if trip.PnL < 0 {
+			losses++
+			grossLoss -= trip.PnL
+			lossPct += trip.ReturnPct
+		}
+	}
+
+	if metrics.Trades > 0 {
+		metrics.WinRatePct = float64(wins) / float64(metrics.Trades) * 100
+		metrics.AvgHoldingBars = holding / float64(metrics.Trades)
+		metrics.ExpectancyPct = (winPct + lossPct) / float64(metrics.Trades)
+	}
+	if wins > 0 {
+		metrics.AvgWinPct = winPct / float64(wins)
+	}
+	if losses > 0 {
+		metrics.AvgLossPct = lossPct / float64(losses)
+	}
+	if grossLoss > 0 {
+		metrics.ProfitFactor = grossProfit / grossLoss
+	}
+
+	var exposedBars int
+	for _, flag := range exposed {
+		if flag {
+			exposedBars++
+		}
+	}
+	metrics.ExposurePct = float64(exposedBars) / float64(len(points)) * 100
+	return metrics
+}
+
+// equityReturns returns per-bar fractional returns, starting from initial.
+func equityReturns(points []EquityPoint, initial float64) []float64 {
+	returns := make([]float64, 0, len(points))
+	prev := initial
+	for _, point := range points {
+		if prev > 0 {
+			returns = append(returns, point.Equity/prev-1)
+		}
+		prev = point.Equity
+	}
+	return returns
+}
+
+// maxDrawdown returns the deepest peak-to-trough decline in percent and the
+// longest time in bars spent below a previous peak.
+func maxDrawdown(points []EquityPoint, initial float64) (float64, int) {
+	peak := initial
+	peakBar := -1
+	var worst float64
+	var longest int
+	for i, point := range points {
+		if point.Equity >= peak {
+			peak = point.Equity
+			peakBar = i
+			continue
+		}
+		if peak > 0 {
+			worst = math.Max(worst, (peak-point.Equity)/peak*100)
+		}
+		if i-peakBar > longest {
+			longest = i - peakBar
+		}
+	}
+	return worst, longest
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// downsideDeviation is the root mean square of negative returns.
+func downsideDeviation(returns []float64) float64 {
+	if len(returns) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, r := range returns {
+		if r < 0 {
+			sum += r * r
+		}
+	}
+	return math.Sqrt(sum / float64(len(returns)))
+}
@@ -0,0 +1,138 @@
+package strategy
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// equityPoints returns one daily point per equity value.
+func equityPoints(values ...float64) []EquityPoint {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := make([]EquityPoint, len(values))
+	for i, value := range values {
+		points[i] = EquityPoint{Time: start.AddDate(0, 0, i), Equity: value}
+	}
+	return points
+}
+
+// TestComputeMetricsAnnualization checks that per-bar returns of +2% and
+// -1% in turn annualize with the bars per year of daily, weekly and
+// 30-minute bars. The returns have mean 0.005, sample deviation
+// 0.015*sqrt(4/3) and downside deviation sqrt(0.0002/4).
+func TestComputeMetricsAnnualization(t *testing.T) {
+	points := returnsRun(0.02, -0.01, 0.02, -0.01).Points
+	final := 1.02 * 0.99 * 1.02 * 0.99
+	sd := 0.015 * math.Sqrt(4.0/3)
+	downside := math.Sqrt(0.0002 / 4)
+
+	tests := []struct {
+		interval    string
+		barsPerYear float64
+	}{
+		{"1d", 252},
+		{"1w", 50.4},
+		{"30m", 2016},
+	}
+	for _, tt := range tests {
+		if got := BarsPerYear(tt.interval); got != tt.barsPerYear {
+			t.Errorf("BarsPerYear(%q) = %v, want %v", tt.interval, got, tt.barsPerYear)
+		}
+		got := ComputeMetrics(points, nil, 100, BarsPerYear(tt.interval))
+		want := map[string][2]float64{
+			"AnnualReturnPct": {got.AnnualReturnPct, (math.Pow(final, tt.barsPerYear/4) - 1) * 100},
+			"VolatilityPct":   {got.VolatilityPct, sd * math.Sqrt(tt.barsPerYear) * 100},
+			"Sharpe":          {got.Sharpe, 0.005 * math.Sqrt(tt.barsPerYear) / sd},
+			"Sortino":         {got.Sortino, 0.005 * math.Sqrt(tt.barsPerYear) / downside},
+		}
+		for name, pair := range want {
+			if math.Abs(pair[0]-pair[1]) > 1e-9*math.Max(1, math.Abs(pair[1])) {
+				t.Errorf("%s: %s = %v, want %v", tt.interval, name, pair[0], pair[1])
+			}
+		}
+	}
+}
+
+// TestComputeMetricsDrawdown checks the deepest drawdown, 10% over two
+// bars, and the longest run of bars below a previous peak, three bars 5%
+// under it.
+func TestComputeMetricsDrawdown(t *testing.T) {
+	got := ComputeMetrics(equityPoints(110, 99, 104.5, 110, 120, 114, 114, 114, 130), nil, 100, 0)
+	if math.Abs(got.MaxDrawdownPct-10) > 1e-9 || got.MaxDrawdownBars != 3 {
+		t.Errorf("drawdown %v%% over %d bars, want 10%% over 3", got.MaxDrawdownPct, got.MaxDrawdownBars)
+	}
+	if want := got.AnnualReturnPct / got.MaxDrawdownPct; got.Calmar != want {
+		t.Errorf("Calmar = %v, want %v", got.Calmar, want)
+	}
+
+	flat := ComputeMetrics(equityPoints(100, 101, 102), nil, 100, 0)
+	if flat.MaxDrawdownPct != 0 || flat.MaxDrawdownBars != 0 || flat.Calmar != 0 {
+		t.Errorf("rising curve: drawdown %v%% over %d bars, Calmar %v, want zeros", flat.MaxDrawdownPct, flat.MaxDrawdownBars, flat.Calmar)
+	}
+}
+
+// tripTrades holds a long position through a dividend and bonus shares,
+// two short positions, one of them through bonus shares owed to the
+// lender, and a long position still open at the end.
+func tripTrades() []Trade {
+	day := func(d int) time.Time { return time.Date(2024, 1, 1+d, 0, 0, 0, 0, time.UTC) }
+	return []Trade{
+		{StockCode: "X", Time: day(0), Side: SideBuy, Price: 10, Shares: 100},
+		{StockCode: "X", Time: day(1), Side: SideDividend, Price: 0.5, Shares: 100},
+		{StockCode: "X", Time: day(2), Side: SideBonus, Shares: 50},
+		{StockCode: "X", Time: day(3), Side: SideSell, Price: 8, Shares: 150, Tax: 5},
+		{StockCode: "X", Time: day(4), Side: SideShort, Price: 20, Shares: 100},
+		{StockCode: "X", Time: day(5), Side: SideBonus, Shares: -50},
+		{StockCode: "X", Time: day(6), Side: SideCover, Price: 14, Shares: 150},
+		{StockCode: "X", Time: day(7), Side: SideShort, Price: 20, Shares: 50},
+		{StockCode: "X", Time: day(8), Side: SideCover, Price: 16, Shares: 50},
+		{StockCode: "X", Time: day(9), Side: SideBuy, Price: 10, Shares: 10},
+	}
+}
+
+func TestRoundTrips(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, 1+d, 0, 0, 0, 0, time.UTC) }
+	want := []RoundTrip{
+		// 1200 from the sale less 5 tax plus a 50 dividend on 1000 of cost.
+		{StockCode: "X", Entry: day(0), Exit: day(3), Cost: 1000, Proceeds: 1245, PnL: 245, ReturnPct: 24.5},
+		// Covering the 50 bonus shares too costs 2100 against 2000 of proceeds.
+		{StockCode: "X", Short: true, Entry: day(4), Exit: day(6), Cost: 2100, Proceeds: 2000, PnL: -100, ReturnPct: -5},
+		{StockCode: "X", Short: true, Entry: day(7), Exit: day(8), Cost: 800, Proceeds: 1000, PnL: 200, ReturnPct: 20},
+		{StockCode: "X", Entry: day(9), Cost: 100, Open: true},
+	}
+	if got := RoundTrips(tripTrades()); !reflect.DeepEqual(got, want) {
+		t.Errorf("RoundTrips =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+// TestComputeMetricsTrades checks the trade statistics of tripTrades on
+// eleven daily bars. The open position counts towards exposure only.
+func TestComputeMetricsTrades(t *testing.T) {
+	values := make([]float64, 11)
+	for i := range values {
+		values[i] = 100000
+	}
+	got := ComputeMetrics(equityPoints(values...), tripTrades(), 100000, 0)
+
+	if got.Trades != 3 {
+		t.Errorf("Trades = %d, want 3", got.Trades)
+	}
+	for _, field := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"WinRatePct", got.WinRatePct, 200.0 / 3},
+		{"ProfitFactor", got.ProfitFactor, 445.0 / 100},
+		{"AvgWinPct", got.AvgWinPct, (24.5 + 20) / 2},
+		{"AvgLossPct", got.AvgLossPct, -5},
+		{"ExpectancyPct", got.ExpectancyPct, (24.5 + 20 - 5) / 3},
+		{"AvgHoldingBars", got.AvgHoldingBars, (3 + 2 + 1) / 3.0},
+		// Bars 0-2, 4-5, 7 and 9-10 hold a position.
+		{"ExposurePct", got.ExposurePct, 8.0 / 11 * 100},
+	} {
+		if math.Abs(field.got-field.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+		}
+	}
+}
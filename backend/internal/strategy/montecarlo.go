@@ -0,0 +1,226 @@
+package strategy
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Monte Carlo resampling methods.
+const (
+	// MonteCarloBootstrap draws as many trades as were taken, with replacement.
+	MonteCarloBootstrap = "bootstrap"
+	// MonteCarloShuffle keeps every trade but randomizes their order.
+	MonteCarloShuffle = "shuffle"
+	// MonteCarloSkip drops each trade with probability SkipPct.
+	MonteCarloSkip = "skip"
+)
+
+// maxSimulations bounds the work of one Monte Carlo request.
+const maxSimulations = 100000
+
+// MonteCarloOptions configures a Monte Carlo analysis of trade returns.
+type MonteCarloOptions struct {
+	Method string `json:"method"`
+	// Simulations is the number of resampled paths (default 1000).
+	Simulations int `json:"simulations"`
+	// SkipPct is the chance in percent that skip drops a trade (default 10).
+	SkipPct float64 `json:"skip_pct"`
+	// Seed makes the paths reproducible; zero seeds from the clock.
+	Seed int64 `json:"seed"`
+	// Initial is the starting equity of every path.
+	Initial float64 `json:"-"`
+	// Bars is the length of the backtest the trades came from; it turns the
+	// trade count of a path into a yearly frequency for its Sharpe ratio.
+	Bars int `json:"-"`
+	// BarsPerYear is the number of bars in a trading year at the
+	// backtest's interval; zero or less means daily bars.
+	BarsPerYear float64 `json:"-"`
+}
+
+// Distribution summarizes one statistic across the simulated paths.
+type Distribution struct {
+	Mean float64 `json:"mean"`
+	P5   float64 `json:"p5"`
+	P25  float64 `json:"p25"`
+	P50  float64 `json:"p50"`
+	P75  float64 `json:"p75"`
+	P95  float64 `json:"p95"`
+}
+
+// PathStats are the statistics of one sequence of trade returns.
+type PathStats struct {
+	FinalEquity    float64 `json:"final_equity"`
+	ReturnPct      float64 `json:"return_pct"`
+	MaxDrawdownPct float64 `json:"max_drawdown_pct"`
+	Sharpe         float64 `json:"sharpe"`
+}
+
+// MonteCarloResult compares the actual trade sequence with resampled ones.
+type MonteCarloResult struct {
+	Method         string       `json:"method"`
+	Simulations    int          `json:"simulations"`
+	Seed           int64        `json:"seed"`
+	Trades         int          `json:"trades"`
+	Actual         PathStats    `json:"actual"`
+	FinalEquity    Distribution `json:"final_equity"`
+	ReturnPct      Distribution `json:"return_pct"`
+	MaxDrawdownPct Distribution `json:"max_drawdown_pct"`
+	Sharpe         Distribution `json:"sharpe"`
+	// LossProbabilityPct is the share of paths ending below the initial equity.
+	LossProbabilityPct float64 `json:"loss_probability_pct"`
+}
+
+// TradeEquityReturns converts closed round trips into returns on the
+// account equity just before each entry, in exit order. Overlapping trips
+// of a portfolio are treated as if they happened one after another.
+func TradeEquityReturns(points []EquityPoint, trades []Trade, initial float64) []float64 {
+	trips := RoundTrips(trades)
+	sort.SliceStable(trips, func(i, j int) bool { return trips[i].Exit.Before(trips[j].Exit) })
+
+	equityBefore := func(t time.Time) float64 {
+		i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(t) })
+		if i == 0 {
+			return initial
+		}
+		return points[i-1].Equity
+	}
+
+	returns := make([]float64, 0, len(trips))
+	for _, trip := range trips {
+		if trip.Open {
+			continue
+		}
+		if equity := equityBefore(trip.Entry); equity > 0 {
+			returns = append(returns, trip.PnL/equity)
+		}
+	}
+	return returns
+}
+
+// MonteCarlo resamples trade returns into many compounded equity paths and
+// reports the spread of their outcomes.
+func MonteCarlo(returns []float64, opts MonteCarloOptions) (MonteCarloResult, error) {
+	if opts.Method == "" {
+		opts.Method = MonteCarloBootstrap
+	}
+	switch opts.Method {
+	case MonteCarloBootstrap, MonteCarloShuffle, MonteCarloSkip:
+	default:
+		return MonteCarloResult{}, fmt.Errorf("unknown monte carlo method %q", opts.Method)
+	}
+	if opts.Simulations <= 0 {
+		opts.Simulations = 1000
+	}
+	if opts.Simulations > maxSimulations {
+		return MonteCarloResult{}, fmt.Errorf("at most %d simulations are allowed", maxSimulations)
+	}
+	if opts.SkipPct <= 0 || opts.SkipPct >= 100 {
+		opts.SkipPct = 10
+	}
+	if opts.Initial <= 0 {
+		opts.Initial = 100000
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	if len(returns) == 0 {
+		return MonteCarloResult{}, fmt.Errorf("no closed trades to resample")
+	}
+
+	result := MonteCarloResult{
+		Method:      opts.Method,
+		Simulations: opts.Simulations,
+		Seed:        opts.Seed,
+		Trades:      len(returns),
+		Actual:      pathStats(returns, opts),
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	finals := make([]float64, opts.Simulations)
+	gains := make([]float64, opts.Simulations)
+	drawdowns := make([]float64, opts.Simulations)
+	sharpes := make([]float64, opts.Simulations)
+	path := make([]float64, 0, len(returns))
+	losses := 0
+	for n := 0; n < opts.Simulations; n++ {
+		path = path[:0]
+		switch opts.Method {
+		case MonteCarloBootstrap:
+			for range returns {
+				path = append(path, returns[rng.Intn(len(returns))])
+			}
+		case MonteCarloShuffle:
+			path = append(path, returns...)
+			rng.Shuffle(len(path), func(i, j int) { path[i], path[j] = path[j], path[i] })
+		case MonteCarloSkip:
+			for _, r := range returns {
+				if rng.Float64()*100 >= opts.SkipPct {
+					path = append(path, r)
+				}
+			}
+		}
+
+		stats := pathStats(path, opts)
+		finals[n], gains[n], drawdowns[n], sharpes[n] = stats.FinalEquity, stats.ReturnPct, stats.MaxDrawdownPct, stats.Sharpe
+		if stats.FinalEquity < opts.Initial {
+			losses++
+		}
+	}
+
+	result.FinalEquity = distribution(finals)
+	result.ReturnPct = distribution(gains)
+	result.MaxDrawdownPct = distribution(drawdowns)
+	result.Sharpe = distribution(sharpes)
+	result.LossProbabilityPct = float64(losses) / float64(opts.Simulations) * 100
+	return result, nil
+}
+
+// pathStats compounds returns from the initial equity.
+func pathStats(returns []float64, opts MonteCarloOptions) PathStats {
+	equity, peak := opts.Initial, opts.Initial
+	var stats PathStats
+	for _, r := range returns {
+		equity *= 1 + r
+		if equity > peak {
+			peak = equity
+		} else if peak > 0 {
+			stats.MaxDrawdownPct = math.Max(stats.MaxDrawdownPct, (peak-equity)/peak*100)
+		}
+	}
+	stats.FinalEquity = equity
+	stats.ReturnPct = (equity - opts.Initial) / opts.Initial * 100
+	// Paths of one repeated trade have no meaningful spread, only rounding noise.
+	if sd := stdDev(returns); sd > 1e-12 && opts.Bars > 0 {
+		tradesPerYear := float64(len(returns)) / (float64(opts.Bars) / annualBars(opts.BarsPerYear))
+		stats.Sharpe = mean(returns) / sd * math.Sqrt(tradesPerYear)
+	}
+	return stats
+}
+
+func distribution(values []float64) Distribution {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return Distribution{
+		Mean: mean(sorted),
+		P5:   percentile(sorted, 5),
+		P25:  percentile(sorted, 25),
+		P50:  percentile(sorted, 50),
+		P75:  percentile(sorted, 75),
+		P95:  percentile(sorted, 95),
+	}
+}
+
+// percentile interpolates linearly between the closest ranks of sorted values.
+func percentile(sorted []float64, pct float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := pct / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
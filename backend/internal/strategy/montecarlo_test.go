@@ -0,0 +1,163 @@
+package strategy
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mcReturns compound from 100000 to 112860, peaking at 110000 and 125400
+// with drawdowns of 5% and 10% after them.
+var mcReturns = []float64{0.1, -0.05, 0.2, -0.1}
+
+func checkOrdered(t *testing.T, name string, d Distribution) {
+	t.Helper()
+	if !(d.P5 <= d.P25 && d.P25 <= d.P50 && d.P50 <= d.P75 && d.P75 <= d.P95) {
+		t.Errorf("%s: percentiles out of order: %+v", name, d)
+	}
+}
+
+func TestMonteCarloActual(t *testing.T) {
+	res, err := MonteCarlo(mcReturns, MonteCarloOptions{Simulations: 10, Seed: 1, Bars: 252, BarsPerYear: 252})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Method != MonteCarloBootstrap || res.Simulations != 10 || res.Seed != 1 || res.Trades != 4 {
+		t.Errorf("ran %s x %d with seed %d on %d trades, want bootstrap x 10 with seed 1 on 4", res.Method, res.Simulations, res.Seed, res.Trades)
+	}
+	// Four trades in a year of bars; the returns have mean 0.0375 and
+	// squared deviations summing to 0.056875.
+	sharpe := 0.0375 / math.Sqrt(0.056875/3) * math.Sqrt(4)
+	for _, field := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"FinalEquity", res.Actual.FinalEquity, 112860},
+		{"ReturnPct", res.Actual.ReturnPct, 12.86},
+		{"MaxDrawdownPct", res.Actual.MaxDrawdownPct, 10},
+		{"Sharpe", res.Actual.Sharpe, sharpe},
+	} {
+		if math.Abs(field.got-field.want) > 1e-9 {
+			t.Errorf("actual %s = %v, want %v", field.name, field.got, field.want)
+		}
+	}
+}
+
+// TestMonteCarloShuffle checks that reordering trades keeps the final
+// equity and only moves the drawdown, which is at least the 10% of the
+// losing trade and at most 14.5% when both losses come in a row.
+func TestMonteCarloShuffle(t *testing.T) {
+	res, err := MonteCarlo(mcReturns, MonteCarloOptions{Method: MonteCarloShuffle, Simulations: 500, Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []float64{res.FinalEquity.Mean, res.FinalEquity.P5, res.FinalEquity.P95} {
+		if math.Abs(value-112860) > 1e-6 {
+			t.Errorf("final equity %+v, want 112860 on every path", res.FinalEquity)
+			break
+		}
+	}
+	if res.LossProbabilityPct != 0 {
+		t.Errorf("loss probability %v%%, want 0", res.LossProbabilityPct)
+	}
+	dd := res.MaxDrawdownPct
+	checkOrdered(t, "drawdown", dd)
+	if dd.P5 < 10-1e-9 || dd.P95 > 14.5+1e-9 || dd.P5 == dd.P95 {
+		t.Errorf("drawdowns %+v, want a spread within 10%% to 14.5%%", dd)
+	}
+}
+
+// TestMonteCarloBootstrap checks that a seed reproduces the paths and that
+// every path stays between four of the worst and four of the best trade.
+func TestMonteCarloBootstrap(t *testing.T) {
+	opts := MonteCarloOptions{Method: MonteCarloBootstrap, Simulations: 2000, Seed: 42, Bars: 252}
+	res, err := MonteCarlo(mcReturns, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := MonteCarlo(mcReturns, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, again) {
+		t.Errorf("same seed gave\n%+v\nthen\n%+v", res, again)
+	}
+	opts.Seed = 43
+	if other, _ := MonteCarlo(mcReturns, opts); reflect.DeepEqual(res.FinalEquity, other.FinalEquity) {
+		t.Error("another seed gave the same final equity distribution")
+	}
+
+	for name, d := range map[string]Distribution{"final equity": res.FinalEquity, "return": res.ReturnPct, "drawdown": res.MaxDrawdownPct, "sharpe": res.Sharpe} {
+		checkOrdered(t, name, d)
+	}
+	low, high := 100000*math.Pow(0.9, 4), 100000*math.Pow(1.2, 4)
+	if res.FinalEquity.P5 < low-1e-6 || res.FinalEquity.P95 > high+1e-6 || res.FinalEquity.P5 == res.FinalEquity.P95 {
+		t.Errorf("final equity %+v, want a spread within %v to %v", res.FinalEquity, low, high)
+	}
+	if res.LossProbabilityPct <= 0 || res.LossProbabilityPct >= 100 {
+		t.Errorf("loss probability %v%%, want some losing paths", res.LossProbabilityPct)
+	}
+}
+
+func TestMonteCarloEdgeCases(t *testing.T) {
+	if _, err := MonteCarlo(nil, MonteCarloOptions{Seed: 1}); err == nil {
+		t.Error("no returns: want an error")
+	}
+	if _, err := MonteCarlo(mcReturns, MonteCarloOptions{Method: "jackknife", Seed: 1}); err == nil {
+		t.Error("unknown method: want an error")
+	}
+	if _, err := MonteCarlo(mcReturns, MonteCarloOptions{Simulations: maxSimulations + 1, Seed: 1}); err == nil {
+		t.Error("too many simulations: want an error")
+	}
+
+	// A single losing trade repeats on every path, without a Sharpe ratio.
+	for _, method := range []string{MonteCarloBootstrap, MonteCarloShuffle} {
+		res, err := MonteCarlo([]float64{-0.02}, MonteCarloOptions{Method: method, Simulations: 50, Seed: 1, Bars: 252})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Distribution{Mean: 98000, P5: 98000, P25: 98000, P50: 98000, P75: 98000, P95: 98000}
+		if res.FinalEquity != want || res.Sharpe != (Distribution{}) || res.Actual.Sharpe != 0 || res.LossProbabilityPct != 100 {
+			t.Errorf("%s: final %+v, sharpe %+v, loss %v%%; want 98000 on every path, no Sharpe and 100%%", method, res.FinalEquity, res.Sharpe, res.LossProbabilityPct)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5}
+	for pct, want := range map[float64]float64{0: 1, 10: 1.4, 25: 2, 50: 3, 95: 4.8, 100: 5} {
+		if got := percentile(sorted, pct); math.Abs(got-want) > 1e-12 {
+			t.Errorf("percentile %v = %v, want %v", pct, got, want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", got)
+	}
+}
+
+// TestTradeEquityReturns checks that trips are returned in exit order as
+// a share of the equity before their entry, leaving open ones out.
+func TestTradeEquityReturns(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, 1+d, 0, 0, 0, 0, time.UTC) }
+	points := equityPoints(1000, 1000, 950, 1000, 1150, 1150)
+	trades := []Trade{
+		{StockCode: "X", Time: day(0), Side: SideBuy, Price: 5, Shares: 100},
+		{StockCode: "Y", Time: day(1), Side: SideBuy, Price: 20, Shares: 10},
+		{StockCode: "Y", Time: day(2), Side: SideSell, Price: 15, Shares: 10},
+		{StockCode: "Z", Time: day(3), Side: SideBuy, Price: 10, Shares: 10},
+		{StockCode: "X", Time: day(4), Side: SideSell, Price: 7, Shares: 100},
+	}
+	// Y lost 50 on the 1000 held before day 1; X made 200 on the initial
+	// 1000; Z is still open.
+	want := []float64{-0.05, 0.2}
+	got := TradeEquityReturns(points, trades, 1000)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-12 {
+			t.Errorf("return %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package strategy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// DefaultObjective is the metric optimized when none is requested.
+const DefaultObjective = "sharpe"
+
+// MaxCombinations bounds the size of an optimization grid.
+const MaxCombinations = 5000
+
+// objectives score a run so that higher is always better.
+var objectives = map[string]func(returnPct float64, m models.BacktestMetrics) float64{
+	"return_pct":        func(r float64, m models.BacktestMetrics) float64 { return r },
+	"annual_return_pct": func(r float64, m models.BacktestMetrics) float64 { return m.AnnualReturnPct },
+	"sharpe":            func(r float64, m models.BacktestMetrics) float64 { return m.Sharpe },
+	"sortino":           func(r float64, m models.BacktestMetrics) float64 { return m.Sortino },
+	"calmar":            func(r float64, m models.BacktestMetrics) float64 { return m.Calmar },
+	"profit_factor":     func(r float64, m models.BacktestMetrics) float64 { return m.ProfitFactor },
+	"win_rate_pct":      func(r float64, m models.BacktestMetrics) float64 { return m.WinRatePct },
+	"expectancy_pct":    func(r float64, m models.BacktestMetrics) float64 { return m.ExpectancyPct },
+	"max_drawdown_pct":  func(r float64, m models.BacktestMetrics) float64 { return -m.MaxDrawdownPct },
+}
+
+// ObjectiveScore scores a backtest by the named objective, higher being
+// better; drawdown is negated so that shallower drawdowns rank first.
+func ObjectiveScore(objective string, returnPct float64, metrics models.BacktestMetrics) (float64, error) {
+	score, ok := objectives[objective]
+	if !ok {
+		return 0, fmt.Errorf("unknown objective %q", objective)
+	}
+	return score(returnPct, metrics), nil
+}
+
+// ParamRange lists the values one parameter takes in a grid, either
+// explicitly or as From..To inclusive in Step increments (default 1).
+type ParamRange struct {
+	Values []float64 `json:"values"`
+	From   float64   `json:"from"`
+	To     float64   `json:"to"`
+	Step   float64   `json:"step"`
+}
+
+func (r ParamRange) expand() ([]float64, error) {
+	if len(r.Values) > 0 {
+		return r.Values, nil
+	}
+	step := r.Step
+	if step <= 0 {
+		step = 1
+	}
+	if r.To < r.From {
+		return nil, fmt.Errorf("range %g..%g is empty", r.From, r.To)
+	}
+	// Count in float64: a tiny step or a huge range overflows int.
+	count := math.Floor((r.To-r.From)/step+1e-9) + 1
+	if math.IsNaN(count) || math.IsInf(count, 0) || count > MaxCombinations {
+		return nil, fmt.Errorf("range %g..%g step %g has more than %d values", r.From, r.To, step, MaxCombinations)
+	}
+	values := make([]float64, int(count))
+	for i := range values {
+		values[i] = math.Round((r.From+float64(i)*step)*1e9) / 1e9
+	}
+	return values, nil
+}
+
+// ParamGrid maps parameter names to the values searched.
+type ParamGrid map[string]ParamRange
+
+// Combinations returns the cartesian product of the grid, varying the last
+// parameter name (in sorted order) fastest.
+func (g ParamGrid) Combinations() ([]map[string]float64, error) {
+	if len(g) == 0 {
+		return nil, fmt.Errorf("parameter grid is empty")
+	}
+	names := make([]string, 0, len(g))
+	for name := range g {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	axes := make([][]float64, len(names))
+	total := 1
+	for i, name := range names {
+		values, err := g[name].expand()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		axes[i] = values
+		total *= len(values)
+		if total > MaxCombinations {
+			return nil, fmt.Errorf("parameter grid has more than %d combinations", MaxCombinations)
+		}
+	}
+
+	combos := make([]map[string]float64, 0, total)
+	index := make([]int, len(names))
+	for {
+		combo := make(map[string]float64, len(names))
+		for i, name := range names {
+			combo[name] = axes[i][index[i]]
+		}
+		combos = append(combos, combo)
+
+		i := len(index) - 1
+		for ; i >= 0; i-- {
+			index[i]++
+			if index[i] < len(axes[i]) {
+				break
+			}
+			index[i] = 0
+		}
+		if i < 0 {
+			return combos, nil
+		}
+	}
+}
+
+// ParamSet is a grid point and the crossover params it maps to.
+type ParamSet struct {
+	Values map[string]float64
+	Params MACrossoverParams
+}
+
+// ParamSets applies grid points to base params. Points the strategy rejects
+// as invalid are dropped and counted.
+func ParamSets(base MACrossoverParams, combos []map[string]float64) ([]ParamSet, int, error) {
+	sets := make([]ParamSet, 0, len(combos))
+	skipped := 0
+	for _, combo := range combos {
+		params, err := base.WithValues(combo)
+		if errors.Is(err, ErrInvalidParams) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		sets = append(sets, ParamSet{Values: combo, Params: params})
+	}
+	if len(sets) == 0 {
+		return nil, skipped, errors.New("no valid parameter combination in the grid")
+	}
+	return sets, skipped, nil
+}
+
+// OptimizationRun is the outcome of one parameter combination.
+type OptimizationRun struct {
+	Rank      int                    `json:"rank"`
+	Params    map[string]float64     `json:"params"`
+	Score     float64                `json:"score"`
+	ReturnPct float64                `json:"return_pct"`
+	Metrics   models.BacktestMetrics `json:"metrics"`
+	// Points and Trades are kept for overfitting diagnostics.
+	Points []EquityPoint `json:"-"`
+	Trades []Trade       `json:"-"`
+}
+
+// EvaluateRun computes the metrics and objective score of one backtest,
+// annualized with barsPerYear as in ComputeMetrics.
+func EvaluateRun(values map[string]float64, points []EquityPoint, trades []Trade, objective string, initial, barsPerYear float64) (OptimizationRun, error) {
+	final := initial
+	if len(points) > 0 {
+		final = points[len(points)-1].Equity
+	}
+	run := OptimizationRun{Params: values, Metrics: ComputeMetrics(points, trades, initial, barsPerYear), Points: points, Trades: trades}
+	if initial > 0 {
+		run.ReturnPct = (final - initial) / initial * 100
+	}
+	score, err := ObjectiveScore(objective, run.ReturnPct, run.Metrics)
+	if err != nil {
+		return run, err
+	}
+	run.Score = score
+	return run, nil
+}
+
+// RankRuns sorts runs best score first and numbers them from 1.
+func RankRuns(runs []OptimizationRun) {
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Score > runs[j].Score })
+	for i := range runs {
+		runs[i].Rank = i + 1
+	}
+}
+
+// Heatmap is the score surface over two grid parameters. Where the grid has
+// further parameters a cell holds the best score across them.
+type Heatmap struct {
+	X       string    `json:"x"`
+	Y       string    `json:"y"`
+	XValues []float64 `json:"x_values"`
+	YValues []float64 `json:"y_values"`
+	// Scores[i][j] belongs to YValues[i] and XValues[j]; null marks
+	// combinations that were invalid or not run.
+	Scores [][]*float64 `json:"scores"`
+}
+
+// BuildHeatmap arranges run scores on the x and y parameters.
+func BuildHeatmap(runs []OptimizationRun, x, y string) Heatmap {
+	heatmap := Heatmap{X: x, Y: y}
+	xs, ys := make(map[float64]bool), make(map[float64]bool)
+	for _, run := range runs {
+		xv, okX := run.Params[x]
+		yv, okY := run.Params[y]
+		if !okX || !okY {
+			continue
+		}
+		if !xs[xv] {
+			xs[xv] = true
+			heatmap.XValues = append(heatmap.XValues, xv)
+		}
+		if !ys[yv] {
+			ys[yv] = true
+			heatmap.YValues = append(heatmap.YValues, yv)
+		}
+	}
+	sort.Float64s(heatmap.XValues)
+	sort.Float64s(heatmap.YValues)
+
+	column := make(map[float64]int, len(heatmap.XValues))
+	for j, v := range heatmap.XValues {
+		column[v] = j
+	}
+	row := make(map[float64]int, len(heatmap.YValues))
+	for i, v := range heatmap.YValues {
+		row[v] = i
+	}
+
+	heatmap.Scores = make([][]*float64, len(heatmap.YValues))
+	for i := range heatmap.Scores {
+		heatmap.Scores[i] = make([]*float64, len(heatmap.XValues))
+	}
+	for _, run := range runs {
+		xv, okX := run.Params[x]
+		yv, okY := run.Params[y]
+		if !okX || !okY {
+			continue
+		}
+		cell := &heatmap.Scores[row[yv]][column[xv]]
+		if *cell == nil || run.Score > **cell {
+			score := run.Score
+			*cell = &score
+		}
+	}
+	return heatmap
+}
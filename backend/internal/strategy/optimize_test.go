@@ -0,0 +1,48 @@
+package strategy
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParamGridCombinations(t *testing.T) {
+	combos, err := ParamGrid{
+		"short_window": {From: 2, To: 4, Step: 1},
+		"long_window":  {Values: []float64{10, 20}},
+	}.Combinations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]float64{
+		{"long_window": 10, "short_window": 2}, {"long_window": 10, "short_window": 3}, {"long_window": 10, "short_window": 4},
+		{"long_window": 20, "short_window": 2}, {"long_window": 20, "short_window": 3}, {"long_window": 20, "short_window": 4},
+	}
+	if !reflect.DeepEqual(combos, want) {
+		t.Errorf("Combinations = %v, want %v", combos, want)
+	}
+}
+
+// TestParamGridTooLarge checks that ranges with more values than
+// MaxCombinations are rejected before any allocation, including counts
+// that overflow int.
+func TestParamGridTooLarge(t *testing.T) {
+	tests := []struct {
+		name string
+		grid ParamGrid
+	}{
+		{"tiny step", ParamGrid{"short_window": {From: 1, To: 10, Step: 1e-300}}},
+		{"huge range", ParamGrid{"short_window": {From: -math.MaxFloat64, To: math.MaxFloat64, Step: 1}}},
+		{"NaN bound", ParamGrid{"short_window": {From: 1, To: math.NaN()}}},
+		{"one over", ParamGrid{"short_window": {From: 1, To: MaxCombinations + 1}}},
+		{"product", ParamGrid{"short_window": {From: 1, To: 100}, "long_window": {From: 1, To: 100}}},
+	}
+	for _, tt := range tests {
+		if combos, err := tt.grid.Combinations(); err == nil {
+			t.Errorf("%s: got %d combinations, want an error", tt.name, len(combos))
+		}
+	}
+	if combos, err := (ParamGrid{"short_window": {From: 1, To: MaxCombinations}}).Combinations(); err != nil || len(combos) != MaxCombinations {
+		t.Errorf("MaxCombinations values: got %d, %v", len(combos), err)
+	}
+}
@@ -0,0 +1,220 @@
+package strategy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// OrderType names how an order is matched against a bar.
+type OrderType string
+
+const (
+	// OrderMarket trades at the execution mode's price: at the close of the
+	// bar it is submitted on for same_close, otherwise on the next bar.
+	OrderMarket OrderType = "market"
+	// OrderMarketOnClose trades at the close of the bar it is submitted on
+	// whatever the execution mode. Time and window-end exits use it.
+	OrderMarketOnClose OrderType = "market_on_close"
+	// OrderLimit buys at or below, or sells at or above, LimitPrice.
+	OrderLimit OrderType = "limit"
+	// OrderStop becomes a market order once the price reaches StopPrice.
+	OrderStop OrderType = "stop"
+	// OrderStopLimit becomes a limit order at LimitPrice once the price
+	// reaches StopPrice.
+	OrderStopLimit OrderType = "stop_limit"
+)
+
+// TimeInForce names how long an order keeps working.
+type TimeInForce string
+
+const (
+	// TIFGoodTillCanceled works until it is filled or canceled.
+	TIFGoodTillCanceled TimeInForce = "gtc"
+	// TIFDay is canceled at the close of the first trading day it can
+	// trade on: its first bar for daily bars, that day's last bar for
+	// intraday ones.
+	TIFDay TimeInForce = "day"
+)
+
+// OrderStatus is where an order is in its life cycle.
+type OrderStatus string
+
+const (
+	OrderWorking  OrderStatus = "working"
+	OrderFilled   OrderStatus = "filled"
+	OrderCanceled OrderStatus = "canceled"
+)
+
+// Reasons carried by cancel events.
+const (
+	CancelRequested        = "requested"
+	CancelExpired          = "expired"
+	CancelInsufficientCash = "insufficient_cash"
+	CancelNoPosition       = "no_position"
+	CancelZeroSize         = "zero_size"
+	CancelNotShortable     = "not_shortable"
+)
+
+// Order is an instruction to buy or sell one stock.
+type Order struct {
+	ID        int
+	StockCode string
+	Side      int // 1 buy, -1 sell
+	// Short marks a sell short, which sells any long position and then
+	// borrowed shares, or a buy to cover, which only buys a short position
+	// back. Without it sells only reduce a long position and buys cover
+	// any short before buying. Selling short needs a margin account.
+	Short bool
+	Type  OrderType
+	TIF   TimeInForce
+	// Shares is the quantity to trade. Zero closes the whole position the
+	// order trades against and, for buys and short sales, adds the shares
+	// the sizing policy asks for on its first fill.
+	Shares     float64
+	LimitPrice float64
+	StopPrice  float64
+	// Reason is recorded on the trades the order produces.
+	Reason string
+	Status OrderStatus
+	Filled float64
+	// Triggered reports whether a stop or stop-limit order has activated.
+	Triggered bool
+
+	placed    int     // bar index of the stock when the order was submitted
+	expires   int     // bar index at whose close a day order is canceled
+	remaining float64 // unfilled shares once sized
+	sized     bool
+}
+
+func (o Order) validate() error {
+	if o.Side != 1 && o.Side != -1 {
+		return errors.New("order side must be 1 (buy) or -1 (sell)")
+	}
+	if o.Shares < 0 {
+		return errors.New("order shares must not be negative")
+	}
+	switch o.Type {
+	case OrderMarket, OrderMarketOnClose:
+	case OrderLimit:
+		if o.LimitPrice <= 0 {
+			return errors.New("limit orders need a positive limit price")
+		}
+	case OrderStop:
+		if o.StopPrice <= 0 {
+			return errors.New("stop orders need a positive stop price")
+		}
+	case OrderStopLimit:
+		if o.StopPrice <= 0 || o.LimitPrice <= 0 {
+			return errors.New("stop-limit orders need positive stop and limit prices")
+		}
+	default:
+		return fmt.Errorf("unknown order type %q", o.Type)
+	}
+	switch o.TIF {
+	case TIFGoodTillCanceled, TIFDay:
+	default:
+		return fmt.Errorf("unknown time in force %q", o.TIF)
+	}
+	return nil
+}
+
+// priority orders a stock's working orders on a bar: stops first, so a
+// protective stop is assumed to trade before a target or a carried market
+// order touched on the same bar.
+func (o *Order) priority() int {
+	switch o.Type {
+	case OrderStop, OrderStopLimit:
+		return 0
+	case OrderLimit:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// opens reports whether the order may open or add to a position: plain
+// buys and short sales.
+func (o *Order) opens() bool {
+	return (o.Side > 0) != o.Short
+}
+
+// limited reports whether fills must not be worse than LimitPrice.
+func (o *Order) limited() bool {
+	return o.Type == OrderLimit || o.Type == OrderStopLimit
+}
+
+// quote returns the price the order trades at on bar, if it trades. A gap
+// through a stop or limit fills at the open; a level reached within the
+// range fills at the level. Triggered stops trade like market orders.
+func (o *Order) quote(bar models.KLine, mode ExecutionMode) (float64, bool) {
+	switch o.Type {
+	case OrderLimit:
+		return limitQuote(o.Side, o.LimitPrice, bar)
+	case OrderStop, OrderStopLimit:
+		if o.Triggered {
+			if o.Type == OrderStop {
+				return mode.fillPrice(bar), true
+			}
+			return limitQuote(o.Side, o.LimitPrice, bar)
+		}
+		trigger, ok := stopQuote(o.Side, o.StopPrice, bar)
+		if !ok {
+			return 0, false
+		}
+		o.Triggered = true
+		if o.Type == OrderStop {
+			return trigger, true
+		}
+		// The limit applies from the trigger on: it fills there if the
+		// limit allows, or at the limit if the rest of the range reaches it.
+		switch {
+		case o.Side > 0 && trigger <= o.LimitPrice, o.Side < 0 && trigger >= o.LimitPrice:
+			return trigger, true
+		case o.Side > 0 && bar.Low <= o.LimitPrice, o.Side < 0 && bar.High >= o.LimitPrice:
+			return o.LimitPrice, true
+		}
+		return 0, false
+	default:
+		return mode.fillPrice(bar), true
+	}
+}
+
+func limitQuote(side int, limit float64, bar models.KLine) (float64, bool) {
+	if side > 0 {
+		switch {
+		case bar.Open <= limit:
+			return bar.Open, true
+		case bar.Low <= limit:
+			return limit, true
+		}
+		return 0, false
+	}
+	switch {
+	case bar.Open >= limit:
+		return bar.Open, true
+	case bar.High >= limit:
+		return limit, true
+	}
+	return 0, false
+}
+
+func stopQuote(side int, stop float64, bar models.KLine) (float64, bool) {
+	if side > 0 {
+		switch {
+		case bar.Open >= stop:
+			return bar.Open, true
+		case bar.High >= stop:
+			return stop, true
+		}
+		return 0, false
+	}
+	switch {
+	case bar.Open <= stop:
+		return bar.Open, true
+	case bar.Low <= stop:
+		return stop, true
+	}
+	return 0, false
+}
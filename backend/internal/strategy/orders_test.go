@@ -0,0 +1,141 @@
+package strategy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// scriptStrategy submits fixed orders on given timestamps, counted from
+// the first, and records the broker's events and submission errors.
+// inspect, when set, sees the broker on every timestamp before the orders
+// are submitted.
+type scriptStrategy struct {
+	orders  map[int][]Order
+	inspect func(b *Broker, stamp int)
+	stamp   int
+	events  []Event
+	errs    []error
+}
+
+func (s *scriptStrategy) Start(map[string][]models.KLine) {}
+
+func (s *scriptStrategy) OnBar(b *Broker, codes []string) {
+	if s.inspect != nil {
+		s.inspect(b, s.stamp)
+	}
+	for _, o := range s.orders[s.stamp] {
+		if _, err := b.Submit(o); err != nil {
+			s.errs = append(s.errs, err)
+		}
+	}
+	s.stamp++
+}
+
+func (s *scriptStrategy) OnEvent(b *Broker, e Event) {
+	s.events = append(s.events, e)
+}
+
+// ohlcBars builds daily bars of one stock from open, high, low, close rows.
+func ohlcBars(code string, rows [][4]float64) []models.KLine {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bars := make([]models.KLine, len(rows))
+	for i, p := range rows {
+		bars[i] = models.KLine{StockCode: code, Time: start.AddDate(0, 0, i), Open: p[0], High: p[1], Low: p[2], Close: p[3], Volume: 1e6}
+	}
+	return bars
+}
+
+type wantTrade struct {
+	code   string
+	day    int
+	side   string
+	price  float64
+	shares float64
+}
+
+func checkTrades(t *testing.T, got []Trade, want []wantTrade) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d trades, want %d: %+v", len(got), len(want), got)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, w := range want {
+		g := got[i]
+		if g.StockCode != w.code || !g.Time.Equal(start.AddDate(0, 0, w.day)) || g.Side != w.side || g.Price != w.price || g.Shares != w.shares {
+			t.Errorf("trade %d = %s %s %s %v x %v, want %s day %d %s %v x %v",
+				i, g.StockCode, g.Time.Format("2006-01-02"), g.Side, g.Price, g.Shares, w.code, w.day, w.side, w.price, w.shares)
+		}
+	}
+}
+
+func TestOrderTypes(t *testing.T) {
+	bars := ohlcBars("X", [][4]float64{
+		{10, 10.5, 9.5, 10},
+		{10, 10.2, 9.6, 9.8},
+		{9.7, 11, 9.7, 10.8},
+		{11.2, 11.5, 10.9, 11},
+		{10.5, 10.6, 9, 9.2},
+		{9, 9.5, 8.8, 9.3},
+	})
+	s := &scriptStrategy{orders: map[int][]Order{
+		0: {
+			// Never reaches 9.5 on its one trading day.
+			{StockCode: "X", Side: 1, Type: OrderLimit, LimitPrice: 9.5, TIF: TIFDay, Shares: 100},
+			{StockCode: "X", Side: 1, Type: OrderStop, StopPrice: 10.5, Shares: 100},
+		},
+		2: {
+			{StockCode: "X", Side: -1, Type: OrderLimit, LimitPrice: 11.3, Shares: 50},
+			{StockCode: "X", Side: -1, Type: OrderStopLimit, StopPrice: 9.5, LimitPrice: 9.4},
+		},
+		4: {{StockCode: "X", Side: 1, Type: "iceberg"}},
+	}}
+	res, err := Run(context.Background(), map[string][]models.KLine{"X": bars}, s, BacktestOptions{Execution: ExecNextOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkTrades(t, res.Trades, []wantTrade{
+		{"X", 2, SideBuy, 10.5, 100}, // stop triggered within the range
+		{"X", 3, SideSell, 11.3, 50}, // limit reached within the range
+		{"X", 4, SideSell, 9.5, 50},  // stop-limit sells the rest at the stop
+	})
+
+	var expired int
+	for _, e := range s.events {
+		if e.Type == EventCancel {
+			if e.Order.ID != 1 || e.Reason != CancelExpired || e.Bar != 1 {
+				t.Errorf("unexpected cancel %+v", e)
+			}
+			expired++
+		}
+	}
+	if expired != 1 {
+		t.Errorf("got %d cancels, want the day order's expiry", expired)
+	}
+	if len(s.errs) != 1 {
+		t.Errorf("got errors %v, want one for the unknown order type", s.errs)
+	}
+}
+
+func TestOrderGapFillsAtOpen(t *testing.T) {
+	bars := ohlcBars("X", [][4]float64{
+		{10, 10, 10, 10},
+		{12, 12.5, 11.8, 12}, // gaps through the buy stop
+		{8, 8.2, 7.9, 8},     // opens below the buy limit
+	})
+	s := &scriptStrategy{orders: map[int][]Order{
+		0: {{StockCode: "X", Side: 1, Type: OrderStop, StopPrice: 11, Shares: 100}},
+		1: {{StockCode: "X", Side: 1, Type: OrderLimit, LimitPrice: 9, Shares: 100}},
+	}}
+	res, err := Run(context.Background(), map[string][]models.KLine{"X": bars}, s, BacktestOptions{Execution: ExecNextOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTrades(t, res.Trades, []wantTrade{
+		{"X", 1, SideBuy, 12, 100},
+		{"X", 2, SideBuy, 8, 100},
+	})
+}
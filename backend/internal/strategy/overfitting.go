@@ -0,0 +1,327 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// eulerGamma is the Euler-Mascheroni constant used by the expected maximum
+// Sharpe ratio of independent trials.
+const eulerGamma = 0.5772156649015329
+
+// maxPartitions bounds CSCV to C(16, 8) = 12870 train/test splits.
+const maxPartitions = 16
+
+// DeflatedSharpe corrects the best Sharpe ratio of many trials for selection
+// bias, skewness and fat tails (Bailey and López de Prado, 2014). Sharpe
+// ratios are per bar unless annualized.
+type DeflatedSharpe struct {
+	Trials       int     `json:"trials"`
+	Observations int     `json:"observations"`
+	Sharpe       float64 `json:"sharpe"`
+	AnnualSharpe float64 `json:"annual_sharpe"`
+	// ExpectedMaxSharpe is the best Sharpe expected from Trials unskilled
+	// configurations with the observed spread of Sharpe ratios.
+	ExpectedMaxSharpe float64 `json:"expected_max_sharpe"`
+	SharpeVariance    float64 `json:"sharpe_variance"`
+	Skewness          float64 `json:"skewness"`
+	Kurtosis          float64 `json:"kurtosis"`
+	// Probability is the deflated Sharpe ratio: the confidence that the best
+	// configuration's true Sharpe exceeds ExpectedMaxSharpe.
+	Probability float64 `json:"probability"`
+}
+
+// PBOResult is the probability of backtest overfitting estimated by
+// combinatorially symmetric cross-validation.
+type PBOResult struct {
+	Partitions   int `json:"partitions"`
+	Combinations int `json:"combinations"`
+	// PBO is the share of splits where the in-sample best configuration
+	// ranks at or below the out-of-sample median.
+	PBO float64 `json:"pbo"`
+	// MeanLogit averages the logit of the out-of-sample relative rank;
+	// negative values indicate overfitting.
+	MeanLogit float64 `json:"mean_logit"`
+}
+
+// RandomBaseline compares a configuration with random entries that take the
+// same number of trades, each held for its average holding period.
+type RandomBaseline struct {
+	Trials          int          `json:"trials"`
+	Seed            int64        `json:"seed"`
+	Trades          int          `json:"trades"`
+	HoldingBars     int          `json:"holding_bars"`
+	ReturnPct       Distribution `json:"return_pct"`
+	Sharpe          Distribution `json:"sharpe"`
+	ActualReturnPct float64      `json:"actual_return_pct"`
+	ActualSharpe    float64      `json:"actual_sharpe"`
+	// ReturnPercentile and SharpePercentile are the shares of random runs
+	// the configuration beats.
+	ReturnPercentile float64 `json:"return_percentile"`
+	SharpePercentile float64 `json:"sharpe_percentile"`
+}
+
+// barReturns returns the per-bar returns of each run's equity curve,
+// truncated to the shortest curve so rows line up across runs.
+func barReturns(runs []OptimizationRun, initial float64) [][]float64 {
+	series := make([][]float64, len(runs))
+	length := -1
+	for i, run := range runs {
+		series[i] = equityReturns(run.Points, initial)
+		if length < 0 || len(series[i]) < length {
+			length = len(series[i])
+		}
+	}
+	for i := range series {
+		series[i] = series[i][:length]
+	}
+	return series
+}
+
+func sharpeOf(returns []float64) float64 {
+	if sd := stdDev(returns); sd > 0 {
+		return mean(returns) / sd
+	}
+	return 0
+}
+
+// ComputeDeflatedSharpe deflates the best per-bar Sharpe ratio among runs
+// by the number of runs tried. barsPerYear annualizes as in ComputeMetrics.
+func ComputeDeflatedSharpe(runs []OptimizationRun, initial, barsPerYear float64) (DeflatedSharpe, error) {
+	var result DeflatedSharpe
+	if len(runs) == 0 {
+		return result, errors.New("no runs to diagnose")
+	}
+	series := barReturns(runs, initial)
+	sharpes := make([]float64, len(series))
+	best := 0
+	for i, returns := range series {
+		sharpes[i] = sharpeOf(returns)
+		if sharpes[i] > sharpes[best] {
+			best = i
+		}
+	}
+
+	returns := series[best]
+	result.Trials = len(runs)
+	result.Observations = len(returns)
+	result.Sharpe = sharpes[best]
+	result.AnnualSharpe = result.Sharpe * math.Sqrt(annualBars(barsPerYear))
+	if result.Observations < 3 {
+		return result, errors.New("not enough bars to diagnose")
+	}
+
+	if sd := stdDev(sharpes); sd > 0 && len(runs) > 1 {
+		result.SharpeVariance = sd * sd
+		n := float64(len(runs))
+		result.ExpectedMaxSharpe = sd * ((1-eulerGamma)*normalQuantile(1-1/n) + eulerGamma*normalQuantile(1-1/(n*math.E)))
+	}
+
+	avg, sd := mean(returns), stdDev(returns)
+	if sd > 0 {
+		var m3, m4 float64
+		for _, r := range returns {
+			z := (r - avg) / sd
+			m3 += z * z * z
+			m4 += z * z * z * z
+		}
+		result.Skewness = m3 / float64(len(returns))
+		result.Kurtosis = m4 / float64(len(returns))
+	} else {
+		result.Kurtosis = 3
+	}
+
+	sr := result.Sharpe
+	variance := 1 - result.Skewness*sr + (result.Kurtosis-1)/4*sr*sr
+	if variance <= 0 {
+		variance = 1e-12
+	}
+	z := (sr - result.ExpectedMaxSharpe) * math.Sqrt(float64(result.Observations-1)) / math.Sqrt(variance)
+	result.Probability = normalCDF(z)
+	return result, nil
+}
+
+// ComputePBO splits the bars into partitions contiguous blocks and, for
+// every choice of half the blocks as in-sample, checks how the in-sample
+// best run ranks on the remaining blocks. Runs are compared by Sharpe.
+func ComputePBO(runs []OptimizationRun, initial float64, partitions int) (PBOResult, error) {
+	result := PBOResult{Partitions: partitions}
+	if len(runs) < 2 {
+		return result, errors.New("PBO needs at least two configurations")
+	}
+	if partitions < 2 || partitions%2 != 0 || partitions > maxPartitions {
+		return result, fmt.Errorf("partitions must be an even number between 2 and %d", maxPartitions)
+	}
+	series := barReturns(runs, initial)
+	bars := len(series[0])
+	if bars < partitions*2 {
+		return result, errors.New("not enough bars for the requested partitions")
+	}
+
+	// Per block sums let any union of blocks be scored without rescanning.
+	type moments struct{ n, sum, sumSq float64 }
+	blocks := make([][]moments, len(series))
+	for i, returns := range series {
+		blocks[i] = make([]moments, partitions)
+		for t, r := range returns {
+			b := &blocks[i][t*partitions/bars]
+			b.n++
+			b.sum += r
+			b.sumSq += r * r
+		}
+	}
+	sharpe := func(run int, mask uint) float64 {
+		var m moments
+		for b := 0; b < partitions; b++ {
+			if mask&(1<<b) != 0 {
+				m.n += blocks[run][b].n
+				m.sum += blocks[run][b].sum
+				m.sumSq += blocks[run][b].sumSq
+			}
+		}
+		if m.n < 2 {
+			return 0
+		}
+		avg := m.sum / m.n
+		variance := (m.sumSq - m.n*avg*avg) / (m.n - 1)
+		if variance <= 0 {
+			return 0
+		}
+		return avg / math.Sqrt(variance)
+	}
+
+	full := uint(1)<<partitions - 1
+	var overfit int
+	var logits float64
+	for mask := uint(0); mask <= full; mask++ {
+		if bits.OnesCount(mask) != partitions/2 {
+			continue
+		}
+		best, bestSharpe := 0, math.Inf(-1)
+		for i := range series {
+			if s := sharpe(i, mask); s > bestSharpe {
+				best, bestSharpe = i, s
+			}
+		}
+
+		test := full &^ mask
+		target := sharpe(best, test)
+		rank := 1.0
+		for i := range series {
+			if i == best {
+				continue
+			}
+			switch s := sharpe(i, test); {
+			case s < target:
+				rank++
+			case s == target:
+				rank += 0.5
+			}
+		}
+		omega := rank / float64(len(series)+1)
+		logit := math.Log(omega / (1 - omega))
+		logits += logit
+		if logit <= 0 {
+			overfit++
+		}
+		result.Combinations++
+	}
+
+	result.PBO = float64(overfit) / float64(result.Combinations)
+	result.MeanLogit = logits / float64(result.Combinations)
+	return result, nil
+}
+
+// CompareRandomEntries runs trials backtests that enter at random reported
+// bars, taking as many non-overlapping trades as run did and exiting after
+// its average holding period, through the same engine options.
+func CompareRandomEntries(ctx context.Context, klines []models.KLine, run OptimizationRun, opts BacktestOptions, trials int, seed int64) (RandomBaseline, error) {
+	if trials <= 0 {
+		trials = 1000
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	baseline := RandomBaseline{
+		Trials:          trials,
+		Seed:            seed,
+		Trades:          run.Metrics.Trades,
+		HoldingBars:     max(1, int(math.Round(run.Metrics.AvgHoldingBars))),
+		ActualReturnPct: run.ReturnPct,
+		ActualSharpe:    run.Metrics.Sharpe,
+	}
+	if baseline.Trades == 0 {
+		return baseline, errors.New("the configuration took no trades")
+	}
+
+	sorted := make([]models.KLine, len(klines))
+	copy(sorted, klines)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	sorted = tradingBars(sorted, opts.Interval)
+	first := sort.Search(len(sorted), func(i int) bool { return !sorted[i].Time.Before(opts.ReportFrom) })
+
+	// Each trade occupies its entry bar, the holding period and one bar of
+	// slack for next-bar execution; the remaining bars are spread randomly.
+	span := baseline.HoldingBars + 2
+	free := len(sorted) - first - baseline.Trades*span
+	if free < 0 {
+		return baseline, errors.New("not enough bars for random entries with the same trade count")
+	}
+
+	initial := opts.InitialCapital
+	if initial <= 0 {
+		initial = 100000
+	}
+	opts.Progress = nil
+	rng := rand.New(rand.NewSource(seed))
+	returns := make([]float64, trials)
+	sharpes := make([]float64, trials)
+	offsets := make([]int, baseline.Trades)
+	for n := 0; n < trials; n++ {
+		for k := range offsets {
+			offsets[k] = rng.Intn(free + 1)
+		}
+		sort.Ints(offsets)
+		signals := make([]int, len(sorted))
+		for k, offset := range offsets {
+			entry := first + offset + k*span
+			signals[entry] = 1
+			signals[entry+baseline.HoldingBars] = -1
+		}
+
+		_, points, trades, err := runSignals(ctx, sorted, signals, opts)
+		if err != nil {
+			return baseline, err
+		}
+		random, _ := EvaluateRun(nil, points, trades, DefaultObjective, initial, opts.BarsPerYear())
+		returns[n], sharpes[n] = random.ReturnPct, random.Metrics.Sharpe
+		if random.ReturnPct < run.ReturnPct {
+			baseline.ReturnPercentile++
+		}
+		if random.Metrics.Sharpe < run.Metrics.Sharpe {
+			baseline.SharpePercentile++
+		}
+	}
+
+	baseline.ReturnPct = distribution(returns)
+	baseline.Sharpe = distribution(sharpes)
+	baseline.ReturnPercentile = baseline.ReturnPercentile / float64(trials) * 100
+	baseline.SharpePercentile = baseline.SharpePercentile / float64(trials) * 100
+	return baseline, nil
+}
+
+func normalCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+func normalQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
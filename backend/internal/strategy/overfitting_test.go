@@ -0,0 +1,182 @@
+package strategy
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// returnsRun builds a run whose equity curve, starting from 100, has the
+// given per-bar returns.
+func returnsRun(returns ...float64) OptimizationRun {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	equity := 100.0
+	var run OptimizationRun
+	for i, r := range returns {
+		equity *= 1 + r
+		run.Points = append(run.Points, EquityPoint{Time: start.AddDate(0, 0, i), Equity: equity})
+	}
+	return run
+}
+
+func TestComputeDeflatedSharpe(t *testing.T) {
+	// The first run has mean 0.01 and sample deviation 0.01/sqrt(0.75), a
+	// Sharpe of sqrt(3)/2, no skew and kurtosis 0.5625. The second has a
+	// Sharpe of 0. The skewed run has mean 0.0075, deviation 0.015, Sharpe
+	// 0.5, skewness 0.75 and kurtosis 1.3125.
+	even := returnsRun(0.02, 0, 0.02, 0)
+	flat := returnsRun(-0.01, 0.01, -0.01, 0.01)
+	skewed := returnsRun(0.03, 0, 0, 0)
+	sr := math.Sqrt(3) / 2
+
+	tests := []struct {
+		name string
+		runs []OptimizationRun
+		want DeflatedSharpe
+	}{
+		{
+			// One trial is not deflated: z = SR*sqrt(3)/sqrt(1-0.4375/4*0.75).
+			name: "single",
+			runs: []OptimizationRun{even},
+			want: DeflatedSharpe{Trials: 1, Observations: 4, Sharpe: sr, AnnualSharpe: sr * math.Sqrt(252), Kurtosis: 0.5625, Probability: 0.9412773762839846},
+		},
+		{
+			// Sharpe ratios {SR, 0} have variance SR^2/2 = 0.375, and with two
+			// trials E[max] = sqrt(0.375)*gamma*Phi^-1(1-1/(2e)).
+			name: "two trials",
+			runs: []OptimizationRun{flat, even},
+			want: DeflatedSharpe{Trials: 2, Observations: 4, Sharpe: sr, AnnualSharpe: sr * math.Sqrt(252), ExpectedMaxSharpe: 0.31828384614301347,
+				SharpeVariance: 0.375, Kurtosis: 0.5625, Probability: 0.8389615069640133},
+		},
+		{
+			// The variance term is 1 - 0.75*0.5 + 0.3125/4*0.25 = 0.64453125.
+			name: "skewed",
+			runs: []OptimizationRun{skewed},
+			want: DeflatedSharpe{Trials: 1, Observations: 4, Sharpe: 0.5, AnnualSharpe: 0.5 * math.Sqrt(252), Skewness: 0.75, Kurtosis: 1.3125, Probability: 0.859643667365752},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ComputeDeflatedSharpe(tt.runs, 100, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got.Trials != tt.want.Trials || got.Observations != tt.want.Observations {
+			t.Errorf("%s: %d trials of %d bars, want %d of %d", tt.name, got.Trials, got.Observations, tt.want.Trials, tt.want.Observations)
+		}
+		for _, field := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"Sharpe", got.Sharpe, tt.want.Sharpe},
+			{"AnnualSharpe", got.AnnualSharpe, tt.want.AnnualSharpe},
+			{"ExpectedMaxSharpe", got.ExpectedMaxSharpe, tt.want.ExpectedMaxSharpe},
+			{"SharpeVariance", got.SharpeVariance, tt.want.SharpeVariance},
+			{"Skewness", got.Skewness, tt.want.Skewness},
+			{"Kurtosis", got.Kurtosis, tt.want.Kurtosis},
+			{"Probability", got.Probability, tt.want.Probability},
+		} {
+			if math.Abs(field.got-field.want) > 1e-9 {
+				t.Errorf("%s: %s = %v, want %v", tt.name, field.name, field.got, field.want)
+			}
+		}
+	}
+
+	if _, err := ComputeDeflatedSharpe(nil, 100, 0); err == nil {
+		t.Error("no runs: want an error")
+	}
+	if _, err := ComputeDeflatedSharpe([]OptimizationRun{returnsRun(0.01, 0.02)}, 100, 0); err == nil {
+		t.Error("two bars: want an error")
+	}
+}
+
+func TestComputePBO(t *testing.T) {
+	up := []float64{0.02, 0, 0.02, 0}
+	down := []float64{-0.02, 0, -0.02, 0}
+	concat := func(blocks ...[]float64) OptimizationRun {
+		var returns []float64
+		for _, block := range blocks {
+			returns = append(returns, block...)
+		}
+		return returnsRun(returns...)
+	}
+
+	tests := []struct {
+		name       string
+		runs       []OptimizationRun
+		partitions int
+		want       PBOResult
+	}{
+		{
+			// Each run wins the half it is trained on and loses the other, so
+			// the in-sample winner ranks last out of sample: omega = 1/3.
+			name:       "overfit",
+			runs:       []OptimizationRun{concat(up, down), concat(down, up)},
+			partitions: 2,
+			want:       PBOResult{Partitions: 2, Combinations: 2, PBO: 1, MeanLogit: -math.Ln2},
+		},
+		{
+			// The first run wins every block: omega = 2/3 in every split.
+			name:       "consistent",
+			runs:       []OptimizationRun{concat(up, up, up, up), concat(down, down, down, down)},
+			partitions: 4,
+			want:       PBOResult{Partitions: 4, Combinations: 6, PBO: 0, MeanLogit: math.Ln2},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ComputePBO(tt.runs, 100, tt.partitions)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got.Partitions != tt.want.Partitions || got.Combinations != tt.want.Combinations || got.PBO != tt.want.PBO || math.Abs(got.MeanLogit-tt.want.MeanLogit) > 1e-9 {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	run := concat(up, down)
+	for _, partitions := range []int{0, 3, maxPartitions + 2} {
+		if _, err := ComputePBO([]OptimizationRun{run, run}, 100, partitions); err == nil {
+			t.Errorf("%d partitions: want an error", partitions)
+		}
+	}
+	if _, err := ComputePBO([]OptimizationRun{run}, 100, 2); err == nil {
+		t.Error("one configuration: want an error")
+	}
+	if _, err := ComputePBO([]OptimizationRun{run, run}, 100, 6); err == nil {
+		t.Error("8 bars in 6 partitions: want an error")
+	}
+}
+
+// TestCompareRandomEntries leaves no free bars after the report start:
+// three trades held two bars fill 3*(2+2) bars exactly, so every trial
+// enters on bars 2, 6 and 10 and exits on bars 4, 8 and 12, filling at the
+// next opens for +10% each.
+func TestCompareRandomEntries(t *testing.T) {
+	bars := closeBars([]float64{10, 10, 10, 10, 10, 11, 11, 11, 11, 12.1, 12.1, 12.1, 12.1, 13.31})
+	run := OptimizationRun{ReturnPct: 40}
+	run.Metrics.Trades = 3
+	run.Metrics.AvgHoldingBars = 2
+	opts := BacktestOptions{InitialCapital: 100000, Execution: ExecNextOpen, ReportFrom: bars[2].Time}
+
+	got, err := CompareRandomEntries(context.Background(), bars, run, opts, 5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Trials != 5 || got.Trades != 3 || got.HoldingBars != 2 {
+		t.Errorf("%d trials of %d trades held %d bars, want 5 of 3 held 2", got.Trials, got.Trades, got.HoldingBars)
+	}
+	if math.Abs(got.ReturnPct.P5-33.1) > 1e-9 || math.Abs(got.ReturnPct.P95-33.1) > 1e-9 {
+		t.Errorf("random returns %+v, want 33.1 in every trial", got.ReturnPct)
+	}
+	if got.ReturnPercentile != 100 {
+		t.Errorf("return percentile = %v, want 100", got.ReturnPercentile)
+	}
+
+	if _, err := CompareRandomEntries(context.Background(), bars[:len(bars)-1], run, opts, 5, 1); err == nil {
+		t.Error("one bar short: want an error")
+	}
+	run.Metrics.Trades = 0
+	if _, err := CompareRandomEntries(context.Background(), bars, run, opts, 5, 1); err == nil {
+		t.Error("no trades: want an error")
+	}
+}
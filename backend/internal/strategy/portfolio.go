@@ -0,0 +1,170 @@
+package strategy
+
+import (
+	"context"
+	"sort"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// Ranking rules used when more buy signals fire than there are free slots.
+const (
+	// RankMomentum prefers the strongest return over the lookback.
+	RankMomentum = "momentum"
+	// RankTurnover prefers the highest close*volume on the signal bar.
+	RankTurnover = "turnover"
+	// RankLowVolatility prefers the calmest return series over the lookback.
+	RankLowVolatility = "low_volatility"
+	// RankCode prefers the lowest stock code, for reproducible tie-breaks.
+	RankCode = "code"
+)
+
+// PortfolioOptions configures a backtest over many stocks with shared cash.
+type PortfolioOptions struct {
+	BacktestOptions
+	// MaxPositions limits concurrently held stocks (default 10).
+	MaxPositions int
+	// Ranking orders competing buy signals (default momentum).
+	Ranking string
+	// RankLookback is the window in bars for momentum and volatility ranking (default 20).
+	RankLookback int
+}
+
+// StockContribution summarizes one stock's share of a portfolio result.
+type StockContribution struct {
+	StockCode string  `json:"stock_code"`
+	PnL       float64 `json:"pnl"`
+	// ContributionPct is PnL as a percentage of initial capital.
+	ContributionPct float64 `json:"contribution_pct"`
+	Trades          int     `json:"trades"`
+}
+
+// PortfolioResult is the combined outcome of a portfolio backtest.
+type PortfolioResult struct {
+	Final         float64             `json:"final"`
+	Points        []EquityPoint       `json:"points"`
+	Trades        []Trade             `json:"trades"`
+	Contributions []StockContribution `json:"contributions"`
+}
+
+// PortfolioBacktest runs the crossover strategy across a universe of stocks
+// sharing one cash balance. Bars are processed in time order; on each
+// timestamp sells and exits settle before new entries, and buy signals
+// beyond the free slots are dropped in ranking order.
+func PortfolioBacktest(series map[string][]models.KLine, params MACrossoverParams, opts PortfolioOptions) PortfolioResult {
+	result, _ := PortfolioBacktestContext(context.Background(), series, params, opts)
+	return result
+}
+
+// PortfolioBacktestContext is PortfolioBacktest that stops with the
+// context's error once ctx is done.
+func PortfolioBacktestContext(ctx context.Context, series map[string][]models.KLine, params MACrossoverParams, opts PortfolioOptions) (PortfolioResult, error) {
+	if opts.InitialCapital <= 0 {
+		opts.InitialCapital = 100000
+	}
+	if opts.MaxPositions <= 0 {
+		opts.MaxPositions = 10
+	}
+	if opts.RankLookback <= 0 {
+		opts.RankLookback = 20
+	}
+	// All-in entries without a position cap would let the first signal take
+	// the cash of every slot, so each stock defaults to an equal share.
+	if (opts.Sizing.Policy == "" || opts.Sizing.Policy == SizingAllIn) && opts.Sizing.MaxPositionPct <= 0 {
+		opts.Sizing.MaxPositionPct = 100 / float64(opts.MaxPositions)
+	}
+
+	strategy := newSignalStrategy(opts, func(bars []models.KLine) []int { return crossoverSignals(bars, params) })
+	b, points, err := run(ctx, series, strategy, opts.BacktestOptions)
+	if err != nil {
+		return PortfolioResult{}, err
+	}
+
+	final := opts.InitialCapital
+	if len(points) > 0 {
+		final = points[len(points)-1].Equity
+	}
+
+	trades := b.trades
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].Time.Before(trades[j].Time) })
+
+	counts := make(map[string]int)
+	for _, trade := range trades {
+		switch trade.Side {
+		case SideBuy, SideSell, SideShort, SideCover:
+			counts[trade.StockCode]++
+		}
+	}
+	contributions := make([]StockContribution, 0, len(counts))
+	for _, code := range b.codes {
+		count := counts[code]
+		if count == 0 {
+			continue
+		}
+		h := b.holdings[code]
+		pnl := h.cashFlow + h.position*h.lastClose
+		contributions = append(contributions, StockContribution{
+			StockCode:       code,
+			PnL:             pnl,
+			ContributionPct: pnl / opts.InitialCapital * 100,
+			Trades:          count,
+		})
+	}
+	sort.Slice(contributions, func(i, j int) bool { return contributions[i].PnL > contributions[j].PnL })
+
+	return PortfolioResult{Final: final, Points: points, Trades: trades, Contributions: contributions}, nil
+}
+
+// rankCandidates orders buy candidates best first using data up to and
+// including the signal bar.
+func rankCandidates(b *Broker, candidates []string, opts PortfolioOptions) {
+	score := func(code string) float64 {
+		h := b.holdings[code]
+		bars := h.view()
+		i := h.bar
+		bar := bars[i]
+		switch opts.Ranking {
+		case RankTurnover:
+			return h.bars[i].Close * h.bars[i].Volume
+		case RankLowVolatility:
+			from := i - opts.RankLookback
+			if from < 0 {
+				from = 0
+			}
+			return -stdDev(closeReturns(bars[from : i+1]))
+		case RankCode:
+			return 0
+		default:
+			from := i - opts.RankLookback
+			if from < 0 {
+				from = 0
+			}
+			if base := bars[from].Close; base > 0 {
+				return bar.Close/base - 1
+			}
+			return 0
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		si, sj := score(candidates[i]), score(candidates[j])
+		if si != sj {
+			return si > sj
+		}
+		return candidates[i] < candidates[j]
+	})
+}
+
+// closeReturns returns close-to-close returns of consecutive bars.
+func closeReturns(klines []models.KLine) []float64 {
+	if len(klines) < 2 {
+		return nil
+	}
+	returns := make([]float64, 0, len(klines)-1)
+	for i := 1; i < len(klines); i++ {
+		if klines[i-1].Close > 0 {
+			returns = append(returns, klines[i].Close/klines[i-1].Close-1)
+		}
+	}
+	return returns
+}
@@ -0,0 +1,237 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// portfolioSeries returns four random walks of 300 daily bars; B and D
+// start a day later than A and C.
+func portfolioSeries() map[string][]models.KLine {
+	series := make(map[string][]models.KLine)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for k, code := range []string{"A", "B", "C", "D"} {
+		r := rand.New(rand.NewSource(int64(k + 5)))
+		price := 50.0
+		for i := 0; i < 300; i++ {
+			price = math.Max(5, price*(1+(r.Float64()*2-1)*0.03))
+			open := price * (1 + (r.Float64()-0.5)*0.02)
+			series[code] = append(series[code], models.KLine{
+				StockCode: code,
+				Time:      start.AddDate(0, 0, i+k%2),
+				Open:      open,
+				High:      math.Max(open, price) * (1 + r.Float64()*0.02),
+				Low:       math.Min(open, price) * (1 - r.Float64()*0.02),
+				Close:     price,
+				Volume:    200 + r.Float64()*3000,
+			})
+		}
+	}
+	return series
+}
+
+// TestPortfolioBacktestResults pins portfolio results to those of the
+// per-stock loop the event-driven engine replaced, with every mode trading
+// on the same crossover signals.
+func TestPortfolioBacktestResults(t *testing.T) {
+	configs := map[string]BacktestOptions{
+		"fixed_fraction":    {Sizing: SizingConfig{Policy: SizingFixedFraction, EquityPct: 40}},
+		"volatility_target": {Sizing: SizingConfig{Policy: SizingVolatilityTarget, TargetVolatilityPct: 15}},
+		"kelly":             {Sizing: SizingConfig{Policy: SizingKelly, KellyMinTrades: 2}},
+		"volume_slippage":   {Slippage: SlippageConfig{Model: SlippageVolume, Impact: 0.1, MaxParticipation: 0.2}, Sizing: SizingConfig{MaxPositionPct: 100}},
+		"atr_pyramid":       {Sizing: SizingConfig{Policy: SizingATRRisk, RiskPct: 1, MaxPyramids: 2, PyramidStepPct: 2, LotSize: 100}},
+	}
+	tests := []struct {
+		config string
+		mode   ExecutionMode
+		final  float64
+		trades int
+		shares float64
+	}{
+		{"fixed_fraction", ExecSameClose, 83230.642120, 50, 37722},
+		{"fixed_fraction", ExecNextOpen, 87786.077631, 48, 36844},
+		{"fixed_fraction", ExecNextClose, 88275.580647, 48, 37033},
+		{"fixed_fraction", ExecNextVWAP, 88705.114905, 48, 37091},
+		{"volatility_target", ExecSameClose, 75660.506663, 50, 45714},
+		{"volatility_target", ExecNextOpen, 80663.059152, 48, 44452},
+		{"volatility_target", ExecNextClose, 81144.351847, 48, 44678},
+		{"volatility_target", ExecNextVWAP, 81634.508619, 48, 44784},
+		{"kelly", ExecSameClose, 99689.380967, 4, 7724},
+		{"kelly", ExecNextOpen, 99878.307016, 14, 9610},
+		{"kelly", ExecNextClose, 99760.888438, 4, 7824},
+		{"kelly", ExecNextVWAP, 99831.020591, 4, 7816},
+		{"volume_slippage", ExecSameClose, 55648.675268, 113, 32322},
+		{"volume_slippage", ExecNextOpen, 46164.967364, 91, 24835},
+		{"volume_slippage", ExecNextClose, 44957.782244, 85, 24523},
+		{"volume_slippage", ExecNextVWAP, 45831.124456, 91, 24759},
+		{"atr_pyramid", ExecSameClose, 89516.826141, 69, 21800},
+		{"atr_pyramid", ExecNextOpen, 94129.467538, 63, 19400},
+		{"atr_pyramid", ExecNextClose, 96029.042644, 66, 21000},
+		{"atr_pyramid", ExecNextVWAP, 95573.570314, 66, 20800},
+	}
+
+	series := portfolioSeries()
+	for _, tt := range tests {
+		opts := configs[tt.config]
+		opts.Execution = tt.mode
+		res := PortfolioBacktest(series, MACrossoverParams{ShortWindow: 5, LongWindow: 20}, PortfolioOptions{BacktestOptions: opts, MaxPositions: 2})
+		var shares float64
+		for _, trade := range res.Trades {
+			shares += trade.Shares
+		}
+		if final := fmt.Sprintf("%.6f", res.Final); final != fmt.Sprintf("%.6f", tt.final) || len(res.Trades) != tt.trades || shares != tt.shares {
+			t.Errorf("%s %s: final %s, %d trades of %.0f shares; want %.6f, %d trades of %.0f shares",
+				tt.config, tt.mode, final, len(res.Trades), shares, tt.final, tt.trades, tt.shares)
+		}
+	}
+}
+
+// TestPortfolioDefaultSizing checks that signals firing on the same bar
+// split the shared cash: without explicit sizing each entry is capped at
+// 100/max_positions percent of equity. Entries already filled on the bar
+// are valued at the prior close of 10 when the next one is sized.
+func TestPortfolioDefaultSizing(t *testing.T) {
+	series := make(map[string][]models.KLine)
+	for _, code := range []string{"A", "B", "C"} {
+		bars := closeBars(crossoverCloses)
+		for i := range bars {
+			bars[i].StockCode = code
+		}
+		series[code] = bars
+	}
+	params := MACrossoverParams{ShortWindow: 2, LongWindow: 4}
+
+	tests := []struct {
+		maxPositions int
+		sizing       SizingConfig
+		want         map[string]float64 // shares bought at the close of the first cross above
+	}{
+		{3, SizingConfig{}, map[string]float64{"A": 3703, "B": 3840, "C": 3568}},
+		{2, SizingConfig{Policy: SizingAllIn}, map[string]float64{"A": 5555, "B": 5556}},
+		{3, SizingConfig{MaxPositionPct: 100}, map[string]float64{"A": 11111}},
+	}
+	for _, tt := range tests {
+		res := PortfolioBacktest(series, params, PortfolioOptions{
+			BacktestOptions: BacktestOptions{InitialCapital: 100000, Execution: ExecSameClose, Sizing: tt.sizing},
+			MaxPositions:    tt.maxPositions,
+			Ranking:         RankCode,
+		})
+		got := make(map[string]float64)
+		for _, trade := range res.Trades {
+			if trade.Side == SideBuy && trade.Time.Equal(series["A"][4].Time) {
+				got[trade.StockCode] = trade.Shares
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("max_positions %d, sizing %+v: entries %v, want %v", tt.maxPositions, tt.sizing, got, tt.want)
+		}
+	}
+}
+
+// TestPortfolioFillOrder checks that orders carried to a bar fill on the
+// stocks already held first, in code order, and entries into flat stocks
+// last, funded by the sales before them.
+func TestPortfolioFillOrder(t *testing.T) {
+	flat := [][4]float64{{10, 10, 10, 10}, {10, 10, 10, 10}, {10, 10, 10, 10}}
+	series := map[string][]models.KLine{
+		"A": ohlcBars("A", flat),
+		"B": ohlcBars("B", flat),
+		"C": ohlcBars("C", flat),
+	}
+	s := &scriptStrategy{orders: map[int][]Order{
+		0: {
+			{StockCode: "B", Side: 1, Shares: 100},
+			{StockCode: "C", Side: 1, Shares: 100},
+		},
+		1: {
+			{StockCode: "A", Side: 1, Shares: 150}, // entry, needs C's proceeds
+			{StockCode: "B", Side: 1, Shares: 50},  // add-on
+			{StockCode: "C", Side: -1, Shares: 100},
+		},
+	}}
+	res, err := Run(context.Background(), series, s, BacktestOptions{InitialCapital: 3000, Execution: ExecNextOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTrades(t, res.Trades, []wantTrade{
+		{"B", 1, SideBuy, 10, 100},
+		{"C", 1, SideBuy, 10, 100},
+		{"B", 2, SideBuy, 10, 50},
+		{"C", 2, SideSell, 10, 100},
+		{"A", 2, SideBuy, 10, 150},
+	})
+}
+
+// TestSameCloseSizing checks that a same-close fill is sized with the other
+// stocks valued at their previous closes.
+func TestSameCloseSizing(t *testing.T) {
+	series := map[string][]models.KLine{
+		"A": ohlcBars("A", [][4]float64{{10, 10, 10, 10}, {20, 20, 20, 20}}),
+		"B": ohlcBars("B", [][4]float64{{10, 10, 10, 10}, {10, 10, 10, 10}}),
+	}
+	s := &scriptStrategy{orders: map[int][]Order{
+		0: {{StockCode: "A", Side: 1}},
+		1: {{StockCode: "B", Side: 1}},
+	}}
+	opts := BacktestOptions{
+		InitialCapital: 10000,
+		Execution:      ExecSameClose,
+		Sizing:         SizingConfig{Policy: SizingFixedFraction, EquityPct: 20},
+	}
+	res, err := Run(context.Background(), series, s, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A's 200 shares count at 10, not today's 20: 20% of 10000.
+	checkTrades(t, res.Trades, []wantTrade{
+		{"A", 0, SideBuy, 10, 200},
+		{"B", 1, SideBuy, 10, 200},
+	})
+}
+
+func TestPortfolioCloseAtEnd(t *testing.T) {
+	series := portfolioSeries()
+	params := MACrossoverParams{ShortWindow: 5, LongWindow: 20}
+	opts := PortfolioOptions{BacktestOptions: BacktestOptions{Execution: ExecNextOpen}, MaxPositions: 2}
+	open := PortfolioBacktest(series, params, opts)
+	opts.CloseAtEnd = true
+	closed := PortfolioBacktest(series, params, opts)
+
+	positions := make(map[string]float64)
+	for _, trade := range open.Trades {
+		if trade.Side == SideBuy {
+			positions[trade.StockCode] += trade.Shares
+		} else {
+			positions[trade.StockCode] -= trade.Shares
+		}
+	}
+	var others []Trade
+	for _, trade := range closed.Trades {
+		if trade.Reason != ReasonWindowEnd {
+			others = append(others, trade)
+			continue
+		}
+		if trade.Side != SideSell || trade.Shares != positions[trade.StockCode] {
+			t.Errorf("closing trade %+v, want a sale of %v shares", trade, positions[trade.StockCode])
+		}
+		delete(positions, trade.StockCode)
+	}
+	for code, shares := range positions {
+		if shares != 0 {
+			t.Errorf("%s: %v shares left open", code, shares)
+		}
+	}
+	if len(others) != len(open.Trades) {
+		t.Errorf("got %d other trades, want %d", len(others), len(open.Trades))
+	}
+	if math.Abs(closed.Final-open.Final) > 1e-6 {
+		t.Errorf("final %v with CloseAtEnd, want %v", closed.Final, open.Final)
+	}
+}
@@ -0,0 +1,350 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// Rebalance frequencies of screen backtests.
+const (
+	RebalanceDaily   = "daily"
+	RebalanceWeekly  = "weekly"
+	RebalanceMonthly = "monthly"
+)
+
+// Weighting schemes of screen backtests.
+const (
+	// WeightEqual splits equity evenly across the picks.
+	WeightEqual = "equal"
+	// WeightScore splits equity in proportion to the picks' screen scores.
+	WeightScore = "score"
+)
+
+// ReasonRebalance is recorded on trades made to reach rebalance targets.
+const ReasonRebalance = "rebalance"
+
+// ParseRebalance normalizes a rebalance frequency, monthly by default.
+func ParseRebalance(raw string) (string, error) {
+	switch raw {
+	case "":
+		return RebalanceMonthly, nil
+	case RebalanceDaily, RebalanceWeekly, RebalanceMonthly:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("unknown rebalance frequency %q", raw)
+	}
+}
+
+// ParseWeighting normalizes a weighting scheme, equal by default.
+func ParseWeighting(raw string) (string, error) {
+	switch raw {
+	case "":
+		return WeightEqual, nil
+	case WeightEqual, WeightScore:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("unknown weighting %q", raw)
+	}
+}
+
+// ScreenBacktestOptions configures a backtest of a screen held as a
+// periodically rebalanced portfolio.
+type ScreenBacktestOptions struct {
+	BacktestOptions
+	// Rebalance is daily, weekly or monthly (default): the screen runs on
+	// the first bar of each period.
+	Rebalance string
+	// Weighting is equal (default) or score.
+	Weighting string
+	// HoldBars holds each pick for that many bars, across rebalances,
+	// before selling it unless the screen picks it again; zero holds picks
+	// until the next rebalance.
+	HoldBars int
+	// MaxPositions keeps the best-scoring picks; zero keeps them all.
+	MaxPositions int
+}
+
+// RebalanceRecord is one run of the screen and the trading it caused.
+type RebalanceRecord struct {
+	Time time.Time `json:"time"`
+	// Weights maps each pick to its target share of equity.
+	Weights map[string]float64 `json:"weights"`
+	Equity  float64            `json:"equity"`
+	// TurnoverPct is the one-way turnover until the next rebalance: half of
+	// the value bought and sold as a percentage of Equity.
+	TurnoverPct float64 `json:"turnover_pct"`
+
+	traded float64 // value bought and sold until the next rebalance
+}
+
+// ScreenBacktestResult is the outcome of a screen backtest.
+type ScreenBacktestResult struct {
+	Final      float64           `json:"final"`
+	Points     []EquityPoint     `json:"points"`
+	Trades     []Trade           `json:"trades"`
+	Rebalances []RebalanceRecord `json:"rebalances"`
+	// AnnualTurnoverPct is the yearly one-way turnover as a percentage of
+	// average equity.
+	AnnualTurnoverPct float64 `json:"annual_turnover_pct"`
+}
+
+// ScreenBacktestContext runs the crossover screen as of the first bar of
+// every rebalance period, using only the bars known at its close, and
+// trades the portfolio toward the picks' target weights. Stocks that are
+// no longer picked are sold unless their holding period runs on; picks
+// are bought or resized to their weight of the equity not held in those,
+// valued at that close, in lots of Sizing.LotSize. It stops with the
+// context's error once ctx is done.
+func ScreenBacktestContext(ctx context.Context, series map[string][]models.KLine, params MACrossoverParams, opts ScreenBacktestOptions) (ScreenBacktestResult, error) {
+	if opts.InitialCapital <= 0 {
+		opts.InitialCapital = 100000
+	}
+	var err error
+	if opts.Rebalance, err = ParseRebalance(opts.Rebalance); err != nil {
+		return ScreenBacktestResult{}, err
+	}
+	if opts.Weighting, err = ParseWeighting(opts.Weighting); err != nil {
+		return ScreenBacktestResult{}, err
+	}
+
+	params.AllowShort = false
+	strategy := &rebalanceStrategy{opts: opts, params: params}
+	b, points, err := run(ctx, series, strategy, opts.BacktestOptions)
+	if err != nil {
+		return ScreenBacktestResult{}, err
+	}
+
+	final := opts.InitialCapital
+	if len(points) > 0 {
+		final = points[len(points)-1].Equity
+	}
+	trades := b.trades
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].Time.Before(trades[j].Time) })
+
+	return ScreenBacktestResult{
+		Final:             final,
+		Points:            points,
+		Trades:            trades,
+		Rebalances:        strategy.records,
+		AnnualTurnoverPct: turnover(strategy.records, points, opts.BarsPerYear()),
+	}, nil
+}
+
+// rebalanceStrategy holds the picks of a screen between rebalances.
+type rebalanceStrategy struct {
+	opts    ScreenBacktestOptions
+	params  MACrossoverParams
+	sizing  SizingConfig
+	spreads map[string][]float64
+	entries map[string]int // bar index a held stock was bought or picked again on
+	period  string
+	records []RebalanceRecord
+}
+
+func (s *rebalanceStrategy) Start(series map[string][]models.KLine) {
+	s.sizing = s.opts.Sizing.normalized()
+	s.spreads = make(map[string][]float64, len(series))
+	s.entries = make(map[string]int)
+	for code, bars := range series {
+		s.spreads[code] = maSpread(bars, s.params)
+	}
+}
+
+// OnBar rebalances on the first bar of a period and otherwise sells picks
+// whose holding period has run out.
+func (s *rebalanceStrategy) OnBar(b *Broker, codes []string) {
+	if len(codes) == 0 {
+		return
+	}
+	_, bar := b.Bar(codes[0])
+	if period := s.periodOf(bar.Time); period != s.period {
+		s.period = period
+		s.rebalance(b, bar.Time, codes)
+		return
+	}
+	if s.opts.HoldBars <= 0 {
+		return
+	}
+	for _, code := range codes {
+		if _, held := s.entries[code]; held && b.Position(code) > 0 && !s.holding(b, code) {
+			s.cancelOrders(b, code)
+			b.Submit(Order{StockCode: code, Side: -1, Type: OrderMarketOnClose, Reason: ReasonTimeExit})
+		}
+	}
+}
+
+// holding reports whether the stock's holding period runs past the
+// current bar.
+func (s *rebalanceStrategy) holding(b *Broker, code string) bool {
+	entry, held := s.entries[code]
+	i, _ := b.Bar(code)
+	return s.opts.HoldBars > 0 && held && i-entry < s.opts.HoldBars
+}
+
+func (s *rebalanceStrategy) OnEvent(b *Broker, event Event) {
+	switch event.Type {
+	case EventFill:
+		if n := len(s.records); n > 0 {
+			s.records[n-1].traded += event.Price * event.Shares
+		}
+		before := event.Position - float64(event.Order.Side)*event.Shares
+		if before <= 0 && event.Position > 0 {
+			s.entries[event.StockCode] = event.Bar
+		}
+	case EventPosition:
+		if event.Position <= 0 {
+			delete(s.entries, event.StockCode)
+		}
+	}
+}
+
+// periodOf returns the rebalance period a bar time falls in.
+func (s *rebalanceStrategy) periodOf(t time.Time) string {
+	switch s.opts.Rebalance {
+	case RebalanceDaily:
+		return t.Format("2006-01-02")
+	case RebalanceWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+// rebalance screens the stocks with a bar at the current timestamp and
+// submits the sales before the purchases that reach the new weights.
+func (s *rebalanceStrategy) rebalance(b *Broker, stamp time.Time, codes []string) {
+	weights := s.weights(b, codes)
+	equity := b.Equity()
+	s.records = append(s.records, RebalanceRecord{Time: stamp, Weights: weights, Equity: equity})
+
+	budget := equity
+	for _, code := range b.codes {
+		if _, picked := weights[code]; !picked && s.holding(b, code) {
+			budget -= b.Position(code) * b.holdings[code].lastClose
+		}
+	}
+	targets := make(map[string]float64, len(weights))
+	for code, weight := range weights {
+		if i, bar := b.Bar(code); bar.Close > 0 {
+			targets[code] = s.sizing.roundLot(math.Max(0, budget) * weight / bar.Close)
+			if b.Position(code) > 0 {
+				s.entries[code] = i
+			}
+		}
+	}
+
+	var buys []Order
+	for _, code := range b.codes {
+		position := b.Position(code)
+		target, picked := targets[code]
+		if !picked && s.holding(b, code) {
+			continue
+		}
+		s.cancelOrders(b, code)
+		switch {
+		case position > 0 && !picked:
+			b.Submit(Order{StockCode: code, Side: -1, Reason: ReasonRebalance})
+		case position > target:
+			b.Submit(Order{StockCode: code, Side: -1, Shares: position - target, Reason: ReasonRebalance})
+		case target-position >= s.sizing.LotSize:
+			buys = append(buys, Order{StockCode: code, Side: 1, Shares: target - position, Reason: ReasonRebalance})
+		}
+	}
+	for _, order := range buys {
+		b.Submit(order)
+	}
+}
+
+// weights runs the screen on the current bars and returns the target
+// weight of each pick.
+func (s *rebalanceStrategy) weights(b *Broker, codes []string) map[string]float64 {
+	var picks []string
+	for _, code := range codes {
+		// A pick is a cross above on the current bar, read from the aligned
+		// spread as ShouldSelect reads it.
+		if i, _ := b.Bar(code); i > 0 && s.spreads[code][i-1] <= 0 && s.spreads[code][i] > 0 {
+			picks = append(picks, code)
+		}
+	}
+	score := func(code string) float64 {
+		i, _ := b.Bar(code)
+		return s.spreads[code][i]
+	}
+	sort.SliceStable(picks, func(i, j int) bool { return score(picks[i]) > score(picks[j]) })
+	if s.opts.MaxPositions > 0 && len(picks) > s.opts.MaxPositions {
+		picks = picks[:s.opts.MaxPositions]
+	}
+
+	weights := make(map[string]float64, len(picks))
+	var total float64
+	for _, code := range picks {
+		total += score(code)
+	}
+	for _, code := range picks {
+		if s.opts.Weighting == WeightScore && total > 0 {
+			weights[code] = score(code) / total
+		} else {
+			weights[code] = 1 / float64(len(picks))
+		}
+	}
+	return weights
+}
+
+// cancelOrders cancels the stock's working orders.
+func (s *rebalanceStrategy) cancelOrders(b *Broker, code string) {
+	for _, order := range b.Orders(code) {
+		b.Cancel(order.ID)
+	}
+}
+
+// turnover fills in each rebalance's turnover from the fills made until
+// the next one and returns the annualized one-way turnover of the run.
+// Dividends and bonus shares are not fills and do not count.
+func turnover(records []RebalanceRecord, points []EquityPoint, barsPerYear float64) float64 {
+	var total float64
+	for n := range records {
+		total += records[n].traded
+		if records[n].Equity > 0 {
+			records[n].TurnoverPct = records[n].traded / 2 / records[n].Equity * 100
+		}
+	}
+
+	if len(points) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, point := range points {
+		sum += point.Equity
+	}
+	average := sum / float64(len(points))
+	years := float64(len(points)) / annualBars(barsPerYear)
+	if average <= 0 || years <= 0 {
+		return 0
+	}
+	return total / 2 / average / years * 100
+}
+
+// maSpread returns, for each bar, how far the short MA is above the long MA
+// in percent of the long MA, or NaN before both are known.
+func maSpread(sorted []models.KLine, params MACrossoverParams) []float64 {
+	spreads := make([]float64, len(sorted))
+	shortMA := movingAverage(sorted, params.ShortWindow)
+	longMA := movingAverage(sorted, params.LongWindow)
+	for i := range sorted {
+		spreads[i] = math.NaN()
+		if i < params.LongWindow-1 || longMA == nil {
+			continue
+		}
+		long := longMA[i-params.LongWindow+1]
+		if long != 0 {
+			spreads[i] = (shortMA[i-params.ShortWindow+1] - long) / long * 100
+		}
+	}
+	return spreads
+}
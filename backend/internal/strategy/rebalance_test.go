@@ -0,0 +1,198 @@
+package strategy
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// priceBars builds daily bars of one stock that open, trade and close at
+// each price.
+func priceBars(code string, prices ...float64) []models.KLine {
+	rows := make([][4]float64, len(prices))
+	for i, p := range prices {
+		rows[i] = [4]float64{p, p, p, p}
+	}
+	return ohlcBars(code, rows)
+}
+
+func TestParseRebalance(t *testing.T) {
+	tests := map[string]string{"": RebalanceMonthly, "daily": RebalanceDaily, "weekly": RebalanceWeekly, "monthly": RebalanceMonthly}
+	for raw, want := range tests {
+		if got, err := ParseRebalance(raw); err != nil || got != want {
+			t.Errorf("ParseRebalance(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := ParseRebalance("yearly"); err == nil {
+		t.Error("ParseRebalance accepted an unknown frequency")
+	}
+	if got, err := ParseWeighting(""); err != nil || got != WeightEqual {
+		t.Errorf("ParseWeighting(\"\") = %q, %v; want %q", got, err, WeightEqual)
+	}
+	if _, err := ParseWeighting("cap"); err == nil {
+		t.Error("ParseWeighting accepted an unknown weighting")
+	}
+}
+
+func TestRebalancePeriods(t *testing.T) {
+	// 2024-12-30 is the Monday of ISO week 1 of 2025.
+	monday := time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)
+	sunday := time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		rebalance string
+		want      [2]string
+	}{
+		{RebalanceDaily, [2]string{"2024-12-30", "2025-01-05"}},
+		{RebalanceWeekly, [2]string{"2025-W01", "2025-W01"}},
+		{RebalanceMonthly, [2]string{"2024-12", "2025-01"}},
+	}
+	for _, tt := range tests {
+		s := &rebalanceStrategy{opts: ScreenBacktestOptions{Rebalance: tt.rebalance}}
+		if got := [2]string{s.periodOf(monday), s.periodOf(sunday)}; got != tt.want {
+			t.Errorf("%s periods = %v, want %v", tt.rebalance, got, tt.want)
+		}
+	}
+}
+
+// TestScreenPicksMatchShouldSelect checks that a daily screen backtest
+// picks a stock on exactly the bars where ShouldSelect selects it.
+func TestScreenPicksMatchShouldSelect(t *testing.T) {
+	bars := closeBars(crossoverCloses)
+	params := MACrossoverParams{ShortWindow: 2, LongWindow: 4}
+	res, err := ScreenBacktestContext(context.Background(), map[string][]models.KLine{"X": bars}, params, ScreenBacktestOptions{
+		BacktestOptions: BacktestOptions{Execution: ExecSameClose},
+		Rebalance:       RebalanceDaily,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rebalances) != len(bars) {
+		t.Fatalf("%d rebalances, want one per bar", len(res.Rebalances))
+	}
+	for i, record := range res.Rebalances {
+		_, picked := record.Weights["X"]
+		if want := ShouldSelect(bars[:i+1], params); picked != want {
+			t.Errorf("bar %d: picked %v, ShouldSelect %v", i, picked, want)
+		}
+	}
+}
+
+// TestScreenBacktest screens on a 1/2-bar crossover, which picks a stock on
+// the bar its price turns up: A on bar 2 and B on bar 3.
+func TestScreenBacktest(t *testing.T) {
+	series := map[string][]models.KLine{
+		"A": priceBars("A", 10, 9, 10, 10, 10, 10, 10, 10),
+		"B": priceBars("B", 10, 10, 9, 10, 10, 10, 10, 10),
+	}
+	params := MACrossoverParams{ShortWindow: 1, LongWindow: 2}
+
+	tests := []struct {
+		name       string
+		opts       ScreenBacktestOptions
+		rebalances int
+		want       []wantTrade
+	}{
+		{
+			"daily", ScreenBacktestOptions{Rebalance: RebalanceDaily}, 8,
+			[]wantTrade{
+				{"A", 3, SideBuy, 10, 10000},
+				{"A", 4, SideSell, 10, 10000},
+				{"B", 4, SideBuy, 10, 10000},
+				{"B", 5, SideSell, 10, 10000},
+			},
+		},
+		{
+			// A's holding period keeps its equity from B.
+			"hold", ScreenBacktestOptions{Rebalance: RebalanceDaily, HoldBars: 3}, 8,
+			[]wantTrade{
+				{"A", 3, SideBuy, 10, 10000},
+				{"A", 7, SideSell, 10, 10000},
+			},
+		},
+		{
+			// The weeks start on bars 0 and 7, which pick nothing.
+			"weekly", ScreenBacktestOptions{Rebalance: RebalanceWeekly}, 2, nil,
+		},
+	}
+	for _, tt := range tests {
+		tt.opts.Execution = ExecNextOpen
+		res, err := ScreenBacktestContext(context.Background(), series, params, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTrades(t, res.Trades, tt.want)
+		if len(res.Rebalances) != tt.rebalances {
+			t.Errorf("%s: %d rebalances, want %d", tt.name, len(res.Rebalances), tt.rebalances)
+		}
+		for _, trade := range res.Trades {
+			if trade.Reason != ReasonRebalance {
+				t.Errorf("%s: trade reason %q, want %q", tt.name, trade.Reason, ReasonRebalance)
+			}
+		}
+		if tt.name != "daily" {
+			continue
+		}
+		// Half of the value bought and sold until the next rebalance.
+		for i, want := range []float64{0, 0, 50, 100, 50, 0, 0, 0} {
+			if got := res.Rebalances[i].TurnoverPct; math.Abs(got-want) > 1e-9 {
+				t.Errorf("rebalance %d turnover %v%%, want %v%%", i, got, want)
+			}
+		}
+		if w := res.Rebalances[2].Weights; len(w) != 1 || w["A"] != 1 {
+			t.Errorf("bar 2 weights %v, want all in A", w)
+		}
+		// 400000 traded over eight bars of 100000 equity.
+		if want := 400000.0 / 2 / 100000 / (8 / 252.0) * 100; math.Abs(res.AnnualTurnoverPct-want) > 1e-6 {
+			t.Errorf("annual turnover %v%%, want %v%%", res.AnnualTurnoverPct, want)
+		}
+	}
+}
+
+// TestScreenBacktestWeighting picks A and C on the same bar, C with about
+// twice the MA spread.
+func TestScreenBacktestWeighting(t *testing.T) {
+	series := map[string][]models.KLine{
+		"A": priceBars("A", 10, 9, 9.5, 9.5),
+		"C": priceBars("C", 10, 9, 10, 10),
+	}
+	params := MACrossoverParams{ShortWindow: 1, LongWindow: 2}
+	spreadA, spreadC := (9.5/9.25-1)*100, (10/9.5-1)*100
+
+	tests := []struct {
+		opts ScreenBacktestOptions
+		want []wantTrade
+	}{
+		{ScreenBacktestOptions{}, []wantTrade{
+			{"A", 3, SideBuy, 9.5, math.Floor(50000 / 9.5)},
+			{"C", 3, SideBuy, 10, 5000},
+		}},
+		{ScreenBacktestOptions{Weighting: WeightScore, BacktestOptions: BacktestOptions{Sizing: SizingConfig{LotSize: 100}}}, []wantTrade{
+			{"A", 3, SideBuy, 9.5, math.Floor(100000*spreadA/(spreadA+spreadC)/9.5/100) * 100},
+			{"C", 3, SideBuy, 10, math.Floor(100000*spreadC/(spreadA+spreadC)/10/100) * 100},
+		}},
+		{ScreenBacktestOptions{MaxPositions: 1}, []wantTrade{
+			{"C", 3, SideBuy, 10, 10000},
+		}},
+	}
+	for _, tt := range tests {
+		tt.opts.Rebalance = RebalanceDaily
+		tt.opts.Execution = ExecNextOpen
+		res, err := ScreenBacktestContext(context.Background(), series, params, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTrades(t, res.Trades, tt.want)
+	}
+}
+
+func TestScreenBacktestCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	series := map[string][]models.KLine{"A": priceBars("A", 10, 9, 10)}
+	if _, err := ScreenBacktestContext(ctx, series, MACrossoverParams{ShortWindow: 1, LongWindow: 2}, ScreenBacktestOptions{}); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
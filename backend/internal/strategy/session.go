@@ -0,0 +1,130 @@
+package strategy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// Session is one continuous trading period of a day, in minutes after
+// midnight exchange time.
+type Session struct {
+	Open  int
+	Close int
+}
+
+// AShareSessions are the continuous trading sessions of the Shanghai and
+// Shenzhen exchanges: 09:30-11:30 and 13:00-15:00.
+var AShareSessions = []Session{
+	{Open: 9*60 + 30, Close: 11*60 + 30},
+	{Open: 13 * 60, Close: 15 * 60},
+}
+
+// tradingMinutesPerDay is the length of the A-share sessions combined.
+const tradingMinutesPerDay = 240
+
+// SessionExit names what happens to open positions when a session ends.
+type SessionExit string
+
+const (
+	// SessionHold keeps positions over the lunch break and overnight.
+	SessionHold SessionExit = "hold"
+	// SessionExitDay closes positions on the last bar of each trading day.
+	SessionExitDay SessionExit = "day_end"
+	// SessionExitSession closes positions on the last bar before the lunch
+	// break as well as on the last bar of the day.
+	SessionExitSession SessionExit = "session_end"
+)
+
+// ParseSessionExit normalizes a session exit name, falling back to hold.
+func ParseSessionExit(raw string) SessionExit {
+	switch exit := SessionExit(raw); exit {
+	case SessionExitDay, SessionExitSession:
+		return exit
+	default:
+		return SessionHold
+	}
+}
+
+// IntervalMinutes returns the bar length of a minute interval such as
+// "30m", or zero for daily ("1d", the default) and weekly ("1w") bars.
+func IntervalMinutes(interval string) (int, error) {
+	switch interval {
+	case "", "1d", "1w":
+		return 0, nil
+	}
+	if !strings.HasSuffix(interval, "m") {
+		return 0, fmt.Errorf("unsupported interval %q", interval)
+	}
+	minutes, err := strconv.Atoi(strings.TrimSuffix(interval, "m"))
+	if err != nil || minutes <= 0 || minutes > tradingMinutesPerDay || tradingMinutesPerDay%minutes != 0 {
+		return 0, fmt.Errorf("unsupported interval %q", interval)
+	}
+	return minutes, nil
+}
+
+// BarsPerYear returns how many bars of interval make up a trading year of
+// 252 days, for annualizing per-bar statistics. Unsupported intervals are
+// treated as daily.
+func BarsPerYear(interval string) float64 {
+	if interval == "1w" {
+		return tradingDaysPerYear / 5.0
+	}
+	minutes, err := IntervalMinutes(interval)
+	if err != nil || minutes == 0 {
+		return tradingDaysPerYear
+	}
+	return tradingDaysPerYear * float64(tradingMinutesPerDay/minutes)
+}
+
+// sessionOf returns the index in AShareSessions of the session a bar time
+// falls in, or -1 outside trading hours. Both session bounds are inclusive
+// so bars stamped with either their start or their end time match.
+func sessionOf(t time.Time) int {
+	hour, minute, _ := t.Clock()
+	clock := hour*60 + minute
+	for i, session := range AShareSessions {
+		if clock >= session.Open && clock <= session.Close {
+			return i
+		}
+	}
+	return -1
+}
+
+// tradingBars returns the time-sorted bars a backtest at interval trades
+// on: every daily bar, or the intraday bars within trading hours. It
+// filters sorted in place.
+func tradingBars(sorted []models.KLine, interval string) []models.KLine {
+	if minutes, _ := IntervalMinutes(interval); minutes == 0 {
+		return sorted
+	}
+	kept := sorted[:0]
+	for _, bar := range sorted {
+		if sessionOf(bar.Time) >= 0 {
+			kept = append(kept, bar)
+		}
+	}
+	return kept
+}
+
+// sessionBreaks marks, for time-sorted intraday bars, the last bar of each
+// session and the last bar of each trading day. The final bar ends both.
+func sessionBreaks(sorted []models.KLine) (sessionEnd, dayEnd []bool) {
+	sessionEnd = make([]bool, len(sorted))
+	dayEnd = make([]bool, len(sorted))
+	for i, bar := range sorted {
+		if i == len(sorted)-1 {
+			sessionEnd[i], dayEnd[i] = true, true
+			continue
+		}
+		next := sorted[i+1].Time
+		y1, m1, d1 := bar.Time.Date()
+		y2, m2, d2 := next.Date()
+		dayEnd[i] = y1 != y2 || m1 != m2 || d1 != d2
+		sessionEnd[i] = dayEnd[i] || sessionOf(bar.Time) != sessionOf(next)
+	}
+	return sessionEnd, dayEnd
+}
@@ -0,0 +1,154 @@
+package strategy
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// thirtyMinuteBars returns 30m bars of weekdays from 2024-01-02: the eight
+// of the two sessions, stamped with their end time, and one at 20:00
+// outside trading hours. Prices oscillate so crossovers happen daily.
+func thirtyMinuteBars(count int) []models.KLine {
+	var bars []models.KLine
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	price := 10.0
+	for i := 0; len(bars) < count; day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		for _, minute := range []int{600, 630, 660, 690, 810, 840, 870, 900, 1200} {
+			price *= 1 + 0.01*math.Sin(float64(i)/7)
+			i++
+			bars = append(bars, models.KLine{StockCode: "X", Interval: "30m", Time: day.Add(time.Duration(minute) * time.Minute), Open: price, High: price * 1.005, Low: price * 0.995, Close: price, Volume: 1e6})
+		}
+	}
+	return bars
+}
+
+func TestIntervalMinutes(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     int
+		ok       bool
+	}{
+		{"", 0, true},
+		{"1d", 0, true},
+		{"1w", 0, true},
+		{"1m", 1, true},
+		{"30m", 30, true},
+		{"60m", 60, true},
+		{"240m", 240, true},
+		{"7m", 0, false},
+		{"0m", 0, false},
+		{"480m", 0, false},
+		{"1h", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := IntervalMinutes(tt.interval)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("IntervalMinutes(%q) = %d, %v; want %d, ok %v", tt.interval, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBarsPerYear(t *testing.T) {
+	tests := map[string]float64{"1d": 252, "": 252, "1w": 50.4, "30m": 252 * 8, "5m": 252 * 48, "bad": 252}
+	for interval, want := range tests {
+		if got := BarsPerYear(interval); got != want {
+			t.Errorf("BarsPerYear(%q) = %v, want %v", interval, got, want)
+		}
+	}
+}
+
+func TestSessionBreaks(t *testing.T) {
+	bars := tradingBars(thirtyMinuteBars(18), "30m")
+	if len(bars) != 16 {
+		t.Fatalf("kept %d bars, want the 16 within trading hours", len(bars))
+	}
+	sessionEnd, dayEnd := sessionBreaks(bars)
+	// Each day runs 10:00-11:30 in the morning and 13:30-15:00 after lunch.
+	wantSession := []bool{false, false, false, true, false, false, false, true}
+	wantDay := []bool{false, false, false, false, false, false, false, true}
+	for day := 0; day < 2; day++ {
+		if got := sessionEnd[day*8 : day*8+8]; !reflect.DeepEqual(got, wantSession) {
+			t.Errorf("day %d session ends = %v, want %v", day, got, wantSession)
+		}
+		if got := dayEnd[day*8 : day*8+8]; !reflect.DeepEqual(got, wantDay) {
+			t.Errorf("day %d day ends = %v, want %v", day, got, wantDay)
+		}
+	}
+}
+
+func TestSessionExit(t *testing.T) {
+	bars := thirtyMinuteBars(400)
+	trading := len(tradingBars(append([]models.KLine(nil), bars...), "30m"))
+	for _, exit := range []SessionExit{SessionHold, SessionExitDay, SessionExitSession} {
+		for _, mode := range []ExecutionMode{ExecNextOpen, ExecSameClose} {
+			opts := BacktestOptions{Interval: "30m", SessionExit: exit, Execution: mode}
+			_, points, trades := Backtest(bars, MACrossoverParams{ShortWindow: 3, LongWindow: 8}, opts)
+			if len(points) != trading {
+				t.Errorf("%s %s: %d points, want one per trading bar", exit, mode, len(points))
+			}
+
+			var position float64
+			var sessionEnds int
+			for _, trade := range trades {
+				hour, minute, _ := trade.Time.Clock()
+				if sessionOf(trade.Time) < 0 {
+					t.Errorf("%s %s: trade outside trading hours at %v", exit, mode, trade.Time)
+				}
+				if trade.Side == SideBuy {
+					position += trade.Shares
+				} else {
+					position -= trade.Shares
+				}
+				if trade.Reason != ReasonSessionEnd {
+					continue
+				}
+				sessionEnds++
+				if position != 0 {
+					t.Errorf("%s %s: session_end at %v left %v shares", exit, mode, trade.Time, position)
+				}
+				switch exit {
+				case SessionHold:
+					t.Errorf("%s %s: session_end trade at %v", exit, mode, trade.Time)
+				case SessionExitDay:
+					if hour != 15 {
+						t.Errorf("%s %s: day_end sale at %v", exit, mode, trade.Time)
+					}
+				case SessionExitSession:
+					if hour != 15 && !(hour == 11 && minute == 30) {
+						t.Errorf("%s %s: session_end sale at %v", exit, mode, trade.Time)
+					}
+				}
+			}
+			if exit != SessionHold && sessionEnds == 0 {
+				t.Errorf("%s %s: no session_end trades", exit, mode)
+			}
+		}
+	}
+}
+
+func TestDayOrderExpiresAtDayEnd(t *testing.T) {
+	bars := tradingBars(thirtyMinuteBars(27), "30m")
+	s := &scriptStrategy{orders: map[int][]Order{
+		2: {{StockCode: "X", Side: 1, Type: OrderLimit, LimitPrice: 1, TIF: TIFDay, Shares: 100}},
+	}}
+	if _, err := Run(context.Background(), map[string][]models.KLine{"X": bars}, s, BacktestOptions{Interval: "30m"}); err != nil {
+		t.Fatal(err)
+	}
+	var cancels []Event
+	for _, e := range s.events {
+		if e.Type == EventCancel {
+			cancels = append(cancels, e)
+		}
+	}
+	if len(cancels) != 1 || cancels[0].Reason != CancelExpired || !cancels[0].Time.Equal(bars[7].Time) {
+		t.Errorf("cancels = %+v, want one expiry on the day's last bar %v", cancels, bars[7].Time)
+	}
+}
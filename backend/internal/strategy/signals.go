@@ -0,0 +1,294 @@
+package strategy
+
+import (
+	"math"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// signalStrategy trades per-bar signals (1 buy, -1 sell, SignalShort and
+// SignalCover) with market orders. A buy covers any short position before
+// going long and a short signal sells any long position before going
+// short. It protects positions with stop and take-profit orders, adds
+// pyramiding units to longs and, across a universe, fills at most
+// MaxPositions slots, long entries in ranking order before short ones.
+type signalStrategy struct {
+	opts    PortfolioOptions
+	signal  func([]models.KLine) []int
+	series  map[string][]models.KLine
+	signals map[string][]int
+	books   map[string]*signalBook
+}
+
+// signalBook is the strategy's state for one stock.
+type signalBook struct {
+	entry   entryState
+	exitATR []float64
+	pending int // working signal, pyramid or exit order
+	stop    int // protective stop order
+	target  int // take-profit order
+}
+
+func newSignalStrategy(opts PortfolioOptions, signal func([]models.KLine) []int) *signalStrategy {
+	return &signalStrategy{opts: opts, signal: signal}
+}
+
+func (s *signalStrategy) Start(series map[string][]models.KLine) {
+	s.opts.Exits = s.opts.Exits.normalized()
+	s.opts.Sizing = s.opts.Sizing.normalized()
+	s.opts.Execution = ParseExecutionMode(string(s.opts.Execution))
+	s.series = series
+	s.signals = make(map[string][]int, len(series))
+	s.books = make(map[string]*signalBook, len(series))
+	for code, bars := range series {
+		s.signals[code] = s.signal(bars)
+		book := &signalBook{}
+		if s.opts.Exits.ATRStopMultiple > 0 {
+			book.exitATR = averageTrueRange(bars, s.opts.Exits.ATRPeriod)
+		}
+		s.books[code] = book
+	}
+}
+
+// OnBar submits exits and the signals of stocks already in a slot first,
+// then new entries while slots are free, and finally applies the
+// close-time rules of every stock.
+func (s *signalStrategy) OnBar(b *Broker, codes []string) {
+	var candidates, shorts []string
+	for _, code := range codes {
+		i, _ := b.Bar(code)
+		switch signal := s.signals[code][i]; {
+		case signal == SignalCover:
+			s.submit(b, code, 1, true)
+		case signal == SignalShort && s.busy(b, code):
+			s.submit(b, code, -1, true)
+		case signal == SignalShort:
+			shorts = append(shorts, code)
+		case signal < 0:
+			s.submit(b, code, -1, false)
+		case signal > 0 && s.busy(b, code):
+			s.submit(b, code, 1, false)
+		case signal > 0:
+			candidates = append(candidates, code)
+		}
+	}
+
+	free := math.MaxInt
+	if s.opts.MaxPositions > 0 {
+		free = s.opts.MaxPositions
+		for code := range s.books {
+			if s.busy(b, code) {
+				free--
+			}
+		}
+	}
+	rankCandidates(b, candidates, s.opts)
+	for n, code := range append(candidates, shorts...) {
+		if free <= 0 {
+			break
+		}
+		if n < len(candidates) {
+			s.submit(b, code, 1, false)
+		} else {
+			s.submit(b, code, -1, true)
+		}
+		if s.busy(b, code) {
+			free--
+		}
+	}
+
+	for _, code := range codes {
+		s.closeBar(b, code)
+	}
+}
+
+func (s *signalStrategy) OnEvent(b *Broker, event Event) {
+	book := s.books[event.StockCode]
+	order := event.Order
+	switch event.Type {
+	case EventFill:
+		before := event.Position - float64(order.Side)*event.Shares
+		crossed := before*event.Position < 0
+		opened := event.Position != 0 && (before == 0 || crossed)
+		if opened || before*event.Position > 0 && math.Abs(event.Position) > math.Abs(before) {
+			if opened {
+				// Protective orders of a reversed position are on the wrong side.
+				b.Cancel(book.stop)
+				b.Cancel(book.target)
+				atr := valueAt(book.exitATR, s.known(event.Bar)) / b.Adjustment(event.StockCode)
+				book.entry = entryState{short: event.Position < 0, bar: event.Bar, atr: atr}
+			}
+			prior, shares := math.Abs(before), event.Shares
+			if crossed {
+				prior, shares = 0, math.Abs(event.Position)
+			}
+			book.entry.price = (book.entry.price*prior + event.Price*shares) / math.Abs(event.Position)
+			book.entry.last = event.Price
+			if order.Reason == ReasonPyramid && order.Filled == event.Shares {
+				book.entry.adds++
+			}
+		} else if order.ID == book.stop || order.ID == book.target {
+			// A risk exit replaces every other working order and runs to
+			// completion.
+			book.stop, book.target = 0, 0
+			for _, other := range b.Orders(event.StockCode) {
+				if other.ID != order.ID {
+					b.Cancel(other.ID)
+				}
+			}
+			if order.Status == OrderWorking {
+				book.pending = order.ID
+			}
+		}
+	case EventAdjust:
+		book.entry.rebase(event.Ratio)
+	case EventPosition:
+		if event.Position == 0 {
+			b.Cancel(book.stop)
+			b.Cancel(book.target)
+		}
+	}
+
+	if order.Status != OrderWorking {
+		switch order.ID {
+		case book.pending:
+			book.pending = 0
+		case book.stop:
+			book.stop = 0
+		case book.target:
+			book.target = 0
+		}
+	}
+}
+
+// known returns the latest bar whose data may inform a fill on bar i.
+func (s *signalStrategy) known(i int) int {
+	if s.opts.Execution == ExecSameClose {
+		return i
+	}
+	return i - 1
+}
+
+// busy reports whether the stock holds or is acquiring a position.
+func (s *signalStrategy) busy(b *Broker, code string) bool {
+	if b.Position(code) != 0 {
+		return true
+	}
+	order, ok := b.Order(s.books[code].pending)
+	return ok && order.opens()
+}
+
+// submit turns a signal into a market order, short for short sales and
+// covers. A signal against the working order cancels its unfilled
+// remainder, except for risk exits which always run to completion. Buys
+// while long are pyramiding add-ons; shorts are not added to.
+func (s *signalStrategy) submit(b *Broker, code string, side int, short bool) {
+	book := s.books[code]
+	if order, ok := b.Order(book.pending); ok {
+		riskExit := order.Reason != ReasonSignal && order.Reason != ReasonPyramid
+		if order.Side == side && order.Short == short || riskExit {
+			return
+		}
+		b.Cancel(order.ID)
+	}
+
+	reason := ReasonSignal
+	position := b.Position(code)
+	switch {
+	case side > 0 && !short && position > 0:
+		if !s.opts.Sizing.canPyramid(book.entry.adds) {
+			return
+		}
+		reason = ReasonPyramid
+	case side < 0 && !short && position <= 0, side < 0 && short && position < 0, side > 0 && short && position >= 0:
+		return
+	}
+	if (side > 0) != short && s.opts.Execution == ExecSameClose && b.flattening(b.holdings[code]) {
+		// The session exit would close the position again at the same close.
+		return
+	}
+	s.place(b, &book.pending, Order{StockCode: code, Side: side, Short: short, Type: OrderMarket, Reason: reason})
+}
+
+// closeBar applies close-time rules: time exits, pyramiding, the trailing
+// stop's highest close and the protective orders for the next bar.
+func (s *signalStrategy) closeBar(b *Broker, code string) {
+	if b.Position(code) == 0 {
+		return
+	}
+	book := s.books[code]
+	i, bar := b.Bar(code)
+
+	if s.opts.Exits.timeExit(book.entry, i) {
+		s.exit(b, code, ReasonTimeExit)
+	} else if _, working := b.Order(book.pending); !working && !book.entry.short && s.opts.Sizing.pyramidTrigger(book.entry.last, bar.Close) {
+		s.submit(b, code, 1, false)
+	}
+	book.entry.markClose(bar.Close)
+	s.protect(b, code)
+}
+
+// exit replaces every working order with a trade closing the whole
+// position at the close.
+func (s *signalStrategy) exit(b *Broker, code string, reason string) {
+	book := s.books[code]
+	for _, order := range b.Orders(code) {
+		b.Cancel(order.ID)
+	}
+	side, short := s.closing(b, code)
+	s.place(b, &book.pending, Order{StockCode: code, Side: side, Short: short, Type: OrderMarketOnClose, Reason: reason})
+}
+
+// closing returns the side and short flag of orders closing the stock's
+// position: a sale of a long one, a cover of a short one.
+func (s *signalStrategy) closing(b *Broker, code string) (int, bool) {
+	if b.Position(code) < 0 {
+		return 1, true
+	}
+	return -1, false
+}
+
+// protect keeps a stop order at the tightest active stop level and a limit
+// order at the take-profit target while a position is open.
+func (s *signalStrategy) protect(b *Broker, code string) {
+	book := s.books[code]
+	if b.Position(code) == 0 {
+		return
+	}
+	if order, ok := b.Order(book.pending); ok && !order.opens() && order.Reason != ReasonSignal {
+		return
+	}
+
+	side, short := s.closing(b, code)
+	level, reason := s.opts.Exits.stopLevel(book.entry)
+	s.keep(b, &book.stop, level > 0, Order{StockCode: code, Side: side, Short: short, Type: OrderStop, StopPrice: level, Reason: reason})
+	target := s.opts.Exits.target(book.entry)
+	s.keep(b, &book.target, target > 0, Order{StockCode: code, Side: side, Short: short, Type: OrderLimit, LimitPrice: target, Reason: ReasonTakeProfit})
+}
+
+// keep makes *slot refer to a working copy of order when want is set,
+// replacing the current order if its prices or reason changed.
+func (s *signalStrategy) keep(b *Broker, slot *int, want bool, order Order) {
+	current, ok := b.Order(*slot)
+	if ok && want && current.StopPrice == order.StopPrice && current.LimitPrice == order.LimitPrice && current.Reason == order.Reason {
+		return
+	}
+	if ok {
+		b.Cancel(current.ID)
+	}
+	*slot = 0
+	if want {
+		s.place(b, slot, order)
+	}
+}
+
+// place submits an order and tracks it in *slot while it keeps working.
+func (s *signalStrategy) place(b *Broker, slot *int, order Order) {
+	id, err := b.Submit(order)
+	if err != nil {
+		return
+	}
+	if _, working := b.Order(id); working {
+		*slot = id
+	}
+}
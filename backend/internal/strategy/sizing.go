@@ -0,0 +1,204 @@
+package strategy
+
+import "math"
+
+// SizingPolicy names how many shares a buy order requests.
+type SizingPolicy string
+
+const (
+	// SizingAllIn spends all available cash on every entry.
+	SizingAllIn SizingPolicy = "all_in"
+	// SizingFixedAmount buys a fixed yuan amount per entry.
+	SizingFixedAmount SizingPolicy = "fixed_amount"
+	// SizingFixedFraction buys a fixed percentage of current equity.
+	SizingFixedFraction SizingPolicy = "fixed_fraction"
+	// SizingVolatilityTarget scales exposure so the position's annualized
+	// volatility matches a target.
+	SizingVolatilityTarget SizingPolicy = "volatility_target"
+	// SizingATRRisk risks a fixed percentage of equity per ATR multiple.
+	SizingATRRisk SizingPolicy = "atr_risk"
+	// SizingKelly sizes by a scaled Kelly fraction estimated from closed trades.
+	SizingKelly SizingPolicy = "kelly"
+)
+
+// tradingDaysPerYear annualizes daily statistics.
+const tradingDaysPerYear = 252
+
+// annualBars returns barsPerYear, or tradingDaysPerYear when it is unset.
+func annualBars(barsPerYear float64) float64 {
+	if barsPerYear <= 0 {
+		return tradingDaysPerYear
+	}
+	return barsPerYear
+}
+
+// SizingConfig configures position sizing. Percentages are expressed in
+// percent (10 means 10%).
+type SizingConfig struct {
+	Policy SizingPolicy `json:"policy"`
+	// Amount is the yuan amount per entry for fixed_amount.
+	Amount float64 `json:"amount"`
+	// EquityPct is the equity share per entry for fixed_fraction and the
+	// fallback for kelly before enough trades have closed (default 100).
+	// Above 100 it borrows on margin accounts.
+	EquityPct float64 `json:"equity_pct"`
+	// TargetVolatilityPct is the annualized volatility target.
+	TargetVolatilityPct float64 `json:"target_volatility_pct"`
+	// VolatilityWindow is the lookback in bars for realized volatility (default 20).
+	VolatilityWindow int `json:"volatility_window"`
+	// RiskPct is the equity share risked per entry for atr_risk.
+	RiskPct float64 `json:"risk_pct"`
+	// ATRPeriod and ATRMultiple define the risk unit for atr_risk (defaults 14 and 2).
+	ATRPeriod   int     `json:"atr_period"`
+	ATRMultiple float64 `json:"atr_multiple"`
+	// KellyScale multiplies the full Kelly fraction (default 0.5).
+	KellyScale float64 `json:"kelly_scale"`
+	// KellyMinTrades is the number of closed trades needed before Kelly
+	// replaces the fallback fraction (default 10).
+	KellyMinTrades int `json:"kelly_min_trades"`
+	// MaxPyramids is the number of add-on entries allowed on top of the
+	// initial one; PyramidStepPct adds a unit each time the close rises that
+	// far above the last entry.
+	MaxPyramids    int     `json:"max_pyramids"`
+	PyramidStepPct float64 `json:"pyramid_step_pct"`
+	// LotSize rounds share counts down (100 for A-share board lots, default 1).
+	LotSize float64 `json:"lot_size"`
+	// MaxPositionPct caps a single stock's position value as a share of
+	// equity, including pyramiding add-ons (default 100). Above 100 it lets
+	// margin accounts lever a position.
+	MaxPositionPct float64 `json:"max_position_pct"`
+}
+
+func (c SizingConfig) normalized() SizingConfig {
+	switch c.Policy {
+	case SizingFixedAmount, SizingFixedFraction, SizingVolatilityTarget, SizingATRRisk, SizingKelly:
+	default:
+		c.Policy = SizingAllIn
+	}
+	if c.EquityPct <= 0 {
+		c.EquityPct = 100
+	}
+	if c.VolatilityWindow < 2 {
+		c.VolatilityWindow = 20
+	}
+	if c.ATRPeriod <= 0 {
+		c.ATRPeriod = 14
+	}
+	if c.ATRMultiple <= 0 {
+		c.ATRMultiple = 2
+	}
+	if c.KellyScale <= 0 {
+		c.KellyScale = 0.5
+	}
+	if c.KellyMinTrades <= 0 {
+		c.KellyMinTrades = 10
+	}
+	if c.MaxPyramids < 0 {
+		c.MaxPyramids = 0
+	}
+	if c.LotSize <= 0 {
+		c.LotSize = 1
+	}
+	if c.MaxPositionPct <= 0 {
+		c.MaxPositionPct = 100
+	}
+	return c
+}
+
+// sizingInput carries the account and market state a policy may use. Market
+// statistics are taken from bars completed before the order is sized.
+type sizingInput struct {
+	equity        float64
+	cash          float64 // cash, or buying power on a margin account
+	price         float64
+	positionValue float64 // value already held in the stock
+	atr           float64
+	volatility    float64   // standard deviation of per-bar returns
+	barsPerYear   float64   // annualizes volatility
+	tradeReturns  []float64 // fractional returns of closed round trips
+}
+
+// shares returns the number of shares to request, rounded down to the lot
+// size and capped by available cash, or buying power on margin, and the
+// per-position limit.
+func (c SizingConfig) shares(in sizingInput) float64 {
+	if in.price <= 0 {
+		return 0
+	}
+	limit := math.Min(in.cash, in.equity*c.MaxPositionPct/100-in.positionValue)
+	if limit <= 0 {
+		return 0
+	}
+
+	var value float64
+	switch c.Policy {
+	case SizingFixedAmount:
+		value = c.Amount
+	case SizingFixedFraction:
+		value = in.equity * c.EquityPct / 100
+	case SizingVolatilityTarget:
+		annualized := in.volatility * math.Sqrt(annualBars(in.barsPerYear))
+		if annualized > 0 {
+			value = in.equity * (c.TargetVolatilityPct / 100) / annualized
+		}
+	case SizingATRRisk:
+		if in.atr > 0 {
+			return c.roundLot(math.Min(in.equity*c.RiskPct/100/(in.atr*c.ATRMultiple), limit/in.price))
+		}
+	case SizingKelly:
+		value = in.equity * c.kellyFraction(in.tradeReturns)
+	default:
+		value = in.cash
+	}
+
+	return c.roundLot(math.Min(value, limit) / in.price)
+}
+
+// kellyFraction estimates f* = W - (1-W)/R from closed trades, scaled and
+// clamped to [0, 1].
+func (c SizingConfig) kellyFraction(returns []float64) float64 {
+	if len(returns) < c.KellyMinTrades {
+		return c.EquityPct / 100
+	}
+
+	var wins, losses int
+	var winSum, lossSum float64
+	for _, r := range returns {
+		if r > 0 {
+			wins++
+			winSum += r
+		} else if r < 0 {
+			losses++
+			lossSum -= r
+		}
+	}
+	if wins == 0 {
+		return 0
+	}
+	if losses == 0 || lossSum == 0 {
+		return c.KellyScale
+	}
+
+	winRate := float64(wins) / float64(len(returns))
+	payoff := (winSum / float64(wins)) / (lossSum / float64(losses))
+	fraction := c.KellyScale * (winRate - (1-winRate)/payoff)
+	return math.Max(0, math.Min(1, fraction))
+}
+
+func (c SizingConfig) roundLot(shares float64) float64 {
+	if shares <= 0 {
+		return 0
+	}
+	return math.Floor(shares/c.LotSize) * c.LotSize
+}
+
+// canPyramid reports whether another add-on entry is allowed.
+func (c SizingConfig) canPyramid(adds int) bool {
+	return adds < c.MaxPyramids
+}
+
+// pyramidTrigger reports whether the close has risen far enough above the
+// last entry to add a unit.
+func (c SizingConfig) pyramidTrigger(lastEntry, close float64) bool {
+	return c.PyramidStepPct > 0 && lastEntry > 0 && close >= lastEntry*(1+c.PyramidStepPct/100)
+}
@@ -0,0 +1,97 @@
+package strategy
+
+import (
+	"math"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// SlippageModel names how fill prices deviate from the quoted price.
+type SlippageModel string
+
+const (
+	// SlippageNone fills at the quoted price.
+	SlippageNone SlippageModel = "none"
+	// SlippageFixedBps moves the price by a fixed number of basis points.
+	SlippageFixedBps SlippageModel = "fixed_bps"
+	// SlippageFixedTicks moves the price by a fixed number of 0.01 yuan ticks.
+	SlippageFixedTicks SlippageModel = "fixed_ticks"
+	// SlippageVolume scales impact with the order's share of bar volume and
+	// caps each fill at a maximum participation rate.
+	SlippageVolume SlippageModel = "volume"
+)
+
+// TickSize is the minimum price increment for A-shares.
+const TickSize = 0.01
+
+// SlippageConfig configures the slippage model of a backtest.
+type SlippageConfig struct {
+	Model SlippageModel `json:"model"`
+	// Bps is the fixed cost for fixed_bps and the base cost for volume.
+	Bps float64 `json:"bps"`
+	// Ticks is the number of ticks paid per share for fixed_ticks.
+	Ticks float64 `json:"ticks"`
+	// Impact is the fractional price move at 100% participation for volume.
+	Impact float64 `json:"impact"`
+	// MaxParticipation caps each fill at this fraction of bar volume (default 0.1).
+	MaxParticipation float64 `json:"max_participation"`
+	// VolumeUnit is the number of shares in one unit of KLine.Volume
+	// (100 for data quoted in 手). Defaults to 1.
+	VolumeUnit float64 `json:"volume_unit"`
+}
+
+func (c SlippageConfig) normalized() SlippageConfig {
+	switch c.Model {
+	case SlippageFixedBps, SlippageFixedTicks, SlippageVolume:
+	default:
+		c.Model = SlippageNone
+	}
+	if c.Bps < 0 {
+		c.Bps = 0
+	}
+	if c.Ticks < 0 {
+		c.Ticks = 0
+	}
+	if c.Impact < 0 {
+		c.Impact = 0
+	}
+	if c.MaxParticipation <= 0 || c.MaxParticipation > 1 {
+		c.MaxParticipation = 0.1
+	}
+	if c.VolumeUnit <= 0 {
+		c.VolumeUnit = 1
+	}
+	return c
+}
+
+// capacity returns how many shares may be filled on the bar.
+func (c SlippageConfig) capacity(bar models.KLine) float64 {
+	if c.Model != SlippageVolume {
+		return math.Inf(1)
+	}
+	return math.Floor(bar.Volume * c.VolumeUnit * c.MaxParticipation)
+}
+
+// adjust returns the fill price for an order of the given side (1 buy,
+// -1 sell) and size after applying slippage against the trader.
+func (c SlippageConfig) adjust(price float64, side int, shares float64, bar models.KLine) float64 {
+	var offset float64
+	switch c.Model {
+	case SlippageFixedBps:
+		offset = price * c.Bps / 10000
+	case SlippageFixedTicks:
+		offset = c.Ticks * TickSize
+	case SlippageVolume:
+		participation := 0.0
+		if bar.Volume > 0 {
+			participation = shares / (bar.Volume * c.VolumeUnit)
+		}
+		offset = price * (c.Bps/10000 + c.Impact*participation)
+	}
+
+	adjusted := price + float64(side)*offset
+	if adjusted < TickSize {
+		adjusted = TickSize
+	}
+	return adjusted
+}
@@ -0,0 +1,94 @@
+package strategy
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+func TestSlippageAdjust(t *testing.T) {
+	bar := models.KLine{Volume: 1000}
+	tests := []struct {
+		name   string
+		config SlippageConfig
+		price  float64
+		side   int
+		shares float64
+		want   float64
+	}{
+		{"none", SlippageConfig{}, 10, 1, 100, 10},
+		{"fixed_bps buy", SlippageConfig{Model: SlippageFixedBps, Bps: 20}, 10, 1, 100, 10.02},
+		{"fixed_bps sell", SlippageConfig{Model: SlippageFixedBps, Bps: 20}, 10, -1, 100, 9.98},
+		{"fixed_ticks", SlippageConfig{Model: SlippageFixedTicks, Ticks: 3}, 10, -1, 100, 9.97},
+		// 2 bps plus 0.1 impact at 10% of 1000 shares of volume.
+		{"volume", SlippageConfig{Model: SlippageVolume, Bps: 2, Impact: 0.1}, 10, 1, 100, 10.102},
+		// Volume quoted in lots of 100 shares: 100 shares are 0.1% of it.
+		{"volume unit", SlippageConfig{Model: SlippageVolume, Impact: 0.1, VolumeUnit: 100}, 10, 1, 100, 10.001},
+		{"tick floor", SlippageConfig{Model: SlippageFixedTicks, Ticks: 5}, 0.03, -1, 100, TickSize},
+	}
+	for _, tt := range tests {
+		config := tt.config.normalized()
+		if got := config.adjust(tt.price, tt.side, tt.shares, bar); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: adjust = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	volume := SlippageConfig{Model: SlippageVolume, MaxParticipation: 0.25, VolumeUnit: 100}.normalized()
+	if got := volume.capacity(bar); got != 25000 {
+		t.Errorf("capacity = %v, want 25000", got)
+	}
+	if got := (SlippageConfig{Model: SlippageFixedBps}).normalized().capacity(bar); !math.IsInf(got, 1) {
+		t.Errorf("fixed_bps capacity = %v, want unlimited", got)
+	}
+}
+
+// TestVolumeSlippageCarryOver checks that fills capped at 10% of 1000
+// shares of volume carry the rest of the order to the following bars, and
+// that each partial fill pays impact on its own size.
+func TestVolumeSlippageCarryOver(t *testing.T) {
+	rows := make([][4]float64, 8)
+	for i := range rows {
+		rows[i] = [4]float64{10, 10, 10, 10}
+	}
+	bars := ohlcBars("X", rows)
+	for i := range bars {
+		bars[i].Volume = 1000
+	}
+	s := &scriptStrategy{orders: map[int][]Order{
+		0: {{StockCode: "X", Side: 1, Shares: 250}},
+		4: {{StockCode: "X", Side: -1}},
+	}}
+	opts := BacktestOptions{
+		InitialCapital: 10000,
+		Execution:      ExecNextOpen,
+		Slippage:       SlippageConfig{Model: SlippageVolume, Impact: 0.1, MaxParticipation: 0.1},
+	}
+	res, err := Run(context.Background(), map[string][]models.KLine{"X": bars}, s, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []wantTrade{
+		{"X", 1, SideBuy, 10.1, 100},
+		{"X", 2, SideBuy, 10.1, 100},
+		{"X", 3, SideBuy, 10.05, 50},
+		{"X", 5, SideSell, 9.9, 100},
+		{"X", 6, SideSell, 9.9, 100},
+		{"X", 7, SideSell, 9.95, 50},
+	}
+	if len(res.Trades) != len(want) {
+		t.Fatalf("got %d trades, want %d: %+v", len(res.Trades), len(want), res.Trades)
+	}
+	for i, w := range want {
+		got := res.Trades[i]
+		if !got.Time.Equal(bars[w.day].Time) || got.Side != w.side || math.Abs(got.Price-w.price) > 1e-9 || got.Shares != w.shares {
+			t.Errorf("trade %d = day %s %s %v x %v, want day %d %s %v x %v",
+				i, got.Time.Format("2006-01-02"), got.Side, got.Price, got.Shares, w.day, w.side, w.price, w.shares)
+		}
+	}
+	if want := 10000 - 2*(100*0.1+100*0.1+50*0.05); math.Abs(res.Final-want) > 1e-6 {
+		t.Errorf("final = %v, want %v after slippage", res.Final, want)
+	}
+}
@@ -0,0 +1,159 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/xiedonge/stock-strategy-system/backend/internal/models"
+)
+
+// WalkForwardOptions configures a walk-forward analysis.
+type WalkForwardOptions struct {
+	BacktestOptions
+	// InSampleBars is the length of each optimization slice (default 250).
+	InSampleBars int
+	// OutOfSampleBars is the length of each test slice and the distance the
+	// windows roll forward (default 60).
+	OutOfSampleBars int
+	// Anchored keeps every in-sample slice starting at the first bar
+	// instead of rolling it forward.
+	Anchored bool
+	// Objective ranks parameter sets in sample (default sharpe).
+	Objective string
+}
+
+// WalkForwardWindow is one in-sample optimization and its out-of-sample test.
+type WalkForwardWindow struct {
+	InSampleStart    time.Time          `json:"in_sample_start"`
+	InSampleEnd      time.Time          `json:"in_sample_end"`
+	OutOfSampleStart time.Time          `json:"out_of_sample_start"`
+	OutOfSampleEnd   time.Time          `json:"out_of_sample_end"`
+	Params           map[string]float64 `json:"params"`
+	InSampleScore    float64            `json:"in_sample_score"`
+	// Annualized returns of the chosen params in and out of sample.
+	InSampleAnnualPct    float64 `json:"in_sample_annual_pct"`
+	OutOfSampleAnnualPct float64 `json:"out_of_sample_annual_pct"`
+	OutOfSampleReturnPct float64 `json:"out_of_sample_return_pct"`
+}
+
+// WalkForwardResult stitches the out-of-sample slices into one curve.
+type WalkForwardResult struct {
+	Windows []WalkForwardWindow    `json:"windows"`
+	Points  []EquityPoint          `json:"points"`
+	Trades  []Trade                `json:"trades"`
+	Metrics models.BacktestMetrics `json:"metrics"`
+	// Efficiency is the mean out-of-sample annualized return divided by the
+	// mean in-sample one; zero when the in-sample mean is not positive.
+	Efficiency float64 `json:"efficiency"`
+}
+
+// WalkForward optimizes the parameter sets on each in-sample slice with
+// Backtest and runs the best one on the following out-of-sample slice.
+// Bars before opts.ReportFrom only warm up indicators. Every slice closes
+// its position on its last bar, so each out-of-sample slice starts flat
+// with the equity the previous one ended with.
+func WalkForward(ctx context.Context, klines []models.KLine, sets []ParamSet, opts WalkForwardOptions) (WalkForwardResult, error) {
+	var result WalkForwardResult
+	if len(sets) == 0 {
+		return result, errors.New("walk-forward needs at least one parameter set")
+	}
+	if opts.InitialCapital <= 0 {
+		opts.InitialCapital = 100000
+	}
+	if opts.InSampleBars <= 0 {
+		opts.InSampleBars = 250
+	}
+	if opts.OutOfSampleBars <= 0 {
+		opts.OutOfSampleBars = 60
+	}
+	if opts.Objective == "" {
+		opts.Objective = DefaultObjective
+	}
+	if _, err := ObjectiveScore(opts.Objective, 0, models.BacktestMetrics{}); err != nil {
+		return result, err
+	}
+
+	sorted := make([]models.KLine, len(klines))
+	copy(sorted, klines)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	sorted = tradingBars(sorted, opts.Interval)
+
+	warmup := 0
+	for _, set := range sets {
+		warmup = max(warmup, opts.WarmupBars(set.Params))
+	}
+	first := sort.Search(len(sorted), func(i int) bool { return !sorted[i].Time.Before(opts.ReportFrom) })
+	first = max(first, warmup)
+	if first+opts.InSampleBars >= len(sorted) {
+		return result, errors.New("not enough bars for one in-sample and out-of-sample window")
+	}
+
+	// slice runs one parameter set on bars [from, to) with warm-up before from.
+	slice := func(params MACrossoverParams, from, to int, initial float64) ([]EquityPoint, []Trade, error) {
+		engine := opts.BacktestOptions
+		engine.InitialCapital = initial
+		engine.ReportFrom = sorted[from].Time
+		engine.CloseAtEnd = true
+		engine.Progress = nil
+		_, points, trades, err := BacktestContext(ctx, sorted[max(0, from-warmup):to], params, engine)
+		return points, trades, err
+	}
+
+	equity := opts.InitialCapital
+	var inSampleSum, outOfSampleSum float64
+	for isStart, isEnd := first, first+opts.InSampleBars; isEnd < len(sorted); isEnd += opts.OutOfSampleBars {
+		if !opts.Anchored {
+			isStart = isEnd - opts.InSampleBars
+		}
+		oosEnd := min(isEnd+opts.OutOfSampleBars, len(sorted))
+
+		var best OptimizationRun
+		var bestSet ParamSet
+		for i, set := range sets {
+			points, trades, err := slice(set.Params, isStart, isEnd, opts.InitialCapital)
+			if err != nil {
+				return result, err
+			}
+			run, err := EvaluateRun(set.Values, points, trades, opts.Objective, opts.InitialCapital, opts.BarsPerYear())
+			if err != nil {
+				return result, err
+			}
+			if i == 0 || run.Score > best.Score {
+				best, bestSet = run, set
+			}
+		}
+
+		points, trades, err := slice(bestSet.Params, isEnd, oosEnd, equity)
+		if err != nil {
+			return result, err
+		}
+		oos, _ := EvaluateRun(bestSet.Values, points, trades, opts.Objective, equity, opts.BarsPerYear())
+		result.Windows = append(result.Windows, WalkForwardWindow{
+			InSampleStart:        sorted[isStart].Time,
+			InSampleEnd:          sorted[isEnd-1].Time,
+			OutOfSampleStart:     sorted[isEnd].Time,
+			OutOfSampleEnd:       sorted[oosEnd-1].Time,
+			Params:               bestSet.Values,
+			InSampleScore:        best.Score,
+			InSampleAnnualPct:    best.Metrics.AnnualReturnPct,
+			OutOfSampleAnnualPct: oos.Metrics.AnnualReturnPct,
+			OutOfSampleReturnPct: oos.ReturnPct,
+		})
+		inSampleSum += best.Metrics.AnnualReturnPct
+		outOfSampleSum += oos.Metrics.AnnualReturnPct
+
+		result.Points = append(result.Points, points...)
+		result.Trades = append(result.Trades, trades...)
+		if len(points) > 0 {
+			equity = points[len(points)-1].Equity
+		}
+	}
+
+	result.Metrics = ComputeMetrics(result.Points, result.Trades, opts.InitialCapital, opts.BarsPerYear())
+	if inSampleSum > 0 {
+		result.Efficiency = outOfSampleSum / inSampleSum
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package strategy
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+// TestWalkForwardWindows checks the window bounds of rolling and anchored
+// runs and that the out-of-sample slices stitch into one curve. With 60
+// bars, a 5-bar warm-up, 20 in-sample bars and 10 out-of-sample bars the
+// tests start on bars 25, 35, 45 and 55, the last one cut to 5 bars.
+func TestWalkForwardWindows(t *testing.T) {
+	closes := make([]float64, 60)
+	for i := range closes {
+		closes[i] = math.Round((50+10*math.Sin(float64(i)/3))*100) / 100
+	}
+	bars := closeBars(closes)
+	sets := []ParamSet{
+		{Values: map[string]float64{"short_window": 2}, Params: MACrossoverParams{ShortWindow: 2, LongWindow: 4}},
+		{Values: map[string]float64{"short_window": 3}, Params: MACrossoverParams{ShortWindow: 3, LongWindow: 4}},
+	}
+
+	for _, anchored := range []bool{false, true} {
+		res, err := WalkForward(context.Background(), bars, sets, WalkForwardOptions{
+			BacktestOptions: BacktestOptions{InitialCapital: 100000, Execution: ExecNextOpen},
+			InSampleBars:    20,
+			OutOfSampleBars: 10,
+			Anchored:        anchored,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		starts := []int{25, 35, 45, 55}
+		if len(res.Windows) != len(starts) {
+			t.Fatalf("anchored %v: got %d windows, want %d", anchored, len(res.Windows), len(starts))
+		}
+		for i, w := range res.Windows {
+			isStart := starts[i] - 20
+			if anchored {
+				isStart = 5
+			}
+			oosEnd := min(starts[i]+10, len(bars))
+			if !w.InSampleStart.Equal(bars[isStart].Time) || !w.InSampleEnd.Equal(bars[starts[i]-1].Time) ||
+				!w.OutOfSampleStart.Equal(bars[starts[i]].Time) || !w.OutOfSampleEnd.Equal(bars[oosEnd-1].Time) {
+				t.Errorf("anchored %v: window %d = %s..%s / %s..%s, want bars %d..%d / %d..%d", anchored, i,
+					w.InSampleStart.Format("01-02"), w.InSampleEnd.Format("01-02"), w.OutOfSampleStart.Format("01-02"), w.OutOfSampleEnd.Format("01-02"),
+					isStart, starts[i]-1, starts[i], oosEnd-1)
+			}
+		}
+
+		// One point per out-of-sample bar, in order, each slice starting
+		// flat from the equity the previous one ended with.
+		if len(res.Points) != len(bars)-starts[0] {
+			t.Fatalf("anchored %v: got %d points, want %d", anchored, len(res.Points), len(bars)-starts[0])
+		}
+		for i, point := range res.Points {
+			if !point.Time.Equal(bars[starts[0]+i].Time) {
+				t.Fatalf("anchored %v: point %d at %s, want bar %d", anchored, i, point.Time.Format("01-02"), starts[0]+i)
+			}
+		}
+		previous := 100000.0
+		for i, w := range res.Windows {
+			from := starts[i] - starts[0]
+			to := min(from+10, len(res.Points))
+			if got := res.Points[from].Equity; math.Abs(got-previous) > 1e-6 {
+				t.Errorf("anchored %v: window %d starts at %v, want %v", anchored, i, got, previous)
+			}
+			end := res.Points[to-1].Equity
+			if want := (end/previous - 1) * 100; math.Abs(w.OutOfSampleReturnPct-want) > 1e-9 {
+				t.Errorf("anchored %v: window %d returned %v%%, want %v%%", anchored, i, w.OutOfSampleReturnPct, want)
+			}
+			previous = end
+		}
+
+		// Every slice trades only in its own bars and ends flat.
+		position := 0.0
+		window := 0
+		for _, trade := range res.Trades {
+			for window+1 < len(starts) && !trade.Time.Before(bars[starts[window+1]].Time) {
+				if position != 0 {
+					t.Errorf("anchored %v: window %d ends holding %v shares", anchored, window, position)
+				}
+				window++
+			}
+			if trade.Time.Before(bars[starts[0]].Time) {
+				t.Errorf("anchored %v: trade in sample at %s", anchored, trade.Time.Format("01-02"))
+			}
+			switch trade.Side {
+			case SideBuy:
+				position += trade.Shares
+			case SideSell:
+				position -= trade.Shares
+			}
+		}
+		if len(res.Trades) == 0 || position != 0 {
+			t.Errorf("anchored %v: %d trades ending with %v shares, want trades ending flat", anchored, len(res.Trades), position)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package tabular
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// parquetMagic opens and closes every Parquet file.
+const parquetMagic = "PAR1"
+
+// parquetGroupRows is the number of rows buffered per row group.
+const parquetGroupRows = 64 * 1024
+
+// Parquet enum values from the format's Thrift definitions.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetUTF8     = 0 // converted type
+	parquetPlain    = 0
+	parquetRLE      = 3
+	parquetGzip     = 2
+	parquetDataPage = 0
+)
+
+// parquetWriter writes required, non-nested columns, one gzip-compressed
+// PLAIN data page per column and row group. Strings are UTF8 byte arrays,
+// floats doubles, integers int64s, and times int64 milliseconds with a
+// TIMESTAMP type not adjusted to UTC, as they are wall-clock times.
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []Column
+	pages   []bytes.Buffer
+	rows    int
+	total   int64
+	groups  []parquetGroup
+	scratch [8]byte
+}
+
+type parquetGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+type parquetChunk struct {
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+func newParquetWriter(w io.Writer, columns []Column) (*parquetWriter, error) {
+	writer := &parquetWriter{w: w, columns: columns, pages: make([]bytes.Buffer, len(columns))}
+	if err := writer.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (p *parquetWriter) Write(row []any) error {
+	if len(row) != len(p.columns) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(p.columns))
+	}
+	for i, column := range p.columns {
+		page := &p.pages[i]
+		switch column.Type {
+		case String:
+			value, ok := row[i].(string)
+			if !ok {
+				return columnError(column, row[i])
+			}
+			binary.LittleEndian.PutUint32(p.scratch[:4], uint32(len(value)))
+			page.Write(p.scratch[:4])
+			page.WriteString(value)
+		case Int:
+			value, ok := intValue(row[i])
+			if !ok {
+				return columnError(column, row[i])
+			}
+			p.int64(page, value)
+		case Float:
+			value, ok := row[i].(float64)
+			if !ok {
+				return columnError(column, row[i])
+			}
+			p.int64(page, int64(math.Float64bits(value)))
+		case Time:
+			value, ok := row[i].(time.Time)
+			if !ok {
+				return columnError(column, row[i])
+			}
+			p.int64(page, value.UnixMilli())
+		}
+	}
+	p.rows++
+	if p.rows == parquetGroupRows {
+		return p.flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) int64(page *bytes.Buffer, value int64) {
+	binary.LittleEndian.PutUint64(p.scratch[:], uint64(value))
+	page.Write(p.scratch[:])
+}
+
+// flush writes the buffered rows as a row group.
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	group := parquetGroup{rows: int64(p.rows), chunks: make([]parquetChunk, len(p.columns))}
+	var compressed bytes.Buffer
+	for i := range p.columns {
+		compressed.Reset()
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(p.pages[i].Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		var header thriftWriter
+		header.begin()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(p.pages[i].Len()))
+		header.i32(3, int32(compressed.Len()))
+		header.structField(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunk := parquetChunk{
+			offset:       p.offset,
+			uncompressed: int64(header.Len() + p.pages[i].Len()),
+			compressed:   int64(header.Len() + compressed.Len()),
+		}
+		if err := p.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(compressed.Bytes()); err != nil {
+			return err
+		}
+		group.chunks[i] = chunk
+		group.size += chunk.uncompressed
+		p.pages[i].Reset()
+	}
+	p.groups = append(p.groups, group)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// Close flushes the last row group and writes the footer.
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.begin()
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(p.columns)+1)
+	meta.begin()
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.end()
+	for _, column := range p.columns {
+		p.schemaElement(&meta, column)
+	}
+	meta.i64(3, p.total)
+	meta.list(4, thriftStruct, len(p.groups))
+	for _, group := range p.groups {
+		meta.begin()
+		meta.list(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			meta.begin()
+			meta.i64(2, chunk.offset)
+			meta.structField(3)
+			meta.i32(1, parquetPhysical(p.columns[i].Type))
+			meta.list(2, thriftI32, 1)
+			meta.zigzag(parquetPlain)
+			meta.list(3, thriftBinary, 1)
+			meta.varint(uint64(len(p.columns[i].Name)))
+			meta.WriteString(p.columns[i].Name)
+			meta.i32(4, parquetGzip)
+			meta.i64(5, group.rows)
+			meta.i64(6, chunk.uncompressed)
+			meta.i64(7, chunk.compressed)
+			meta.i64(9, chunk.offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, group.size)
+		meta.i64(3, group.rows)
+		meta.end()
+	}
+	meta.str(6, "stock-strategy-system")
+	meta.end()
+
+	if err := p.write(meta.Bytes()); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(p.scratch[:4], uint32(meta.Len()))
+	if err := p.write(p.scratch[:4]); err != nil {
+		return err
+	}
+	return p.write([]byte(parquetMagic))
+}
+
+// schemaElement describes a column as a required leaf of the root schema.
+func (p *parquetWriter) schemaElement(meta *thriftWriter, column Column) {
+	meta.begin()
+	meta.i32(1, parquetPhysical(column.Type))
+	meta.i32(3, parquetRequired)
+	meta.str(4, column.Name)
+	switch column.Type {
+	case String:
+		meta.i32(6, parquetUTF8)
+		meta.structField(10) // LogicalType
+		meta.structField(1)  // STRING
+		meta.end()
+		meta.end()
+	case Time:
+		meta.structField(10) // LogicalType
+		meta.structField(8)  // TIMESTAMP
+		meta.boolean(1, false)
+		meta.structField(2) // unit
+		meta.structField(1) // MILLIS
+		meta.end()
+		meta.end()
+		meta.end()
+		meta.end()
+	}
+	meta.end()
+}
+
+func parquetPhysical(kind ColumnType) int32 {
+	switch kind {
+	case String:
+		return parquetByteArray
+	case Float:
+		return parquetDouble
+	default:
+		return parquetInt64
+	}
+}
+
+func (p *parquetWriter) write(data []byte) error {
+	n, err := p.w.Write(data)
+	p.offset += int64(n)
+	return err
+}
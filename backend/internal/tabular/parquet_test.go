@@ -0,0 +1,374 @@
+package tabular
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// thriftReader decodes the Thrift compact protocol into generic values:
+// structs as maps from field id, lists as slices, integers as int64,
+// booleans and binaries as strings.
+type thriftReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.t.Fatalf("thrift data ends at %d", r.pos)
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("bad varint at %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]any {
+	fields := make(map[int16]any)
+	var id int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		kind := header & 0x0f
+		switch kind {
+		case thriftTrue, thriftFalse:
+			fields[id] = kind == thriftTrue
+		default:
+			fields[id] = r.value(kind)
+		}
+	}
+}
+
+func (r *thriftReader) value(kind byte) any {
+	switch kind {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		r.pos += n
+		if r.pos > len(r.data) {
+			r.t.Fatalf("binary of %d bytes ends past the data", n)
+		}
+		return string(r.data[r.pos-n : r.pos])
+	case thriftList:
+		header := r.byte()
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.t.Fatalf("unexpected thrift type %d at %d", kind, r.pos)
+	return nil
+}
+
+func TestThriftWriter(t *testing.T) {
+	var w thriftWriter
+	w.begin()
+	w.i32(1, -7)
+	w.boolean(2, true)
+	w.i64(40, 1<<40) // a field id jump past 15
+	w.structField(41)
+	w.boolean(1, false)
+	w.str(2, "行情")
+	w.end()
+	w.list(42, thriftI32, 20)
+	for i := 0; i < 20; i++ {
+		w.zigzag(int64(-i))
+	}
+	w.end()
+
+	r := &thriftReader{t: t, data: w.Bytes()}
+	got := r.readStruct()
+	long := make([]any, 20)
+	for i := range long {
+		long[i] = int64(-i)
+	}
+	want := map[int16]any{
+		1:  int64(-7),
+		2:  true,
+		40: int64(1 << 40),
+		41: map[int16]any{1: false, 2: "行情"},
+		42: long,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+	if r.pos != w.Len() {
+		t.Errorf("decoded %d of %d bytes", r.pos, w.Len())
+	}
+}
+
+var parquetColumns = []Column{
+	{Name: "code", Type: String},
+	{Name: "time", Type: Time},
+	{Name: "close", Type: Float},
+	{Name: "volume", Type: Int},
+}
+
+// parquetFooter checks a file's magic numbers and returns its decoded
+// FileMetaData and the offset the footer starts at.
+func parquetFooter(t *testing.T, file []byte) (map[int16]any, int) {
+	t.Helper()
+	if len(file) < 12 || string(file[:4]) != parquetMagic || string(file[len(file)-4:]) != parquetMagic {
+		t.Fatalf("file of %d bytes lacks the PAR1 magic", len(file))
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	start := len(file) - 8 - size
+	if start < 4 {
+		t.Fatalf("footer of %d bytes in a file of %d", size, len(file))
+	}
+	r := &thriftReader{t: t, data: file[start : len(file)-8]}
+	meta := r.readStruct()
+	if r.pos != size {
+		t.Errorf("footer metadata is %d bytes, length says %d", r.pos, size)
+	}
+	return meta, start
+}
+
+func writeParquet(t *testing.T, rows int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Parquet, parquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < rows; i++ {
+		if err := w.Write(parquetRow(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func parquetRow(i int) []any {
+	code := "600519"
+	if i%3 == 0 {
+		code = "000001,中文"
+	}
+	return []any{
+		code,
+		time.Date(2024, 6, 19, 9, 30, 0, 0, time.UTC).Add(time.Duration(i) * time.Minute),
+		float64(i) / 4,
+		i * 100,
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	const rows = parquetGroupRows + 10
+	file := writeParquet(t, rows)
+	meta, footer := parquetFooter(t, file)
+
+	schema := []any{
+		map[int16]any{4: "schema", 5: int64(4)},
+		map[int16]any{1: int64(parquetByteArray), 3: int64(parquetRequired), 4: "code", 6: int64(parquetUTF8),
+			10: map[int16]any{1: map[int16]any{}}},
+		map[int16]any{1: int64(parquetInt64), 3: int64(parquetRequired), 4: "time",
+			10: map[int16]any{8: map[int16]any{1: false, 2: map[int16]any{1: map[int16]any{}}}}},
+		map[int16]any{1: int64(parquetDouble), 3: int64(parquetRequired), 4: "close"},
+		map[int16]any{1: int64(parquetInt64), 3: int64(parquetRequired), 4: "volume"},
+	}
+	if !reflect.DeepEqual(meta[2], schema) {
+		t.Errorf("schema %v,\nwant %v", meta[2], schema)
+	}
+	if meta[1] != int64(1) || meta[3] != int64(rows) || meta[6] != "stock-strategy-system" {
+		t.Errorf("version %v, rows %v, created by %v; want 1, %d and the system", meta[1], meta[3], meta[6], rows)
+	}
+
+	groups, _ := meta[4].([]any)
+	if len(groups) != 2 {
+		t.Fatalf("%d row groups, want 2", len(groups))
+	}
+	// Column chunks follow one another from the leading magic to the footer.
+	offset := int64(len(parquetMagic))
+	for g, wantRows := range []int64{parquetGroupRows, 10} {
+		group := groups[g].(map[int16]any)
+		if group[3] != wantRows {
+			t.Errorf("group %d: %v rows, want %d", g, group[3], wantRows)
+		}
+		chunks := group[1].([]any)
+		if len(chunks) != len(parquetColumns) {
+			t.Fatalf("group %d: %d column chunks", g, len(chunks))
+		}
+		var size int64
+		for i, column := range parquetColumns {
+			chunk := chunks[i].(map[int16]any)
+			data := chunk[3].(map[int16]any)
+			if chunk[2] != offset || data[9] != offset {
+				t.Errorf("group %d %s: offsets %v and %v, want %d", g, column.Name, chunk[2], data[9], offset)
+			}
+			if data[1] != int64(parquetPhysical(column.Type)) || !reflect.DeepEqual(data[3], []any{column.Name}) ||
+				data[4] != int64(parquetGzip) || data[5] != wantRows {
+				t.Errorf("group %d %s: column metadata %v", g, column.Name, data)
+			}
+			compressed, uncompressed := data[7].(int64), data[6].(int64)
+			value := parquetPage(t, file, column, offset, compressed, uncompressed, wantRows)
+			if first := parquetRow(g * parquetGroupRows)[i]; value != parquetValue(first) {
+				t.Errorf("group %d %s: first value %v, want %v", g, column.Name, value, first)
+			}
+			offset += compressed
+			size += uncompressed
+		}
+		if group[2] != size {
+			t.Errorf("group %d: total byte size %v, want %d", g, group[2], size)
+		}
+	}
+	if offset != int64(footer) {
+		t.Errorf("column chunks end at %d, footer starts at %d", offset, footer)
+	}
+}
+
+// parquetPage decodes the data page of a column chunk, checking its sizes
+// against the chunk's, and returns its first value.
+func parquetPage(t *testing.T, file []byte, column Column, offset, compressed, uncompressed, rows int64) any {
+	t.Helper()
+	r := &thriftReader{t: t, data: file[offset : offset+compressed]}
+	header := r.readStruct()
+	page := file[offset+int64(r.pos) : offset+compressed]
+	if header[1] != int64(parquetDataPage) || header[3] != int64(len(page)) || header[2] != uncompressed-int64(r.pos) {
+		t.Fatalf("page header %v for a page of %d bytes and a chunk of %d uncompressed", header, len(page), uncompressed)
+	}
+	data := header[5].(map[int16]any)
+	if data[1] != rows || data[2] != int64(parquetPlain) {
+		t.Errorf("data page header %v, want %d PLAIN values", data, rows)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(plain)) != header[2] {
+		t.Errorf("page unzips to %d bytes, header says %v", len(plain), header[2])
+	}
+	if column.Type == String {
+		n := binary.LittleEndian.Uint32(plain)
+		return string(plain[4 : 4+n])
+	}
+	return binary.LittleEndian.Uint64(plain)
+}
+
+// parquetValue is how a written value is stored in a PLAIN page.
+func parquetValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return uint64(v.UnixMilli())
+	case float64:
+		return math.Float64bits(v)
+	case int:
+		return uint64(v)
+	}
+	return nil
+}
+
+// TestParquetWriterReadBack reads a written file with parquet-go, an
+// independent implementation of the format, which decodes every page.
+func TestParquetWriterReadBack(t *testing.T) {
+	const rows = parquetGroupRows + 10
+	file := writeParquet(t, rows)
+	f, err := parquet.OpenFile(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := `message schema {
+	required binary code (STRING);
+	required int64 time (TIMESTAMP(isAdjustedToUTC=false,unit=MILLIS));
+	required double close;
+	required int64 volume (INT(64,true));
+}`
+	if got := f.Schema().String(); got != schema {
+		t.Errorf("schema\n%s\nwant\n%s", got, schema)
+	}
+	if f.NumRows() != rows || len(f.RowGroups()) != 2 {
+		t.Errorf("%d rows in %d row groups, want %d in 2", f.NumRows(), len(f.RowGroups()), rows)
+	}
+
+	type exported struct {
+		Code   string    `parquet:"code"`
+		Time   time.Time `parquet:"time,timestamp(millisecond)"`
+		Close  float64   `parquet:"close"`
+		Volume int64     `parquet:"volume"`
+	}
+	reader := parquet.NewGenericReader[exported](bytes.NewReader(file))
+	defer reader.Close()
+	got := make([]exported, rows+1)
+	n, err := reader.Read(got)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if n != rows {
+		t.Fatalf("read %d rows, want %d", n, rows)
+	}
+	for i, row := range got[:n] {
+		want := parquetRow(i)
+		if row.Code != want[0] || !row.Time.Equal(want[1].(time.Time)) || row.Close != want[2] || row.Volume != int64(want[3].(int)) {
+			t.Fatalf("row %d = %+v, want %v", i, row, want)
+		}
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	meta, footer := parquetFooter(t, writeParquet(t, 0))
+	if footer != len(parquetMagic) || meta[3] != int64(0) || len(meta[4].([]any)) != 0 || len(meta[2].([]any)) != len(parquetColumns)+1 {
+		t.Errorf("empty file: footer at %d, metadata %v", footer, meta)
+	}
+}
+
+func TestParquetWriterErrors(t *testing.T) {
+	w, err := NewWriter(io.Discard, Parquet, parquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]any{
+		{"600519"},
+		{600519, time.Now(), 1.5, 1},
+		{"600519", "2024-06-19", 1.5, 1},
+		{"600519", time.Now(), 1, 1},
+		{"600519", time.Now(), 1.5, 1.5},
+	} {
+		if err := w.Write(row); err == nil {
+			t.Errorf("wrote row %v", row)
+		}
+	}
+}
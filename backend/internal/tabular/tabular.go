@@ -0,0 +1,119 @@
+// Package tabular reads tables from CSV and XLSX files and writes them as
+// CSV or Parquet.
+package tabular
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Supported formats.
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+)
+
+// ErrTooLarge marks a workbook beyond the limits of what is read into
+// memory.
+var ErrTooLarge = errors.New("file too large")
+
+// RowReader returns one row of cells per call and io.EOF after the last.
+type RowReader interface {
+	Read() ([]string, error)
+	// Row returns the file's number, from 1, of the row last read.
+	Row() int
+}
+
+// ParseFormat normalizes a format name, inferring it from the file name's
+// extension when empty.
+func ParseFormat(raw, filename string) (string, error) {
+	if raw == "" {
+		raw = strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+	switch format := strings.ToLower(raw); format {
+	case CSV, XLSX:
+		return format, nil
+	case "":
+		return "", fmt.Errorf("unknown file format of %q", filename)
+	default:
+		return "", fmt.Errorf("unsupported file format %q", raw)
+	}
+}
+
+// Open reads r as format. CSV is streamed, with any UTF-8 byte order mark
+// dropped and rows of any length allowed; an XLSX workbook is read whole
+// and the named sheet, or the first one, returned.
+func Open(r io.Reader, format, sheet string) (RowReader, error) {
+	switch format {
+	case CSV:
+		reader := csv.NewReader(skipBOM(r))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		reader.ReuseRecord = true
+		return &csvReader{Reader: reader}, nil
+	case XLSX:
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := readXLSX(bytes.NewReader(raw), int64(len(raw)), sheet)
+		if err != nil {
+			return nil, err
+		}
+		return &sliceReader{rows: rows}, nil
+	default:
+		return nil, fmt.Errorf("unsupported file format %q", format)
+	}
+}
+
+// skipBOM drops the byte order mark spreadsheet programs write at the
+// start of UTF-8 CSV files.
+func skipBOM(r io.Reader) io.Reader {
+	buffered := make([]byte, 3)
+	n, _ := io.ReadFull(r, buffered)
+	if n == 3 && bytes.Equal(buffered, []byte("\xef\xbb\xbf")) {
+		return r
+	}
+	return io.MultiReader(bytes.NewReader(buffered[:n]), r)
+}
+
+// csvReader numbers records by the line they start on, as editors show
+// them, since csv.Reader skips empty lines and quoted fields span lines.
+type csvReader struct {
+	*csv.Reader
+	row int
+}
+
+func (c *csvReader) Read() ([]string, error) {
+	record, err := c.Reader.Read()
+	if err == nil {
+		c.row, _ = c.Reader.FieldPos(0)
+	}
+	return record, err
+}
+
+func (c *csvReader) Row() int {
+	return c.row
+}
+
+type sliceReader struct {
+	rows [][]string
+	next int
+}
+
+func (s *sliceReader) Read() ([]string, error) {
+	if s.next >= len(s.rows) {
+		return nil, io.EOF
+	}
+	s.next++
+	return s.rows[s.next-1], nil
+}
+
+func (s *sliceReader) Row() int {
+	return s.next
+}
@@ -0,0 +1,93 @@
+package tabular
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		raw, filename, want string
+	}{
+		{"", "bars.csv", CSV},
+		{"", "行情.XLSX", XLSX},
+		{"CSV", "bars.xlsx", CSV},
+		{"", "bars", ""},
+		{"", "bars.xls", ""},
+		{"json", "bars.csv", ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.raw, tt.filename)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("ParseFormat(%q, %q) = %q, %v; want %q", tt.raw, tt.filename, got, err, tt.want)
+		}
+	}
+}
+
+type readRow struct {
+	row   int
+	cells []string
+}
+
+func readAll(t *testing.T, r RowReader) []readRow {
+	t.Helper()
+	var rows []readRow
+	for {
+		cells, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, readRow{r.Row(), append([]string(nil), cells...)})
+	}
+}
+
+func TestOpenCSV(t *testing.T) {
+	raw := "\xef\xbb\xbfcode,time,close\n600519, 2024-06-19,1570\n\n\"000001\",\"2024-06-19\n15:00\",10.2,extra\n600000\n"
+	r, err := Open(strings.NewReader(raw), CSV, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The byte order mark is dropped, the blank line skipped and records
+	// numbered by the line they start on, whatever their length.
+	want := []readRow{
+		{1, []string{"code", "time", "close"}},
+		{2, []string{"600519", "2024-06-19", "1570"}},
+		{4, []string{"000001", "2024-06-19\n15:00", "10.2", "extra"}},
+		{6, []string{"600000"}},
+	}
+	if got := readAll(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+
+	// Files shorter than a byte order mark are read whole.
+	r, _ = Open(strings.NewReader("a"), CSV, "")
+	if got := readAll(t, r); !reflect.DeepEqual(got, []readRow{{1, []string{"a"}}}) {
+		t.Errorf("short file: rows = %q", got)
+	}
+}
+
+func TestOpenXLSX(t *testing.T) {
+	raw := testXLSX(t, map[string]string{"xl/worksheets/sheet1.xml": sheetXML(
+		`<row r="1"><c r="A1" t="s"><v>0</v></c></row><row r="3"><c r="B3"><v>1</v></c></row>`)})
+	r, err := Open(bytes.NewReader(raw), XLSX, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rows are numbered as in the sheet, the missing one returned empty.
+	want := []readRow{{1, []string{"日期"}}, {2, nil}, {3, []string{"", "1"}}}
+	if got := readAll(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+	if _, err := Open(strings.NewReader("a,b"), XLSX, ""); err == nil {
+		t.Error("opened a CSV file as a workbook")
+	}
+	if _, err := Open(strings.NewReader("a,b"), "xls", ""); err == nil {
+		t.Error("opened an unsupported format")
+	}
+}
@@ -0,0 +1,145 @@
+package tabular
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parquet is a format tables can be written in but not read.
+const Parquet = "parquet"
+
+// TimeLayout is the form times are written in to CSV, readable by Open's
+// callers such as the kline import.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// ColumnType is the type of a written column's values.
+type ColumnType int
+
+// Column types and the Go values rows hold for them.
+const (
+	String ColumnType = iota // string
+	Int                      // int, int64 or uint
+	Float                    // float64; NaN is written as an empty CSV cell
+	Time                     // time.Time, a wall-clock time in UTC
+)
+
+// Column names and types a column of a written table.
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Writer writes a table one row at a time. Close must be called to finish
+// the file; it does not close the underlying writer.
+type Writer interface {
+	Write(row []any) error
+	Close() error
+}
+
+// ParseOutputFormat normalizes a format tables can be written in, CSV by
+// default.
+func ParseOutputFormat(raw string) (string, error) {
+	switch format := strings.ToLower(raw); format {
+	case "":
+		return CSV, nil
+	case CSV, Parquet:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q", raw)
+	}
+}
+
+// NewWriter starts a table of columns in format on w. Rows are written
+// through as they come for CSV and in row groups for Parquet, so memory
+// stays bounded however many rows are written.
+func NewWriter(w io.Writer, format string, columns []Column) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w, columns)
+	case Parquet:
+		return newParquetWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+type csvWriter struct {
+	csv     *csv.Writer
+	columns []Column
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	writer := &csvWriter{csv: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	for i, column := range columns {
+		writer.record[i] = column.Name
+	}
+	if err := writer.csv.Write(writer.record); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (c *csvWriter) Write(row []any) error {
+	if len(row) != len(c.columns) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(c.columns))
+	}
+	for i, column := range c.columns {
+		switch column.Type {
+		case String:
+			value, ok := row[i].(string)
+			if !ok {
+				return columnError(column, row[i])
+			}
+			c.record[i] = value
+		case Int:
+			value, ok := intValue(row[i])
+			if !ok {
+				return columnError(column, row[i])
+			}
+			c.record[i] = strconv.FormatInt(value, 10)
+		case Float:
+			value, ok := row[i].(float64)
+			if !ok {
+				return columnError(column, row[i])
+			}
+			c.record[i] = ""
+			if !math.IsNaN(value) {
+				c.record[i] = strconv.FormatFloat(value, 'f', -1, 64)
+			}
+		case Time:
+			value, ok := row[i].(time.Time)
+			if !ok {
+				return columnError(column, row[i])
+			}
+			c.record[i] = value.UTC().Format(TimeLayout)
+		}
+	}
+	return c.csv.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.csv.Flush()
+	return c.csv.Error()
+}
+
+func intValue(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func columnError(column Column, value any) error {
+	return fmt.Errorf("column %s: unexpected value %v (%T)", column.Name, value, value)
+}
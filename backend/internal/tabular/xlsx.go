@@ -0,0 +1,308 @@
+package tabular
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateLayout is the form XLSX date cells are returned in.
+const DateLayout = "2006-01-02 15:04:05"
+
+// Limits of a workbook, which is read whole: the sheet dimensions of
+// Excel, the unpacked size of each XML part and the cells of the sheet,
+// counting those that pad rows out to their last column.
+const (
+	maxXLSXColumns  = 16384 // XFD
+	maxXLSXRows     = 1048576
+	maxXLSXPartSize = 128 << 20
+	maxXLSXCells    = 1 << 22
+)
+
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Items []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	var b strings.Builder
+	b.WriteString(t.Text)
+	for _, run := range t.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Index int `xml:"r,attr"`
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Style  int      `xml:"s,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX returns the cells of a worksheet as text: shared and inline
+// strings as written, numbers in their stored form and date-formatted
+// numbers as DateLayout. Empty cells and rows between filled ones are
+// returned empty, so rows keep their sheet numbers.
+func readXLSX(r io.ReaderAt, size int64, sheet string) ([][]string, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("open xlsx: %w", err)
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var workbook xlsxWorkbook
+	if err := decodeXML(files, "xl/workbook.xml", &workbook, true); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err := decodeXML(files, "xl/_rels/workbook.xml.rels", &rels, true); err != nil {
+		return nil, err
+	}
+	var shared xlsxSharedStrings
+	if err := decodeXML(files, "xl/sharedStrings.xml", &shared, false); err != nil {
+		return nil, err
+	}
+	var styles xlsxStyles
+	if err := decodeXML(files, "xl/styles.xml", &styles, false); err != nil {
+		return nil, err
+	}
+
+	target, err := sheetTarget(workbook, rels, sheet)
+	if err != nil {
+		return nil, err
+	}
+	var data xlsxSheet
+	if err := decodeXML(files, target, &data, true); err != nil {
+		return nil, err
+	}
+
+	dates := dateStyles(styles)
+	rows := make([][]string, 0, len(data.Rows))
+	cellCount := 0
+	for _, row := range data.Rows {
+		if row.Index > maxXLSXRows {
+			return nil, fmt.Errorf("xlsx row %d is beyond row %d", row.Index, maxXLSXRows)
+		}
+		for row.Index > len(rows)+1 {
+			rows = append(rows, nil)
+		}
+		var cells []string
+		for _, cell := range row.Cells {
+			column := len(cells)
+			if cell.Ref != "" {
+				if column, err = columnIndex(cell.Ref); err != nil {
+					return nil, err
+				}
+			}
+			for len(cells) < column {
+				cells = append(cells, "")
+			}
+
+			var text string
+			switch cell.Type {
+			case "s":
+				i, err := strconv.Atoi(cell.Value)
+				if err != nil || i < 0 || i >= len(shared.Items) {
+					return nil, fmt.Errorf("xlsx cell %s: bad shared string %q", cell.Ref, cell.Value)
+				}
+				text = shared.Items[i].String()
+			case "inlineStr":
+				text = cell.Inline.String()
+			case "", "n":
+				text = cell.Value
+				if cell.Style < len(dates) && dates[cell.Style] && text != "" {
+					if serial, err := strconv.ParseFloat(text, 64); err == nil {
+						text = serialTime(serial, workbook.Properties.Date1904).Format(DateLayout)
+					}
+				}
+			default:
+				text = cell.Value
+			}
+			if column < len(cells) {
+				cells[column] = text
+			} else {
+				cells = append(cells, text)
+			}
+		}
+		if cellCount += len(cells); cellCount > maxXLSXCells {
+			return nil, fmt.Errorf("%w: xlsx sheet has more than %d cells", ErrTooLarge, maxXLSXCells)
+		}
+		rows = append(rows, cells)
+	}
+	return rows, nil
+}
+
+// sheetTarget resolves the archive path of the named sheet, or of the first
+// sheet when name is empty.
+func sheetTarget(workbook xlsxWorkbook, rels xlsxRelationships, name string) (string, error) {
+	if len(workbook.Sheets) == 0 {
+		return "", fmt.Errorf("xlsx has no sheets")
+	}
+	id := workbook.Sheets[0].ID
+	if name != "" {
+		id = ""
+		for _, sheet := range workbook.Sheets {
+			if sheet.Name == name {
+				id = sheet.ID
+			}
+		}
+		if id == "" {
+			return "", fmt.Errorf("xlsx has no sheet %q", name)
+		}
+	}
+	for _, rel := range rels.Items {
+		if rel.ID == id {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+	}
+	return "", fmt.Errorf("xlsx sheet %s not found", id)
+}
+
+func decodeXML(files map[string]*zip.File, name string, out any, required bool) error {
+	file, ok := files[name]
+	if !ok {
+		if required {
+			return fmt.Errorf("xlsx is missing %s", name)
+		}
+		return nil
+	}
+	// The zip reader fails parts that unpack to more than they declare.
+	if file.UncompressedSize64 > maxXLSXPartSize {
+		return fmt.Errorf("%w: xlsx part %s unpacks to %d bytes, more than %d", ErrTooLarge, name, file.UncompressedSize64, maxXLSXPartSize)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := xml.NewDecoder(reader).Decode(out); err != nil {
+		return fmt.Errorf("parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// dateStyles reports, per cell style, whether its number format shows a
+// date or time: one of the built-in date formats, including those of the
+// Chinese locale, or a custom format using date or time tokens.
+func dateStyles(styles xlsxStyles) []bool {
+	custom := make(map[int]string, len(styles.NumFmts))
+	for _, format := range styles.NumFmts {
+		custom[format.ID] = format.Code
+	}
+	dates := make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		id := xf.NumFmtID
+		if code, ok := custom[id]; ok {
+			dates[i] = isDateFormat(code)
+			continue
+		}
+		dates[i] = (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+	}
+	return dates
+}
+
+// isDateFormat looks for date tokens outside quoted text, escapes and
+// bracketed colors or locales.
+func isDateFormat(code string) bool {
+	var quoted, bracketed bool
+	for i := 0; i < len(code); i++ {
+		switch ch := code[i]; {
+		case ch == '"':
+			quoted = !quoted
+		case quoted:
+		case ch == '\\':
+			i++
+		case ch == '[':
+			bracketed = true
+		case ch == ']':
+			bracketed = false
+		case bracketed:
+		case strings.IndexByte("yYmMdDhHsS", ch) >= 0:
+			return true
+		}
+	}
+	return false
+}
+
+// serialTime converts an Excel serial date, days since 1899-12-30 (or
+// 1904-01-01), to a time rounded to the second.
+func serialTime(serial float64, date1904 bool) time.Time {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	seconds := math.Round(serial * 24 * 60 * 60)
+	return epoch.Add(time.Duration(seconds) * time.Second)
+}
+
+// columnIndex returns the zero-based column of a cell reference such as C7,
+// up to XFD.
+func columnIndex(ref string) (int, error) {
+	column := 0
+	for _, ch := range ref {
+		if ch >= 'A' && ch <= 'Z' {
+			column = column*26 + int(ch-'A'+1)
+			if column > maxXLSXColumns {
+				return 0, fmt.Errorf("xlsx cell %q is beyond column XFD", ref)
+			}
+			continue
+		}
+		break
+	}
+	if column == 0 {
+		return 0, fmt.Errorf("xlsx cell has bad reference %q", ref)
+	}
+	return column - 1, nil
+}
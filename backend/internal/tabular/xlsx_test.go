@@ -0,0 +1,315 @@
+package tabular
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<workbookPr%s/><sheets><sheet name="行情" sheetId="1" r:id="rId1"/><sheet name="Other" sheetId="2" r:id="rId2"/></sheets></workbook>`
+	testRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet2.xml"/>
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+	testSharedStrings = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" count="3" uniqueCount="3">
+<si><t>日期</t></si><si><r><t>收</t></r><r><rPr><b/></rPr><t>盘</t></r></si><si><t xml:space="preserve"> code </t></si></sst>`
+	// Styles 1 and 2 show dates, 3 a plain number and 4 quoted text that
+	// contains date letters.
+	testStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="3"><numFmt numFmtId="164" formatCode="yyyy/m/d\ h:mm"/><numFmt numFmtId="165" formatCode="0.00"/><numFmt numFmtId="166" formatCode="0&quot; d&quot;"/></numFmts>
+<cellXfs count="5"><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/><xf numFmtId="165"/><xf numFmtId="166"/></cellXfs></styleSheet>`
+)
+
+// sheetXML wraps rows in a worksheet.
+func sheetXML(rows string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + rows + `</sheetData></worksheet>`
+}
+
+// testXLSX zips a workbook of two sheets with the shared strings and
+// styles above; parts overrides or adds archive entries, and an empty
+// part is left out.
+func testXLSX(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	files := map[string]string{
+		"[Content_Types].xml":        `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`,
+		"xl/workbook.xml":            fmt.Sprintf(testWorkbook, ""),
+		"xl/_rels/workbook.xml.rels": testRels,
+		"xl/sharedStrings.xml":       testSharedStrings,
+		"xl/styles.xml":              testStyles,
+		"xl/worksheets/sheet1.xml":   sheetXML(""),
+		"xl/worksheets/sheet2.xml":   sheetXML(`<row r="1"><c r="A1" t="inlineStr"><is><t>other</t></is></c></row>`),
+	}
+	for name, content := range parts {
+		files[name] = content
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		if content == "" {
+			continue
+		}
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readTestXLSX(raw []byte, sheet string) ([][]string, error) {
+	return readXLSX(bytes.NewReader(raw), int64(len(raw)), sheet)
+}
+
+func TestReadXLSX(t *testing.T) {
+	raw := testXLSX(t, map[string]string{"xl/worksheets/sheet1.xml": sheetXML(`
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c><c r="D1" t="inlineStr"><is><r><t>成交</t></r><r><t>量</t></r></is></c></row>
+<row r="2"><c r="A2" s="1"><v>45462</v></c><c r="B2" s="3"><v>1570.5</v></c><c r="C2" t="str"><v>600519</v></c><c r="D2" s="4"><v>60911</v></c></row>
+<row r="4"><c r="A4" s="2"><v>45462.4375</v></c><c r="C4" t="inlineStr"><is><t>000001</t></is></c><c r="E4" t="b"><v>1</v></c></row>
+<row r="5"><c s="1"><v>45463</v></c><c><v>10.2</v></c><c r="D5"><v>7</v></c></row>`)})
+
+	rows, err := readTestXLSX(raw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		// Shared strings keep rich text runs and spaces; inline strings too.
+		{"日期", "收盘", " code ", "成交量"},
+		// Date styles turn serials into times; other numbers stay as stored.
+		{"2024-06-19 00:00:00", "1570.5", "600519", "60911"},
+		// Row 3 is missing and returned empty.
+		nil,
+		// Cells without a reference follow the one before.
+		{"2024-06-19 10:30:00", "", "000001", "", "1"},
+		{"2024-06-20 00:00:00", "10.2", "", "7"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows =\n%q\nwant\n%q", rows, want)
+	}
+
+	// The 1904 date system counts from 1904-01-01.
+	raw = testXLSX(t, map[string]string{
+		"xl/workbook.xml":          fmt.Sprintf(testWorkbook, ` date1904="1"`),
+		"xl/worksheets/sheet1.xml": sheetXML(`<row r="1"><c r="A1" s="1"><v>44000</v></c></row>`),
+	})
+	if rows, err := readTestXLSX(raw, ""); err != nil || rows[0][0] != "2024-06-19 00:00:00" {
+		t.Errorf("1904 workbook: rows %q, %v", rows, err)
+	}
+}
+
+func TestReadXLSXSheets(t *testing.T) {
+	raw := testXLSX(t, map[string]string{"xl/sharedStrings.xml": "", "xl/styles.xml": ""})
+	rows, err := readTestXLSX(raw, "Other")
+	if err != nil || !reflect.DeepEqual(rows, [][]string{{"other"}}) {
+		t.Errorf("sheet Other: rows %q, %v", rows, err)
+	}
+	if _, err := readTestXLSX(raw, "Missing"); err == nil || !strings.Contains(err.Error(), `no sheet "Missing"`) {
+		t.Errorf("missing sheet: error %v", err)
+	}
+	if _, err := readTestXLSX(testXLSX(t, map[string]string{"xl/workbook.xml": ""}), ""); err == nil || !strings.Contains(err.Error(), "missing xl/workbook.xml") {
+		t.Errorf("no workbook: error %v", err)
+	}
+	if _, err := readTestXLSX([]byte("a,b\n1,2\n"), ""); err == nil {
+		t.Error("read a CSV file as a workbook")
+	}
+}
+
+// TestReadXLSXFixtures reads workbooks saved by other programs; see
+// testdata/README.md.
+func TestReadXLSXFixtures(t *testing.T) {
+	read := func(name string) [][]string {
+		t.Helper()
+		raw, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := readTestXLSX(raw, "")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return rows
+	}
+
+	// Excel keeps every string shared, here with a phonetic guide that is
+	// not part of the text. Serial 40543 in the 1904 system is 2015-01-01.
+	rows := read("excel_cell_types.xlsx")
+	want := [][]string{
+		{"hello world", "string"},
+		{"日本語", "string"},
+		{"12345", "int"},
+		{"1.024", "float"},
+		{"2015-01-01 00:00:00", "date"},
+		{"1", "bool"},
+		{"30", "formula"},
+		{"#DIV/0!", "error"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Excel workbook rows =\n%q\nwant\n%q", rows, want)
+	}
+
+	// LibreOffice leaves empty cells out of the sheet.
+	rows = read("libreoffice_empty_cells.xlsx")
+	want = [][]string{
+		{"", "B1", "C1", "D1"},
+		{"A2", "", "C2", "D2"},
+		{"A3", "B3", "", "D3"},
+		{"A4", "B4", "C4"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("LibreOffice workbook rows =\n%q\nwant\n%q", rows, want)
+	}
+
+	// Inline strings made of formatted runs, between empty rows and cells.
+	rows = read("inline_strings.xlsx")
+	if len(rows) != 38 {
+		t.Fatalf("inline string workbook has %d rows, want 38", len(rows))
+	}
+	for _, tt := range []struct {
+		row   int
+		cells []string
+	}{
+		{1, []string{"", "HL Retail - North America - Activity by Day - MTD"}},
+		{4, nil},
+		{10, []string{"", "Date", "Campaign", "Impressions", "Clicks"}},
+		{11, []string{"", "06/25/16", "Malformed Campaign", "3599619", "13351"}},
+		{36, []string{"", "Total", "1", "80547974", "274533"}},
+	} {
+		got := rows[tt.row-1]
+		if len(got) > len(tt.cells) {
+			got = got[:len(tt.cells)]
+		}
+		if !reflect.DeepEqual(got, tt.cells) {
+			t.Errorf("inline string workbook row %d starts %q, want %q", tt.row, got, tt.cells)
+		}
+	}
+}
+
+func TestReadXLSXErrors(t *testing.T) {
+	tests := []struct {
+		rows  string
+		err   string
+		large bool
+	}{
+		{`<row r="1"><c r="A1" t="s"><v>3</v></c></row>`, "bad shared string", false},
+		{`<row r="1"><c r="1A"><v>1</v></c></row>`, "bad reference", false},
+		{`<row r="1"><c r="XFD1"><v>1</v></c></row>`, "", false},
+		{`<row r="1"><c r="XFE1"><v>1</v></c></row>`, "beyond column XFD", false},
+		{`<row r="1"><c r="AAAAAAAAAAAAAAAAA1"><v>1</v></c></row>`, "beyond column XFD", false},
+		{`<row r="1048577"><c><v>1</v></c></row>`, "beyond row 1048576", false},
+		// Every row is padded out to its last column.
+		{strings.Repeat(`<row><c r="XFD1"><v>1</v></c></row>`, maxXLSXCells/maxXLSXColumns+1), "more than", true},
+	}
+	for _, tt := range tests {
+		_, err := readTestXLSX(testXLSX(t, map[string]string{"xl/worksheets/sheet1.xml": sheetXML(tt.rows)}), "")
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%.40s: %v", tt.rows, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) || errors.Is(err, ErrTooLarge) != tt.large {
+			t.Errorf("%.40s: error %v, want %q", tt.rows, err, tt.err)
+		}
+	}
+}
+
+// TestReadXLSXPartSize declares a sheet larger than the limit; it is
+// refused before anything is unpacked.
+func TestReadXLSXPartSize(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"xl/workbook.xml":            fmt.Sprintf(testWorkbook, ""),
+		"xl/_rels/workbook.xml.rels": testRels,
+	} {
+		w, _ := archive.Create(name)
+		w.Write([]byte(content))
+	}
+	sheet := []byte(sheetXML(""))
+	w, err := archive.CreateRaw(&zip.FileHeader{
+		Name:               "xl/worksheets/sheet1.xml",
+		Method:             zip.Store,
+		CompressedSize64:   uint64(len(sheet)),
+		UncompressedSize64: maxXLSXPartSize + 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(sheet)
+	archive.Close()
+
+	if _, err := readTestXLSX(buf.Bytes(), ""); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("error %v, want ErrTooLarge", err)
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := map[string]int{"A1": 0, "Z9": 25, "AA10": 26, "AZ1": 51, "BA1": 52, "XFD1048576": 16383}
+	for ref, want := range tests {
+		if got, err := columnIndex(ref); err != nil || got != want {
+			t.Errorf("columnIndex(%q) = %d, %v; want %d", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"", "7", "a1", "XFE1"} {
+		if _, err := columnIndex(ref); err == nil {
+			t.Errorf("columnIndex(%q) succeeded", ref)
+		}
+	}
+}
+
+func TestIsDateFormat(t *testing.T) {
+	tests := map[string]bool{
+		"yyyy-mm-dd":             true,
+		"yyyy/m/d\\ h:mm":        true,
+		"[$-804]yyyy\"年\"m\"月\"": true,
+		"h:mm:ss":                true,
+		"0.00":                   false,
+		"#,##0":                  false,
+		"0\" d\"":                false,
+		"0\\d":                   false,
+		"[Red]0.00":              false,
+		"General":                false,
+	}
+	for code, want := range tests {
+		if got := isDateFormat(code); got != want {
+			t.Errorf("isDateFormat(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestSerialTime(t *testing.T) {
+	tests := []struct {
+		serial   float64
+		date1904 bool
+		want     time.Time
+	}{
+		{1, false, time.Date(1899, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{45462, false, time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC)},
+		{45462.625, false, time.Date(2024, 6, 19, 15, 0, 0, 0, time.UTC)},
+		// A time of day stored a hair short of the second rounds up.
+		{45462.6249999999, false, time.Date(2024, 6, 19, 15, 0, 0, 0, time.UTC)},
+		{44000, true, time.Date(2024, 6, 19, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := serialTime(tt.serial, tt.date1904); !got.Equal(tt.want) {
+			t.Errorf("serialTime(%v, %v) = %v, want %v", tt.serial, tt.date1904, got, tt.want)
+		}
+	}
+}
@@ -15,6 +15,10 @@ except Exception as exc:  # pragma: no cover - runtime dependency check
     print("Please run ./scripts/sync_akshare.sh to install them.", file=sys.stderr)
     raise SystemExit(1) from exc
 
+# Kline times are written the way the Go server writes them, so both share
+# the unique (stock_code, interval, time) key.
+KLINE_TIME_FORMAT = "%Y-%m-%d %H:%M:%S+00:00"
+
 
 def log(message: str) -> None:
     print(message, file=sys.stderr)
@@ -63,15 +67,74 @@ def ensure_schema(conn: sqlite3.Connection) -> None:
         )
         """
     )
+    dedup_klines(conn)
     conn.execute(
         """
-        CREATE INDEX IF NOT EXISTS idx_k_lines_code_interval_time
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_k_lines_key
         ON k_lines(stock_code, interval, time)
         """
     )
+    conn.execute(
+        """
+        CREATE TABLE IF NOT EXISTS adjust_factors (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            stock_code TEXT,
+            date DATETIME,
+            factor REAL,
+            created_at DATETIME
+        )
+        """
+    )
+    conn.execute(
+        """
+        CREATE INDEX IF NOT EXISTS idx_adjust_factors_code_date
+        ON adjust_factors(stock_code, date)
+        """
+    )
+    conn.execute(
+        """
+        CREATE TABLE IF NOT EXISTS corporate_actions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            stock_code TEXT,
+            ex_date DATETIME,
+            pay_date DATETIME,
+            cash_per_share REAL,
+            bonus_ratio REAL,
+            created_at DATETIME
+        )
+        """
+    )
+    conn.execute(
+        """
+        CREATE INDEX IF NOT EXISTS idx_corporate_actions_code_ex_date
+        ON corporate_actions(stock_code, ex_date)
+        """
+    )
     conn.commit()
 
 
+def dedup_klines(conn: sqlite3.Connection) -> None:
+    """Prepare k_lines created before the unique key, as the server does:
+    rewrite zone-less times in KLINE_TIME_FORMAT and keep the latest row of
+    each bar."""
+    exists = conn.execute(
+        "SELECT 1 FROM sqlite_master WHERE type = 'index' AND name = 'idx_k_lines_key'"
+    ).fetchone()
+    if exists:
+        return
+    conn.execute("UPDATE k_lines SET time = time || ' 00:00:00+00:00' WHERE length(time) = 10")
+    conn.execute("UPDATE k_lines SET time = time || '+00:00' WHERE length(time) = 19")
+    conn.execute(
+        """
+        DELETE FROM k_lines
+        WHERE id NOT IN (
+            SELECT MAX(id) FROM k_lines GROUP BY stock_code, interval, datetime(time)
+        )
+        """
+    )
+    conn.execute("DROP INDEX IF EXISTS idx_k_lines_code_interval_time")
+
+
 def infer_exchange(code: str) -> str:
     if code.startswith("6"):
         return "SH"
@@ -129,7 +192,7 @@ def normalize_daily(df: pd.DataFrame) -> pd.DataFrame:
         if key not in df.columns:
             raise ValueError(f"Missing column {key} in daily data")
     df = df[list(mapping.values())]
-    df["time"] = pd.to_datetime(df["time"]).dt.strftime("%Y-%m-%d")
+    df["time"] = pd.to_datetime(df["time"]).dt.strftime(KLINE_TIME_FORMAT)
     return df
 
 
@@ -147,36 +210,100 @@ def normalize_minute(df: pd.DataFrame) -> pd.DataFrame:
         if key not in df.columns:
             raise ValueError(f"Missing column {key} in minute data")
     df = df[list(mapping.values())]
-    df["time"] = pd.to_datetime(df["time"]).dt.strftime("%Y-%m-%d %H:%M:%S")
+    df["time"] = pd.to_datetime(df["time"]).dt.strftime(KLINE_TIME_FORMAT)
     return df
 
 
-def delete_existing(conn: sqlite3.Connection, code: str, interval: str, start: str, end: str) -> None:
-    conn.execute(
+def insert_klines(conn: sqlite3.Connection, code: str, interval: str, df: pd.DataFrame) -> int:
+    if df.empty:
+        return 0
+
+    now = datetime.utcnow().isoformat(sep=" ", timespec="seconds")
+    payload = [
+        (code, interval, row.time, row.open, row.high, row.low, row.close, row.volume, now)
+        for row in df.itertuples(index=False)
+    ]
+    conn.executemany(
         """
-        DELETE FROM k_lines
-        WHERE stock_code = ? AND interval = ? AND time BETWEEN ? AND ?
+        INSERT INTO k_lines (stock_code, interval, time, open, high, low, close, volume, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (stock_code, interval, time) DO UPDATE SET
+            open = excluded.open,
+            high = excluded.high,
+            low = excluded.low,
+            close = excluded.close,
+            volume = excluded.volume
         """,
-        (code, interval, start, end),
+        payload,
     )
+    return len(payload)
 
 
-def insert_klines(conn: sqlite3.Connection, code: str, interval: str, df: pd.DataFrame) -> int:
+def fetch_adjust_factors(code: str) -> pd.DataFrame:
+    prefix = infer_exchange(code).lower()
+    if not prefix:
+        raise ValueError(f"Unknown exchange for {code}")
+    df = ak.stock_zh_a_daily(symbol=f"{prefix}{code}", adjust="hfq-factor")
+    df = df.rename(columns={"hfq_factor": "factor"})
+    for key in ("date", "factor"):
+        if key not in df.columns:
+            raise ValueError(f"Missing column {key} in adjust factor data")
+    df = df[["date", "factor"]]
+    df["date"] = pd.to_datetime(df["date"]).dt.strftime("%Y-%m-%d")
+    df["factor"] = df["factor"].astype(float)
+    return df
+
+
+def replace_adjust_factors(conn: sqlite3.Connection, code: str, df: pd.DataFrame) -> int:
     if df.empty:
         return 0
-    start = df["time"].min()
-    end = df["time"].max()
-    delete_existing(conn, code, interval, start, end)
+    conn.execute("DELETE FROM adjust_factors WHERE stock_code = ?", (code,))
+    now = datetime.utcnow().isoformat(sep=" ", timespec="seconds")
+    payload = [(code, row.date, row.factor, now) for row in df.itertuples(index=False)]
+    conn.executemany(
+        """
+        INSERT INTO adjust_factors (stock_code, date, factor, created_at)
+        VALUES (?, ?, ?, ?)
+        """,
+        payload,
+    )
+    return len(payload)
+
+
+def fetch_corporate_actions(code: str) -> pd.DataFrame:
+    df = ak.stock_dividend_cninfo(symbol=code)
+    for key in ("除权日", "派息日", "送股比例", "转增比例", "派息比例"):
+        if key not in df.columns:
+            raise ValueError(f"Missing column {key} in dividend data")
+    df = df[df["除权日"].notna()].copy()
+    # cninfo quotes bonus, transfer and cash per 10 shares.
+    per10 = lambda col: pd.to_numeric(df[col], errors="coerce").fillna(0.0) / 10
+    out = pd.DataFrame(
+        {
+            "ex_date": pd.to_datetime(df["除权日"]).dt.strftime("%Y-%m-%d"),
+            "pay_date": pd.to_datetime(df["派息日"], errors="coerce").dt.strftime("%Y-%m-%d"),
+            "cash_per_share": per10("派息比例"),
+            "bonus_ratio": per10("送股比例") + per10("转增比例"),
+        }
+    )
+    out["pay_date"] = out["pay_date"].fillna(out["ex_date"])
+    out = out[(out["cash_per_share"] > 0) | (out["bonus_ratio"] > 0)]
+    return out.drop_duplicates(subset=["ex_date"], keep="last")
+
 
+def replace_corporate_actions(conn: sqlite3.Connection, code: str, df: pd.DataFrame) -> int:
+    conn.execute("DELETE FROM corporate_actions WHERE stock_code = ?", (code,))
+    if df.empty:
+        return 0
     now = datetime.utcnow().isoformat(sep=" ", timespec="seconds")
     payload = [
-        (code, interval, row.time, row.open, row.high, row.low, row.close, row.volume, now)
+        (code, row.ex_date, row.pay_date, row.cash_per_share, row.bonus_ratio, now)
         for row in df.itertuples(index=False)
     ]
     conn.executemany(
         """
-        INSERT INTO k_lines (stock_code, interval, time, open, high, low, close, volume, created_at)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        INSERT INTO corporate_actions (stock_code, ex_date, pay_date, cash_per_share, bonus_ratio, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
         """,
         payload,
     )
@@ -237,6 +364,8 @@ def main() -> None:
         "stocks": len(symbols),
         "daily_rows": 0,
         "minute_rows": 0,
+        "factor_rows": 0,
+        "action_rows": 0,
         "errors": [],
     }
 
@@ -256,6 +385,16 @@ def main() -> None:
                 summary["daily_rows"] += inserted
             except Exception as exc:  # pragma: no cover
                 summary["errors"].append({"symbol": code, "mode": "daily", "error": str(exc)})
+            try:
+                factor_df = fetch_adjust_factors(code)
+                summary["factor_rows"] += replace_adjust_factors(conn, code, factor_df)
+            except Exception as exc:  # pragma: no cover
+                summary["errors"].append({"symbol": code, "mode": "factor", "error": str(exc)})
+            try:
+                action_df = fetch_corporate_actions(code)
+                summary["action_rows"] += replace_corporate_actions(conn, code, action_df)
+            except Exception as exc:  # pragma: no cover
+                summary["errors"].append({"symbol": code, "mode": "action", "error": str(exc)})
 
         if args.mode in ("minute", "all"):
             try: